@@ -0,0 +1,326 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/ceph/auth"
+	"github.com/dpeckett/picoceph/internal/ceph/cephfsmirror"
+	"github.com/dpeckett/picoceph/internal/ceph/crash"
+	"github.com/dpeckett/picoceph/internal/ceph/dashboard"
+	"github.com/dpeckett/picoceph/internal/ceph/exporter"
+	"github.com/dpeckett/picoceph/internal/ceph/iscsi"
+	"github.com/dpeckett/picoceph/internal/ceph/manager"
+	"github.com/dpeckett/picoceph/internal/ceph/monitor"
+	"github.com/dpeckett/picoceph/internal/ceph/monmap"
+	"github.com/dpeckett/picoceph/internal/ceph/nfs"
+	"github.com/dpeckett/picoceph/internal/ceph/nvmeof"
+	"github.com/dpeckett/picoceph/internal/ceph/osd"
+	"github.com/dpeckett/picoceph/internal/ceph/prometheus"
+	"github.com/dpeckett/picoceph/internal/ceph/radosgw"
+	"github.com/dpeckett/picoceph/internal/ceph/rbdmirror"
+	"github.com/dpeckett/picoceph/internal/ceph/restful"
+	"github.com/dpeckett/picoceph/internal/config"
+	"github.com/dpeckett/picoceph/internal/dns"
+	"github.com/dpeckett/picoceph/internal/join"
+	"github.com/dpeckett/picoceph/internal/loadgen"
+	"github.com/dpeckett/picoceph/internal/lsm"
+	"github.com/dpeckett/picoceph/internal/platform"
+)
+
+// bootstrapOptions are the options for bootstrapping a cluster.
+type bootstrapOptions struct {
+	fsid string
+	// existing is true if the cluster was bootstrapped by a previous run.
+	existing bool
+	monMap   *monmap.MonMap
+	monIDs   []string
+	mgrIDs   []string
+	osdID    string
+	// virtualHosts replace the OSD with the OSDs of each virtual host.
+	virtualHosts  []ceph.VirtualHost
+	publicNetwork string
+	bindAddr      string
+	ipv6          bool
+	msgrSecure    bool
+	platform      *platform.Platform
+	crushLocation ceph.CrushLocation
+	confTemplate  string
+	manager       manager.Options
+	osd           osd.Options
+	// osdOverrides override the options of individual OSDs, keyed by id.
+	osdOverrides map[string]config.OSD
+	// addedOSDIDs are the OSDs added while a previous run was running.
+	addedOSDIDs  []string
+	radosgw      radosgw.Options
+	dashboard    dashboard.Options
+	dashboardRGW dashboard.RGWOptions
+	prometheus   *prometheus.Options
+	exporter     *exporter.Options
+	restful      *restful.Options
+	dns          *dns.Options
+	loadGen      *loadgen.Options
+	nfs          *nfs.Options
+	iscsi        *iscsi.Options
+	nvmeof       *nvmeof.Options
+	rbdMirror    *rbdmirror.Options
+	cephFSMirror *cephfsmirror.Options
+	crash        *crash.Options
+	s3User       *radosgw.UserOptions
+	s3AdminUser  *radosgw.UserOptions
+	swift        bool
+	stsRole      *radosgw.RoleOptions
+	buckets      []string
+	uploads      []radosgw.Upload
+}
+
+// bootstrap prepares the host for a new (or previously bootstrapped) cluster,
+// and returns its components.
+func bootstrap(ctx context.Context, logger *slog.Logger, opts bootstrapOptions) ([]ceph.Component, error) {
+	logger.Info("Creating ceph directories")
+
+	if err := ceph.CreateDirectories(); err != nil {
+		return nil, fmt.Errorf("could not create ceph directories: %w", err)
+	}
+
+	if opts.existing {
+		// The monitor store only knows the original admin key.
+		if _, err := os.Stat(auth.AdminKeyringPath); err != nil {
+			return nil, fmt.Errorf("existing cluster is missing its admin keyring, /etc/ceph must be persisted along with /var/lib/ceph: %w", err)
+		}
+	} else if err := ceph.WriteFSID(opts.fsid); err != nil {
+		return nil, fmt.Errorf("could not record fsid: %w", err)
+	}
+
+	if lsm.SELinuxEnforcing() {
+		if err := lsm.Relabel(ctx, ceph.Directories...); err != nil {
+			logger.Warn("Could not relabel ceph directories", "error", err)
+		}
+	}
+
+	logger.Info("Creating shared keyrings")
+
+	if err := auth.Bootstrap(); err != nil {
+		return nil, fmt.Errorf("could not create shared keyrings: %w", err)
+	}
+
+	logger.Info("Writing ceph.conf")
+
+	if err := ceph.WriteConfig(ctx, ceph.Config{
+		MonMap:          opts.monMap,
+		OSDMemoryTarget: opts.platform.OSDMemoryTarget,
+		CrushLocation:   opts.crushLocation,
+		Discard:         opts.osd.Discard,
+		Template:        opts.confTemplate,
+		PublicNetwork:   opts.publicNetwork,
+		BindAddr:        opts.bindAddr,
+		IPv6:            opts.ipv6,
+		MsgrSecure:      opts.msgrSecure,
+		OSDID:           opts.osdID,
+		VirtualHosts:    opts.virtualHosts,
+	}); err != nil {
+		return nil, fmt.Errorf("could not write ceph.conf: %w", err)
+	}
+
+	var components []ceph.Component
+	for _, id := range opts.monIDs {
+		components = append(components, monitor.New(logger, id, opts.fsid))
+	}
+
+	for _, id := range opts.mgrIDs {
+		components = append(components, manager.New(logger, id, opts.manager))
+	}
+
+	osdIDs := []string{opts.osdID}
+	if len(opts.virtualHosts) > 0 {
+		osdIDs = nil
+		for _, host := range opts.virtualHosts {
+			osdIDs = append(osdIDs, host.OSDIDs...)
+		}
+	}
+
+	for _, id := range osdIDs {
+		components = append(components, osd.New(logger, id, osdOptions(opts.osd, opts.osdOverrides, id)))
+	}
+
+	// Added OSDs don't share the device of the first OSD.
+	addedOpts := opts.osd
+	addedOpts.Device = ""
+	for _, id := range opts.addedOSDIDs {
+		if !slices.Contains(osdIDs, id) {
+			components = append(components, osd.New(logger, id, osdOptions(addedOpts, opts.osdOverrides, id)))
+		}
+	}
+
+	components = append(components,
+		radosgw.New(logger, opts.radosgw),
+		dashboard.New(logger, opts.dashboard),
+		dashboard.NewRGW(opts.dashboardRGW),
+	)
+
+	if opts.crash != nil {
+		components = append(components, crash.New(logger, *opts.crash))
+	}
+
+	if opts.restful != nil {
+		components = append(components, restful.New(logger, *opts.restful))
+	}
+
+	if opts.exporter != nil {
+		components = append(components, exporter.New(logger, *opts.exporter))
+	}
+
+	if opts.prometheus != nil {
+		components = append(components, prometheus.New(logger, *opts.prometheus))
+	}
+
+	if opts.s3User != nil {
+		components = append(components, radosgw.NewDefaultUser(logger, opts.radosgw.Endpoint(), *opts.s3User, opts.swift))
+	}
+
+	if opts.s3AdminUser != nil {
+		components = append(components, radosgw.NewAdminUser(logger, opts.radosgw.Endpoint(), *opts.s3AdminUser))
+	}
+
+	if opts.s3User != nil && len(opts.buckets) > 0 {
+		components = append(components, radosgw.NewBuckets(logger, opts.buckets))
+	}
+
+	if opts.s3User != nil && len(opts.uploads) > 0 {
+		components = append(components, radosgw.NewUploads(logger, opts.uploads))
+	}
+
+	if opts.s3User != nil && opts.nfs != nil {
+		components = append(components, nfs.New(logger, *opts.nfs))
+	}
+
+	if opts.iscsi != nil {
+		components = append(components, iscsi.New(logger, *opts.iscsi)...)
+	}
+
+	if opts.nvmeof != nil {
+		components = append(components, nvmeof.New(logger, *opts.nvmeof)...)
+	}
+
+	if opts.rbdMirror != nil {
+		components = append(components, rbdmirror.New(logger, *opts.rbdMirror))
+	}
+
+	if opts.cephFSMirror != nil {
+		components = append(components, cephfsmirror.New(logger, *opts.cephFSMirror))
+	}
+
+	if opts.dns != nil {
+		components = append(components, dns.New(logger, *opts.dns))
+	}
+
+	if opts.loadGen != nil {
+		components = append(components, loadgen.New(logger, *opts.loadGen))
+	}
+
+	if opts.stsRole != nil {
+		components = append(components, radosgw.NewRole(logger, *opts.stsRole))
+	}
+
+	return components, nil
+}
+
+// joinOptions are the options for joining the cluster of another instance.
+type joinOptions struct {
+	fsid string
+	// existing is true if the cluster was joined by a previous run.
+	existing      bool
+	monMap        *monmap.MonMap
+	osdID         string
+	publicNetwork string
+	ipv6          bool
+	msgrSecure    bool
+	platform      *platform.Platform
+	crushLocation ceph.CrushLocation
+	confTemplate  string
+	key           string
+	osd           osd.Options
+	// osdOverrides override the options of individual OSDs, keyed by id.
+	osdOverrides map[string]config.OSD
+}
+
+// joinCluster prepares the host to add an OSD to the cluster of another instance,
+// and returns its components.
+func joinCluster(ctx context.Context, logger *slog.Logger, opts joinOptions) ([]ceph.Component, error) {
+	logger.Info("Creating ceph directories")
+
+	if err := ceph.CreateDirectories(); err != nil {
+		return nil, fmt.Errorf("could not create ceph directories: %w", err)
+	}
+
+	if !opts.existing {
+		if err := ceph.WriteFSID(opts.fsid); err != nil {
+			return nil, fmt.Errorf("could not record fsid: %w", err)
+		}
+	}
+
+	if lsm.SELinuxEnforcing() {
+		if err := lsm.Relabel(ctx, ceph.Directories...); err != nil {
+			logger.Warn("Could not relabel ceph directories", "error", err)
+		}
+	}
+
+	logger.Info("Writing bootstrap-osd keyring")
+
+	if err := join.WriteKeyring(opts.key); err != nil {
+		return nil, fmt.Errorf("could not write bootstrap-osd keyring: %w", err)
+	}
+
+	logger.Info("Writing ceph.conf")
+
+	if err := ceph.WriteConfig(ctx, ceph.Config{
+		MonMap:          opts.monMap,
+		OSDMemoryTarget: opts.platform.OSDMemoryTarget,
+		CrushLocation:   opts.crushLocation,
+		Discard:         opts.osd.Discard,
+		Template:        opts.confTemplate,
+		PublicNetwork:   opts.publicNetwork,
+		IPv6:            opts.ipv6,
+		MsgrSecure:      opts.msgrSecure,
+		OSDID:           opts.osdID,
+	}); err != nil {
+		return nil, fmt.Errorf("could not write ceph.conf: %w", err)
+	}
+
+	opts.osd.Joined = true
+
+	return []ceph.Component{osd.New(logger, opts.osdID, osdOptions(opts.osd, opts.osdOverrides, opts.osdID))}, nil
+}
+
+// osdOptions returns the options of an OSD, with any overrides set for it in
+// the config file.
+func osdOptions(opts osd.Options, overrides map[string]config.OSD, id string) osd.Options {
+	override := overrides[id]
+
+	if override.SizeGiB > 0 {
+		opts.ImageSize = override.SizeGiB << 30
+	}
+
+	if override.DeviceClass != "" {
+		opts.DeviceClass = override.DeviceClass
+	}
+
+	if override.Device != "" {
+		opts.Device = override.Device
+	}
+
+	return opts
+}
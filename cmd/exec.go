@@ -0,0 +1,111 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dpeckett/picoceph/internal/api"
+	"github.com/dpeckett/picoceph/internal/ceph/auth"
+	"github.com/dpeckett/picoceph/internal/ceph/radosgw"
+	"github.com/dpeckett/picoceph/internal/datadir"
+)
+
+// execCommand runs a command with the environment set up to talk to the
+// cluster (eg. rados, rbd, or an S3 client).
+func execCommand(args []string) error {
+	fs := flag.NewFlagSet("exec", flag.ExitOnError)
+	apiAddr := fs.String("api-addr", defaultControlSocket, "The address of the picoceph HTTP API, or the path of its control socket")
+	instance := fs.String("instance", "", "The name of the instance (defaults to the unnamed instance)")
+	_ = fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: picoceph exec [flags] <command> [args...]")
+	}
+
+	if *instance != "" {
+		if err := validateInstance(*instance); err != nil {
+			return err
+		}
+
+		if !isFlagSetIn(fs, "api-addr") {
+			*apiAddr = instanceControlSocket(*instance)
+		}
+
+		// The paths of the instance's ceph.conf and keyrings are only
+		// meaningful under its data directory.
+		if err := datadir.Enter(instanceDataDir(*instance)); err != nil {
+			return err
+		}
+	}
+
+	path, err := exec.LookPath(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Fall back to the default paths if picoceph isn't running.
+	conn, err := api.NewClient(*apiAddr).Connection(ctx)
+	if err != nil {
+		conn = &api.Connection{
+			ConfigPath:        "/etc/ceph/ceph.conf",
+			AdminKeyringPath:  auth.AdminKeyringPath,
+			S3CredentialsPath: radosgw.CredentialsPath,
+		}
+	}
+
+	return syscall.Exec(path, fs.Args(), append(os.Environ(), clusterEnv(conn)...))
+}
+
+// clusterEnv returns the environment variables that point Ceph, S3 and Swift
+// clients at the cluster.
+func clusterEnv(conn *api.Connection) []string {
+	env := []string{
+		"CEPH_CONF=" + conn.ConfigPath,
+		"CEPH_ARGS=" + strings.TrimSpace(os.Getenv("CEPH_ARGS")+" --keyring="+conn.AdminKeyringPath),
+	}
+
+	if conn.S3CredentialsPath == "" {
+		return env
+	}
+
+	creds, err := radosgw.ReadCredentials(conn.S3CredentialsPath)
+	if err != nil {
+		return env
+	}
+
+	env = append(env,
+		"AWS_ACCESS_KEY_ID="+creds.AccessKey,
+		"AWS_SECRET_ACCESS_KEY="+creds.SecretKey,
+		"AWS_ENDPOINT_URL="+creds.Endpoint,
+		"AWS_REGION=us-east-1",
+		"S3_ENDPOINT="+creds.Endpoint,
+	)
+
+	if creds.SwiftAuthURL != "" {
+		env = append(env,
+			"ST_AUTH="+creds.SwiftAuthURL,
+			"ST_USER="+creds.SwiftUser,
+			"ST_KEY="+creds.SwiftKey,
+		)
+	}
+
+	return env
+}
@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/dpeckett/picoceph/internal/ceph/cephfs"
+)
+
+// fsMirrorCommand configures CephFS snapshot mirroring between clusters.
+func fsMirrorCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: picoceph fs-mirror <enable|add|token|import> [flags]")
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+
+	switch args[0] {
+	case "enable":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: picoceph fs-mirror enable <fs>")
+		}
+
+		return cephfs.EnableMirroring(ctx, args[1])
+	case "add":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: picoceph fs-mirror add <fs> <path>")
+		}
+
+		return cephfs.AddMirrorPath(ctx, args[1], args[2])
+	case "token":
+		return fsMirrorTokenCommand(ctx, args[1:])
+	case "import":
+		return fsMirrorImportCommand(ctx, args[1:])
+	default:
+		return fmt.Errorf("unknown fs-mirror command: %s", args[0])
+	}
+}
+
+// fsMirrorTokenCommand prints a peer bootstrap token for a filesystem.
+func fsMirrorTokenCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("fs-mirror token", flag.ExitOnError)
+	clientName := fs.String("client-name", "client.mirror_remote", "The user the peer cluster mirrors as")
+	siteName := fs.String("site-name", "picoceph", "The name of this cluster")
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: picoceph fs-mirror token [flags] <fs>")
+	}
+
+	token, err := cephfs.CreatePeerToken(ctx, fs.Arg(0), *clientName, *siteName)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(token)
+
+	return nil
+}
+
+// fsMirrorImportCommand adds the peer cluster that created a bootstrap token.
+func fsMirrorImportCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("fs-mirror import", flag.ExitOnError)
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: picoceph fs-mirror import <fs> <token file or ->")
+	}
+
+	var token []byte
+	var err error
+	if fs.Arg(1) == "-" {
+		token, err = io.ReadAll(os.Stdin)
+	} else {
+		token, err = os.ReadFile(fs.Arg(1))
+	}
+	if err != nil {
+		return fmt.Errorf("could not read token: %w", err)
+	}
+
+	return cephfs.ImportPeerToken(ctx, fs.Arg(0), string(token))
+}
@@ -0,0 +1,188 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"syscall"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/lockfile"
+)
+
+// instanceRegexp matches instance names, which are used in the names of
+// volume groups, images and paths.
+var instanceRegexp = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,30}[a-z0-9])?$`)
+
+// ephemeralMarker marks the data directory of an ephemeral instance, whose
+// state is removed whenever it starts.
+const ephemeralMarker = ".ephemeral"
+
+// portFlags are the flags of the ports shifted by --port-offset (along with
+// the ports of the monitors and the API).
+var portFlags = []string{
+	"rgw-port", "rgw-tls-port", "dashboard-port", "prometheus-port",
+	"exporter-port", "restful-port", "nfs-port", "nvmeof-port",
+}
+
+// validateInstance returns an error if instance isn't a valid instance name.
+func validateInstance(instance string) error {
+	if !instanceRegexp.MatchString(instance) {
+		return fmt.Errorf("invalid instance name (lowercase letters, digits and hyphens): %q", instance)
+	}
+
+	return nil
+}
+
+// instanceDataDir returns the data directory of a named instance.
+func instanceDataDir(instance string) string {
+	return filepath.Join("/var/lib/picoceph", instance)
+}
+
+// instanceControlSocket returns the path of the control socket of an instance
+// (empty for the unnamed instance).
+func instanceControlSocket(instance string) string {
+	if instance == "" {
+		return defaultControlSocket
+	}
+
+	return "/run/picoceph-" + instance + ".sock"
+}
+
+// resetEphemeralDataDir removes the state left in the data directory of an
+// ephemeral instance by its previous run, as its OSD data was lost when it
+// exited. The state of a persistent instance, or of an instance that is still
+// running, is never removed.
+func resetEphemeralDataDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not read data directory: %w", err)
+	}
+
+	if len(entries) > 0 {
+		if _, err := os.Stat(filepath.Join(dir, ephemeralMarker)); err != nil {
+			return fmt.Errorf("data directory %s holds the state of a persistent instance (run picoceph purge to start over)", dir)
+		}
+
+		lock, err := lockfile.Acquire(filepath.Join(dir, lockfile.Path))
+		if err != nil {
+			return err
+		}
+
+		err = os.RemoveAll(dir)
+		_ = lock.Release()
+		if err != nil {
+			return fmt.Errorf("could not remove data directory: %w", err)
+		}
+	}
+
+	if err := ceph.MkdirAll(dir); err != nil {
+		return fmt.Errorf("could not create data directory: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, ephemeralMarker), nil, 0o644); err != nil {
+		return fmt.Errorf("could not mark data directory as ephemeral: %w", err)
+	}
+
+	return nil
+}
+
+// ephemeralStateMarker marks ceph state created by an ephemeral run.
+const ephemeralStateMarker = "/var/lib/ceph/.ephemeral"
+
+// resetEphemeralState removes the cluster state left in the ceph directories
+// by a previous ephemeral run (eg. the monitor store, whose osdmap still has
+// the OSDs that were lost when it exited), so that a new cluster is
+// bootstrapped. The state of a persistent cluster is never removed. The lock
+// of the ceph directories must be held.
+func resetEphemeralState() error {
+	// The tmpfs of the OSD image outlives an unclean exit.
+	if err := syscall.Unmount("/var/lib/ceph/disk", 0); err != nil && !errors.Is(err, syscall.EINVAL) && !errors.Is(err, syscall.ENOENT) {
+		return fmt.Errorf("could not unmount ephemeral storage: %w", err)
+	}
+
+	var paths []string
+	for _, dir := range ceph.Directories {
+		entries, err := os.ReadDir(dir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("could not read directory: %w", err)
+		}
+
+		for _, entry := range entries {
+			if path := filepath.Join(dir, entry.Name()); path != lockfile.Path && path != ephemeralStateMarker {
+				paths = append(paths, path)
+			}
+		}
+	}
+
+	if len(paths) > 0 {
+		if _, err := os.Stat(ephemeralStateMarker); err != nil {
+			return errors.New("the ceph directories hold the state of a persistent cluster (run picoceph purge to start over)")
+		}
+
+		for _, path := range paths {
+			if err := os.RemoveAll(path); err != nil {
+				return fmt.Errorf("could not remove ephemeral state: %w", err)
+			}
+		}
+	}
+
+	if err := os.WriteFile(ephemeralStateMarker, nil, 0o644); err != nil {
+		return fmt.Errorf("could not mark ceph state as ephemeral: %w", err)
+	}
+
+	return nil
+}
+
+// applyPortOffset shifts the ports that weren't explicitly set by offset, so
+// that several instances can share the network of a host.
+func applyPortOffset(offset int) error {
+	for _, name := range portFlags {
+		if isFlagSet(name) {
+			continue
+		}
+
+		f := flag.Lookup(name)
+
+		port, err := strconv.Atoi(f.Value.String())
+		if err != nil {
+			return fmt.Errorf("invalid --%s: %w", name, err)
+		}
+
+		if port+offset > 65535 {
+			return fmt.Errorf("port offset %d is too large for --%s", offset, name)
+		}
+
+		_ = f.Value.Set(strconv.Itoa(port + offset))
+	}
+
+	if f := flag.Lookup("api-addr"); !isFlagSet("api-addr") && f.Value.String() != "" {
+		host, port, err := net.SplitHostPort(f.Value.String())
+		if err != nil {
+			return fmt.Errorf("invalid --api-addr: %w", err)
+		}
+
+		n, err := strconv.Atoi(port)
+		if err != nil || n+offset > 65535 {
+			return fmt.Errorf("port offset %d is too large for --api-addr", offset)
+		}
+
+		_ = f.Value.Set(net.JoinHostPort(host, strconv.Itoa(n+offset)))
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchLogLevelSignals makes logging more verbose on SIGUSR1 and less verbose
+// on SIGUSR2, until the context is cancelled.
+func watchLogLevelSignals(ctx context.Context, logger *slog.Logger, level *slog.LevelVar) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(sigs)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-sigs:
+			switch sig {
+			case syscall.SIGUSR1:
+				level.Set(max(level.Level()-4, slog.LevelDebug))
+			case syscall.SIGUSR2:
+				level.Set(min(level.Level()+4, slog.LevelError))
+			}
+
+			logger.Info("Changed log level", "level", level.Level())
+		}
+	}
+}
@@ -12,114 +12,1275 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"log/slog"
+	"maps"
+	"net"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/dpeckett/picoceph/internal/adopt"
+	"github.com/dpeckett/picoceph/internal/api"
+	"github.com/dpeckett/picoceph/internal/audit"
 	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/ceph/auth"
+	"github.com/dpeckett/picoceph/internal/ceph/cephfsmirror"
+	"github.com/dpeckett/picoceph/internal/ceph/crash"
+	"github.com/dpeckett/picoceph/internal/ceph/custom"
 	"github.com/dpeckett/picoceph/internal/ceph/dashboard"
+	"github.com/dpeckett/picoceph/internal/ceph/exporter"
+	"github.com/dpeckett/picoceph/internal/ceph/health"
+	"github.com/dpeckett/picoceph/internal/ceph/iscsi"
 	"github.com/dpeckett/picoceph/internal/ceph/manager"
 	"github.com/dpeckett/picoceph/internal/ceph/monitor"
+	"github.com/dpeckett/picoceph/internal/ceph/monmap"
+	"github.com/dpeckett/picoceph/internal/ceph/nfs"
+	"github.com/dpeckett/picoceph/internal/ceph/nvmeof"
 	"github.com/dpeckett/picoceph/internal/ceph/osd"
+	"github.com/dpeckett/picoceph/internal/ceph/pools"
+	"github.com/dpeckett/picoceph/internal/ceph/prometheus"
 	"github.com/dpeckett/picoceph/internal/ceph/radosgw"
+	"github.com/dpeckett/picoceph/internal/ceph/rbdmirror"
+	"github.com/dpeckett/picoceph/internal/ceph/restful"
+	"github.com/dpeckett/picoceph/internal/command"
+	"github.com/dpeckett/picoceph/internal/config"
+	"github.com/dpeckett/picoceph/internal/daemon"
+	"github.com/dpeckett/picoceph/internal/datadir"
+	"github.com/dpeckett/picoceph/internal/diskusage"
+	"github.com/dpeckett/picoceph/internal/dns"
+	"github.com/dpeckett/picoceph/internal/faketime"
+	"github.com/dpeckett/picoceph/internal/fault"
+	"github.com/dpeckett/picoceph/internal/join"
+	"github.com/dpeckett/picoceph/internal/k8s"
+	"github.com/dpeckett/picoceph/internal/loadgen"
+	"github.com/dpeckett/picoceph/internal/lockfile"
+	"github.com/dpeckett/picoceph/internal/logring"
+	"github.com/dpeckett/picoceph/internal/orchestrator"
+	"github.com/dpeckett/picoceph/internal/platform"
+	"github.com/dpeckett/picoceph/internal/preflight"
+	"github.com/dpeckett/picoceph/internal/proxy"
+	"github.com/dpeckett/picoceph/internal/resolve"
+	"github.com/dpeckett/picoceph/internal/seed"
+	"github.com/dpeckett/picoceph/internal/tempfile"
+	"github.com/dpeckett/picoceph/internal/util"
 	"github.com/google/uuid"
-	"golang.org/x/sync/errgroup"
 )
 
+// defaultControlSocket is the default path of the control socket.
+const defaultControlSocket = "/run/picoceph.sock"
+
+// maxMonCount is the largest supported number of monitors.
+const maxMonCount = 5
+
+// maxMgrStandbys is the largest supported number of standby managers.
+const maxMgrStandbys = 4
+
+// maxVirtualHostOSDs is the largest supported number of OSDs across virtual
+// hosts.
+const maxVirtualHostOSDs = 8
+
+// commands are the subcommands of picoceph, without one picoceph runs the cluster.
+var commands = map[string]func(args []string) error{
+	"doctor":    doctorCommand,
+	"exec":      execCommand,
+	"fs-mirror": fsMirrorCommand,
+	"mirror":    mirrorCommand,
+	"osd":       osdCommand,
+	"preflight": preflightCommand,
+	"purge":     purgeCommand,
+	"snapshot":  snapshotCommand,
+	"status":    statusCommand,
+}
+
 func main() {
-	ctx, cancel := context.WithCancel(context.Background())
+	if command.IsShim() {
+		os.Exit(command.RunShim())
+	}
+
+	if len(os.Args) > 1 {
+		if subcommand, ok := commands[os.Args[1]]; ok {
+			if err := subcommand(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			return
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
 	defer cancel()
 
-	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{}))
+	// Never leave sensitive temporary files behind (error paths exit through
+	// tempfile.Exit, which skips deferred calls but cleans up first).
+	defer tempfile.RemoveAll()
+	tempfile.RemoveStale("picoceph-")
 
-	logger.Info("Creating ceph directories")
+	configPath := flag.String("config", "", "The path of a JSON configuration file")
+	osdBackendName := flag.String("osd-backend", string(osd.BackendAuto), "The block device backend for OSDs (auto, nbd, loop)")
+	osdStorageName := flag.String("storage", string(osd.StoragePersistent), "Where to keep OSD data (persistent, ephemeral)")
+	osdSizeGiB := flag.Int64("osd-size-gib", osd.DefaultImageSize>>30, "The size of each OSD backing image, in GiB (only applies to new images)")
+	osdDBSizeMiB := flag.Int64("osd-db-size-mib", 0, "The size of a separate BlueStore DB volume for each new OSD, in MiB (zero keeps the DB on the data volume)")
+	osdWALSizeMiB := flag.Int64("osd-wal-size-mib", 0, "The size of a separate BlueStore WAL volume for each new OSD, in MiB (zero keeps the WAL on the data or DB volume)")
+	osdEncrypted := flag.Bool("osd-encrypted", false, "Encrypt new OSDs at rest with dm-crypt (needs cryptsetup and the dm_crypt kernel module)")
+	osdRaw := flag.Bool("osd-raw", false, "Prepare new OSDs directly on their block devices with ceph-volume raw, skipping LVM")
+	osdDevice := flag.String("osd-device", "", "An existing block device to use for the OSD instead of a backing image (eg. /dev/sdb)")
+	osdDeviceClass := flag.String("osd-device-class", "", "The CRUSH device class of each OSD, eg. ssd, hdd or nvme (empty keeps the class Ceph detects)")
+	maxRestarts := flag.Int("max-restarts", 5, "How many times to restart a crashed daemon before giving up")
+	stopTimeout := flag.Duration("stop-timeout", orchestrator.DefaultStopTimeout, "How long to wait for each daemon to stop gracefully before it is killed")
+	stopTimeouts := flag.String("stop-timeouts", "", "Comma separated component=timeout pairs overriding --stop-timeout (eg. osd=2m,mon.a=10s)")
+	restartBackoff := flag.Duration("restart-backoff", orchestrator.DefaultRestartBackoff, "Delay before restarting a crashed daemon, doubled after each restart")
+	logFormat := flag.String("log-format", "text", "The log output format (text, json)")
+	logRetention := flag.Int("log-retention", 100, "How many recent log lines of each component to retain, and report if it fails (zero disables)")
+	logLevelName := flag.String("log-level", "info", "The log level (debug, info, warn, error), SIGUSR1 and SIGUSR2 raise and lower verbosity at runtime")
+	umask := flag.String("umask", "", "The file mode creation mask in octal, eg. 0027 (defaults to the inherited umask)")
+	dirMode := flag.String("dir-mode", "0755", "The permissions of created ceph directories in octal")
+	setgidDirs := flag.Bool("setgid-dirs", false, "Set the setgid bit on created ceph directories")
+	recordCommands := flag.String("record-commands", "", "Record the output of every external command to this fixture file")
+	replayCommands := flag.String("replay-commands", "", "Replay the output of external commands from this fixture file, instead of running them")
+	seedFlag := flag.String("seed", "", "INSECURE: derive the fsid, keys and credentials from this seed, so that they are the same every time the cluster is recreated")
+	fakeTime := flag.String("faketime", "", "Run the daemons under libfaketime with this time specification, eg. +2d or \"@2030-01-01 00:00:00\" (empty disables)")
+	auditLogPath := flag.String("audit-log", "", "Append a record of every privileged operation to this file")
+	instance := flag.String("instance", "", "A name for this instance, which namespaces its volume groups, data directory (/var/lib/picoceph/<instance>) and control socket, so that several instances can run on one host")
+	portOffset := flag.Int("port-offset", 0, "Shift every port that isn't explicitly set (including the monitor and API ports) by this offset, so that several instances can share the network of a host")
+	dataDir := flag.String("data-dir", "", "Keep all state under this directory (eg. /data/picoceph) rather than /etc/ceph, /var/lib/ceph and /var/log/ceph")
+	fsidFlag := flag.String("fsid", "", "The fsid of the cluster (defaults to the fsid of an existing cluster, or a random one)")
+	discard := flag.Bool("discard", true, "Pass discards through to the OSD backing images, so that deleted data is returned to the host")
+	trimInterval := flag.Duration("trim-interval", time.Hour, "How often to compact the OSD databases, so that space freed by deleted metadata is discarded (zero disables)")
+	diskUsageInterval := flag.Duration("disk-usage-interval", 30*time.Second, "How often to check the disk usage of the OSD backing images (zero disables)")
+	diskWarnPercent := flag.Float64("disk-warn-percent", 90, "How full the host filesystem can get before a warning is logged (zero disables)")
+	diskCap := flag.Int64("disk-cap-mib", 0, "How large the OSD backing images can get, in MiB, before client writes are paused (zero disables)")
+	healthInterval := flag.Duration("health-interval", 10*time.Second, "How often to check the health of the cluster and tag new pools (zero disables)")
+	confTemplateName := flag.String("ceph-conf-template", "", "The release whose ceph.conf template is used, one of quincy, reef or squid (defaults to the installed release)")
+	k8sStatefulSet := flag.Bool("k8s-statefulset", false, "Form a cluster with the other pods of a Kubernetes StatefulSet, deriving ids and addresses from the pod hostname (requires --seed)")
+	k8sService := flag.String("k8s-service", "", "The headless service governing the StatefulSet (defaults to the name of the StatefulSet)")
+	mgrStandbys := flag.Int("mgr-standbys", 0, "The number of standby managers to run alongside the active one, to exercise manager failover")
+	publicAddr := flag.String("public-addr", "", "The address the monitors and OSDs are reached on, which must be an address of the host (defaults to 127.0.0.1, so the cluster is only reachable locally)")
+	ipv6 := flag.Bool("ipv6", false, "Run the cluster over IPv6, with the monitors on ::1 (or --public-addr) and services bound to all IPv6 addresses")
+	msgrSecure := flag.Bool("msgr-secure", false, "Require the secure msgr2 mode, so that all traffic to and between daemons is encrypted")
+	bindAddr := flag.String("bind-addr", "", "The address the monitors bind to, if it differs from --public-addr (eg. 0.0.0.0 behind NAT)")
+	monCount := flag.Int("mon-count", 1, "The number of monitors (1, 3 or 5), each listening on its own ports, to exercise quorum and elections")
+	virtualHostsSpec := flag.String("virtual-hosts", "", "Virtual CRUSH hosts and their number of OSDs, eg. \"node1=2 node2=2 node3=1\", to replicate pools across failure domains")
+	crushLocationSpec := flag.String("crush-location", "", "The CRUSH location of the OSDs, eg. \"root=default rack=r1 host=node1\"")
+	poolApplications := flag.String("pool-applications", "", "Comma separated pool=application pairs (rbd, cephfs, rgw) to tag pools with, otherwise guessed from the pool name")
+	rgwAddr := flag.String("rgw-addr", "", "The address RGW binds to (defaults to all addresses)")
+	rgwPort := flag.Int("rgw-port", radosgw.DefaultPort, "The port RGW is served on")
+	rgwTLS := flag.Bool("rgw-tls", false, "Serve HTTPS from RGW (with a self-signed certificate unless --rgw-tls-cert is set)")
+	rgwTLSPort := flag.Int("rgw-tls-port", radosgw.DefaultTLSPort, "The port RGW serves HTTPS on")
+	rgwTLSCert := flag.String("rgw-tls-cert", "", "The path of the RGW TLS certificate")
+	rgwTLSKey := flag.String("rgw-tls-key", "", "The path of the RGW TLS private key")
+	rgwPrimaryURL := flag.String("rgw-primary-url", "", "Run RGW as a secondary zone, syncing from the primary zone gateway at this URL")
+	rgwPrimaryAccessKey := flag.String("rgw-primary-access-key", "", "The access key of a system user on the primary zone")
+	rgwPrimarySecretKey := flag.String("rgw-primary-secret-key", "", "The secret key of a system user on the primary zone")
+	rgwRealm := flag.String("rgw-realm", "", "The realm to pull from the primary (defaults to its default realm)")
+	rgwZoneGroup := flag.String("rgw-zonegroup", "", "The zonegroup of the secondary zone (defaults to the realm's default zonegroup)")
+	rgwZone := flag.String("rgw-zone", "secondary", "The name of the secondary zone")
+	rgwZoneEndpoint := flag.String("rgw-zone-endpoint", "", "The URL the primary reaches the secondary zone on (defaults to the RGW endpoint)")
+	rgwFrontendName := flag.String("rgw-frontend", string(radosgw.FrontendBeast), "The RGW HTTP frontend (beast, or civetweb on Ceph releases that support it)")
+	rgwThreads := flag.Int("rgw-threads", 0, "The number of RGW (beast or civetweb) request threads (zero keeps the Ceph default)")
+	rgwRequestTimeout := flag.Duration("rgw-request-timeout", 0, "How long RGW waits for a request (zero keeps the Ceph default)")
+	rgwMaxConnections := flag.Int("rgw-max-connections", 0, "The maximum number of concurrent RGW requests (zero keeps the Ceph default)")
+	s3User := flag.String("s3-user", "picoceph", "The id of the default S3 user (empty disables)")
+	swift := flag.Bool("swift", false, "Give the default S3 user a Swift subuser, for testing Swift clients")
+	rgwDNSName := flag.String("rgw-dns-name", "", "The domain buckets are served under, for virtual-hosted-style S3 addressing (eg. s3.localhost)")
+	dnsAddr := flag.String("dns-addr", "", "The UDP address to serve DNS on, resolving --rgw-dns-name and its bucket subdomains to RGW (empty disables)")
+	sts := flag.Bool("sts", false, "Enable the RGW Security Token Service, for testing clients that use temporary credentials")
+	stsRole := flag.String("sts-role", "picoceph", "The name of an STS role, that the default S3 user can assume, to provision (empty disables)")
+	buckets := flag.String("bucket", "", "Comma separated buckets to create, owned by the default S3 user")
+	seedDirs := flag.String("seed-dir", "", "Comma separated dir=bucket pairs of local directories to upload into buckets, owned by the default S3 user")
+	s3AdminUser := flag.String("s3-admin-user", "picoceph-admin", "The id of a user with access to the RGW admin API (empty disables)")
+	s3AccessKey := flag.String("s3-access-key", "", "A static access key for the default S3 user (defaults to a generated key)")
+	s3SecretKey := flag.String("s3-secret-key", "", "A static secret key for the default S3 user (defaults to a generated key)")
+	rgwProxyAddr := flag.String("rgw-proxy-addr", "", "The address of a reverse proxy in front of RGW, for injecting faults (empty disables)")
+	rgwProxyTLS := flag.Bool("rgw-proxy-tls", false, "Terminate TLS on the RGW proxy (with a self-signed certificate unless --rgw-proxy-tls-cert is set)")
+	rgwProxyTLSCert := flag.String("rgw-proxy-tls-cert", "", "The path of the RGW proxy TLS certificate")
+	rgwProxyTLSKey := flag.String("rgw-proxy-tls-key", "", "The path of the RGW proxy TLS private key")
+	rgwProxyLatency := flag.Duration("rgw-proxy-latency", 0, "Latency to add to every request through the RGW proxy")
+	rgwProxyDropPercent := flag.Float64("rgw-proxy-drop-percent", 0, "The percentage of requests through the RGW proxy to drop")
+	dashboardProxyAddr := flag.String("dashboard-proxy-addr", "", "The address of a reverse proxy in front of the dashboard, for injecting faults (empty disables)")
+	prometheusEnabled := flag.Bool("prometheus", false, "Enable the prometheus manager module, to export Ceph metrics")
+	prometheusAddr := flag.String("prometheus-addr", "", "The address metrics are served on (defaults to all addresses)")
+	prometheusPort := flag.Int("prometheus-port", prometheus.DefaultPort, "The port metrics are served on")
+	crashEnabled := flag.Bool("crash", true, "Run the ceph-crash agent, so that daemon crashes are reported by ceph crash ls")
+	telemetry := flag.Bool("telemetry", false, "Leave the telemetry manager module, and its health warnings, enabled")
+	exporterEnabled := flag.Bool("exporter", false, "Run ceph-exporter, to export the perf counters of every daemon (Reef and later)")
+	exporterSockDir := flag.String("exporter-sock-dir", exporter.DefaultSockDir, "The directory containing the admin sockets of the daemons, for ceph-exporter")
+	exporterPort := flag.Int("exporter-port", exporter.DefaultPort, "The port ceph-exporter serves metrics on")
+	nfsEnabled := flag.Bool("nfs", false, "Serve the buckets of the default S3 user over NFSv4, with NFS-Ganesha")
+	nfsPort := flag.Int("nfs-port", nfs.DefaultPort, "The port NFS is served on")
+	nfsBucket := flag.String("nfs-bucket", "", "The bucket to export over NFS (defaults to every bucket of the default S3 user)")
+	iscsiEnabled := flag.Bool("iscsi", false, "Export an RBD image as an iSCSI LUN, with ceph-iscsi")
+	iscsiIQN := flag.String("iscsi-iqn", iscsi.DefaultIQN, "The name of the iSCSI target")
+	iscsiImageSize := flag.Int64("iscsi-image-size-mib", iscsi.DefaultImageSize>>20, "The size of the RBD image exported over iSCSI, in MiB")
+	nvmeofEnabled := flag.Bool("nvmeof", false, "Export an RBD image over NVMe/TCP, with the Ceph NVMe-oF gateway")
+	nvmeofNQN := flag.String("nvmeof-nqn", nvmeof.DefaultNQN, "The name of the NVMe subsystem")
+	nvmeofPort := flag.Int("nvmeof-port", nvmeof.DefaultPort, "The port NVMe/TCP is served on")
+	nvmeofImageSize := flag.Int64("nvmeof-image-size-mib", nvmeof.DefaultImageSize>>20, "The size of the RBD image exported over NVMe/TCP, in MiB")
+	rbdMirrorEnabled := flag.Bool("rbd-mirror", false, "Run rbd-mirror, to replicate RBD images to and from peer clusters")
+	rbdMirrorPools := flag.String("rbd-mirror-pools", "", "Comma separated pools to create, and enable mirroring on (in image mode)")
+	cephFSMirrorEnabled := flag.Bool("cephfs-mirror", false, "Run cephfs-mirror, to replicate CephFS snapshots to peer clusters")
+	loadGenTarget := flag.String("loadgen", "", "Continuously write and read objects, through s3 or rados, to give dashboards something to show (empty disables)")
+	loadGenObjectSize := flag.Int64("loadgen-object-size-kib", 64, "The size of the objects written by the load generator, in KiB")
+	loadGenRate := flag.Float64("loadgen-rate", 10, "The number of operations per second of the load generator")
+	loadGenReadPercent := flag.Int("loadgen-read-percent", 50, "The percentage of load generator operations that are reads")
+	loadGenObjects := flag.Int("loadgen-objects", 100, "The number of distinct objects the load generator overwrites in turn")
+	restfulEnabled := flag.Bool("restful", false, "Enable the restful manager module, and create an API key")
+	restfulPort := flag.Int("restful-port", restful.DefaultPort, "The port the REST API is served on")
+	dashboardAddr := flag.String("dashboard-addr", "", "The address the dashboard binds to (defaults to all addresses)")
+	dashboardPort := flag.Int("dashboard-port", dashboard.DefaultPort, "The port the dashboard is served on")
+	dashboardUsername := flag.String("dashboard-username", "admin", "The name of the dashboard admin user")
+	dashboardPassword := flag.String("dashboard-password", "", "The password of the dashboard admin user (defaults to a random password)")
+	apiAddr := flag.String("api-addr", "127.0.0.1:7490", "The address to serve the HTTP API (eg. /healthz, /readyz and /status) on, it isn't authenticated so pass eg. :7490 to expose it (empty disables)")
+	controlSocket := flag.String("control-socket", defaultControlSocket, "The path of a unix socket to also serve the HTTP API on (empty disables)")
+	skipPreflight := flag.Bool("skip-preflight", false, "Don't fail if the preflight checks do")
+	force := flag.Bool("force", false, "Run even if a conflicting Ceph installation is found")
+	adoptCluster := flag.Bool("adopt", false, "Supervise the daemons of an existing cluster (matching /etc/ceph/ceph.conf) rather than bootstrapping a new one")
+	joinSpec := flag.String("join", "", "Only run an OSD, adding it to the cluster of another instance (eg. \"mon-host=10.0.0.2 osd-id=1\")")
+	bootstrapOSDKey := flag.String("bootstrap-osd-key", "", "The client.bootstrap-osd key of the cluster to --join")
+	flag.Parse()
+
+	var logLevel slog.LevelVar
+	if err := logLevel.UnmarshalText([]byte(*logLevelName)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		tempfile.Exit(1)
+	}
 
-	cephUserUid, cephGroupGid, err := ceph.User()
+	logHandler, err := newLogHandler(*logFormat, &logLevel)
 	if err != nil {
-		logger.Error("Could not get ceph user", "error", err)
-		os.Exit(1)
+		fmt.Fprintln(os.Stderr, err)
+		tempfile.Exit(1)
+	}
+
+	var recentLogs *logring.Buffer
+	if *logRetention > 0 {
+		recentLogs = logring.NewBuffer(*logRetention)
+		logHandler = recentLogs.Handler(logHandler)
+	}
+
+	resolved, conflicts := resolve.Resolve(resolve.Options{
+		OSDBackend:         osd.Backend(*osdBackendName),
+		OSDBackendExplicit: isFlagSet("osd-backend"),
+		OSDEncrypted:       *osdEncrypted,
+		OSDDeviceClass:     *osdDeviceClass,
+		OSDDevice:          *osdDevice,
+		OSDRaw:             *osdRaw,
+		OSDDBSizeMiB:       *osdDBSizeMiB,
+		OSDWALSizeMiB:      *osdWALSizeMiB,
+		Storage:            osd.Storage(*osdStorageName),
+		DataDir:            *dataDir,
+		Adopt:              *adoptCluster,
+		FSID:               *fsidFlag,
+		Seed:               *seedFlag,
+		RecordCommands:     *recordCommands,
+		ReplayCommands:     *replayCommands,
+		S3User:             *s3User,
+		Swift:              *swift,
+		PrimaryURL:         *rgwPrimaryURL,
+		K8sStatefulSet:     *k8sStatefulSet,
+		IPv6:               *ipv6,
+		MsgrSecure:         *msgrSecure,
+		PublicAddr:         *publicAddr,
+		Join:               *joinSpec,
+		BootstrapOSDKey:    *bootstrapOSDKey,
+		DNSAddr:            *dnsAddr,
+		LoadGen:            *loadGenTarget,
+		RGWDNSName:         *rgwDNSName,
+		VirtualHosts:       *virtualHostsSpec,
+	}, resolve.DetectHost())
+
+	for _, c := range conflicts {
+		if c.Fatal {
+			slog.New(logHandler).Error("Conflicting options", "options", c.Options, "message", c.Message)
+		} else {
+			slog.New(logHandler).Warn("Conflicting options", "options", c.Options, "message", c.Message, "resolution", c.Resolution)
+		}
+	}
+
+	if resolve.Fatal(conflicts, *skipPreflight) {
+		tempfile.Exit(1)
 	}
 
-	for _, dir := range []string{"/etc/ceph", "/var/lib/ceph", "/var/log/ceph"} {
-		if err := os.MkdirAll(dir, 0o755); err != nil {
-			logger.Error("Could not create directory", "error", err)
-			os.Exit(1)
+	*osdBackendName = string(resolved.OSDBackend)
+	*osdStorageName = string(resolved.Storage)
+	*dataDir = resolved.DataDir
+	*seedFlag = resolved.Seed
+	*swift = resolved.Swift
+	*rgwPrimaryURL = resolved.PrimaryURL
+	*k8sStatefulSet = resolved.K8sStatefulSet
+	*dnsAddr = resolved.DNSAddr
+	*loadGenTarget = resolved.LoadGen
+	*publicAddr = resolved.PublicAddr
+	*msgrSecure = resolved.MsgrSecure
+	*osdEncrypted = resolved.OSDEncrypted
+	*osdDeviceClass = resolved.OSDDeviceClass
+	*osdDevice = resolved.OSDDevice
+	*osdRaw = resolved.OSDRaw
+	*osdDBSizeMiB = resolved.OSDDBSizeMiB
+	*osdWALSizeMiB = resolved.OSDWALSizeMiB
+	*virtualHostsSpec = resolved.VirtualHosts
+
+	seed.Set(*seedFlag)
+
+	if *recordCommands != "" {
+		if err := command.RecordTo(*recordCommands); err != nil {
+			fmt.Fprintf(os.Stderr, "could not record commands: %v\n", err)
+			tempfile.Exit(1)
 		}
+	}
 
-		if err := os.Chown(dir, cephUserUid, cephGroupGid); err != nil {
-			logger.Error("Could not change owner", "error", err)
-			os.Exit(1)
+	if *replayCommands != "" {
+		if err := command.ReplayFrom(*replayCommands); err != nil {
+			fmt.Fprintf(os.Stderr, "could not replay commands: %v\n", err)
+			tempfile.Exit(1)
 		}
 	}
 
-	logger.Info("Writing ceph.conf")
+	// Daemons inherit the instance (empty for the unnamed instance), so that
+	// the preflight checks of other instances can tell them apart from the
+	// daemons of a Ceph installation.
+	_ = os.Setenv(preflight.InstanceEnv, *instance)
+
+	if *instance != "" {
+		if err := validateInstance(*instance); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			tempfile.Exit(1)
+		}
+
+		if !isFlagSet("control-socket") {
+			*controlSocket = instanceControlSocket(*instance)
+		}
+
+		if *dataDir == "" {
+			*dataDir = instanceDataDir(*instance)
 
-	fsid := uuid.New().String()
+			if osd.Storage(*osdStorageName) == osd.StorageEphemeral {
+				if err := resetEphemeralDataDir(*dataDir); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					tempfile.Exit(1)
+				}
+			}
+		}
+	}
 
-	if err := ceph.WriteConfig(fsid); err != nil {
-		logger.Error("Could not write ceph.conf", "error", err)
-		os.Exit(1)
+	if *portOffset < 0 {
+		fmt.Fprintln(os.Stderr, "invalid port offset, must not be negative")
+		tempfile.Exit(1)
 	}
 
-	g, ctx := errgroup.WithContext(ctx)
+	if err := applyPortOffset(*portOffset); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		tempfile.Exit(1)
+	}
 
-	components := []ceph.Component{
-		monitor.New("a", fsid),
-		manager.New("a"),
-		osd.New("0"),
-		radosgw.New(),
-		dashboard.New(),
+	// Keep the state of a replayed cluster off the host.
+	if *replayCommands != "" && *dataDir == "" {
+		fmt.Fprintln(os.Stderr, "replaying commands needs --data-dir (or --instance), so that the state of the replayed cluster is kept off the host")
+		tempfile.Exit(1)
 	}
 
-	for _, cmp := range components {
-		cmp := cmp
+	if *dataDir != "" {
+		if err := datadir.Enter(*dataDir); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			tempfile.Exit(1)
+		}
+	}
 
-		g.Go(func() error {
-			logger.Info("Configuring", "component", cmp.Name())
+	// Fail fast, rather than racing another process on mkfs and device
+	// allocation.
+	lock, err := lockfile.Acquire(lockfile.Path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		tempfile.Exit(1)
+	}
+	defer func() { _ = lock.Release() }()
+
+	// An ephemeral cluster starts from scratch every time, as its OSD data
+	// was lost when it exited.
+	if osd.Storage(*osdStorageName) == osd.StorageEphemeral {
+		if err := resetEphemeralState(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			tempfile.Exit(1)
+		}
+	}
+
+	var joinOpts *join.Options
+	if *joinSpec != "" {
+		joinOpts, err = join.Parse(*joinSpec)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			tempfile.Exit(1)
+		}
+
+		if err := join.CheckKey(*bootstrapOSDKey); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			tempfile.Exit(1)
+		}
+	}
+
+	// Reuse the cluster from a previous run, if there is one.
+	fsid, err := ceph.ReadFSID()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		tempfile.Exit(1)
+	}
+
+	if *adoptCluster {
+		confFSID := ceph.ConfiguredFSID()
+		if confFSID == "" {
+			fmt.Fprintln(os.Stderr, "could not adopt cluster: no fsid found in /etc/ceph/ceph.conf")
+			tempfile.Exit(1)
+		}
+
+		if fsid != "" && fsid != confFSID {
+			fmt.Fprintf(os.Stderr, "could not adopt cluster: fsid %s does not match %s\n", confFSID, fsid)
+			tempfile.Exit(1)
+		}
+
+		fsid = confFSID
+	}
+
+	existing := fsid != ""
+
+	if *fsidFlag != "" {
+		parsed, err := uuid.Parse(*fsidFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid fsid: %v\n", err)
+			tempfile.Exit(1)
+		}
+
+		if existing && fsid != parsed.String() {
+			fmt.Fprintf(os.Stderr, "fsid %s does not match the existing cluster (%s)\n", parsed, fsid)
+			tempfile.Exit(1)
+		}
+
+		fsid = parsed.String()
+	} else if !existing && joinOpts != nil {
+		// A joined OSD belongs to the cluster of the monitors.
+		fsid, err = joinOpts.FSID(ctx, *bootstrapOSDKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not join cluster: %v\n", err)
+			tempfile.Exit(1)
+		}
+	} else if !existing && *seedFlag != "" {
+		fsid = seed.UUID("fsid").String()
+	} else if !existing {
+		fsid = uuid.New().String()
+	}
+
+	logger := slog.New(logHandler).With("fsid", fsid)
+
+	if *seedFlag != "" {
+		logger.Warn("Deriving keys and credentials from --seed, anyone who knows the seed can access the cluster (INSECURE)")
+	}
+
+	if existing {
+		logger.Info("Reusing existing cluster")
+	}
+
+	if *monCount < 1 || *monCount > maxMonCount || *monCount%2 == 0 {
+		logger.Error("Invalid monitor count, must be an odd number no greater than 5", "monCount", *monCount)
+		tempfile.Exit(1)
+	}
+
+	if *publicAddr != "" && net.ParseIP(*publicAddr) == nil {
+		logger.Error("Invalid public address", "publicAddr", *publicAddr)
+		tempfile.Exit(1)
+	}
+
+	if *bindAddr != "" && net.ParseIP(*bindAddr) == nil {
+		logger.Error("Invalid bind address", "bindAddr", *bindAddr)
+		tempfile.Exit(1)
+	}
+
+	for _, addr := range []string{*publicAddr, *bindAddr} {
+		if addr != "" && (net.ParseIP(addr).To4() == nil) != *ipv6 {
+			logger.Error("Address family does not match the cluster (see --ipv6)", "addr", addr)
+			tempfile.Exit(1)
+		}
+	}
 
-			if err := cmp.Configure(ctx); err != nil {
-				return fmt.Errorf("could not configure component: %w", err)
+	if *ipv6 {
+		// Services otherwise bind to all IPv4 addresses.
+		for _, addr := range []*string{rgwAddr, dashboardAddr, prometheusAddr} {
+			if *addr == "" {
+				*addr = "::"
 			}
+		}
+	}
 
-			// Start echoing logs from the component.
-			go func() {
-				t, err := cmp.Logs()
-				if err != nil {
-					logger.Error("Could not tail logs", "error", err)
-					return
-				}
-				defer t.Cleanup()
+	if *mgrStandbys < 0 || *mgrStandbys > maxMgrStandbys {
+		logger.Error("Invalid standby manager count, must be no greater than 4", "mgrStandbys", *mgrStandbys)
+		tempfile.Exit(1)
+	}
+
+	go watchLogLevelSignals(ctx, logger, &logLevel)
+
+	p := platform.Detect(ctx)
+	if p.Degraded() {
+		logger.Warn("Running in a nested virtualization environment, using degraded defaults",
+			"environment", p.Environment, "osdBackend", p.OSDBackend, "readyTimeout", p.ReadyTimeout)
+	}
+
+	for _, note := range p.Notes {
+		logger.Info("Adjusted defaults for platform", "arch", p.Arch, "reason", note)
+	}
+
+	// Only override the OSD backend if the user hasn't explicitly chosen one.
+	if !isFlagSet("osd-backend") {
+		*osdBackendName = string(p.OSDBackend)
+	}
+
+	osdBackend, err := osd.ParseBackend(*osdBackendName)
+	if err != nil {
+		logger.Error("Invalid OSD backend", "error", err)
+		tempfile.Exit(1)
+	}
+
+	osdStorage, err := osd.ParseStorage(*osdStorageName)
+	if err != nil {
+		logger.Error("Invalid OSD storage type", "error", err)
+		tempfile.Exit(1)
+	}
+
+	if *osdSizeGiB<<30 < osd.MinImageSize {
+		logger.Error("Invalid OSD size, must be at least 2 GiB", "osdSizeGiB", *osdSizeGiB)
+		tempfile.Exit(1)
+	}
+
+	for _, size := range []int64{*osdDBSizeMiB, *osdWALSizeMiB} {
+		if size != 0 && size < 64 {
+			logger.Error("Invalid OSD DB or WAL size, must be zero or at least 64 MiB", "sizeMiB", size)
+			tempfile.Exit(1)
+		}
+	}
+
+	if *osdDeviceClass != "" {
+		if err := osd.ValidateDeviceClass(*osdDeviceClass); err != nil {
+			logger.Error("Invalid OSD device class", "error", err)
+			tempfile.Exit(1)
+		}
+	}
+
+	crushLocation, err := ceph.ParseCrushLocation(*crushLocationSpec)
+	if err != nil {
+		logger.Error("Invalid CRUSH location", "error", err)
+		tempfile.Exit(1)
+	}
+
+	virtualHosts, err := ceph.ParseVirtualHosts(*virtualHostsSpec, maxVirtualHostOSDs)
+	if err != nil {
+		logger.Error("Invalid virtual hosts", "error", err)
+		tempfile.Exit(1)
+	}
+
+	confTemplate, err := ceph.ParseConfigTemplate(*confTemplateName)
+	if err != nil {
+		logger.Error("Invalid ceph.conf template", "error", err)
+		tempfile.Exit(1)
+	}
+
+	poolOverrides, err := pools.ParseOverrides(*poolApplications)
+	if err != nil {
+		logger.Error("Invalid pool applications", "error", err)
+		tempfile.Exit(1)
+	}
+
+	rgwTLSOptions := radosgw.TLSOptions{
+		Enabled:  *rgwTLS || *rgwTLSCert != "",
+		Port:     *rgwTLSPort,
+		CertFile: *rgwTLSCert,
+		KeyFile:  *rgwTLSKey,
+	}
+
+	rgwFrontend, err := radosgw.ParseFrontend(*rgwFrontendName)
+	if err != nil {
+		logger.Error("Invalid RGW frontend", "error", err)
+		tempfile.Exit(1)
+	}
+
+	conf := &config.Config{}
+	if *configPath != "" {
+		conf, err = config.Load(*configPath)
+		if err != nil {
+			logger.Error("Could not load config", "error", err)
+			tempfile.Exit(1)
+		}
+	}
+
+	findings := preflight.Run(ctx)
+	for _, f := range findings {
+		switch f.Status {
+		case preflight.StatusFail:
+			logger.Error("Preflight check failed", "check", f.Check, "message", f.Message, "remediation", f.Remediation)
+		case preflight.StatusWarn:
+			logger.Warn("Preflight check warning", "check", f.Check, "message", f.Message, "remediation", f.Remediation)
+		}
+	}
+
+	var ignoredChecks []string
+	if *force || *adoptCluster {
+		ignoredChecks = append(ignoredChecks, preflight.CheckConflictingCeph)
+	}
+
+	if preflight.Failed(findings, ignoredChecks...) && !*skipPreflight {
+		tempfile.Exit(1)
+	}
+
+	if *auditLogPath != "" {
+		if err := audit.Open(*auditLogPath); err != nil {
+			logger.Error("Could not open audit log", "error", err)
+			tempfile.Exit(1)
+		}
+		defer audit.Close()
+	}
+
+	if *umask != "" {
+		mask, err := strconv.ParseUint(*umask, 8, 32)
+		if err != nil {
+			logger.Error("Invalid umask", "error", err)
+			tempfile.Exit(1)
+		}
+
+		audit.Record("set umask", "umask", fmt.Sprintf("%04o", mask))
+
+		syscall.Umask(int(mask))
+	}
+
+	mode, err := strconv.ParseUint(*dirMode, 8, 32)
+	if err != nil || mode > 0o777 {
+		logger.Error("Invalid directory mode", "mode", *dirMode)
+		tempfile.Exit(1)
+	}
+
+	ceph.DirMode = os.FileMode(mode)
+	if *setgidDirs {
+		ceph.DirMode |= os.ModeSetgid
+	}
+
+	var clock *faketime.Clock
+	if *fakeTime != "" {
+		clock, err = faketime.New(*fakeTime)
+		if err != nil {
+			logger.Warn("Not running daemons under libfaketime", "error", err)
+		} else {
+			logger.Info("Running daemons under libfaketime", "spec", *fakeTime)
+
+			daemon.SetEnv(clock.Env())
+		}
+	}
+
+	monMap := monmap.New(fsid)
+	// The monitors, manager and OSD run here, which differ between the pods
+	// of a StatefulSet.
+	var monIDs []string
+	mgrID, osdID, osdCount, publicNetwork := "a", "0", 1, ""
+	if *k8sStatefulSet {
+		pod, err := k8s.CurrentPod(*k8sService)
+		if err != nil {
+			logger.Error("Could not get StatefulSet pod", "error", err)
+			tempfile.Exit(1)
+		}
+
+		if pod.Ordinal >= *monCount {
+			logger.Error("Every pod of the StatefulSet runs a monitor, so --mon-count must be its number of replicas",
+				"pod", pod.Name(), "monCount", *monCount)
+			tempfile.Exit(1)
+		}
+
+		logger.Info("Resolving StatefulSet pods", "statefulSet", pod.StatefulSet, "service", pod.Service, "replicas", *monCount)
+
+		resolveCtx, cancel := context.WithTimeout(ctx, p.ReadyTimeout)
+		addrs, err := pod.ResolvePeers(resolveCtx, *monCount)
+		cancel()
+		if err != nil {
+			logger.Error("Could not resolve StatefulSet pods", "error", err)
+			tempfile.Exit(1)
+		}
+
+		for i, addr := range addrs {
+			if err := monMap.Add(pod.Peer(i), addr); err != nil {
+				logger.Error("Could not create monmap", "error", err)
+				tempfile.Exit(1)
+			}
+		}
 
-				for line := range t.Lines {
-					logger.Info(line.Text, "component", cmp.Name())
+		monIDs = []string{pod.Name()}
+		mgrID = pod.Name()
+		osdID = strconv.Itoa(pod.Ordinal)
+		osdCount = *monCount
+		publicNetwork = addrs[pod.Ordinal] + "/32"
+	} else if joinOpts != nil {
+		monMap, err = joinOpts.MonMap(ctx, fsid)
+		if err != nil {
+			logger.Error("Could not create monmap", "error", err)
+			tempfile.Exit(1)
+		}
+
+		if *publicAddr != "" {
+			publicNetwork = util.HostNetwork(net.ParseIP(*publicAddr))
+		} else if publicNetwork, err = join.PublicNetwork(monMap); err != nil {
+			logger.Error("Could not find public network", "error", err)
+			tempfile.Exit(1)
+		}
+
+		osdID = joinOpts.OSDID
+	} else {
+		monAddr := "127.0.0.1"
+		if *ipv6 {
+			monAddr = "::1"
+		}
+
+		if *publicAddr != "" {
+			monAddr = *publicAddr
+		}
+
+		publicNetwork = util.HostNetwork(net.ParseIP(monAddr))
+
+		// Each monitor listens on its own ports, counting up from the defaults.
+		for i := 0; i < *monCount; i++ {
+			id := string(rune('a' + i))
+			if err := monMap.AddWithPorts(id, monAddr, monmap.DefaultV2Port+*portOffset+i, monmap.DefaultV1Port+*portOffset+i); err != nil {
+				logger.Error("Could not create monmap", "error", err)
+				tempfile.Exit(1)
+			}
+
+			monIDs = append(monIDs, id)
+		}
+
+		if len(virtualHosts) > 0 {
+			osdCount = 0
+			for _, host := range virtualHosts {
+				osdCount += len(host.OSDIDs)
+			}
+		}
+	}
+
+	// Standby managers are "b", "c" and so on (prefixed with the pod name in
+	// a StatefulSet).
+	mgrIDs := []string{mgrID}
+	for i := 1; i <= *mgrStandbys; i++ {
+		id := string(rune('a' + i))
+		if *k8sStatefulSet {
+			id = mgrID + "-" + id
+		}
+
+		mgrIDs = append(mgrIDs, id)
+	}
+
+	// Monitors can't be added to (or removed from) an existing monmap by
+	// rewriting ceph.conf.
+	if ids := monitor.Existing(); existing && !*adoptCluster && len(ids) > 0 && len(ids) != len(monIDs) {
+		logger.Error("The monitor count of an existing cluster can't be changed (run picoceph purge to start over)",
+			"monCount", *monCount, "existing", len(ids))
+		tempfile.Exit(1)
+	}
+
+	osdOpts := osd.Options{Backend: osdBackend, Storage: osdStorage, Discard: *discard, Instance: *instance, ImageSize: *osdSizeGiB << 30, DBSize: *osdDBSizeMiB << 20, WALSize: *osdWALSizeMiB << 20, Encrypted: *osdEncrypted, DeviceClass: *osdDeviceClass, Device: *osdDevice, Raw: *osdRaw}
+
+	// OSDs can be added while running (see POST /osds) to clusters that
+	// picoceph looks after, and which have room for more than one image.
+	canAddOSDs := !*adoptCluster && joinOpts == nil && !*k8sStatefulSet && osdStorage != osd.StorageEphemeral
+
+	osdOverrides := make(map[string]config.OSD)
+	maps.Copy(osdOverrides, conf.OSDs)
+
+	var addedOSDs map[string]config.OSD
+	if canAddOSDs {
+		addedOSDs, err = loadAddedOSDs()
+		if err != nil {
+			logger.Error("Could not load added OSDs", "error", err)
+			tempfile.Exit(1)
+		}
+
+		maps.Copy(osdOverrides, addedOSDs)
+	}
+
+	var components []ceph.Component
+	if *adoptCluster {
+		logger.Info("Adopting existing cluster")
+
+		components, err = adopt.Discover(ctx, logger, fsid)
+		if err != nil {
+			logger.Error("Could not adopt existing cluster", "error", err)
+			tempfile.Exit(1)
+		}
+	} else if joinOpts != nil {
+		logger.Info("Joining existing cluster", "monHost", monMap.Hosts(), "osd", osdID)
+
+		components, err = joinCluster(ctx, logger, joinOptions{
+			fsid:          fsid,
+			existing:      existing,
+			monMap:        monMap,
+			osdID:         osdID,
+			publicNetwork: publicNetwork,
+			ipv6:          *ipv6,
+			msgrSecure:    *msgrSecure,
+			platform:      p,
+			crushLocation: crushLocation,
+			confTemplate:  confTemplate,
+			key:           *bootstrapOSDKey,
+			osd:           osdOpts,
+			osdOverrides:  osdOverrides,
+		})
+		if err != nil {
+			logger.Error("Could not join cluster", "error", err)
+			tempfile.Exit(1)
+		}
+	} else {
+		secondary := radosgw.SecondaryOptions{
+			PrimaryURL: *rgwPrimaryURL,
+			AccessKey:  *rgwPrimaryAccessKey,
+			SecretKey:  *rgwPrimarySecretKey,
+			Realm:      *rgwRealm,
+			ZoneGroup:  *rgwZoneGroup,
+			Zone:       *rgwZone,
+			Endpoint:   *rgwZoneEndpoint,
+		}
+
+		var dashboardRGWOptions dashboard.RGWOptions
+		if secondary.Enabled() {
+			// Users are created on the primary zone, and synced from there.
+			if *s3User != "" || *s3AdminUser != "" {
+				logger.Info("Not creating S3 users in a secondary zone")
+				*s3User = ""
+				*s3AdminUser = ""
+			}
+
+			// As are roles.
+			*stsRole = ""
+
+			dashboardRGWOptions.Key = &radosgw.Key{AccessKey: secondary.AccessKey, SecretKey: secondary.SecretKey}
+		}
+
+		var s3AdminUserOptions *radosgw.UserOptions
+		if *s3AdminUser != "" {
+			s3AdminUserOptions = &radosgw.UserOptions{UID: *s3AdminUser, DisplayName: "picoceph admin"}
+		}
+
+		var s3UserOptions *radosgw.UserOptions
+		if *s3User != "" {
+			s3UserOptions = &radosgw.UserOptions{
+				UID:         *s3User,
+				DisplayName: "picoceph",
+				AccessKey:   *s3AccessKey,
+				SecretKey:   *s3SecretKey,
+			}
+		}
+
+		var stsRoleOptions *radosgw.RoleOptions
+		if *sts && *stsRole != "" {
+			stsRoleOptions = &radosgw.RoleOptions{Name: *stsRole, TrustedUser: *s3User}
+		}
+
+		var bucketNames []string
+		if *buckets != "" {
+			if *s3User == "" {
+				logger.Warn("Not creating buckets without a default S3 user")
+			} else {
+				bucketNames = strings.Split(*buckets, ",")
+			}
+		}
+
+		uploads, err := radosgw.ParseUploads(*seedDirs)
+		if err != nil {
+			logger.Error("Could not parse directories to upload", "error", err)
+			tempfile.Exit(1)
+		}
+
+		if len(uploads) > 0 && *s3User == "" {
+			logger.Warn("Not uploading directories without a default S3 user")
+			uploads = nil
+		}
+
+		var nfsOptions *nfs.Options
+		if *nfsEnabled {
+			if *s3User == "" {
+				logger.Warn("Not serving NFS without a default S3 user")
+			} else {
+				nfsOptions = &nfs.Options{Addr: *rgwAddr, Port: *nfsPort, Bucket: *nfsBucket}
+			}
+		}
+
+		var iscsiOptions *iscsi.Options
+		if *iscsiEnabled {
+			iscsiOptions = &iscsi.Options{IQN: *iscsiIQN, ImageSize: *iscsiImageSize << 20}
+		}
+
+		var nvmeofOptions *nvmeof.Options
+		if *nvmeofEnabled {
+			nvmeofOptions = &nvmeof.Options{NQN: *nvmeofNQN, Port: *nvmeofPort, ImageSize: *nvmeofImageSize << 20}
+		}
+
+		var rbdMirrorOptions *rbdmirror.Options
+		if *rbdMirrorEnabled {
+			rbdMirrorOptions = &rbdmirror.Options{Caps: conf.Caps["rbd-mirror"]}
+			for _, pool := range strings.Split(*rbdMirrorPools, ",") {
+				if pool = strings.TrimSpace(pool); pool != "" {
+					rbdMirrorOptions.Pools = append(rbdMirrorOptions.Pools, pool)
 				}
-			}()
+			}
+		}
+
+		var cephFSMirrorOptions *cephfsmirror.Options
+		if *cephFSMirrorEnabled {
+			cephFSMirrorOptions = &cephfsmirror.Options{Caps: conf.Caps["cephfs-mirror"]}
+		}
+
+		var crashOptions *crash.Options
+		if *crashEnabled {
+			crashOptions = &crash.Options{Caps: conf.Caps["crash"]}
+		}
+
+		var dnsOptions *dns.Options
+		if *dnsAddr != "" {
+			ip := util.HostIP()
+			if addr := net.ParseIP(*rgwAddr); addr != nil && !addr.IsUnspecified() {
+				ip = *rgwAddr
+			}
+
+			dnsOptions = &dns.Options{Addr: *dnsAddr, Domain: *rgwDNSName, IP: ip}
+		}
+
+		var loadGenOptions *loadgen.Options
+		if *loadGenTarget != "" {
+			target, err := loadgen.ParseTarget(*loadGenTarget)
+			if err != nil {
+				logger.Error("Invalid load generator target", "error", err)
+				tempfile.Exit(1)
+			}
+
+			loadGenOptions = &loadgen.Options{
+				Target:      target,
+				ObjectSize:  *loadGenObjectSize << 10,
+				Rate:        *loadGenRate,
+				ReadPercent: *loadGenReadPercent,
+				Objects:     *loadGenObjects,
+			}
+		}
+
+		var restfulOptions *restful.Options
+		if *restfulEnabled {
+			restfulOptions = &restful.Options{Addr: *dashboardAddr, Port: *restfulPort}
+		}
 
-			logger.Info("Starting", "component", cmp.Name())
+		var exporterOptions *exporter.Options
+		if *exporterEnabled {
+			exporterOptions = &exporter.Options{
+				Caps:    conf.Caps["exporter"],
+				Addr:    *prometheusAddr,
+				Port:    *exporterPort,
+				SockDir: *exporterSockDir,
+			}
+		}
+
+		var prometheusOptions *prometheus.Options
+		if *prometheusEnabled {
+			prometheusOptions = &prometheus.Options{
+				Addr:    *prometheusAddr,
+				Port:    *prometheusPort,
+				Profile: prometheus.Profile{Monitors: len(monMap.Monitors), OSDs: osdCount, Degraded: p.Degraded()},
+			}
 
-			if err := cmp.Start(ctx); err != nil {
-				return fmt.Errorf("could not start component: %w", err)
+			if exporterOptions != nil {
+				prometheusOptions.ExporterEndpoint = exporterOptions.Endpoint()
 			}
+		}
 
-			return nil
+		components, err = bootstrap(ctx, logger, bootstrapOptions{
+			fsid:          fsid,
+			existing:      existing,
+			monMap:        monMap,
+			monIDs:        monIDs,
+			mgrIDs:        mgrIDs,
+			osdID:         osdID,
+			virtualHosts:  virtualHosts,
+			publicNetwork: publicNetwork,
+			bindAddr:      *bindAddr,
+			ipv6:          *ipv6,
+			msgrSecure:    *msgrSecure,
+			platform:      p,
+			crushLocation: crushLocation,
+			confTemplate:  confTemplate,
+			manager:       manager.Options{Caps: conf.Caps["mgr"], Telemetry: *telemetry},
+			osd:           osdOpts,
+			osdOverrides:  osdOverrides,
+			addedOSDIDs:   sortedOSDIDs(addedOSDs),
+			radosgw: radosgw.Options{
+				Caps:      conf.Caps["rgw"],
+				Addr:      *rgwAddr,
+				Port:      *rgwPort,
+				TLS:       rgwTLSOptions,
+				Secondary: secondary,
+				STS:       *sts,
+				DNSName:   *rgwDNSName,
+				Frontend: radosgw.FrontendOptions{
+					Frontend:       rgwFrontend,
+					Threads:        *rgwThreads,
+					RequestTimeout: *rgwRequestTimeout,
+					MaxConnections: *rgwMaxConnections,
+				},
+			},
+			dashboard: dashboard.Options{
+				Addr:          *dashboardAddr,
+				Port:          *dashboardPort,
+				AdminUsername: *dashboardUsername,
+				AdminPassword: *dashboardPassword,
+			},
+			dashboardRGW: dashboardRGWOptions,
+			prometheus:   prometheusOptions,
+			exporter:     exporterOptions,
+			restful:      restfulOptions,
+			dns:          dnsOptions,
+			loadGen:      loadGenOptions,
+			nfs:          nfsOptions,
+			iscsi:        iscsiOptions,
+			nvmeof:       nvmeofOptions,
+			rbdMirror:    rbdMirrorOptions,
+			cephFSMirror: cephFSMirrorOptions,
+			crash:        crashOptions,
+			s3User:       s3UserOptions,
+			s3AdminUser:  s3AdminUserOptions,
+			swift:        *swift,
+			stsRole:      stsRoleOptions,
+			buckets:      bucketNames,
+			uploads:      uploads,
 		})
+		if err != nil {
+			logger.Error("Could not bootstrap cluster", "error", err)
+			tempfile.Exit(1)
+		}
 	}
 
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
-	go func() {
-		<-sigCh
+	for _, spec := range conf.Components {
+		components = append(components, custom.New(logger, spec))
+	}
 
-		logger.Info("Shutting down")
+	rgwEndpoint := radosgw.Options{Addr: *rgwAddr, Port: *rgwPort}.Endpoint()
+	dashboardEndpoint := "http://" + util.LocalAddr(net.JoinHostPort(*dashboardAddr, strconv.Itoa(*dashboardPort)))
 
-		cancel()
-	}()
+	rgwFaults := fault.Policy{Latency: fault.Duration(*rgwProxyLatency)}
+	if *rgwProxyDropPercent > 0 {
+		rgwFaults.Action = fault.ActionDrop
+		rgwFaults.Percent = *rgwProxyDropPercent
+	}
+
+	proxies, faults, proxyEndpoints, err := newProxies(logger,
+		proxySpec{
+			name:     "rgw.proxy",
+			requires: []string{"rgw"},
+			opts: proxy.Options{
+				Addr:        *rgwProxyAddr,
+				Upstream:    rgwEndpoint,
+				TLS:         *rgwProxyTLS,
+				TLSCertFile: *rgwProxyTLSCert,
+				TLSKeyFile:  *rgwProxyTLSKey,
+			},
+			policy: rgwFaults,
+		},
+		proxySpec{
+			name:     "dashboard.proxy",
+			requires: []string{"dashboard"},
+			opts:     proxy.Options{Addr: *dashboardProxyAddr, Upstream: dashboardEndpoint},
+		},
+	)
+	if err != nil {
+		logger.Error("Could not create proxies", "error", err)
+		tempfile.Exit(1)
+	}
+
+	components = append(components, proxies...)
+
+	stopTimeoutOverrides, err := orchestrator.ParseStopTimeouts(*stopTimeouts)
+	if err != nil {
+		logger.Error("Could not parse stop timeouts", "error", err)
+		tempfile.Exit(1)
+	}
+
+	o, err := orchestrator.New(logger, orchestrator.Options{
+		ReadyTimeout:   p.ReadyTimeout,
+		StopTimeout:    *stopTimeout,
+		StopTimeouts:   stopTimeoutOverrides,
+		MaxRestarts:    *maxRestarts,
+		RestartBackoff: *restartBackoff,
+		Logs:           recentLogs,
+	}, components...)
+	if err != nil {
+		logger.Error("Could not create orchestrator", "error", err)
+		tempfile.Exit(1)
+	}
 
-	if err := g.Wait(); err != nil && !errors.Is(err, context.Canceled) {
+	// A joined instance has no admin keyring, the instance it joined looks
+	// after the cluster.
+	if *healthInterval > 0 && joinOpts == nil {
+		go health.NewWatcher(logger, *healthInterval).Run(ctx)
+		go pools.NewTagger(logger, *healthInterval, poolOverrides).Run(ctx)
+	}
+
+	if *discard && *trimInterval > 0 && !*adoptCluster && joinOpts == nil {
+		go osd.NewTrimmer(logger, *trimInterval).Run(ctx)
+	}
+
+	if *diskUsageInterval > 0 && !*adoptCluster {
+		go diskusage.NewMonitor(logger, diskusage.Options{
+			Interval:    *diskUsageInterval,
+			WarnPercent: *diskWarnPercent,
+			MaxBytes:    *diskCap << 20,
+		}).Run(ctx)
+	}
+
+	if *apiAddr != "" || *controlSocket != "" {
+		conn := api.Connection{
+			ConfigPath:       "/etc/ceph/ceph.conf",
+			AdminKeyringPath: auth.AdminKeyringPath,
+		}
+
+		if !*adoptCluster && joinOpts == nil {
+			for _, mon := range monMap.Monitors {
+				conn.MonAddrs = append(conn.MonAddrs, mon.AddrVec())
+			}
+
+			conn.DashboardCredentialsPath = dashboard.CredentialsPath
+			if *restfulEnabled {
+				conn.RestfulCredentialsPath = restful.CredentialsPath
+			}
+			if *s3User != "" {
+				conn.S3CredentialsPath = radosgw.CredentialsPath
+			}
+			if *s3AdminUser != "" {
+				conn.S3AdminCredentialsPath = radosgw.AdminCredentialsPath
+			}
+		}
+
+		endpoints := map[string]string{
+			"mon":       monMap.Hosts(),
+			"rgw":       rgwEndpoint,
+			"dashboard": dashboardEndpoint,
+		}
+
+		if *swift && *s3User != "" && !*adoptCluster {
+			endpoints["swift"] = rgwEndpoint + radosgw.SwiftAuthPath
+		}
+
+		if *s3AdminUser != "" && !*adoptCluster {
+			endpoints["rgw.admin"] = rgwEndpoint + "/admin"
+		}
+
+		if *prometheusEnabled && !*adoptCluster {
+			endpoints["prometheus"] = prometheus.Options{Addr: *prometheusAddr, Port: *prometheusPort}.Endpoint()
+		}
+
+		if *nfsEnabled && *s3User != "" && !*adoptCluster {
+			endpoints["nfs"] = nfs.Options{Addr: *rgwAddr, Port: *nfsPort}.Endpoint()
+		}
+
+		if *iscsiEnabled && !*adoptCluster {
+			endpoints["iscsi"] = iscsi.Options{}.Endpoint()
+		}
+
+		if *nvmeofEnabled && !*adoptCluster {
+			endpoints["nvmeof"] = nvmeof.Options{Port: *nvmeofPort}.Endpoint()
+		}
+
+		if *restfulEnabled && !*adoptCluster {
+			endpoints["restful"] = "https://" + util.LocalAddr(net.JoinHostPort(*dashboardAddr, strconv.Itoa(*restfulPort)))
+		}
+
+		if *exporterEnabled && !*adoptCluster {
+			endpoints["exporter"] = exporter.Options{Addr: *prometheusAddr, Port: *exporterPort}.Endpoint()
+		}
+
+		if *sts && !*adoptCluster {
+			endpoints["sts"] = rgwEndpoint
+		}
+
+		if *dnsAddr != "" && !*adoptCluster {
+			endpoints["dns"] = "udp://" + util.LocalAddr(*dnsAddr)
+		}
+
+		if tlsEndpoint := (radosgw.Options{Addr: *rgwAddr, TLS: rgwTLSOptions}).TLSEndpoint(); tlsEndpoint != "" {
+			endpoints["rgw.tls"] = tlsEndpoint
+		}
+
+		// Only the OSD runs here.
+		if joinOpts != nil {
+			endpoints = map[string]string{"mon": monMap.Hosts()}
+		}
+
+		for name, endpoint := range proxyEndpoints {
+			endpoints[name] = endpoint
+		}
+
+		var addOSD func(context.Context, api.AddOSDRequest) (string, error)
+		if canAddOSDs {
+			// Added OSDs get their own backing images, rather than sharing the
+			// device of the first OSD.
+			addedOSDOpts := osdOpts
+			addedOSDOpts.Device = ""
+
+			addOSD = (&osdAdder{
+				logger:    logger,
+				o:         o,
+				opts:      addedOSDOpts,
+				overrides: conf.OSDs,
+				added:     addedOSDs,
+			}).Add
+		}
+
+		go func() {
+			srv := api.NewServer(logger, api.Options{
+				Addr:       *apiAddr,
+				SocketPath: *controlSocket,
+				FSID:       fsid,
+				Connection: conn,
+				Endpoints:  endpoints,
+				LogLevel:   &logLevel,
+				Faults:     faults,
+				Clock:      clock,
+				AddOSD:     addOSD,
+			}, o)
+
+			if err := srv.Run(ctx); err != nil {
+				logger.Error("Could not serve API", "error", err)
+			}
+		}()
+	}
+
+	if err := o.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
 		logger.Error("Could not run picoceph", "error", err)
 
-		os.Exit(1)
+		tempfile.Exit(1)
 	}
 }
+
+// newLogHandler creates a log handler for the named output format.
+func newLogHandler(format string, level slog.Leveler) (slog.Handler, error) {
+	switch format {
+	case "text":
+		return slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}), nil
+	case "json":
+		return slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level}), nil
+	default:
+		return nil, fmt.Errorf("unknown log format: %s", format)
+	}
+}
+
+// isFlagSet returns true if the named flag was explicitly set on the command line.
+func isFlagSet(name string) bool {
+	return isFlagSetIn(flag.CommandLine, name)
+}
+
+// isFlagSetIn returns true if the named flag of fs was explicitly set.
+func isFlagSetIn(fs *flag.FlagSet, name string) (set bool) {
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+
+	return
+}
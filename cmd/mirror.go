@@ -0,0 +1,107 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/dpeckett/picoceph/internal/ceph/rbd"
+)
+
+// mirrorCommand configures RBD mirroring between clusters.
+func mirrorCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: picoceph mirror <enable|token|import> [flags]")
+	}
+
+	switch args[0] {
+	case "enable":
+		return mirrorEnableCommand(args[1:])
+	case "token":
+		return mirrorTokenCommand(args[1:])
+	case "import":
+		return mirrorImportCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown mirror command: %s", args[0])
+	}
+}
+
+// mirrorEnableCommand enables mirroring on a pool.
+func mirrorEnableCommand(args []string) error {
+	fs := flag.NewFlagSet("mirror enable", flag.ExitOnError)
+	mode := fs.String("mode", string(rbd.MirrorModeImage), "How images are mirrored (image or pool)")
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: picoceph mirror enable [flags] <pool>")
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+
+	return rbd.EnableMirroring(ctx, fs.Arg(0), rbd.MirrorMode(*mode))
+}
+
+// mirrorTokenCommand prints a peer bootstrap token for a pool.
+func mirrorTokenCommand(args []string) error {
+	fs := flag.NewFlagSet("mirror token", flag.ExitOnError)
+	siteName := fs.String("site-name", "picoceph", "The name of this cluster")
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: picoceph mirror token [flags] <pool>")
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+
+	token, err := rbd.CreatePeerToken(ctx, fs.Arg(0), *siteName)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(token)
+
+	return nil
+}
+
+// mirrorImportCommand adds the peer cluster that created a bootstrap token.
+func mirrorImportCommand(args []string) error {
+	fs := flag.NewFlagSet("mirror import", flag.ExitOnError)
+	siteName := fs.String("site-name", "picoceph", "The name of this cluster")
+	direction := fs.String("direction", "rx-tx", "Whether images are only mirrored from the peer (rx-only), or in both directions (rx-tx)")
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: picoceph mirror import [flags] <pool> <token file or ->")
+	}
+
+	var token []byte
+	var err error
+	if fs.Arg(1) == "-" {
+		token, err = io.ReadAll(os.Stdin)
+	} else {
+		token, err = os.ReadFile(fs.Arg(1))
+	}
+	if err != nil {
+		return fmt.Errorf("could not read token: %w", err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+
+	return rbd.ImportPeerToken(ctx, fs.Arg(0), *siteName, string(token), *direction)
+}
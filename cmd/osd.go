@@ -0,0 +1,152 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/dpeckett/picoceph/internal/api"
+	"github.com/dpeckett/picoceph/internal/ceph/osd"
+)
+
+// osdCommand runs OSD admin commands against the local cluster.
+func osdCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: picoceph osd <add|bench|perf> [flags]")
+	}
+
+	switch args[0] {
+	case "add":
+		return osdAddCommand(args[1:])
+	case "bench":
+		return osdBenchCommand(args[1:])
+	case "perf":
+		return osdPerfCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown osd command: %s", args[0])
+	}
+}
+
+// osdAddCommand adds an OSD to a running picoceph instance.
+func osdAddCommand(args []string) error {
+	fs := flag.NewFlagSet("osd add", flag.ExitOnError)
+	apiAddr := fs.String("api-addr", defaultControlSocket, "The path of the picoceph control socket (OSDs can only be added through it)")
+	instance := fs.String("instance", "", "The name of the instance (defaults to the unnamed instance)")
+	sizeGiB := fs.Int64("size-gib", 0, "The size of the OSD backing image, in GiB (defaults to --osd-size-gib)")
+	deviceClass := fs.String("device-class", "", "The CRUSH device class of the OSD (defaults to --osd-device-class)")
+	device := fs.String("device", "", "An existing block device to use for the OSD instead of a backing image")
+	asJSON := fs.Bool("json", false, "Print the new OSD as JSON")
+	_ = fs.Parse(args)
+
+	if *instance != "" {
+		if err := validateInstance(*instance); err != nil {
+			return err
+		}
+
+		if !isFlagSetIn(fs, "api-addr") {
+			*apiAddr = instanceControlSocket(*instance)
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+
+	resp, err := api.NewClient(*apiAddr).AddOSD(ctx, api.AddOSDRequest{
+		SizeGiB:     *sizeGiB,
+		DeviceClass: *deviceClass,
+		Device:      *device,
+	})
+	if err != nil {
+		return fmt.Errorf("could not add OSD: %w", err)
+	}
+
+	if *asJSON {
+		return printJSON(resp)
+	}
+
+	fmt.Printf("osd.%s\n", resp.ID)
+
+	return nil
+}
+
+// osdBenchCommand measures the write throughput of an OSD.
+func osdBenchCommand(args []string) error {
+	fs := flag.NewFlagSet("osd bench", flag.ExitOnError)
+	totalBytes := fs.Int64("bytes", 0, "The number of bytes to write (defaults to 1GiB)")
+	blockSize := fs.Int64("block-size", 0, "The size of each write (defaults to 4MiB)")
+	asJSON := fs.Bool("json", false, "Print the result as JSON")
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: picoceph osd bench [flags] <id>")
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+
+	result, err := osd.Bench(ctx, fs.Arg(0), osd.BenchOptions{TotalBytes: *totalBytes, BlockSize: *blockSize})
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(result)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if result.Device != "" {
+		fmt.Fprintf(w, "DEVICE:\t%s\n", result.Device)
+	}
+	fmt.Fprintf(w, "WRITTEN:\t%d bytes in %.2fs (%d byte blocks)\n", result.BytesWritten, result.ElapsedSec, result.BlockSize)
+	fmt.Fprintf(w, "THROUGHPUT:\t%.1f MiB/s\n", result.BytesPerSec/(1<<20))
+	fmt.Fprintf(w, "IOPS:\t%.0f\n", result.IOPS)
+
+	return w.Flush()
+}
+
+// osdPerfCommand prints the commit and apply latencies of every OSD.
+func osdPerfCommand(args []string) error {
+	fs := flag.NewFlagSet("osd perf", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "Print the stats as JSON")
+	_ = fs.Parse(args)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+
+	stats, err := osd.Perf(ctx)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(stats)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "OSD\tCOMMIT LATENCY (ms)\tAPPLY LATENCY (ms)")
+	for _, s := range stats {
+		fmt.Fprintf(w, "osd.%d\t%d\t%d\n", s.ID, s.Stats.CommitLatencyMs, s.Stats.ApplyLatencyMs)
+	}
+
+	return w.Flush()
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
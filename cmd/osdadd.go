@@ -0,0 +1,166 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dpeckett/picoceph/internal/api"
+	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/ceph/osd"
+	"github.com/dpeckett/picoceph/internal/config"
+	"github.com/dpeckett/picoceph/internal/orchestrator"
+)
+
+// addedOSDsPath records the OSDs added while running, so that they are
+// started again by later runs.
+const addedOSDsPath = "/var/lib/ceph/added-osds.json"
+
+// loadAddedOSDs returns the OSDs added by previous runs, keyed by id.
+func loadAddedOSDs() (map[string]config.OSD, error) {
+	data, err := os.ReadFile(addedOSDsPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("could not read added OSDs: %w", err)
+	}
+
+	var added map[string]config.OSD
+	if err := json.Unmarshal(data, &added); err != nil {
+		return nil, fmt.Errorf("could not parse added OSDs: %w", err)
+	}
+
+	return added, nil
+}
+
+// writeAddedOSDs records the OSDs added while running, keyed by id.
+func writeAddedOSDs(added map[string]config.OSD) error {
+	data, err := json.Marshal(added)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(addedOSDsPath, data, 0o644); err != nil {
+		return fmt.Errorf("could not write added OSDs: %w", err)
+	}
+
+	return nil
+}
+
+// sortedOSDIDs returns the OSD ids in numeric order.
+func sortedOSDIDs(osds map[string]config.OSD) []string {
+	ids := make([]string, 0, len(osds))
+	for id := range osds {
+		ids = append(ids, id)
+	}
+
+	slices.SortFunc(ids, func(a, b string) int {
+		x, _ := strconv.Atoi(a)
+		y, _ := strconv.Atoi(b)
+		return x - y
+	})
+
+	return ids
+}
+
+// osdAdder adds OSDs to the running cluster (see POST /osds).
+type osdAdder struct {
+	logger *slog.Logger
+	o      *orchestrator.Orchestrator
+	// opts are the options shared by every OSD.
+	opts      osd.Options
+	overrides map[string]config.OSD
+	mu        sync.Mutex
+	added     map[string]config.OSD
+}
+
+// Add provisions and starts a new OSD, with the next free id.
+func (a *osdAdder) Add(ctx context.Context, req api.AddOSDRequest) (string, error) {
+	// One at a time, so that they don't pick the same id.
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	id, err := a.nextID(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	override := a.overrides[id]
+	if req.SizeGiB > 0 {
+		override.SizeGiB = req.SizeGiB
+	}
+	if req.DeviceClass != "" {
+		override.DeviceClass = req.DeviceClass
+	}
+	if req.Device != "" {
+		override.Device = req.Device
+	}
+
+	a.logger.Info("Adding OSD", "id", id)
+
+	// Record the OSD before it is provisioned, so that a running OSD is never
+	// left out of later runs.
+	added := maps.Clone(a.added)
+	if added == nil {
+		added = make(map[string]config.OSD)
+	}
+	added[id] = override
+
+	if err := writeAddedOSDs(added); err != nil {
+		return "", fmt.Errorf("could not record OSD %s: %w", id, err)
+	}
+
+	if err := a.o.Add(osd.New(a.logger, id, osdOptions(a.opts, map[string]config.OSD{id: override}, id))); err != nil {
+		// The OSD was stopped and removed again.
+		if err := writeAddedOSDs(a.added); err != nil {
+			a.logger.Warn("Could not forget OSD that failed to start", "id", id, "error", err)
+		}
+
+		return "", fmt.Errorf("could not add OSD %s: %w", id, err)
+	}
+
+	a.added = added
+
+	return id, nil
+}
+
+// nextID returns the id after the highest one in the OSD map, or managed by
+// picoceph.
+func (a *osdAdder) nextID(ctx context.Context) (string, error) {
+	var ids []int
+	if err := ceph.RunJSON(ctx, &ids, "osd", "ls"); err != nil {
+		return "", fmt.Errorf("could not list OSDs: %w", err)
+	}
+
+	next := 0
+	for _, id := range ids {
+		next = max(next, id+1)
+	}
+
+	for _, name := range a.o.Names() {
+		if s, ok := strings.CutPrefix(name, "osd."); ok {
+			if id, err := strconv.Atoi(s); err == nil {
+				next = max(next, id+1)
+			}
+		}
+	}
+
+	return strconv.Itoa(next), nil
+}
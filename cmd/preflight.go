@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dpeckett/picoceph/internal/api"
+	"github.com/dpeckett/picoceph/internal/preflight"
+)
+
+// preflightReport is the JSON output of the preflight and doctor commands.
+type preflightReport struct {
+	SchemaVersion int `json:"schema_version"`
+	// Passed is false if any check failed.
+	Passed   bool                `json:"passed"`
+	Findings []preflight.Finding `json:"findings"`
+}
+
+// preflightCommand runs the checks that picoceph runs on startup.
+func preflightCommand(args []string) error {
+	return runChecks("preflight", args, preflight.Checks)
+}
+
+// doctorCommand runs the preflight checks, along with checks of what the host
+// is capable of.
+func doctorCommand(args []string) error {
+	return runChecks("doctor", args, append(append([]preflight.Check{}, preflight.Checks...), preflight.HostChecks...))
+}
+
+func runChecks(name string, args []string, checks []preflight.Check) error {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "Print the findings as JSON")
+	ignore := fs.String("ignore", "", "Comma separated checks whose failures are ignored")
+	_ = fs.Parse(args)
+
+	var ignored []string
+	if *ignore != "" {
+		ignored = strings.Split(*ignore, ",")
+	}
+
+	findings := preflight.RunChecks(context.Background(), checks)
+	report := preflightReport{
+		SchemaVersion: api.SchemaVersion,
+		Passed:        !preflight.Failed(findings, ignored...),
+		Findings:      findings,
+	}
+
+	if *asJSON {
+		if err := printJSON(report); err != nil {
+			return err
+		}
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "CHECK\tSTATUS\tMESSAGE")
+		for _, f := range findings {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", f.Check, f.Status, f.Message)
+			if f.Remediation != "" && f.Status != preflight.StatusOK {
+				fmt.Fprintf(w, "\t\thint: %s\n", f.Remediation)
+			}
+		}
+
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if !report.Passed {
+		return fmt.Errorf("%s checks failed", name)
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/fault"
+	"github.com/dpeckett/picoceph/internal/proxy"
+	"github.com/dpeckett/picoceph/internal/util"
+)
+
+// proxySpec describes a fault injecting proxy in front of a service.
+type proxySpec struct {
+	name     string
+	requires []string
+	opts     proxy.Options
+	policy   fault.Policy
+}
+
+// newProxies creates the proxy components, and returns them along with their
+// fault injectors and endpoints (keyed by proxy name).
+func newProxies(logger *slog.Logger, specs ...proxySpec) ([]ceph.Component, map[string]*fault.Injector, map[string]string, error) {
+	var components []ceph.Component
+	faults := map[string]*fault.Injector{}
+	endpoints := map[string]string{}
+
+	for _, spec := range specs {
+		if spec.opts.Addr == "" {
+			continue
+		}
+
+		injector, err := fault.NewInjector(spec.policy)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid fault policy for %s: %w", spec.name, err)
+		}
+
+		spec.opts.Faults = injector
+
+		scheme := "http"
+		if spec.opts.TLSEnabled() {
+			scheme = "https"
+		}
+
+		components = append(components, proxy.New(logger, spec.name, spec.requires, spec.opts))
+		faults[spec.name] = injector
+		endpoints[spec.name] = scheme + "://" + util.LocalAddr(spec.opts.Addr)
+	}
+
+	return components, faults, endpoints, nil
+}
@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/dpeckett/picoceph/internal/datadir"
+	"github.com/dpeckett/picoceph/internal/lockfile"
+	"github.com/dpeckett/picoceph/internal/purge"
+)
+
+// purgeCommand removes all of the state left behind by previous runs.
+func purgeCommand(args []string) error {
+	fs := flag.NewFlagSet("purge", flag.ExitOnError)
+	yes := fs.Bool("yes", false, "Confirm that all ceph data should be irrecoverably deleted")
+	dataDir := fs.String("data-dir", "", "The data directory used by picoceph (if any)")
+	instance := fs.String("instance", "", "The name of the instance (defaults to the unnamed instance)")
+	_ = fs.Parse(args)
+
+	if !*yes {
+		return fmt.Errorf("refusing to purge without --yes, this will delete all ceph data")
+	}
+
+	if *instance != "" {
+		if err := validateInstance(*instance); err != nil {
+			return err
+		}
+
+		if *dataDir == "" {
+			*dataDir = instanceDataDir(*instance)
+		}
+	}
+
+	if *dataDir != "" {
+		if err := datadir.Enter(*dataDir); err != nil {
+			return err
+		}
+	}
+
+	lock, err := lockfile.Acquire(lockfile.Path)
+	if err != nil {
+		return fmt.Errorf("picoceph must be stopped first: %w", err)
+	}
+	defer func() { _ = lock.Release() }()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{}))
+
+	if err := purge.Run(ctx, logger, *instance); err != nil {
+		return fmt.Errorf("could not purge: %w", err)
+	}
+
+	logger.Info("Purged")
+
+	return nil
+}
@@ -0,0 +1,168 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/ceph/manager"
+	"github.com/dpeckett/picoceph/internal/ceph/monmap"
+	"github.com/dpeckett/picoceph/internal/command"
+	"github.com/dpeckett/picoceph/internal/datadir"
+	"github.com/dpeckett/picoceph/internal/platform"
+	"github.com/dpeckett/picoceph/internal/tempfile"
+)
+
+// replayDataDirEnv is the data directory of the child process that replays
+// the bootstrap.
+const replayDataDirEnv = "PICOCEPH_TEST_REPLAY_DATA_DIR"
+
+// replayFSID is the fsid of the recorded cluster.
+const replayFSID = "1e8ca9a4-2a3b-4bd8-a6a2-7e1c64b8e5b4"
+
+func TestMain(m *testing.M) {
+	// Replayed commands re-execute the test binary as a shim.
+	if command.IsShim() {
+		os.Exit(command.RunShim())
+	}
+
+	os.Exit(m.Run())
+}
+
+func TestReplayBootstrap(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("replaying needs root, to enter a data directory")
+	}
+
+	if _, _, err := ceph.User(); err != nil {
+		t.Skip("replaying needs the ceph user")
+	}
+
+	dataDir := os.Getenv(replayDataDirEnv)
+	if dataDir == "" {
+		// Entering the data directory re-executes the process (and never
+		// returns), so replay in a child process.
+		dataDir = t.TempDir()
+
+		cmd := exec.Command(os.Args[0], "-test.run=^TestReplayBootstrap$")
+		cmd.Env = append(os.Environ(), replayDataDirEnv+"="+dataDir)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("could not replay bootstrap: %v: %s", err, out)
+		}
+
+		// The state of the replayed cluster is kept in the data directory.
+		fsid, err := os.ReadFile(filepath.Join(dataDir, ceph.FSIDPath))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.TrimSpace(string(fsid)) != replayFSID {
+			t.Fatalf("got fsid %s", fsid)
+		}
+
+		if _, err := os.Stat(filepath.Join(dataDir, "/var/lib/ceph/mon/ceph-a/done")); err != nil {
+			t.Fatalf("monitor was not created: %v", err)
+		}
+
+		keyringPath := filepath.Join(dataDir, "/var/lib/ceph/mgr/ceph-a/keyring")
+		fi, err := os.Stat(keyringPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if fi.Mode().Perm() != 0o600 {
+			t.Fatalf("manager keyring has mode %o", fi.Mode().Perm())
+		}
+
+		keyring, err := os.ReadFile(keyringPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.HasPrefix(string(keyring), "[mgr.a]\n") {
+			t.Fatalf("manager keyring is not the recorded output:\n%s", keyring)
+		}
+
+		return
+	}
+
+	if err := datadir.Enter(dataDir); err != nil {
+		t.Fatal(err)
+	}
+	defer tempfile.RemoveAll()
+
+	if err := command.ReplayFrom("testdata/bootstrap.jsonl"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	monMap := monmap.New(replayFSID)
+	if err := monMap.Add("a", "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	components, err := bootstrap(ctx, logger, bootstrapOptions{
+		fsid:     replayFSID,
+		monMap:   monMap,
+		monIDs:   []string{"a"},
+		mgrIDs:   []string{"a"},
+		osdID:    "0",
+		platform: &platform.Platform{},
+		manager:  manager.Options{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Configure the monitor and manager, as the orchestrator would.
+	for _, c := range components[:2] {
+		if err := c.Configure(ctx); err != nil {
+			t.Fatalf("could not configure %s: %v", c.Name(), err)
+		}
+	}
+
+	// The recorded monitor was stopped, so it runs until it is stopped again.
+	mon := components[0]
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- mon.Start(ctx)
+	}()
+
+	for mon.(ceph.Process).Pid() == 0 {
+		select {
+		case err := <-errCh:
+			t.Fatalf("monitor exited before it was stopped: %v", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	stopCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if err := mon.Stop(stopCtx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os/signal"
+	"syscall"
+
+	"github.com/dpeckett/picoceph/internal/datadir"
+	"github.com/dpeckett/picoceph/internal/lockfile"
+	"github.com/dpeckett/picoceph/internal/snapshot"
+)
+
+// snapshotCommand takes, reverts and removes snapshots of a stopped cluster.
+func snapshotCommand(args []string) error {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	dataDir := fs.String("data-dir", "", "The data directory used by picoceph (if any)")
+	instance := fs.String("instance", "", "The name of the instance (defaults to the unnamed instance)")
+	_ = fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: picoceph snapshot [flags] <create|revert|delete|list> [name]")
+	}
+
+	if *instance != "" {
+		if err := validateInstance(*instance); err != nil {
+			return err
+		}
+
+		if *dataDir == "" {
+			*dataDir = instanceDataDir(*instance)
+		}
+	}
+
+	if *dataDir != "" {
+		if err := datadir.Enter(*dataDir); err != nil {
+			return err
+		}
+	}
+
+	lock, err := lockfile.Acquire(lockfile.Path)
+	if err != nil {
+		return fmt.Errorf("picoceph must be stopped first: %w", err)
+	}
+	defer func() { _ = lock.Release() }()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+
+	op := fs.Arg(0)
+	if op == "list" {
+		names, err := snapshot.List()
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			fmt.Println(name)
+		}
+
+		return nil
+	}
+
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: picoceph snapshot [flags] %s <name>", op)
+	}
+
+	name := fs.Arg(1)
+
+	switch op {
+	case "create":
+		return snapshot.Create(ctx, name)
+	case "revert":
+		return snapshot.Revert(ctx, name)
+	case "delete":
+		return snapshot.Delete(ctx, name)
+	default:
+		return fmt.Errorf("unknown snapshot command: %s", op)
+	}
+}
@@ -0,0 +1,125 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dpeckett/picoceph/internal/api"
+)
+
+// statusCommand prints the status of a running picoceph instance.
+func statusCommand(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	apiAddr := fs.String("api-addr", defaultControlSocket, "The address of the picoceph HTTP API, or the path of its control socket (credentials are only included through the socket)")
+	asJSON := fs.Bool("json", false, "Print the status as JSON")
+	instance := fs.String("instance", "", "The name of the instance (defaults to the unnamed instance)")
+	_ = fs.Parse(args)
+
+	if *instance != "" {
+		if err := validateInstance(*instance); err != nil {
+			return err
+		}
+
+		if !isFlagSetIn(fs, "api-addr") {
+			*apiAddr = instanceControlSocket(*instance)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	status, err := api.NewClient(*apiAddr).Status(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get status: %w", err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(status)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(w, "FSID:\t%s\n", status.FSID)
+	if status.Ceph != nil {
+		fmt.Fprintf(w, "HEALTH:\t%s\n", status.Ceph.Health.Status)
+	} else {
+		fmt.Fprintf(w, "HEALTH:\tunknown (%s)\n", status.CephError)
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "COMPONENT\tSTATE\tPID")
+	for _, name := range sortedKeys(status.Components) {
+		pid := "-"
+		if p, ok := status.Pids[name]; ok {
+			pid = strconv.Itoa(p)
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\n", name, status.Components[name], pid)
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "SERVICE\tENDPOINT")
+	for _, name := range sortedKeys(status.Endpoints) {
+		fmt.Fprintf(w, "%s\t%s\n", name, status.Endpoints[name])
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "MONITORS:\t%s\n", strings.Join(status.Connection.MonAddrs, ","))
+	fmt.Fprintf(w, "CONFIG:\t%s\n", status.Connection.ConfigPath)
+	fmt.Fprintf(w, "ADMIN KEYRING:\t%s\n", status.Connection.AdminKeyringPath)
+	if status.Connection.DashboardCredentialsPath != "" {
+		fmt.Fprintf(w, "DASHBOARD CREDENTIALS:\t%s\n", status.Connection.DashboardCredentialsPath)
+	}
+	if status.Connection.RestfulCredentialsPath != "" {
+		fmt.Fprintf(w, "REST API CREDENTIALS:\t%s\n", status.Connection.RestfulCredentialsPath)
+	}
+	if status.S3 != nil {
+		fmt.Fprintf(w, "S3 USER:\t%s\n", status.S3.UserID)
+		fmt.Fprintf(w, "S3 ACCESS KEY:\t%s\n", status.S3.AccessKey)
+		fmt.Fprintf(w, "S3 SECRET KEY:\t%s\n", status.S3.SecretKey)
+		if status.S3.SwiftUser != "" {
+			fmt.Fprintf(w, "SWIFT USER:\t%s\n", status.S3.SwiftUser)
+			fmt.Fprintf(w, "SWIFT KEY:\t%s\n", status.S3.SwiftKey)
+		}
+	} else if status.Connection.S3CredentialsPath != "" {
+		fmt.Fprintf(w, "S3 CREDENTIALS:\t%s\n", status.Connection.S3CredentialsPath)
+	}
+	if status.S3Admin != nil {
+		fmt.Fprintf(w, "S3 ADMIN USER:\t%s\n", status.S3Admin.UserID)
+		fmt.Fprintf(w, "S3 ADMIN ACCESS KEY:\t%s\n", status.S3Admin.AccessKey)
+		fmt.Fprintf(w, "S3 ADMIN SECRET KEY:\t%s\n", status.S3Admin.SecretKey)
+	} else if status.Connection.S3AdminCredentialsPath != "" {
+		fmt.Fprintf(w, "S3 ADMIN CREDENTIALS:\t%s\n", status.Connection.S3AdminCredentialsPath)
+	}
+
+	return w.Flush()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
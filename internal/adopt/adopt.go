@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package adopt discovers the daemons of an existing cluster, so that they
+// can be supervised by picoceph without being bootstrapped again.
+package adopt
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"github.com/dpeckett/picoceph/internal/audit"
+	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/ceph/manager"
+	"github.com/dpeckett/picoceph/internal/ceph/monitor"
+	"github.com/dpeckett/picoceph/internal/ceph/osd"
+	"github.com/dpeckett/picoceph/internal/ceph/radosgw"
+	"github.com/dpeckett/picoceph/internal/command"
+)
+
+// Discover returns the components of the existing cluster found under
+// /var/lib/ceph. None of the returned components will be reconfigured.
+func Discover(ctx context.Context, logger *slog.Logger, fsid string) ([]ceph.Component, error) {
+	var components []ceph.Component
+
+	for _, id := range daemonIDs("mon") {
+		components = append(components, ceph.Adopt(monitor.New(logger, id, fsid)))
+	}
+
+	if len(components) == 0 {
+		return nil, fmt.Errorf("no monitors found under /var/lib/ceph/mon")
+	}
+
+	for _, id := range daemonIDs("mgr") {
+		components = append(components, ceph.Adopt(manager.New(logger, id, manager.Options{})))
+	}
+
+	// OSD data directories are tmpfs mounts populated from the LVM tags of
+	// the OSD volumes, so they need to be activated before they can be found.
+	audit.Record("activate OSD", "id", "all")
+
+	cmd := command.Context(ctx, "ceph-volume", "lvm", "activate", "--no-systemd", "--all")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		logger.Warn("Could not activate OSDs", "error", err, "output", string(out))
+	}
+
+	osdIDs := daemonIDs("osd")
+	for _, id := range osdIDs {
+		components = append(components, ceph.Adopt(osd.New(logger, id, osd.Options{})))
+	}
+
+	for _, id := range daemonIDs("radosgw") {
+		// RGW can't create its pools without any OSDs.
+		if len(osdIDs) == 0 {
+			break
+		}
+
+		if id != "radosgw.gateway" {
+			logger.Warn("Not adopting unsupported RADOS Gateway", "id", id)
+			continue
+		}
+
+		components = append(components, ceph.Adopt(radosgw.New(logger, radosgw.Options{})))
+	}
+
+	return components, nil
+}
+
+// daemonIDs returns the ids of the daemons of the given type that have a
+// data directory (eg. /var/lib/ceph/mon/ceph-a).
+func daemonIDs(daemonType string) []string {
+	dirs, _ := filepath.Glob(filepath.Join("/var/lib/ceph", daemonType, "ceph-*"))
+
+	var ids []string
+	for _, dir := range dirs {
+		ids = append(ids, strings.TrimPrefix(filepath.Base(dir), "ceph-"))
+	}
+
+	return ids
+}
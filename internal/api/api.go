@@ -0,0 +1,243 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package api serves picoceph's HTTP API (eg. health checks).
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/dpeckett/picoceph/internal/faketime"
+	"github.com/dpeckett/picoceph/internal/fault"
+	"github.com/dpeckett/picoceph/internal/orchestrator"
+	"golang.org/x/sync/errgroup"
+)
+
+// readyTimeout bounds how long a readiness or status check may take.
+const readyTimeout = 5 * time.Second
+
+// Options are the options for an API server.
+type Options struct {
+	// Addr is the TCP address to listen on (empty disables).
+	Addr string
+	// SocketPath is the path of a unix control socket to listen on (empty disables).
+	SocketPath string
+	// FSID is the fsid of the cluster.
+	FSID string
+	// Endpoints are the addresses of the services provided by the cluster,
+	// keyed by service name (eg. "rgw").
+	Endpoints map[string]string
+	// Connection is what clients need to connect to the cluster.
+	Connection Connection
+	// LogLevel is picoceph's log level, it can be changed through the API.
+	LogLevel *slog.LevelVar
+	// Faults are the fault injectors of the proxies, keyed by proxy name.
+	Faults map[string]*fault.Injector
+	// Clock is the fake clock of the daemons (nil if it is disabled).
+	Clock *faketime.Clock
+	// AddOSD provisions and starts an additional OSD, and returns its id (nil
+	// if OSDs can't be added).
+	AddOSD func(ctx context.Context, req AddOSDRequest) (string, error)
+}
+
+// Connection is what clients (eg. sidecars) need to connect to the cluster.
+type Connection struct {
+	MonAddrs                 []string `json:"monAddrs,omitempty"`
+	ConfigPath               string   `json:"configPath"`
+	AdminKeyringPath         string   `json:"adminKeyringPath"`
+	DashboardCredentialsPath string   `json:"dashboardCredentialsPath,omitempty"`
+	RestfulCredentialsPath   string   `json:"restfulCredentialsPath,omitempty"`
+	S3CredentialsPath        string   `json:"s3CredentialsPath,omitempty"`
+	S3AdminCredentialsPath   string   `json:"s3AdminCredentialsPath,omitempty"`
+}
+
+// Server is the picoceph HTTP API server.
+type Server struct {
+	logger *slog.Logger
+	opts   Options
+	o      *orchestrator.Orchestrator
+}
+
+// NewServer creates a new API server for the given orchestrator.
+func NewServer(logger *slog.Logger, opts Options, o *orchestrator.Orchestrator) *Server {
+	return &Server{
+		logger: logger.With("component", "api"),
+		opts:   opts,
+		o:      o,
+	}
+}
+
+// Run serves the API until the context is cancelled.
+func (s *Server) Run(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.healthz)
+	mux.HandleFunc("GET /readyz", s.readyz)
+	mux.HandleFunc("GET /status", s.status)
+	mux.HandleFunc("POST /tell", controlOnly(s.tell))
+	mux.HandleFunc("POST /signal", controlOnly(s.signal))
+	mux.HandleFunc("GET /loglevel", s.getLogLevel)
+	mux.HandleFunc("PUT /loglevel", controlOnly(s.setLogLevel))
+	mux.HandleFunc("GET /faketime", s.getFakeTime)
+	mux.HandleFunc("PUT /faketime", controlOnly(s.setFakeTime))
+	mux.HandleFunc("GET /faults", s.listFaults)
+	mux.HandleFunc("GET /faults/{proxy}", s.getFaults)
+	mux.HandleFunc("PUT /faults/{proxy}", controlOnly(s.setFaults))
+	mux.HandleFunc("DELETE /faults/{proxy}", controlOnly(s.setFaults))
+
+	mux.HandleFunc("POST /osds", controlOnly(s.addOSD))
+
+	mux.HandleFunc("GET /connection", s.connection)
+
+	mux.HandleFunc("GET /buckets", s.listBucketStats)
+	mux.HandleFunc("GET /buckets/{bucket}", s.getBucketStats)
+	mux.HandleFunc("GET /usage", s.getUsage)
+
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	var listeners []net.Listener
+	defer func() {
+		for _, l := range listeners {
+			_ = l.Close()
+		}
+	}()
+
+	if s.opts.Addr != "" {
+		l, err := net.Listen("tcp", s.opts.Addr)
+		if err != nil {
+			return fmt.Errorf("could not listen: %w", err)
+		}
+
+		listeners = append(listeners, l)
+	}
+
+	if s.opts.SocketPath != "" {
+		// Remove any stale socket left behind by a previous run.
+		_ = os.Remove(s.opts.SocketPath)
+
+		l, err := net.Listen("unix", s.opts.SocketPath)
+		if err != nil {
+			return fmt.Errorf("could not listen: %w", err)
+		}
+
+		// The API can signal daemons, so only root may use the socket.
+		if err := os.Chmod(s.opts.SocketPath, 0o600); err != nil {
+			_ = l.Close()
+			return fmt.Errorf("could not change mode of control socket: %w", err)
+		}
+
+		listeners = append(listeners, l)
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	s.logger.Info("Serving API", "addr", s.opts.Addr, "socket", s.opts.SocketPath)
+
+	var g errgroup.Group
+	for _, l := range listeners {
+		l := l
+
+		g.Go(func() error {
+			if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				return fmt.Errorf("could not serve api: %w", err)
+			}
+
+			return nil
+		})
+	}
+
+	return g.Wait()
+}
+
+// controlOnly restricts a handler that changes the cluster (or picoceph) to
+// requests made through the control socket, which only root may use. The TCP
+// address is usually reachable by anyone (eg. it is published by docker).
+func controlOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !fromControlSocket(r) {
+			http.Error(w, "only available through the control socket", http.StatusForbidden)
+			return
+		}
+
+		h(w, r)
+	}
+}
+
+// fromControlSocket returns true if the request was made through the control
+// socket.
+func fromControlSocket(r *http.Request) bool {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && addr.Network() == "unix"
+}
+
+// connection returns what clients need to connect to the cluster.
+func (s *Server) connection(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(connectionDocument{SchemaVersion: SchemaVersion, Connection: s.opts.Connection})
+}
+
+// healthz reports whether every daemon is still running.
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	if err := s.o.Live(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	fmt.Fprintln(w, "ok")
+}
+
+// Readiness is the response to GET /readyz.
+type Readiness struct {
+	SchemaVersion int                            `json:"schema_version"`
+	Ready         bool                           `json:"ready"`
+	Components    []orchestrator.ComponentStatus `json:"components"`
+}
+
+// readyz reports whether every component has started and is ready, along
+// with the detailed status of each component.
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	readiness := Readiness{
+		SchemaVersion: SchemaVersion,
+		Ready:         true,
+		Components:    s.o.Readiness(ctx),
+	}
+
+	for _, cmp := range readiness.Components {
+		if !cmp.Ready {
+			readiness.Ready = false
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !readiness.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	_ = json.NewEncoder(w).Encode(readiness)
+}
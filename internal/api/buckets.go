@@ -0,0 +1,163 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package api
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dpeckett/picoceph/internal/ceph/radosgw"
+)
+
+// BucketStats are the object count and size of a bucket.
+type BucketStats struct {
+	Bucket  string `json:"bucket"`
+	Owner   string `json:"owner"`
+	Objects int64  `json:"objects"`
+	Bytes   int64  `json:"bytes"`
+	// ActualBytes is the space used, rounded up to the allocation size.
+	ActualBytes int64 `json:"actualBytes"`
+}
+
+// BucketUsage is the usage of a bucket by a user, keyed by the category of
+// operation (eg. "put_obj").
+type BucketUsage struct {
+	User       string                   `json:"user"`
+	Bucket     string                   `json:"bucket"`
+	Categories map[string]UsageCounters `json:"categories"`
+}
+
+// UsageCounters count the operations of a category.
+type UsageCounters struct {
+	Ops           int64 `json:"ops"`
+	SuccessfulOps int64 `json:"successfulOps"`
+	BytesSent     int64 `json:"bytesSent"`
+	BytesReceived int64 `json:"bytesReceived"`
+}
+
+// UsageQuery selects the usage returned by GET /usage.
+type UsageQuery struct {
+	// UID and Bucket select a single user or bucket (defaults to all).
+	UID    string
+	Bucket string
+	// Start and End bound the time of the usage (zero is unbounded).
+	Start time.Time
+	End   time.Time
+}
+
+func newBucketStats(stats radosgw.BucketStats) BucketStats {
+	objects := stats.Objects()
+
+	return BucketStats{
+		Bucket:      stats.Bucket,
+		Owner:       stats.Owner,
+		Objects:     objects.NumObjects,
+		Bytes:       objects.Size,
+		ActualBytes: objects.SizeActual,
+	}
+}
+
+// listBucketStats returns the stats of every bucket.
+func (s *Server) listBucketStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := radosgw.ListBucketStats(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	buckets := make([]BucketStats, 0, len(stats))
+	for _, st := range stats {
+		buckets = append(buckets, newBucketStats(st))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(buckets)
+}
+
+// getBucketStats returns the stats of a bucket.
+func (s *Server) getBucketStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := radosgw.GetBucketStats(r.Context(), r.PathValue("bucket"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "No such file or directory") {
+			status = http.StatusNotFound
+		}
+
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(newBucketStats(*stats))
+}
+
+// getUsage returns the usage of each bucket by each user, summed over time.
+func (s *Server) getUsage(w http.ResponseWriter, r *http.Request) {
+	opts := radosgw.UsageOptions{UID: r.URL.Query().Get("uid")}
+	for param, t := range map[string]*time.Time{"start": &opts.Start, "end": &opts.End} {
+		if value := r.URL.Query().Get(param); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid %s: %v", param, err), http.StatusBadRequest)
+				return
+			}
+
+			*t = parsed
+		}
+	}
+
+	usage, err := radosgw.GetUsage(r.Context(), opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	bucket := r.URL.Query().Get("bucket")
+
+	byBucket := map[[2]string]*BucketUsage{}
+	for _, entry := range usage.Entries {
+		for _, b := range entry.Buckets {
+			if bucket != "" && b.Bucket != bucket {
+				continue
+			}
+
+			key := [2]string{entry.User, b.Bucket}
+			if byBucket[key] == nil {
+				byBucket[key] = &BucketUsage{User: entry.User, Bucket: b.Bucket, Categories: map[string]UsageCounters{}}
+			}
+
+			for _, c := range b.Categories {
+				counters := byBucket[key].Categories[c.Category]
+				counters.Ops += c.Ops
+				counters.SuccessfulOps += c.SuccessfulOps
+				counters.BytesSent += c.BytesSent
+				counters.BytesReceived += c.BytesReceived
+				byBucket[key].Categories[c.Category] = counters
+			}
+		}
+	}
+
+	buckets := make([]BucketUsage, 0, len(byBucket))
+	for _, u := range byBucket {
+		buckets = append(buckets, *u)
+	}
+
+	slices.SortFunc(buckets, func(a, b BucketUsage) int {
+		return cmp.Or(cmp.Compare(a.User, b.User), cmp.Compare(a.Bucket, b.Bucket))
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(buckets)
+}
@@ -0,0 +1,181 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client is a client for the API of a running picoceph instance.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewClient creates a new client for the API served on the given address,
+// which is either a TCP address or the path of a control socket.
+func NewClient(addr string) *Client {
+	if strings.HasPrefix(addr, "/") {
+		socketPath := addr
+
+		return &Client{
+			baseURL: "http://picoceph",
+			httpClient: &http.Client{
+				Transport: &http.Transport{
+					DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+						var d net.Dialer
+						return d.DialContext(ctx, "unix", socketPath)
+					},
+				},
+			},
+		}
+	}
+
+	if strings.HasPrefix(addr, ":") {
+		addr = "127.0.0.1" + addr
+	}
+
+	return &Client{baseURL: "http://" + addr, httpClient: http.DefaultClient}
+}
+
+// Connection returns what clients need to connect to the cluster.
+func (c *Client) Connection(ctx context.Context) (*Connection, error) {
+	var doc connectionDocument
+	if err := c.get(ctx, "/connection", &doc); err != nil {
+		return nil, err
+	}
+
+	if err := checkSchemaVersion(doc.SchemaVersion); err != nil {
+		return nil, err
+	}
+
+	return &doc.Connection, nil
+}
+
+// Status returns the status of the running instance.
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	var status Status
+	if err := c.get(ctx, "/status", &status); err != nil {
+		return nil, err
+	}
+
+	if err := checkSchemaVersion(status.SchemaVersion); err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
+
+// ListBucketStats returns the object count and size of every bucket.
+func (c *Client) ListBucketStats(ctx context.Context) ([]BucketStats, error) {
+	var buckets []BucketStats
+	if err := c.get(ctx, "/buckets", &buckets); err != nil {
+		return nil, err
+	}
+
+	return buckets, nil
+}
+
+// BucketStats returns the object count and size of a bucket.
+func (c *Client) BucketStats(ctx context.Context, bucket string) (*BucketStats, error) {
+	var stats BucketStats
+	if err := c.get(ctx, "/buckets/"+url.PathEscape(bucket), &stats); err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+// Usage returns the usage of each bucket by each user, from the RGW usage log.
+func (c *Client) Usage(ctx context.Context, query UsageQuery) ([]BucketUsage, error) {
+	params := url.Values{}
+	if query.UID != "" {
+		params.Set("uid", query.UID)
+	}
+	if query.Bucket != "" {
+		params.Set("bucket", query.Bucket)
+	}
+	if !query.Start.IsZero() {
+		params.Set("start", query.Start.Format(time.RFC3339))
+	}
+	if !query.End.IsZero() {
+		params.Set("end", query.End.Format(time.RFC3339))
+	}
+
+	var usage []BucketUsage
+	if err := c.get(ctx, "/usage?"+params.Encode(), &usage); err != nil {
+		return nil, err
+	}
+
+	return usage, nil
+}
+
+// AddOSD provisions and starts an additional OSD, and blocks until it is
+// ready.
+func (c *Client) AddOSD(ctx context.Context, req AddOSDRequest) (*AddOSDResponse, error) {
+	var resp AddOSDResponse
+	if err := c.post(ctx, "/osds", req, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+func (c *Client) get(ctx context.Context, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+
+	return c.do(req, v)
+}
+
+func (c *Client) post(ctx context.Context, path string, body, v any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return c.do(req, v)
+}
+
+func (c *Client) do(req *http.Request, v any) error {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not connect to picoceph: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("could not decode response: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// FakeTime is the request and response body of /faketime.
+type FakeTime struct {
+	// Spec is a libfaketime time specification (eg. "+2d").
+	Spec string `json:"spec"`
+}
+
+// getFakeTime returns the fake time of the daemons.
+func (s *Server) getFakeTime(w http.ResponseWriter, r *http.Request) {
+	if s.opts.Clock == nil {
+		http.Error(w, "daemons are not running under libfaketime", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(FakeTime{Spec: s.opts.Clock.Spec()})
+}
+
+// setFakeTime changes the fake time of the daemons.
+func (s *Server) setFakeTime(w http.ResponseWriter, r *http.Request) {
+	if s.opts.Clock == nil {
+		http.Error(w, "daemons are not running under libfaketime", http.StatusNotFound)
+		return
+	}
+
+	var req FakeTime
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.opts.Clock.Set(req.Spec); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.logger.Info("Changed fake time", "spec", req.Spec)
+
+	s.getFakeTime(w, r)
+}
@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/dpeckett/picoceph/internal/fault"
+)
+
+// Faults is the fault policy of a proxy, and how many requests it has faulted.
+type Faults struct {
+	Policy   fault.Policy `json:"policy"`
+	Injected int          `json:"injected"`
+}
+
+// listFaults returns the fault policy of every proxy, keyed by proxy name.
+func (s *Server) listFaults(w http.ResponseWriter, r *http.Request) {
+	faults := map[string]Faults{}
+	for name, injector := range s.opts.Faults {
+		faults[name] = Faults{Policy: injector.Policy(), Injected: injector.Injected()}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(faults)
+}
+
+// getFaults returns the fault policy of a proxy.
+func (s *Server) getFaults(w http.ResponseWriter, r *http.Request) {
+	injector, ok := s.opts.Faults[r.PathValue("proxy")]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown proxy: %s", r.PathValue("proxy")), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(Faults{Policy: injector.Policy(), Injected: injector.Injected()})
+}
+
+// setFaults replaces the fault policy of a proxy.
+func (s *Server) setFaults(w http.ResponseWriter, r *http.Request) {
+	injector, ok := s.opts.Faults[r.PathValue("proxy")]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown proxy: %s", r.PathValue("proxy")), http.StatusNotFound)
+		return
+	}
+
+	var policy fault.Policy
+	if r.Method != http.MethodDelete {
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+
+		if err := dec.Decode(&policy); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := injector.SetPolicy(policy); err != nil {
+		http.Error(w, fmt.Sprintf("invalid policy: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	s.logger.Info("Changed fault policy", "proxy", r.PathValue("proxy"), "policy", policy)
+
+	s.getFaults(w, r)
+}
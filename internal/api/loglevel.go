@@ -0,0 +1,49 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// LogLevel is the request and response body of /loglevel.
+type LogLevel struct {
+	Level string `json:"level"`
+}
+
+// getLogLevel returns picoceph's current log level.
+func (s *Server) getLogLevel(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(LogLevel{Level: s.opts.LogLevel.Level().String()})
+}
+
+// setLogLevel changes picoceph's log level (eg. to "debug").
+func (s *Server) setLogLevel(w http.ResponseWriter, r *http.Request) {
+	var req LogLevel
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(req.Level)); err != nil {
+		http.Error(w, fmt.Sprintf("invalid log level: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	s.opts.LogLevel.Set(level)
+
+	s.logger.Info("Changed log level", "level", level)
+
+	s.getLogLevel(w, r)
+}
@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"github.com/dpeckett/picoceph/internal/ceph/osd"
+)
+
+// AddOSDRequest is the request body of POST /osds. Unset fields default to
+// the options picoceph was started with.
+type AddOSDRequest struct {
+	// SizeGiB is the size of the backing image of the OSD, in GiB.
+	SizeGiB int64 `json:"sizeGiB,omitempty"`
+	// DeviceClass is the CRUSH device class of the OSD, eg. "ssd".
+	DeviceClass string `json:"deviceClass,omitempty"`
+	// Device is an existing block device to use instead of a backing image.
+	Device string `json:"device,omitempty"`
+}
+
+// AddOSDResponse is the response body of POST /osds.
+type AddOSDResponse struct {
+	// ID is the id of the new OSD, eg. "3".
+	ID string `json:"id"`
+}
+
+// addOSD provisions and starts an additional OSD, and responds once it is
+// ready.
+func (s *Server) addOSD(w http.ResponseWriter, r *http.Request) {
+	if s.opts.AddOSD == nil {
+		http.Error(w, "OSDs can't be added to this instance", http.StatusConflict)
+		return
+	}
+
+	var req AddOSDRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if req.SizeGiB < 0 || (req.SizeGiB > 0 && req.SizeGiB<<30 < osd.MinImageSize) {
+		http.Error(w, fmt.Sprintf("invalid size, must be at least %d GiB", osd.MinImageSize>>30), http.StatusBadRequest)
+		return
+	}
+
+	if req.DeviceClass != "" {
+		if err := osd.ValidateDeviceClass(req.DeviceClass); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if req.Device != "" && !filepath.IsAbs(req.Device) {
+		http.Error(w, fmt.Sprintf("device must be an absolute path: %q", req.Device), http.StatusBadRequest)
+		return
+	}
+
+	id, err := s.opts.AddOSD(r.Context(), req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.logger.Info("Added OSD", "id", id)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(AddOSDResponse{ID: id})
+}
@@ -0,0 +1,35 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package api
+
+import "fmt"
+
+// SchemaVersion is the version of the JSON documents picoceph outputs (the
+// status, connection, readiness and doctor reports), in their schema_version
+// field. Fields may be added within a version, but are only removed, renamed
+// or given a different meaning by a new version.
+const SchemaVersion = 1
+
+// checkSchemaVersion returns an error if a document is of a newer schema
+// than this build understands. Documents without a version predate
+// versioning, and are compatible with the first version.
+func checkSchemaVersion(version int) error {
+	if version > SchemaVersion {
+		return fmt.Errorf("unsupported schema version %d (at most %d is supported), picoceph needs to be upgraded", version, SchemaVersion)
+	}
+
+	return nil
+}
+
+// connectionDocument is the response to GET /connection.
+type connectionDocument struct {
+	SchemaVersion int `json:"schema_version"`
+	Connection
+}
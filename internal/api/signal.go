@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"syscall"
+)
+
+// signals are the signals that can be sent to daemons through the API.
+var signals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"TERM": syscall.SIGTERM,
+	"KILL": syscall.SIGKILL,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+	"STOP": syscall.SIGSTOP,
+	"CONT": syscall.SIGCONT,
+}
+
+// SignalRequest is the request body of POST /signal.
+type SignalRequest struct {
+	// Component is the name of the component, eg. "osd.0".
+	Component string `json:"component"`
+	// Signal is the name of the signal, eg. "HUP" or "SIGKILL".
+	Signal string `json:"signal"`
+}
+
+// signal sends a signal to the daemon of a component (eg. SIGHUP to reload,
+// or SIGKILL to simulate a crash).
+func (s *Server) signal(w http.ResponseWriter, r *http.Request) {
+	var req SignalRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	sig, ok := signals[strings.TrimPrefix(strings.ToUpper(req.Signal), "SIG")]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown signal: %s", req.Signal), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.o.Signal(req.Component, sig); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	s.logger.Info("Signalled component", "target", req.Component, "signal", sig)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,81 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/ceph/radosgw"
+	"github.com/dpeckett/picoceph/internal/orchestrator"
+)
+
+// Status is the response to GET /status.
+type Status struct {
+	SchemaVersion int                           `json:"schema_version"`
+	FSID          string                        `json:"fsid"`
+	Components    map[string]orchestrator.State `json:"components"`
+	// Pids are the pids of the running daemons, keyed by component name.
+	Pids       map[string]int    `json:"pids"`
+	Endpoints  map[string]string `json:"endpoints"`
+	Connection Connection        `json:"connection"`
+	// Ceph is the latest `ceph status` summary, it is omitted if the
+	// cluster could not be reached (see CephError).
+	Ceph      *ceph.Status `json:"ceph,omitempty"`
+	CephError string       `json:"cephError,omitempty"`
+	// S3 are the credentials of the default S3 user, once it has been
+	// provisioned (only served on the control socket, see
+	// Connection.S3CredentialsPath).
+	S3 *radosgw.Credentials `json:"s3,omitempty"`
+	// S3Admin are the credentials of the admin API user (only served on the
+	// control socket, see Connection.S3AdminCredentialsPath).
+	S3Admin *radosgw.Credentials `json:"s3Admin,omitempty"`
+}
+
+// status reports the state of every component and of the cluster.
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	status := Status{
+		SchemaVersion: SchemaVersion,
+		FSID:          s.opts.FSID,
+		Components:    s.o.States(),
+		Pids:          s.o.Pids(),
+		Endpoints:     s.opts.Endpoints,
+		Connection:    s.opts.Connection,
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	cephStatus, err := ceph.GetStatus(ctx)
+	if err != nil {
+		status.CephError = err.Error()
+	} else {
+		status.Ceph = cephStatus
+	}
+
+	// Anyone who can reach the TCP address gets the paths of the credentials,
+	// but only root may read the keys.
+	if s.opts.Connection.S3CredentialsPath != "" && fromControlSocket(r) {
+		if creds, err := radosgw.ReadCredentials(s.opts.Connection.S3CredentialsPath); err == nil {
+			status.S3 = creds
+		}
+	}
+
+	if s.opts.Connection.S3AdminCredentialsPath != "" && fromControlSocket(r) {
+		if creds, err := radosgw.ReadCredentials(s.opts.Connection.S3AdminCredentialsPath); err == nil {
+			status.S3Admin = creds
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+)
+
+// TellRequest is the request body of POST /tell.
+type TellRequest struct {
+	// Target is either a daemon type (eg. "osd") to send the command to every
+	// managed daemon of that type, or the name of a single daemon (eg. "osd.0").
+	Target string `json:"target"`
+	// Command is the command and its arguments, eg. ["config", "set", "debug_osd", "20"].
+	Command []string `json:"command"`
+}
+
+// tell fans a `ceph tell` command out to the targeted daemons and returns
+// their aggregated results.
+func (s *Server) tell(w http.ResponseWriter, r *http.Request) {
+	var req TellRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Command) == 0 {
+		http.Error(w, "missing command", http.StatusBadRequest)
+		return
+	}
+
+	var daemons []string
+	for _, name := range s.o.Names() {
+		daemonType, _, _ := strings.Cut(name, ".")
+		if !slices.Contains(ceph.TellTypes, daemonType) {
+			continue
+		}
+
+		if name == req.Target || daemonType == req.Target {
+			daemons = append(daemons, name)
+		}
+	}
+
+	if len(daemons) == 0 {
+		http.Error(w, fmt.Sprintf("no daemons match target: %s", req.Target), http.StatusNotFound)
+		return
+	}
+
+	results := ceph.Tell(r.Context(), daemons, req.Command...)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(results)
+}
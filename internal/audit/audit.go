@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package audit records the privileged operations performed by picoceph
+// (loading kernel modules, creating devices, changing ownership, launching
+// daemons, etc.) to an append-only log.
+package audit
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	mu     sync.Mutex
+	file   *os.File
+	logger *slog.Logger
+)
+
+// Open opens (or creates) the audit log at the given path. Until it is
+// opened, operations are not recorded.
+func Open(path string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("could not create directory: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("could not open audit log: %w", err)
+	}
+
+	file = f
+	logger = slog.New(slog.NewJSONHandler(f, &slog.HandlerOptions{})).
+		With("pid", os.Getpid(), "uid", os.Getuid())
+
+	return nil
+}
+
+// Close closes the audit log.
+func Close() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if file == nil {
+		return nil
+	}
+
+	err := file.Close()
+	file, logger = nil, nil
+
+	return err
+}
+
+// Record records a privileged operation, along with any attributes
+// (as alternating key/value pairs) that describe it.
+func Record(operation string, args ...any) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if logger != nil {
+		logger.Info(operation, args...)
+	}
+}
@@ -0,0 +1,46 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package ceph
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// Adopt wraps a component of an existing cluster, so that it is supervised
+// without ever being (re)configured.
+func Adopt(cmp Component) Component {
+	return &adopted{Component: cmp}
+}
+
+type adopted struct {
+	Component
+}
+
+func (a *adopted) Configure(_ context.Context) error {
+	return nil
+}
+
+func (a *adopted) Pid() int {
+	if p, ok := a.Component.(Process); ok {
+		return p.Pid()
+	}
+
+	return 0
+}
+
+func (a *adopted) Signal(sig os.Signal) error {
+	if p, ok := a.Component.(Process); ok {
+		return p.Signal(sig)
+	}
+
+	return fmt.Errorf("component %s does not run a daemon", a.Name())
+}
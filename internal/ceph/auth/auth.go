@@ -0,0 +1,161 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package auth
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/command"
+	"github.com/dpeckett/picoceph/internal/keyring"
+	"github.com/dpeckett/picoceph/internal/seed"
+	"github.com/dpeckett/picoceph/internal/tempfile"
+	"github.com/dpeckett/picoceph/internal/util"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// AdminKeyringPath is the path to the client.admin keyring.
+	AdminKeyringPath = "/etc/ceph/ceph.client.admin.keyring"
+	// BootstrapOSDKeyringPath is the path to the client.bootstrap-osd keyring.
+	BootstrapOSDKeyringPath = "/var/lib/ceph/bootstrap-osd/ceph.keyring"
+)
+
+// SharedKeyrings returns the paths of the keyrings that every monitor must
+// import into its initial keyring.
+func SharedKeyrings() []string {
+	return []string{AdminKeyringPath, BootstrapOSDKeyringPath}
+}
+
+// Bootstrap creates the keyrings that are shared by all components. Existing
+// keyrings are left untouched, so it is safe to run more than once.
+func Bootstrap() error {
+	cephUserUid, cephGroupGid, err := ceph.User()
+	if err != nil {
+		return fmt.Errorf("could not get ceph user: %w", err)
+	}
+
+	var g errgroup.Group
+
+	g.Go(func() error {
+		return createKeyring(AdminKeyringPath, "client.admin", cephUserUid, cephGroupGid, map[string]string{
+			"mon": "allow *",
+			"osd": "allow *",
+			"mds": "allow *",
+			"mgr": "allow *",
+		})
+	})
+
+	g.Go(func() error {
+		return createKeyring(BootstrapOSDKeyringPath, "client.bootstrap-osd", cephUserUid, cephGroupGid, map[string]string{
+			"mon": "profile bootstrap-osd",
+			"mgr": "allow r",
+		})
+	})
+
+	return g.Wait()
+}
+
+func createKeyring(path, name string, uid, gid int, caps map[string]string) error {
+	if err := ceph.MkdirAll(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("could not create directory: %w", err)
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		entry, err := keyring.NewEntry(name, caps)
+		if err != nil {
+			return fmt.Errorf("could not create keyring: %w", err)
+		}
+
+		if err := (keyring.Keyring{*entry}).WriteFile(path); err != nil {
+			return fmt.Errorf("could not create keyring: %w", err)
+		}
+	}
+
+	if err := util.Chown(path, uid, gid); err != nil {
+		return fmt.Errorf("could not change owner: %w", err)
+	}
+
+	return nil
+}
+
+// GetOrCreate writes the keyring of the named entity to path, creating the
+// entity if necessary. The caps of an existing entity are updated to match.
+func GetOrCreate(ctx context.Context, name string, caps ceph.Caps, path string) error {
+	// Don't block forever if ceph does not come up.
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+
+	// Fails harmlessly if the entity doesn't exist yet, otherwise get-or-create
+	// would fail because the caps don't match.
+	cmd := command.Context(ctx, "ceph", append([]string{"auth", "caps", name}, caps.Args()...)...)
+	_ = cmd.Run()
+
+	if seed.Enabled() {
+		if err := importSeeded(ctx, name, caps); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("could not create keyring: %w", err)
+	}
+	defer f.Close()
+
+	// Keyrings written by earlier versions may be world-readable.
+	if err := f.Chmod(0o600); err != nil {
+		return fmt.Errorf("could not change mode of keyring: %w", err)
+	}
+
+	cmd = command.Context(ctx, "ceph", append([]string{"auth", "get-or-create", name}, caps.Args()...)...)
+	cmd.Stdout = f
+
+	var out strings.Builder
+	cmd.Stderr = &out
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("could not create keyring: %w: %s", err, out.String())
+	}
+
+	return nil
+}
+
+// importSeeded creates (or updates) the named entity with a key derived from
+// the seed, rather than one generated by the monitor.
+func importSeeded(ctx context.Context, name string, caps ceph.Caps) error {
+	entry, err := keyring.NewEntry(name, caps)
+	if err != nil {
+		return fmt.Errorf("could not create keyring: %w", err)
+	}
+
+	tmpDir, err := tempfile.MkdirPrivate("picoceph-auth-")
+	if err != nil {
+		return err
+	}
+	defer tmpDir.Remove()
+
+	keyringPath := tmpDir.Path("keyring")
+	if err := (keyring.Keyring{*entry}).WriteFile(keyringPath); err != nil {
+		return fmt.Errorf("could not create keyring: %w", err)
+	}
+
+	cmd := command.Context(ctx, "ceph", "auth", "import", "-i", keyringPath)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not import keyring: %w: %s", err, string(out))
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package ceph
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// Caps are the capabilities of a Ceph entity, keyed by daemon type (eg.
+// "mon"). Each capability is a template, which is expanded with the name
+// (eg. "mgr.a") and id (eg. "a") of the entity, eg. "allow rwx pool={{ .ID }}".
+type Caps map[string]string
+
+// Render expands the capability templates for the named entity.
+func (c Caps) Render(name, id string) (Caps, error) {
+	data := struct{ Name, ID string }{Name: name, ID: id}
+
+	rendered := make(Caps, len(c))
+	for daemonType, capTmpl := range c {
+		tmpl, err := template.New(daemonType).Option("missingkey=error").Parse(capTmpl)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %s caps: %w", daemonType, err)
+		}
+
+		var sb strings.Builder
+		if err := tmpl.Execute(&sb, data); err != nil {
+			return nil, fmt.Errorf("could not render %s caps: %w", daemonType, err)
+		}
+
+		rendered[daemonType] = sb.String()
+	}
+
+	return rendered, nil
+}
+
+// Args returns the capabilities as arguments for `ceph auth`, eg.
+// ["mon", "allow r", "osd", "allow *"].
+func (c Caps) Args() []string {
+	daemonTypes := make([]string, 0, len(c))
+	for daemonType := range c {
+		daemonTypes = append(daemonTypes, daemonType)
+	}
+	sort.Strings(daemonTypes)
+
+	var args []string
+	for _, daemonType := range daemonTypes {
+		args = append(args, daemonType, c[daemonType])
+	}
+
+	return args
+}
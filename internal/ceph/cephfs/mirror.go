@@ -0,0 +1,57 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package cephfs configures snapshot mirroring of CephFS filesystems.
+package cephfs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/command"
+)
+
+// EnableMirroring enables snapshot mirroring on a filesystem.
+func EnableMirroring(ctx context.Context, fs string) error {
+	return mirror(ctx, "enable", fs)
+}
+
+// AddMirrorPath mirrors the snapshots of a directory of a filesystem.
+func AddMirrorPath(ctx context.Context, fs, path string) error {
+	return mirror(ctx, "add", fs, path)
+}
+
+// CreatePeerToken creates a user for the peer cluster, and returns a token
+// that it can import to mirror the filesystem.
+func CreatePeerToken(ctx context.Context, fs, clientName, siteName string) (string, error) {
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if err := ceph.RunJSON(ctx, &resp, "fs", "snapshot", "mirror", "peer_bootstrap", "create", fs, clientName, siteName); err != nil {
+		return "", err
+	}
+
+	return resp.Token, nil
+}
+
+// ImportPeerToken adds the peer cluster that created the token.
+func ImportPeerToken(ctx context.Context, fs, token string) error {
+	return mirror(ctx, "peer_bootstrap", "import", fs, strings.TrimSpace(token))
+}
+
+func mirror(ctx context.Context, args ...string) error {
+	cmd := command.Context(ctx, "ceph", append([]string{"fs", "snapshot", "mirror"}, args...)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not run fs snapshot mirror %s: %w: %s", strings.Join(args, " "), err, string(out))
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package cephfsmirror runs cephfs-mirror, which replicates CephFS snapshots
+// between clusters.
+package cephfsmirror
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/ceph/auth"
+	"github.com/dpeckett/picoceph/internal/command"
+	"github.com/dpeckett/picoceph/internal/daemon"
+	"github.com/dpeckett/picoceph/internal/util"
+	"github.com/nxadm/tail"
+)
+
+const (
+	// name is the name of the cephfs-mirror entity.
+	name = "client.cephfs-mirror.a"
+	// dataDir is where the keyring of cephfs-mirror is kept.
+	dataDir = "/var/lib/ceph/cephfs-mirror"
+	// sockPath is the admin socket of cephfs-mirror.
+	sockPath = "/var/run/ceph/ceph-" + name + ".asok"
+)
+
+// DefaultCaps are the default capabilities of cephfs-mirror.
+var DefaultCaps = ceph.Caps{
+	"mon": "profile cephfs-mirror",
+	"mds": "allow r",
+	"osd": "allow rw tag cephfs metadata=*, allow r tag cephfs data=*",
+	"mgr": "allow r",
+}
+
+// Options are the options for cephfs-mirror.
+type Options struct {
+	// Caps are the capabilities of cephfs-mirror (defaults to DefaultCaps).
+	Caps ceph.Caps
+}
+
+// CephFSMirror runs cephfs-mirror.
+type CephFSMirror struct {
+	opts   Options
+	daemon *daemon.Daemon
+}
+
+func New(logger *slog.Logger, opts Options) ceph.Component {
+	if opts.Caps == nil {
+		opts.Caps = DefaultCaps
+	}
+
+	return &CephFSMirror{
+		opts: opts,
+		daemon: daemon.New(logger.With("component", "cephfs-mirror"), "cephfs-mirror",
+			"-f", "-n", name, "--keyring", dataDir+"/keyring"),
+	}
+}
+
+func (m *CephFSMirror) Name() string {
+	return "cephfs-mirror"
+}
+
+func (m *CephFSMirror) Requires() []string {
+	// Mirroring is coordinated by the mirroring manager module.
+	return []string{"mgr"}
+}
+
+func (m *CephFSMirror) Configure(ctx context.Context) error {
+	cmd := command.Context(ctx, "ceph", "mgr", "module", "enable", "mirroring")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not enable mirroring module: %w: %s", err, string(out))
+	}
+
+	if err := ceph.MkdirAll(dataDir); err != nil {
+		return fmt.Errorf("could not create directory: %w", err)
+	}
+
+	caps, err := m.opts.Caps.Render(name, "cephfs-mirror.a")
+	if err != nil {
+		return err
+	}
+
+	if err := auth.GetOrCreate(ctx, name, caps, dataDir+"/keyring"); err != nil {
+		return err
+	}
+
+	cephUserUid, cephGroupGid, err := ceph.User()
+	if err != nil {
+		return fmt.Errorf("could not get ceph user: %w", err)
+	}
+
+	if err := util.ChownRecursive(dataDir, cephUserUid, cephGroupGid); err != nil {
+		return fmt.Errorf("could not change owner: %w", err)
+	}
+
+	return nil
+}
+
+func (m *CephFSMirror) Start(ctx context.Context) error {
+	if err := m.daemon.Run(ctx); err != nil {
+		return fmt.Errorf("could not start cephfs-mirror: %w", err)
+	}
+
+	return nil
+}
+
+func (m *CephFSMirror) Stop(ctx context.Context) error {
+	return m.daemon.Stop(ctx)
+}
+
+func (m *CephFSMirror) Pid() int {
+	return m.daemon.Pid()
+}
+
+func (m *CephFSMirror) Signal(sig os.Signal) error {
+	return m.daemon.Signal(sig)
+}
+
+func (m *CephFSMirror) Ready(ctx context.Context) error {
+	if m.daemon.Pid() == 0 {
+		return fmt.Errorf("cephfs-mirror is not running")
+	}
+
+	// The admin socket is created once cephfs-mirror has connected to the cluster.
+	if _, err := os.Stat(sockPath); err != nil {
+		return fmt.Errorf("cephfs-mirror is not running: %w", err)
+	}
+
+	return nil
+}
+
+func (m *CephFSMirror) Logs() (*tail.Tail, error) {
+	return tail.TailFile(
+		"/var/log/ceph/ceph-"+name+".log",
+		tail.Config{Follow: true, ReOpen: true},
+	)
+}
@@ -0,0 +1,38 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package ceph
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/dpeckett/picoceph/internal/command"
+)
+
+// RunJSON runs a ceph command and decodes its JSON output into v.
+func RunJSON(ctx context.Context, v any, args ...string) error {
+	cmd := command.Context(ctx, "ceph", append(args, "--format=json")...)
+
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("could not run ceph %s: %w: %s", strings.Join(args, " "), err, stderr.String())
+	}
+
+	if err := json.Unmarshal(out, v); err != nil {
+		return fmt.Errorf("could not parse output of ceph %s: %w", strings.Join(args, " "), err)
+	}
+
+	return nil
+}
@@ -11,18 +11,35 @@ package ceph
 
 import (
 	"context"
+	"os"
 
 	"github.com/nxadm/tail"
 )
 
 // Component is a Ceph component eg. monitor, dashboard, etc.
 type Component interface {
-	// Name returns the name of the component.
+	// Name returns the name of the component (eg. "mon.a").
 	Name() string
+	// Requires returns the names of the components that must be ready before
+	// this component is configured. A bare type (eg. "mon") matches every
+	// component of that type.
+	Requires() []string
 	// Configure configures the component (eg. writes config files, creates directories, etc.)
 	Configure(ctx context.Context) error
-	// Start starts the component.
+	// Start starts the component, for daemons it blocks until the daemon exits.
 	Start(ctx context.Context) error
+	// Stop gracefully stops the component, waiting for it to exit.
+	Stop(ctx context.Context) error
+	// Ready returns nil if the component is ready to serve requests.
+	Ready(ctx context.Context) error
 	// Logs returns the logs of the component.
 	Logs() (*tail.Tail, error)
 }
+
+// Process is implemented by components that run a daemon process.
+type Process interface {
+	// Pid returns the pid of the running daemon, or zero if it isn't running.
+	Pid() int
+	// Signal sends a signal to the running daemon.
+	Signal(sig os.Signal) error
+}
@@ -10,34 +10,182 @@
 package ceph
 
 import (
+	"context"
+	"embed"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"text/template"
 
-	_ "embed"
+	"github.com/dpeckett/picoceph/internal/ceph/monmap"
+	"github.com/dpeckett/picoceph/internal/util"
 )
 
-//go:embed assets/ceph.conf.tmpl
-var cephConfTmpl string
+// DefaultPublicNetwork is the default network daemons bind to.
+const DefaultPublicNetwork = "127.0.0.1/32"
+
+//go:embed assets/ceph.conf/*.tmpl
+var cephConfTemplates embed.FS
+
+// ConfigTemplates are the releases with a ceph.conf template (as option names
+// and defaults differ between releases), oldest first.
+var ConfigTemplates = []struct {
+	Major   int
+	Release string
+}{
+	{17, "quincy"},
+	{18, "reef"},
+	{19, "squid"},
+}
+
+// ParseConfigTemplate parses the name of a ceph.conf template (an empty name
+// detects the installed release).
+func ParseConfigTemplate(name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+
+	for _, t := range ConfigTemplates {
+		if t.Release == name {
+			return name, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown ceph.conf template: %s", name)
+}
+
+// ConfigTemplate returns the release of the ceph.conf template for the given
+// version, which is the newest template that isn't newer than the version.
+func ConfigTemplate(version *Version) string {
+	release := ConfigTemplates[0].Release
+	for _, t := range ConfigTemplates {
+		if t.Major <= version.Major {
+			release = t.Release
+		}
+	}
+
+	return release
+}
+
+// Config is the cluster wide configuration written to ceph.conf.
+type Config struct {
+	// MonMap is the initial monitor map of the cluster.
+	MonMap *monmap.MonMap
+	// OSDMemoryTarget is the osd_memory_target in bytes (zero keeps the Ceph default).
+	OSDMemoryTarget int64
+	// CrushLocation is where OSDs are placed in the CRUSH hierarchy (empty
+	// keeps the Ceph default of root=default host=<hostname>).
+	CrushLocation CrushLocation
+	// Discard enables discards in BlueStore, so that freed space is released
+	// by the OSD block devices.
+	Discard bool
+	// PublicNetwork is the network daemons bind to (defaults to
+	// DefaultPublicNetwork).
+	PublicNetwork string
+	// BindAddr is the address monitors bind to, if it differs from their
+	// address in the monmap (eg. 0.0.0.0 behind NAT).
+	BindAddr string
+	// IPv6 binds daemons to IPv6 rather than IPv4 addresses.
+	IPv6 bool
+	// MsgrSecure requires the secure msgr2 mode, which encrypts traffic
+	// between daemons and clients.
+	MsgrSecure bool
+	// OSDID is the id of the local OSD (defaults to "0").
+	OSDID string
+	// VirtualHosts place the local OSDs under hosts of their own in the CRUSH
+	// map, rather than OSDID, and replicate pools across them.
+	VirtualHosts []VirtualHost
+	// Template is the release whose ceph.conf template is used (defaults to
+	// the template for the installed release).
+	Template string
+}
+
+// OSDSection is the ceph.conf section of a local OSD.
+type OSDSection struct {
+	ID string
+	// CrushLocation overrides the location of the [osd] section.
+	CrushLocation CrushLocation
+}
+
+// OSDs returns the sections of the local OSDs.
+func (conf Config) OSDs() []OSDSection {
+	if len(conf.VirtualHosts) == 0 {
+		return []OSDSection{{ID: conf.OSDID}}
+	}
+
+	var sections []OSDSection
+	for _, host := range conf.VirtualHosts {
+		for _, id := range host.OSDIDs {
+			sections = append(sections, OSDSection{ID: id, CrushLocation: conf.CrushLocation.WithHost(host.Name)})
+		}
+	}
+
+	return sections
+}
+
+// PoolSize is the default number of replicas of a pool, one per virtual host
+// (up to three).
+func (conf Config) PoolSize() int {
+	return min(max(len(conf.VirtualHosts), 1), 3)
+}
+
+// PoolMinSize is the default number of replicas a pool needs to accept IO,
+// so that a pool stays writable with a virtual host down.
+func (conf Config) PoolMinSize() int {
+	return conf.PoolSize() - conf.PoolSize()/2
+}
+
+// ChooseLeafType is the bucket type replicas are spread across by the
+// default CRUSH rule: hosts with virtual hosts, otherwise OSDs.
+func (conf Config) ChooseLeafType() int {
+	if len(conf.VirtualHosts) > 0 {
+		return 1
+	}
+
+	return 0
+}
 
 // WriteConfig writes the ceph.conf file.
-func WriteConfig(fsid string) error {
-	cephConf, err := os.Create("/etc/ceph/ceph.conf")
+func WriteConfig(ctx context.Context, conf Config) error {
+	if conf.PublicNetwork == "" {
+		conf.PublicNetwork = DefaultPublicNetwork
+	}
+
+	if conf.OSDID == "" {
+		conf.OSDID = "0"
+	}
+
+	if conf.Template == "" {
+		version, err := InstalledVersion(ctx)
+		if err != nil {
+			return err
+		}
+
+		conf.Template = ConfigTemplate(version)
+	}
+
+	cephConfTmpl, err := cephConfTemplates.ReadFile("assets/ceph.conf/" + conf.Template + ".tmpl")
 	if err != nil {
-		return fmt.Errorf("could not create ceph.conf: %w", err)
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("unknown ceph.conf template: %s", conf.Template)
+		}
+
+		return fmt.Errorf("could not read ceph.conf template: %w", err)
 	}
-	defer cephConf.Close()
 
-	tmpl, err := template.New("ceph.conf").Parse(cephConfTmpl)
+	tmpl, err := template.New("ceph.conf").Parse(string(cephConfTmpl))
 	if err != nil {
 		return fmt.Errorf("could not parse ceph.conf template: %w", err)
 	}
 
-	if err := tmpl.Execute(cephConf, struct {
-		FSID string
-	}{
-		FSID: fsid,
-	}); err != nil {
+	cephConf, err := os.Create("/etc/ceph/ceph.conf")
+	if err != nil {
+		return fmt.Errorf("could not create ceph.conf: %w", err)
+	}
+	defer cephConf.Close()
+
+	if err := tmpl.Execute(cephConf, conf); err != nil {
 		return fmt.Errorf("could not execute ceph.conf template: %w", err)
 	}
 
@@ -46,7 +194,7 @@ func WriteConfig(fsid string) error {
 		return fmt.Errorf("could not get ceph user: %w", err)
 	}
 
-	if err := os.Chown("/etc/ceph/ceph.conf", cephUserUid, cephGroupGid); err != nil {
+	if err := util.Chown("/etc/ceph/ceph.conf", cephUserUid, cephGroupGid); err != nil {
 		return fmt.Errorf("could not change owner: %w", err)
 	}
 
@@ -0,0 +1,137 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package crash runs the ceph-crash agent, which posts the crash dumps of
+// daemons to the crash manager module (so that they show up in `ceph crash ls`).
+package crash
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/ceph/auth"
+	"github.com/dpeckett/picoceph/internal/daemon"
+	"github.com/dpeckett/picoceph/internal/util"
+	"github.com/nxadm/tail"
+)
+
+const (
+	// Dir is where daemons write their crash dumps.
+	Dir = "/var/lib/ceph/crash"
+	// keyringPath is where ceph-crash looks for the keyring of client.crash.
+	keyringPath = "/etc/ceph/ceph.client.crash.keyring"
+)
+
+// DefaultCaps are the default capabilities of the crash agent.
+var DefaultCaps = ceph.Caps{
+	"mon": "profile crash",
+	"mgr": "profile crash",
+}
+
+// Options are the options for the crash agent.
+type Options struct {
+	// Caps are the capabilities of the crash agent (defaults to DefaultCaps).
+	Caps ceph.Caps
+}
+
+// Crash runs ceph-crash.
+type Crash struct {
+	opts   Options
+	daemon *daemon.Daemon
+}
+
+func New(logger *slog.Logger, opts Options) ceph.Component {
+	if opts.Caps == nil {
+		opts.Caps = DefaultCaps
+	}
+
+	return &Crash{
+		opts:   opts,
+		daemon: daemon.New(logger.With("component", "crash"), "ceph-crash", "-n", "client.crash"),
+	}
+}
+
+func (c *Crash) Name() string {
+	return "crash"
+}
+
+func (c *Crash) Requires() []string {
+	// Crashes are posted to the crash manager module.
+	return []string{"mgr"}
+}
+
+func (c *Crash) Configure(ctx context.Context) error {
+	// Daemons only write crash dumps if the directory exists.
+	if err := ceph.MkdirAll(Dir + "/posted"); err != nil {
+		return fmt.Errorf("could not create directory: %w", err)
+	}
+
+	caps, err := c.opts.Caps.Render("client.crash", "crash")
+	if err != nil {
+		return err
+	}
+
+	if err := auth.GetOrCreate(ctx, "client.crash", caps, keyringPath); err != nil {
+		return err
+	}
+
+	cephUserUid, cephGroupGid, err := ceph.User()
+	if err != nil {
+		return fmt.Errorf("could not get ceph user: %w", err)
+	}
+
+	if err := util.ChownRecursive(Dir, cephUserUid, cephGroupGid); err != nil {
+		return fmt.Errorf("could not change owner: %w", err)
+	}
+
+	if err := util.Chown(keyringPath, cephUserUid, cephGroupGid); err != nil {
+		return fmt.Errorf("could not change owner: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Crash) Start(ctx context.Context) error {
+	if err := c.daemon.Run(ctx); err != nil {
+		return fmt.Errorf("could not start crash agent: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Crash) Stop(ctx context.Context) error {
+	return c.daemon.Stop(ctx)
+}
+
+func (c *Crash) Pid() int {
+	return c.daemon.Pid()
+}
+
+func (c *Crash) Signal(sig os.Signal) error {
+	return c.daemon.Signal(sig)
+}
+
+func (c *Crash) Ready(ctx context.Context) error {
+	if c.daemon.Pid() == 0 {
+		return fmt.Errorf("crash agent is not running")
+	}
+
+	return nil
+}
+
+func (c *Crash) Logs() (*tail.Tail, error) {
+	// The crash agent logs to stderr, which is streamed by the daemon.
+	return tail.TailFile(
+		"/dev/null",
+		tail.Config{Follow: true, ReOpen: true},
+	)
+}
@@ -0,0 +1,135 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package ceph
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// crushTypes are the bucket types in the default CRUSH map.
+var crushTypes = []string{
+	"host", "chassis", "rack", "row", "pdu", "pod", "room", "datacenter", "zone", "region", "root",
+}
+
+var crushNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// CrushBucket is a single level of a CRUSH location, eg. rack=r1.
+type CrushBucket struct {
+	Type string
+	Name string
+}
+
+// CrushLocation is where an OSD is placed in the CRUSH hierarchy.
+type CrushLocation []CrushBucket
+
+// ParseCrushLocation parses a CRUSH location in the form used by ceph.conf,
+// eg. "root=default rack=r1 host=node1".
+func ParseCrushLocation(s string) (CrushLocation, error) {
+	var loc CrushLocation
+	for _, field := range strings.Fields(s) {
+		bucketType, name, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid crush location: %s", field)
+		}
+
+		if !slices.Contains(crushTypes, bucketType) {
+			return nil, fmt.Errorf("unknown crush bucket type: %s", bucketType)
+		}
+
+		if !crushNameRegexp.MatchString(name) {
+			return nil, fmt.Errorf("invalid crush bucket name: %q", name)
+		}
+
+		if slices.ContainsFunc(loc, func(b CrushBucket) bool { return b.Type == bucketType }) {
+			return nil, fmt.Errorf("duplicate crush bucket type: %s", bucketType)
+		}
+
+		loc = append(loc, CrushBucket{Type: bucketType, Name: name})
+	}
+
+	return loc, nil
+}
+
+func (loc CrushLocation) String() string {
+	fields := make([]string, len(loc))
+	for i, b := range loc {
+		fields[i] = b.Type + "=" + b.Name
+	}
+
+	return strings.Join(fields, " ")
+}
+
+// WithHost returns the location with its host replaced by name. A root is
+// added if there isn't one, as a host outside of any root holds no data.
+func (loc CrushLocation) WithHost(name string) CrushLocation {
+	var withHost CrushLocation
+	if !slices.ContainsFunc(loc, func(b CrushBucket) bool { return b.Type == "root" }) {
+		withHost = append(withHost, CrushBucket{Type: "root", Name: "default"})
+	}
+
+	for _, b := range loc {
+		if b.Type != "host" {
+			withHost = append(withHost, b)
+		}
+	}
+
+	return append(withHost, CrushBucket{Type: "host", Name: name})
+}
+
+// VirtualHost is a host in the CRUSH map that OSDs are placed under, although
+// every OSD runs here, so that failure domains can be simulated.
+type VirtualHost struct {
+	Name   string
+	OSDIDs []string
+}
+
+// ParseVirtualHosts parses virtual hosts and their number of OSDs, eg.
+// "node1=2 node2=1". OSD ids are assigned in order, starting from 0.
+func ParseVirtualHosts(s string, maxOSDs int) ([]VirtualHost, error) {
+	var hosts []VirtualHost
+	var nextID int
+	for _, field := range strings.Fields(s) {
+		name, count, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid virtual host: %s", field)
+		}
+
+		if !crushNameRegexp.MatchString(name) {
+			return nil, fmt.Errorf("invalid virtual host name: %q", name)
+		}
+
+		if slices.ContainsFunc(hosts, func(h VirtualHost) bool { return h.Name == name }) {
+			return nil, fmt.Errorf("duplicate virtual host: %s", name)
+		}
+
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid number of OSDs for virtual host %s: %s", name, count)
+		}
+
+		if nextID+n > maxOSDs {
+			return nil, fmt.Errorf("too many OSDs for virtual hosts: at most %d are supported", maxOSDs)
+		}
+
+		host := VirtualHost{Name: name}
+		for i := 0; i < n; i++ {
+			host.OSDIDs = append(host.OSDIDs, strconv.Itoa(nextID))
+			nextID++
+		}
+
+		hosts = append(hosts, host)
+	}
+
+	return hosts, nil
+}
@@ -0,0 +1,151 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package custom implements user defined components, that run arbitrary
+// commands (eg. an S3 proxy or an exporter) alongside the cluster.
+package custom
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/command"
+	"github.com/dpeckett/picoceph/internal/daemon"
+	"github.com/nxadm/tail"
+)
+
+// Spec describes a custom component.
+type Spec struct {
+	// Name is the name of the component, eg. "proxy.s3".
+	Name string `json:"name"`
+	// Requires are the components that must be ready before this one is
+	// configured, eg. ["rgw"].
+	Requires []string `json:"requires,omitempty"`
+	// Configure is an optional command that is run once, before starting.
+	Configure []string `json:"configure,omitempty"`
+	// Start is the command to run, it must run in the foreground.
+	Start []string `json:"start"`
+	// Ready is an optional command that exits successfully once the
+	// component is ready, otherwise the component is ready once started.
+	Ready []string `json:"ready,omitempty"`
+	// LogFile is an optional log file to tail, in addition to the output of
+	// the start command.
+	LogFile string `json:"logFile,omitempty"`
+}
+
+// reservedNames are the names of built-in components. A requirement on a name
+// is also met by every component named after it (eg. "osd" by "osd.0"), so
+// custom components can't be named after them either.
+var reservedNames = []string{
+	"cephfs-mirror", "crash", "dashboard", "dns", "exporter", "iscsi", "loadgen", "mgr",
+	"mon", "nfs", "nvmeof", "osd", "prometheus", "rbd-mirror", "restful", "rgw",
+}
+
+// Validate checks that the spec is complete.
+func (s *Spec) Validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("custom component is missing a name")
+	}
+
+	for _, reserved := range reservedNames {
+		if s.Name == reserved || strings.HasPrefix(s.Name, reserved+".") {
+			return fmt.Errorf("custom component %s collides with the built-in %s components", s.Name, reserved)
+		}
+	}
+
+	if len(s.Start) == 0 {
+		return fmt.Errorf("custom component %s is missing a start command", s.Name)
+	}
+
+	return nil
+}
+
+type Custom struct {
+	spec   Spec
+	daemon *daemon.Daemon
+}
+
+func New(logger *slog.Logger, spec Spec) ceph.Component {
+	return &Custom{
+		spec:   spec,
+		daemon: daemon.New(logger.With("component", spec.Name), spec.Start[0], spec.Start[1:]...),
+	}
+}
+
+func (c *Custom) Name() string {
+	return c.spec.Name
+}
+
+func (c *Custom) Requires() []string {
+	return c.spec.Requires
+}
+
+func (c *Custom) Configure(ctx context.Context) error {
+	if len(c.spec.Configure) == 0 {
+		return nil
+	}
+
+	cmd := command.Context(ctx, c.spec.Configure[0], c.spec.Configure[1:]...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not run configure command: %w: %s", err, string(out))
+	}
+
+	return nil
+}
+
+func (c *Custom) Start(ctx context.Context) error {
+	if err := c.daemon.Run(ctx); err != nil {
+		return fmt.Errorf("could not start %s: %w", c.spec.Name, err)
+	}
+
+	return nil
+}
+
+func (c *Custom) Stop(ctx context.Context) error {
+	return c.daemon.Stop(ctx)
+}
+
+func (c *Custom) Pid() int {
+	return c.daemon.Pid()
+}
+
+func (c *Custom) Signal(sig os.Signal) error {
+	return c.daemon.Signal(sig)
+}
+
+func (c *Custom) Ready(ctx context.Context) error {
+	if len(c.spec.Ready) == 0 {
+		if c.daemon.Pid() == 0 {
+			return fmt.Errorf("%s is not running", c.spec.Name)
+		}
+
+		return nil
+	}
+
+	cmd := command.Context(ctx, c.spec.Ready[0], c.spec.Ready[1:]...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ready command failed: %w: %s", err, string(out))
+	}
+
+	return nil
+}
+
+func (c *Custom) Logs() (*tail.Tail, error) {
+	logFile := c.spec.LogFile
+	if logFile == "" {
+		// The output of the start command is already logged.
+		logFile = "/dev/null"
+	}
+
+	return tail.TailFile(logFile, tail.Config{Follow: true, ReOpen: true})
+}
@@ -0,0 +1,38 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package custom
+
+import "testing"
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		start []string
+		ok    bool
+	}{
+		{name: "proxy.s3", start: []string{"s3proxy"}, ok: true},
+		{name: "mds", start: []string{"ceph-mds"}, ok: true},
+		{name: "osdextra", start: []string{"true"}, ok: true},
+		{name: "proxy.s3"},
+		{start: []string{"true"}},
+		{name: "mon", start: []string{"true"}},
+		{name: "mon.a", start: []string{"true"}},
+		{name: "osd.3", start: []string{"true"}},
+		{name: "rgw", start: []string{"true"}},
+		{name: "rgw.proxy", start: []string{"true"}},
+		{name: "iscsi.api", start: []string{"true"}},
+		{name: "dashboard", start: []string{"true"}},
+	} {
+		spec := Spec{Name: tc.name, Start: tc.start}
+		if err := spec.Validate(); (err == nil) != tc.ok {
+			t.Errorf("Validate(%q, %q) = %v", tc.name, tc.start, err)
+		}
+	}
+}
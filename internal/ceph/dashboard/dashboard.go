@@ -11,90 +11,161 @@ package dashboard
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"os/exec"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/command"
+	"github.com/dpeckett/picoceph/internal/seed"
+	"github.com/dpeckett/picoceph/internal/tempfile"
 	"github.com/nxadm/tail"
 )
 
-type Dashboard struct{}
+// CredentialsPath is where the URL and admin credentials of the dashboard are written.
+const CredentialsPath = "/etc/ceph/dashboard-credentials.json"
+
+// DefaultPort is the default port the dashboard is served on.
+const DefaultPort = 8080
+
+// Options are the options for the dashboard.
+type Options struct {
+	// Addr is the address the dashboard binds to (defaults to all addresses).
+	Addr string
+	// Port is the port the dashboard is served on (defaults to DefaultPort).
+	Port int
+	// AdminUsername is the name of the dashboard admin user (defaults to "admin").
+	AdminUsername string
+	// AdminPassword is the password of the dashboard admin user (defaults to
+	// a random password).
+	AdminPassword string
+}
+
+// Credentials are the URL and admin credentials of the dashboard.
+type Credentials struct {
+	URL      string `json:"url"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
 
-func New() ceph.Component {
-	return &Dashboard{}
+type Dashboard struct {
+	logger *slog.Logger
+	opts   Options
+}
+
+func New(logger *slog.Logger, opts Options) ceph.Component {
+	if opts.AdminUsername == "" {
+		opts.AdminUsername = "admin"
+	}
+
+	if opts.Port == 0 {
+		opts.Port = DefaultPort
+	}
+
+	return &Dashboard{
+		logger: logger.With("component", "dashboard"),
+		opts:   opts,
+	}
 }
 
 func (d *Dashboard) Name() string {
 	return "dashboard"
 }
 
+func (d *Dashboard) Requires() []string {
+	// The dashboard is a manager module.
+	return []string{"mgr"}
+}
+
 func (d *Dashboard) Configure(ctx context.Context) error {
-	// Don't block forever if ceph does not come up.
-	cephCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
-	defer cancel()
+	cmd := command.Context(ctx, "ceph", "config", "set", "mgr", "mgr/dashboard/ssl", "false")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not disable SSL for dashboard: %w: %s", err, string(out))
+	}
 
-	// Wait for the mgr module to be ready.
-	for {
-		select {
-		case <-cephCtx.Done():
-			return fmt.Errorf("timed out waiting for dashboard module to load")
-		default:
-
-			cmd := exec.CommandContext(cephCtx, "ceph", "mgr", "module", "ls", "--format=json")
-			out, err := cmd.CombinedOutput()
-			if err != nil {
-				return fmt.Errorf("could not list mgr modules: %w: %s", err, string(out))
-			}
-
-			// Parse the JSON output.
-			var moduleList struct {
-				AlwaysOnModules []string `json:"always_on_modules"`
-				EnabledModules  []string `json:"enabled_modules"`
-				DisabledModules []struct {
-					Name string `json:"name"`
-				} `json:"disabled_modules"`
-			}
-
-			if err := json.Unmarshal(out, &moduleList); err != nil {
-				return fmt.Errorf("could not parse mgr modules: %w: %s", err, string(out))
-			}
-
-			// Check if the dashboard module is loaded.
-			var modules []string
-			modules = append(modules, moduleList.AlwaysOnModules...)
-			modules = append(modules, moduleList.EnabledModules...)
-			for _, mod := range moduleList.DisabledModules {
-				modules = append(modules, mod.Name)
-			}
-
-			for _, module := range modules {
-				if module == "dashboard" {
-					return nil
-				}
-			}
-
-			// If the module is not loaded, wait a bit and try again.
-			time.Sleep(time.Second)
-		}
+	cmd = command.Context(ctx, "ceph", "config", "set", "mgr", "mgr/dashboard/server_port", strconv.Itoa(d.opts.Port))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not set dashboard port: %w: %s", err, string(out))
 	}
+
+	if d.opts.Addr != "" {
+		cmd = command.Context(ctx, "ceph", "config", "set", "mgr", "mgr/dashboard/server_addr", d.opts.Addr)
+	} else {
+		cmd = command.Context(ctx, "ceph", "config", "rm", "mgr", "mgr/dashboard/server_addr")
+	}
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not set dashboard address: %w: %s", err, string(out))
+	}
+
+	return nil
 }
 
 func (d *Dashboard) Start(ctx context.Context) error {
-	cmd := exec.CommandContext(ctx, "ceph", "mgr", "module", "enable", "dashboard")
+	cmd := command.Context(ctx, "ceph", "mgr", "module", "enable", "dashboard")
 	if out, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("could not enable dashboard: %w: %s", err, string(out))
 	}
 
-	cmd = exec.CommandContext(ctx, "ceph", "config", "set", "mgr", "mgr/dashboard/ssl", "false")
-	if out, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("could not disable SSL for dashboard: %w: %s", err, string(out))
+	// Don't block forever if the dashboard does not come up.
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+
+	// The dashboard commands aren't available until the module is loaded.
+	url, err := d.waitForURL(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get dashboard url: %w", err)
 	}
 
+	creds := Credentials{
+		URL:      url,
+		Username: d.opts.AdminUsername,
+		Password: d.opts.AdminPassword,
+	}
+
+	if creds.Password == "" && seed.Enabled() {
+		creds.Password = seed.String("dashboard/password/"+creds.Username, 16, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789")
+	} else if creds.Password == "" {
+		creds.Password, err = randomPassword()
+		if err != nil {
+			return fmt.Errorf("could not generate password: %w", err)
+		}
+	}
+
+	if err := createAdminUser(ctx, creds.Username, creds.Password); err != nil {
+		return err
+	}
+
+	credsJSON, err := json.MarshalIndent(creds, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(CredentialsPath, credsJSON, 0o600); err != nil {
+		return fmt.Errorf("could not write dashboard credentials: %w", err)
+	}
+
+	d.logger.Info("Dashboard is available", "url", creds.URL, "username", creds.Username, "password", creds.Password)
+
+	return nil
+}
+
+func (d *Dashboard) Stop(ctx context.Context) error {
+	// The dashboard is served by the manager, so there is nothing to stop.
 	return nil
 }
 
+func (d *Dashboard) Ready(ctx context.Context) error {
+	_, err := serviceURL(ctx)
+	return err
+}
+
 func (d *Dashboard) Logs() (*tail.Tail, error) {
 	// Dashboard logs are logged by the manager.
 	return tail.TailFile(
@@ -102,3 +173,80 @@ func (d *Dashboard) Logs() (*tail.Tail, error) {
 		tail.Config{Follow: true, ReOpen: true},
 	)
 }
+
+// serviceURL returns the url the dashboard is being served on.
+func serviceURL(ctx context.Context) (string, error) {
+	services := map[string]string{}
+	if err := ceph.RunJSON(ctx, &services, "mgr", "services"); err != nil {
+		return "", err
+	}
+
+	url, ok := services["dashboard"]
+	if !ok {
+		return "", fmt.Errorf("dashboard is not being served")
+	}
+
+	return url, nil
+}
+
+// waitForURL polls until the dashboard is being served, and returns its url.
+func (d *Dashboard) waitForURL(ctx context.Context) (string, error) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		url, err := serviceURL(ctx)
+		if err == nil {
+			return url, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("%w: %w", ctx.Err(), err)
+		case <-ticker.C:
+		}
+	}
+}
+
+// createAdminUser creates the dashboard admin user, or resets its password if
+// it already exists (eg. because the cluster is being reused).
+func createAdminUser(ctx context.Context, username, password string) error {
+	// Passwords are read from a file, so they don't show up in the process list.
+	tmpDir, err := tempfile.MkdirPrivate("picoceph-dashboard-")
+	if err != nil {
+		return err
+	}
+	defer tmpDir.Remove()
+
+	passwordPath := tmpDir.Path("password")
+	if err := os.WriteFile(passwordPath, []byte(password), 0o600); err != nil {
+		return fmt.Errorf("could not write password: %w", err)
+	}
+
+	cmd := command.Context(ctx, "ceph", "dashboard", "ac-user-create", username, "-i", passwordPath, "administrator", "--force-password")
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil
+	}
+
+	if !strings.Contains(string(out), "already exists") {
+		return fmt.Errorf("could not create dashboard user: %w: %s", err, string(out))
+	}
+
+	cmd = command.Context(ctx, "ceph", "dashboard", "ac-user-set-password", username, "-i", passwordPath, "--force-password")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not set dashboard password: %w: %s", err, string(out))
+	}
+
+	return nil
+}
+
+// randomPassword generates a random password.
+func randomPassword() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package dashboard
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/ceph/radosgw"
+	"github.com/dpeckett/picoceph/internal/command"
+	"github.com/dpeckett/picoceph/internal/tempfile"
+	"github.com/nxadm/tail"
+)
+
+// rgwUserID is the id of the system user the dashboard uses to manage RGW.
+const rgwUserID = "dashboard"
+
+// RGWOptions are the options for wiring the dashboard up to the RADOS Gateway.
+type RGWOptions struct {
+	// Key is the S3 key of an existing system user to use, instead of
+	// creating one (eg. because users can only be created on the primary zone).
+	Key *radosgw.Key
+}
+
+// RGW gives the dashboard credentials for the RADOS Gateway, so that its
+// Object Gateway pages work.
+type RGW struct {
+	opts       RGWOptions
+	configured atomic.Bool
+}
+
+// NewRGW creates a component that wires the dashboard up to the RADOS Gateway.
+func NewRGW(opts RGWOptions) ceph.Component {
+	return &RGW{opts: opts}
+}
+
+func (r *RGW) Name() string {
+	return "dashboard.rgw"
+}
+
+func (r *RGW) Requires() []string {
+	return []string{"dashboard", "rgw"}
+}
+
+func (r *RGW) Configure(ctx context.Context) error {
+	return nil
+}
+
+func (r *RGW) Start(ctx context.Context) error {
+	key := r.opts.Key
+	if key == nil {
+		user, err := radosgw.CreateUser(ctx, radosgw.UserOptions{
+			UID:         rgwUserID,
+			DisplayName: "Ceph Dashboard",
+			System:      true,
+		})
+		if err != nil {
+			return err
+		}
+
+		var ok bool
+		if key, ok = user.Key(""); !ok {
+			return fmt.Errorf("user %s has no keys", rgwUserID)
+		}
+	}
+
+	// Keys are read from files, so they don't show up in the process list.
+	tmpDir, err := tempfile.MkdirPrivate("picoceph-dashboard-rgw-")
+	if err != nil {
+		return err
+	}
+	defer tmpDir.Remove()
+
+	for setting, key := range map[string]string{
+		"set-rgw-api-access-key": key.AccessKey,
+		"set-rgw-api-secret-key": key.SecretKey,
+	} {
+		keyPath := tmpDir.Path(setting)
+		if err := os.WriteFile(keyPath, []byte(key), 0o600); err != nil {
+			return fmt.Errorf("could not write key: %w", err)
+		}
+
+		cmd := command.Context(ctx, "ceph", "dashboard", setting, "-i", keyPath)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("could not %s: %w: %s", setting, err, string(out))
+		}
+	}
+
+	r.configured.Store(true)
+
+	return nil
+}
+
+func (r *RGW) Stop(ctx context.Context) error {
+	// Nothing is running.
+	return nil
+}
+
+func (r *RGW) Ready(ctx context.Context) error {
+	if !r.configured.Load() {
+		return fmt.Errorf("dashboard has not been given RGW credentials")
+	}
+
+	return nil
+}
+
+func (r *RGW) Logs() (*tail.Tail, error) {
+	return tail.TailFile(
+		"/dev/null",
+		tail.Config{Follow: true, ReOpen: true},
+	)
+}
@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package ceph
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dpeckett/picoceph/internal/util"
+	"golang.org/x/sync/errgroup"
+)
+
+// Directories are the top level directories where ceph keeps its state (and
+// the admin sockets of its daemons, whose names are the same in every
+// instance).
+var Directories = []string{"/etc/ceph", "/var/lib/ceph", "/var/log/ceph", "/var/run/ceph"}
+
+// DirMode is the mode (permissions, and optionally the setgid bit) that ceph
+// directories are created with.
+var DirMode os.FileMode = 0o755
+
+// MkdirAll creates a directory, along with any necessary parents, and sets
+// its mode to DirMode (regardless of the umask).
+func MkdirAll(path string) error {
+	if err := os.MkdirAll(path, DirMode.Perm()); err != nil {
+		return err
+	}
+
+	return os.Chmod(path, DirMode)
+}
+
+// CreateDirectories creates the directories that are shared by all components. It
+// must be run once before any component is configured.
+func CreateDirectories() error {
+	cephUserUid, cephGroupGid, err := User()
+	if err != nil {
+		return fmt.Errorf("could not get ceph user: %w", err)
+	}
+
+	var g errgroup.Group
+
+	for _, dir := range Directories {
+		dir := dir
+
+		g.Go(func() error {
+			if err := MkdirAll(dir); err != nil {
+				return fmt.Errorf("could not create directory: %w", err)
+			}
+
+			if err := util.Chown(dir, cephUserUid, cephGroupGid); err != nil {
+				return fmt.Errorf("could not change owner: %w", err)
+			}
+
+			return nil
+		})
+	}
+
+	return g.Wait()
+}
@@ -0,0 +1,197 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/ceph/auth"
+	"github.com/dpeckett/picoceph/internal/daemon"
+	"github.com/dpeckett/picoceph/internal/util"
+	"github.com/nxadm/tail"
+)
+
+const (
+	// DefaultPort is the default port daemon perf counters are served on.
+	DefaultPort = 9926
+	// DefaultSockDir is where daemons create their admin sockets (the Ceph
+	// default run_dir).
+	DefaultSockDir = "/var/run/ceph"
+	// keyringPath is where the keyring of the exporter is kept.
+	keyringPath = "/var/lib/ceph/exporter/keyring"
+	// firstRelease is the first Ceph release (Reef) that ships ceph-exporter.
+	firstRelease = 18
+)
+
+// DefaultCaps are the default capabilities of the exporter.
+var DefaultCaps = ceph.Caps{
+	"mon": "allow r",
+	"mgr": "allow r",
+	"osd": "allow r",
+}
+
+// Options are the options for the exporter.
+type Options struct {
+	// Caps are the capabilities of the exporter (defaults to DefaultCaps).
+	Caps ceph.Caps
+	// Addr is the address metrics are served on (defaults to all addresses).
+	Addr string
+	// Port is the port metrics are served on (defaults to DefaultPort).
+	Port int
+	// SockDir is the directory containing the admin sockets of the daemons
+	// (defaults to DefaultSockDir).
+	SockDir string
+}
+
+// Endpoint returns the URL metrics can be scraped from.
+func (o Options) Endpoint() string {
+	port := o.Port
+	if port == 0 {
+		port = DefaultPort
+	}
+
+	return "http://" + util.LocalAddr(net.JoinHostPort(o.Addr, strconv.Itoa(port))) + "/metrics"
+}
+
+// Exporter runs ceph-exporter, which exports the perf counters of every
+// daemon (read over their admin sockets) for scraping.
+type Exporter struct {
+	opts   Options
+	daemon *daemon.Daemon
+}
+
+func New(logger *slog.Logger, opts Options) ceph.Component {
+	if opts.Caps == nil {
+		opts.Caps = DefaultCaps
+	}
+
+	if opts.Port == 0 {
+		opts.Port = DefaultPort
+	}
+
+	if opts.SockDir == "" {
+		opts.SockDir = DefaultSockDir
+	}
+
+	addr := opts.Addr
+	if addr == "" {
+		addr = "0.0.0.0"
+	}
+
+	return &Exporter{
+		opts: opts,
+		daemon: daemon.New(logger.With("component", "exporter"), "ceph-exporter",
+			"-n", "client.ceph-exporter", "-f", "--keyring", keyringPath,
+			"--sock-dir", opts.SockDir, "--addrs", addr, "--port", strconv.Itoa(opts.Port)),
+	}
+}
+
+func (e *Exporter) Name() string {
+	return "exporter"
+}
+
+func (e *Exporter) Requires() []string {
+	// The exporter scrapes the admin sockets of the daemons.
+	return []string{"mon", "mgr", "osd"}
+}
+
+func (e *Exporter) Configure(ctx context.Context) error {
+	version, err := ceph.InstalledVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	if version.Major < firstRelease {
+		return fmt.Errorf("ceph-exporter is not shipped with ceph %s", version)
+	}
+
+	// The daemons it depends on are running, so their sockets should exist.
+	if sockets, _ := filepath.Glob(filepath.Join(e.opts.SockDir, "*.asok")); len(sockets) == 0 {
+		return fmt.Errorf("no admin sockets found in %s", e.opts.SockDir)
+	}
+
+	if err := ceph.MkdirAll("/var/lib/ceph/exporter"); err != nil {
+		return fmt.Errorf("could not create directory: %w", err)
+	}
+
+	caps, err := e.opts.Caps.Render("client.ceph-exporter", "ceph-exporter")
+	if err != nil {
+		return err
+	}
+
+	if err := auth.GetOrCreate(ctx, "client.ceph-exporter", caps, keyringPath); err != nil {
+		return err
+	}
+
+	cephUserUid, cephGroupGid, err := ceph.User()
+	if err != nil {
+		return fmt.Errorf("could not get ceph user: %w", err)
+	}
+
+	if err := util.ChownRecursive("/var/lib/ceph/exporter", cephUserUid, cephGroupGid); err != nil {
+		return fmt.Errorf("could not change owner: %w", err)
+	}
+
+	return nil
+}
+
+func (e *Exporter) Start(ctx context.Context) error {
+	if err := e.daemon.Run(ctx); err != nil {
+		return fmt.Errorf("could not start exporter: %w", err)
+	}
+
+	return nil
+}
+
+func (e *Exporter) Stop(ctx context.Context) error {
+	return e.daemon.Stop(ctx)
+}
+
+func (e *Exporter) Pid() int {
+	return e.daemon.Pid()
+}
+
+func (e *Exporter) Signal(sig os.Signal) error {
+	return e.daemon.Signal(sig)
+}
+
+func (e *Exporter) Ready(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.opts.Endpoint(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (e *Exporter) Logs() (*tail.Tail, error) {
+	return tail.TailFile(
+		"/var/log/ceph/ceph-client.ceph-exporter.log",
+		tail.Config{Follow: true, ReOpen: true},
+	)
+}
@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package ceph
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// FSIDPath is where the fsid of a bootstrapped cluster is recorded, so that
+// the cluster can be reused by subsequent runs.
+const FSIDPath = "/var/lib/ceph/fsid"
+
+// ReadFSID returns the fsid of a previously bootstrapped cluster, or an empty
+// string if there isn't one.
+func ReadFSID() (string, error) {
+	fsid, err := os.ReadFile(FSIDPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+
+		return "", fmt.Errorf("could not read fsid: %w", err)
+	}
+
+	return strings.TrimSpace(string(fsid)), nil
+}
+
+// WriteFSID records the fsid of a newly bootstrapped cluster.
+func WriteFSID(fsid string) error {
+	if err := os.WriteFile(FSIDPath, []byte(fsid+"\n"), 0o644); err != nil {
+		return fmt.Errorf("could not write fsid: %w", err)
+	}
+
+	return nil
+}
+
+// ConfiguredFSID returns the fsid from /etc/ceph/ceph.conf, or an empty string
+// if there isn't one.
+func ConfiguredFSID() string {
+	f, err := os.Open("/etc/ceph/ceph.conf")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if ok && strings.TrimSpace(key) == "fsid" {
+			return strings.TrimSpace(value)
+		}
+	}
+
+	return ""
+}
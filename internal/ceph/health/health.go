@@ -0,0 +1,166 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package health tracks the health of the cluster.
+package health
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+)
+
+// Status is the overall health status of the cluster.
+type Status string
+
+const (
+	StatusOK   Status = "HEALTH_OK"
+	StatusWarn Status = "HEALTH_WARN"
+	StatusErr  Status = "HEALTH_ERR"
+)
+
+// Check is a single failing health check.
+type Check struct {
+	Severity Status `json:"severity"`
+	Summary  struct {
+		Message string `json:"message"`
+	} `json:"summary"`
+	Detail []struct {
+		Message string `json:"message"`
+	} `json:"detail"`
+}
+
+// Report is the output of `ceph health detail`.
+type Report struct {
+	Status Status           `json:"status"`
+	Checks map[string]Check `json:"checks"`
+}
+
+// Get returns the current health of the cluster.
+func Get(ctx context.Context) (*Report, error) {
+	var report Report
+	if err := ceph.RunJSON(ctx, &report, "health", "detail"); err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
+
+// Watcher periodically checks the health of the cluster and logs whenever it
+// changes.
+type Watcher struct {
+	logger   *slog.Logger
+	interval time.Duration
+	mu       sync.Mutex
+	latest   *Report
+}
+
+// NewWatcher creates a new health watcher that polls at the given interval.
+func NewWatcher(logger *slog.Logger, interval time.Duration) *Watcher {
+	return &Watcher{
+		logger:   logger.With("component", "health"),
+		interval: interval,
+	}
+}
+
+// Latest returns the most recent health report, or nil if the cluster has
+// not yet reported its health.
+func (w *Watcher) Latest() *Report {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.latest
+}
+
+// Run polls the health of the cluster until the context is cancelled.
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pollCtx, cancel := context.WithTimeout(ctx, w.interval)
+		report, err := Get(pollCtx)
+		cancel()
+		if err != nil {
+			// Expected until the monitor is up.
+			w.logger.Debug("Could not get cluster health", "error", err)
+			continue
+		}
+
+		w.mu.Lock()
+		previous := w.latest
+		w.latest = report
+		w.mu.Unlock()
+
+		w.logTransitions(previous, report)
+	}
+}
+
+func (w *Watcher) logTransitions(previous, current *Report) {
+	if previous == nil || previous.Status != current.Status {
+		var from Status
+		if previous != nil {
+			from = previous.Status
+		}
+
+		w.logger.Log(context.Background(), level(current.Status), "Cluster health changed",
+			"from", from, "to", current.Status)
+	}
+
+	for _, name := range sortedNames(current.Checks) {
+		if previous != nil {
+			if _, ok := previous.Checks[name]; ok {
+				continue
+			}
+		}
+
+		check := current.Checks[name]
+		w.logger.Log(context.Background(), level(check.Severity), "Health check raised",
+			"check", name, "message", check.Summary.Message)
+	}
+
+	if previous != nil {
+		for _, name := range sortedNames(previous.Checks) {
+			if _, ok := current.Checks[name]; !ok {
+				w.logger.Info("Health check cleared", "check", name)
+			}
+		}
+	}
+}
+
+func level(status Status) slog.Level {
+	switch status {
+	case StatusOK:
+		return slog.LevelInfo
+	case StatusWarn:
+		return slog.LevelWarn
+	default:
+		return slog.LevelError
+	}
+}
+
+func sortedNames(checks map[string]Check) []string {
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
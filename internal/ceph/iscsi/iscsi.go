@@ -0,0 +1,304 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package iscsi exports an RBD image as an iSCSI LUN, using ceph-iscsi. It is
+// made up of three components: tcmu-runner (the userspace backstore), the
+// rbd-target-api daemon, and the target that is configured through it.
+package iscsi
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/dpeckett/picoceph/internal/audit"
+	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/ceph/rbd"
+	"github.com/dpeckett/picoceph/internal/command"
+	"github.com/dpeckett/picoceph/internal/daemon"
+	"github.com/dpeckett/picoceph/internal/seed"
+	"github.com/dpeckett/picoceph/internal/util"
+	"github.com/nxadm/tail"
+)
+
+const (
+	// DefaultIQN is the default name of the iSCSI target.
+	DefaultIQN = "iqn.2003-01.com.redhat.iscsi-gw:picoceph"
+	// DefaultPool is the default pool of the exported image.
+	DefaultPool = "rbd"
+	// DefaultImage is the default name of the exported image.
+	DefaultImage = "iscsi"
+	// DefaultImageSize is the default size of the exported image.
+	DefaultImageSize = 1 << 30
+	// Port is the port iSCSI is served on.
+	Port = 3260
+	// apiPort is the port rbd-target-api is served on.
+	apiPort = 5000
+	// configPath is where the ceph-iscsi configuration is written.
+	configPath = "/etc/ceph/iscsi-gateway.cfg"
+	// apiPasswordPath is where the password of the rbd-target-api admin user
+	// is written.
+	apiPasswordPath = "/etc/ceph/iscsi-api-password"
+	// configFSPath is where the kernel target configuration is exposed.
+	configFSPath = "/sys/kernel/config"
+)
+
+// Options are the options for the iSCSI gateway.
+type Options struct {
+	// Addr is the address iSCSI is served on (defaults to the address of the
+	// host).
+	Addr string
+	// IQN is the name of the target (defaults to DefaultIQN).
+	IQN string
+	// Pool is the pool of the exported image (defaults to DefaultPool).
+	Pool string
+	// Image is the name of the exported image (defaults to DefaultImage).
+	Image string
+	// ImageSize is the size of the exported image in bytes (defaults to
+	// DefaultImageSize).
+	ImageSize int64
+}
+
+func (o *Options) setDefaults() {
+	if o.Addr == "" {
+		o.Addr = util.HostIP()
+	}
+
+	if o.IQN == "" {
+		o.IQN = DefaultIQN
+	}
+
+	if o.Pool == "" {
+		o.Pool = DefaultPool
+	}
+
+	if o.Image == "" {
+		o.Image = DefaultImage
+	}
+
+	if o.ImageSize == 0 {
+		o.ImageSize = DefaultImageSize
+	}
+}
+
+// Endpoint returns the portal iSCSI initiators can discover the target on.
+func (o Options) Endpoint() string {
+	o.setDefaults()
+
+	return net.JoinHostPort(o.Addr, strconv.Itoa(Port))
+}
+
+// New creates the components of the iSCSI gateway.
+func New(logger *slog.Logger, opts Options) []ceph.Component {
+	opts.setDefaults()
+
+	return []ceph.Component{
+		&TCMU{daemon: daemon.New(logger.With("component", "iscsi.tcmu"), "tcmu-runner")},
+		&API{opts: opts, daemon: daemon.New(logger.With("component", "iscsi.api"), "rbd-target-api")},
+		&Target{logger: logger.With("component", "iscsi.target"), opts: opts},
+	}
+}
+
+// TCMU runs tcmu-runner, which serves the RBD backed LUNs of the kernel target.
+type TCMU struct {
+	daemon *daemon.Daemon
+}
+
+func (t *TCMU) Name() string {
+	return "iscsi.tcmu"
+}
+
+func (t *TCMU) Requires() []string {
+	return []string{"mon"}
+}
+
+func (t *TCMU) Configure(ctx context.Context) error {
+	for _, module := range []string{"target_core_user", "iscsi_target_mod"} {
+		audit.Record("load kernel module", "module", module)
+
+		// Load the kernel module (if not already loaded or built-in).
+		cmd := command.Context(ctx, "/sbin/modprobe", module)
+		_ = cmd.Run()
+	}
+
+	if _, err := os.Stat(configFSPath + "/target"); err == nil {
+		return nil
+	}
+
+	audit.Record("mount configfs", "path", configFSPath)
+
+	if err := syscall.Mount("configfs", configFSPath, "configfs", 0, ""); err != nil && !errors.Is(err, syscall.EBUSY) {
+		return fmt.Errorf("could not mount configfs: %w", err)
+	}
+
+	if _, err := os.Stat(configFSPath + "/target"); err != nil {
+		return fmt.Errorf("your kernel does not support iSCSI targets (target_core_user and iscsi_target_mod)")
+	}
+
+	return nil
+}
+
+func (t *TCMU) Start(ctx context.Context) error {
+	if err := t.daemon.Run(ctx); err != nil {
+		return fmt.Errorf("could not start tcmu-runner: %w", err)
+	}
+
+	return nil
+}
+
+func (t *TCMU) Stop(ctx context.Context) error {
+	return t.daemon.Stop(ctx)
+}
+
+func (t *TCMU) Pid() int {
+	return t.daemon.Pid()
+}
+
+func (t *TCMU) Signal(sig os.Signal) error {
+	return t.daemon.Signal(sig)
+}
+
+func (t *TCMU) Ready(ctx context.Context) error {
+	if t.daemon.Pid() == 0 {
+		return fmt.Errorf("tcmu-runner is not running")
+	}
+
+	return nil
+}
+
+func (t *TCMU) Logs() (*tail.Tail, error) {
+	return tail.TailFile(
+		"/var/log/tcmu-runner.log",
+		tail.Config{Follow: true, ReOpen: true},
+	)
+}
+
+// API runs rbd-target-api, which configures the kernel target and stores the
+// configuration of the gateway in RADOS.
+type API struct {
+	opts   Options
+	daemon *daemon.Daemon
+}
+
+func (a *API) Name() string {
+	return "iscsi.api"
+}
+
+func (a *API) Requires() []string {
+	// The gateway configuration is stored in a pool, which requires OSDs.
+	return []string{"osd", "iscsi.tcmu"}
+}
+
+func (a *API) Configure(ctx context.Context) error {
+	if err := rbd.CreatePool(ctx, a.opts.Pool); err != nil {
+		return err
+	}
+
+	password, err := apiPassword()
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(apiPasswordPath, []byte(password+"\n"), 0o600); err != nil {
+		return fmt.Errorf("could not write rbd-target-api password: %w", err)
+	}
+
+	conf := fmt.Sprintf(`[config]
+cluster_name = ceph
+gateway_keyring = ceph.client.admin.keyring
+pool = %s
+api_secure = false
+api_port = %d
+api_user = admin
+api_password = %s
+trusted_ip_list = 127.0.0.1,%s
+`, a.opts.Pool, apiPort, password, a.opts.Addr)
+
+	if err := os.WriteFile(configPath, []byte(conf), 0o600); err != nil {
+		return fmt.Errorf("could not write iscsi-gateway.cfg: %w", err)
+	}
+
+	return nil
+}
+
+func (a *API) Start(ctx context.Context) error {
+	if err := a.daemon.Run(ctx); err != nil {
+		return fmt.Errorf("could not start rbd-target-api: %w", err)
+	}
+
+	return nil
+}
+
+func (a *API) Stop(ctx context.Context) error {
+	return a.daemon.Stop(ctx)
+}
+
+func (a *API) Pid() int {
+	return a.daemon.Pid()
+}
+
+func (a *API) Signal(sig os.Signal) error {
+	return a.daemon.Signal(sig)
+}
+
+func (a *API) Ready(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://127.0.0.1:%d/api/_ping", apiPort), nil)
+	if err != nil {
+		return err
+	}
+
+	password, err := os.ReadFile(apiPasswordPath)
+	if err != nil {
+		return fmt.Errorf("could not read rbd-target-api password: %w", err)
+	}
+	req.SetBasicAuth("admin", strings.TrimSpace(string(password)))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (a *API) Logs() (*tail.Tail, error) {
+	return tail.TailFile(
+		"/var/log/rbd-target-api/rbd-target-api.log",
+		tail.Config{Follow: true, ReOpen: true},
+	)
+}
+
+// apiPassword returns the password of the rbd-target-api admin user, which is
+// derived from the seed (if any).
+func apiPassword() (string, error) {
+	if seed.Enabled() {
+		return seed.String("iscsi/api-password", 16, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"), nil
+	}
+
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate rbd-target-api password: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
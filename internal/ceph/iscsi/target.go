@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package iscsi
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/dpeckett/picoceph/internal/ceph/rbd"
+	"github.com/dpeckett/picoceph/internal/command"
+	"github.com/nxadm/tail"
+)
+
+// Target configures the iSCSI target, and exports the image as its LUN.
+type Target struct {
+	logger     *slog.Logger
+	opts       Options
+	configured atomic.Bool
+}
+
+func (t *Target) Name() string {
+	return "iscsi.target"
+}
+
+func (t *Target) Requires() []string {
+	return []string{"iscsi.api"}
+}
+
+func (t *Target) Configure(ctx context.Context) error {
+	return nil
+}
+
+func (t *Target) Start(ctx context.Context) error {
+	if err := rbd.CreateImage(ctx, t.opts.Pool, t.opts.Image, t.opts.ImageSize); err != nil {
+		return err
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("could not get hostname: %w", err)
+	}
+
+	disk := t.opts.Pool + "/" + t.opts.Image
+	target := "/iscsi-targets/" + t.opts.IQN
+
+	// Every step is skipped if it has already been done (eg. by a previous run).
+	steps := [][]string{
+		{"/iscsi-targets", "create", t.opts.IQN},
+		{target + "/gateways", "create", hostname, t.opts.Addr, "skipchecks=true"},
+		{"/disks", "create", "pool=" + t.opts.Pool, "image=" + t.opts.Image, fmt.Sprintf("size=%dM", t.opts.ImageSize>>20)},
+		{target + "/disks", "add", disk},
+		// Any initiator can log in.
+		{target + "/hosts", "auth", "disable_acl"},
+	}
+
+	for _, args := range steps {
+		if err := gwcli(ctx, args...); err != nil {
+			return err
+		}
+	}
+
+	t.logger.Info("iSCSI target is available", "portal", t.opts.Endpoint(), "iqn", t.opts.IQN, "disk", disk)
+
+	t.configured.Store(true)
+
+	return nil
+}
+
+func (t *Target) Stop(ctx context.Context) error {
+	// Nothing is running.
+	return nil
+}
+
+func (t *Target) Ready(ctx context.Context) error {
+	if !t.configured.Load() {
+		return fmt.Errorf("iSCSI target has not been configured")
+	}
+
+	return nil
+}
+
+func (t *Target) Logs() (*tail.Tail, error) {
+	return tail.TailFile(
+		"/dev/null",
+		tail.Config{Follow: true, ReOpen: true},
+	)
+}
+
+// gwcli runs a gwcli command, ignoring errors about things that already exist.
+func gwcli(ctx context.Context, args ...string) error {
+	out, err := command.Context(ctx, "gwcli", args...).CombinedOutput()
+	if err != nil && !strings.Contains(string(out), "already") {
+		return fmt.Errorf("could not run gwcli %s: %w: %s", strings.Join(args, " "), err, string(out))
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package ceph
+
+import (
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// logTimeLayout is the layout of the timestamps in Ceph daemon logs.
+const logTimeLayout = "2006-01-02T15:04:05.000-0700"
+
+// clusterLogRegexp matches the severity of cluster/audit log channel messages,
+// eg. "log_channel(cluster) log [WRN] : Health check failed: ...".
+var clusterLogRegexp = regexp.MustCompile(`log_channel\((\w+)\) log \[(DBG|INF|WRN|ERR|SEC)\] : `)
+
+// LogLine is a parsed line from a Ceph daemon log.
+type LogLine struct {
+	// Time is when the line was logged.
+	Time time.Time
+	// Thread is the id of the thread that logged the line.
+	Thread string
+	// Priority is the debug level of the line (-1 is an error, 0 is always logged).
+	Priority int
+	// Channel is the log channel (eg. "cluster" or "audit"), if any.
+	Channel string
+	// Level is the equivalent slog level.
+	Level slog.Level
+	// Message is the remainder of the line.
+	Message string
+}
+
+// ParseLogLine parses a line from a Ceph daemon log, eg.
+//
+//	2024-03-25T10:12:13.123+0000 7f2e5d4f9640 -1 osd.0 0 unable to find ...
+//
+// Lines that don't match the expected format are returned as-is, at info level.
+func ParseLogLine(line string) LogLine {
+	l := LogLine{Level: slog.LevelInfo, Message: line}
+
+	fields := strings.SplitN(strings.TrimSpace(line), " ", 3)
+	if len(fields) < 3 {
+		return l
+	}
+
+	t, err := time.Parse(logTimeLayout, fields[0])
+	if err != nil {
+		return l
+	}
+
+	rest := strings.TrimLeft(fields[2], " ")
+	priority, message, ok := strings.Cut(rest, " ")
+	if !ok {
+		return l
+	}
+
+	l.Priority, err = strconv.Atoi(priority)
+	if err != nil {
+		return l
+	}
+
+	l.Time = t
+	l.Thread = fields[1]
+	l.Message = message
+
+	switch {
+	case l.Priority < 0:
+		l.Level = slog.LevelError
+	case l.Priority <= 1:
+		l.Level = slog.LevelInfo
+	default:
+		l.Level = slog.LevelDebug
+	}
+
+	// Cluster log messages carry an explicit severity.
+	if m := clusterLogRegexp.FindStringSubmatch(message); m != nil {
+		l.Channel = m[1]
+
+		switch m[2] {
+		case "DBG":
+			l.Level = slog.LevelDebug
+		case "INF":
+			l.Level = slog.LevelInfo
+		case "WRN", "SEC":
+			l.Level = slog.LevelWarn
+		case "ERR":
+			l.Level = slog.LevelError
+		}
+	}
+
+	return l
+}
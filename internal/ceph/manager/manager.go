@@ -12,53 +12,77 @@ package manager
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/exec"
-	"strings"
-	"time"
 
 	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/ceph/auth"
+	"github.com/dpeckett/picoceph/internal/command"
+	"github.com/dpeckett/picoceph/internal/daemon"
 	"github.com/dpeckett/picoceph/internal/util"
 	"github.com/nxadm/tail"
 )
 
+// DefaultCaps are the default capabilities of a manager.
+var DefaultCaps = ceph.Caps{
+	"mon": "allow profile mgr",
+	"osd": "allow *",
+	"mds": "allow *",
+}
+
+// Options are the options for a manager.
+type Options struct {
+	// Caps are the capabilities of the manager (defaults to DefaultCaps).
+	Caps ceph.Caps
+	// Telemetry leaves the telemetry module (and its health warnings) at the
+	// Ceph defaults, otherwise it is turned off so that new clusters are
+	// HEALTH_OK.
+	Telemetry bool
+}
+
 type Manager struct {
-	id string
+	logger *slog.Logger
+	id     string
+	opts   Options
+	daemon *daemon.Daemon
 }
 
-func New(id string) ceph.Component {
+func New(logger *slog.Logger, id string, opts Options) ceph.Component {
+	if opts.Caps == nil {
+		opts.Caps = DefaultCaps
+	}
+
+	logger = logger.With("component", "mgr."+id)
+
 	return &Manager{
-		id: id,
+		logger: logger,
+		id:     id,
+		opts:   opts,
+		daemon: daemon.New(logger, "ceph-mgr", "-f", "-i", id),
 	}
 }
 
 func (mgr *Manager) Name() string {
-	return fmt.Sprintf("manager (mgr.%s)", mgr.id)
+	return "mgr." + mgr.id
+}
+
+func (mgr *Manager) Requires() []string {
+	return []string{"mon"}
 }
 
 func (mgr *Manager) Configure(ctx context.Context) error {
-	if err := os.MkdirAll("/var/lib/ceph/mgr/ceph-"+mgr.id, 0o755); err != nil {
+	if err := ceph.MkdirAll("/var/lib/ceph/mgr/ceph-" + mgr.id); err != nil {
 		return fmt.Errorf("could not create directory: %w", err)
 	}
 
-	mgrKeyring, err := os.Create(fmt.Sprintf("/var/lib/ceph/mgr/ceph-%s/keyring", mgr.id))
+	caps, err := mgr.opts.Caps.Render(mgr.Name(), mgr.id)
 	if err != nil {
-		return fmt.Errorf("could not create keyring: %w", err)
+		return err
 	}
-	defer mgrKeyring.Close()
-
-	// Don't block forever if ceph does not come up.
-	cephCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
-	defer cancel()
-
-	cmd := exec.CommandContext(cephCtx, "ceph", "auth", "get-or-create", fmt.Sprintf("mgr.%s", mgr.id), "mon", "allow profile mgr", "osd", "allow *", "mds", "allow *")
-	cmd.Stdout = mgrKeyring
 
-	var out strings.Builder
-	cmd.Stderr = &out
-
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("could not create keyring: %w: %s", err, out.String())
+	if err := auth.GetOrCreate(ctx, mgr.Name(), caps, fmt.Sprintf("/var/lib/ceph/mgr/ceph-%s/keyring", mgr.id)); err != nil {
+		return err
 	}
 
 	cephUserUid, cephGroupGid, err := ceph.User()
@@ -70,20 +94,83 @@ func (mgr *Manager) Configure(ctx context.Context) error {
 		return fmt.Errorf("could not change owner: %w", err)
 	}
 
+	return mgr.configureTelemetry(ctx)
+}
+
+// configureTelemetry turns the telemetry module and its nags off (or back to
+// the Ceph defaults).
+func (mgr *Manager) configureTelemetry(ctx context.Context) error {
+	for _, key := range []string{"mgr/telemetry/enabled", "mgr/telemetry/nag"} {
+		var cmd *exec.Cmd
+		if mgr.opts.Telemetry {
+			cmd = command.Context(ctx, "ceph", "config", "rm", "mgr", key)
+		} else {
+			cmd = command.Context(ctx, "ceph", "config", "set", "mgr", key, "false")
+		}
+
+		if out, err := cmd.CombinedOutput(); err != nil {
+			// Releases before Pacific don't nag.
+			if key == "mgr/telemetry/nag" {
+				mgr.logger.Debug("Could not configure telemetry nag", "error", err, "output", string(out))
+				continue
+			}
+
+			return fmt.Errorf("could not configure telemetry: %w: %s", err, string(out))
+		}
+	}
+
 	return nil
 }
 
 func (mgr *Manager) Start(ctx context.Context) error {
-	cmd := exec.CommandContext(ctx, "ceph-mgr", "-f", "-i", mgr.id)
-	if out, err := cmd.CombinedOutput(); err != nil {
-		if strings.Contains(err.Error(), "signal: killed") {
+	if err := mgr.daemon.Run(ctx); err != nil {
+		return fmt.Errorf("could not start manager: %w", err)
+	}
+
+	return nil
+}
+
+func (mgr *Manager) Stop(ctx context.Context) error {
+	return mgr.daemon.Stop(ctx)
+}
+
+func (mgr *Manager) Pid() int {
+	return mgr.daemon.Pid()
+}
+
+func (mgr *Manager) Signal(sig os.Signal) error {
+	return mgr.daemon.Signal(sig)
+}
+
+func (mgr *Manager) Ready(ctx context.Context) error {
+	var mgrDump struct {
+		Available  bool   `json:"available"`
+		ActiveName string `json:"active_name"`
+		Standbys   []struct {
+			Name string `json:"name"`
+		} `json:"standbys"`
+	}
+
+	if err := ceph.RunJSON(ctx, &mgrDump, "mgr", "dump"); err != nil {
+		return err
+	}
+
+	if !mgrDump.Available {
+		return fmt.Errorf("manager is not available")
+	}
+
+	// Any one of several managers may be the active one.
+	if mgrDump.ActiveName == mgr.id {
+		return nil
+	}
+
+	for _, standby := range mgrDump.Standbys {
+		if standby.Name == mgr.id {
 			return nil
 		}
-
-		return fmt.Errorf("could not start manager: %w: %s", err, string(out))
 	}
 
-	return nil
+	return fmt.Errorf("manager is neither active nor a standby")
 }
 
 func (mgr *Manager) Logs() (*tail.Tail, error) {
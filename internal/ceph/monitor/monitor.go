@@ -12,110 +12,180 @@ package monitor
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/ceph/auth"
+	"github.com/dpeckett/picoceph/internal/command"
+	"github.com/dpeckett/picoceph/internal/daemon"
+	"github.com/dpeckett/picoceph/internal/keyring"
+	"github.com/dpeckett/picoceph/internal/tempfile"
 	"github.com/dpeckett/picoceph/internal/util"
 	"github.com/nxadm/tail"
+	"golang.org/x/sync/errgroup"
 )
 
+// storeDir is where the stores of the monitors are kept.
+const storeDir = "/var/lib/ceph/mon"
+
+// Existing returns the ids of the monitors created by a previous run.
+func Existing() []string {
+	stores, _ := filepath.Glob(filepath.Join(storeDir, "ceph-*", "done"))
+
+	var ids []string
+	for _, store := range stores {
+		ids = append(ids, strings.TrimPrefix(filepath.Base(filepath.Dir(store)), "ceph-"))
+	}
+
+	return ids
+}
+
 type Monitor struct {
-	id   string
-	fsid string
+	id     string
+	fsid   string
+	daemon *daemon.Daemon
 }
 
-func New(id, fsid string) ceph.Component {
+func New(logger *slog.Logger, id, fsid string) ceph.Component {
 	return &Monitor{
-		id:   id,
-		fsid: fsid,
+		id:     id,
+		fsid:   fsid,
+		daemon: daemon.New(logger.With("component", "mon."+id), "ceph-mon", "-f", "-i", id),
 	}
 }
 
 func (mon *Monitor) Name() string {
-	return fmt.Sprintf("monitor (mon.%s)", mon.id)
+	return "mon." + mon.id
+}
+
+func (mon *Monitor) Requires() []string {
+	return nil
 }
 
 func (mon *Monitor) Configure(ctx context.Context) error {
-	if _, err := os.Stat("/etc/ceph/ceph.client.admin.keyring"); os.IsNotExist(err) {
-		cmd := exec.CommandContext(ctx, "ceph-authtool", "--create-keyring", "/etc/ceph/ceph.client.admin.keyring", "--gen-key", "-n", "client.admin", "--cap", "mon", "allow *", "--cap", "osd", "allow *", "--cap", "mds", "allow *", "--cap", "mgr", "allow *")
-		if out, err := cmd.CombinedOutput(); err != nil {
-			return fmt.Errorf("could not create keyring: %w: %s", err, string(out))
-		}
+	cephUserUid, cephGroupGid, err := ceph.User()
+	if err != nil {
+		return fmt.Errorf("could not get ceph user: %w", err)
 	}
 
-	if _, err := os.Stat("/var/lib/ceph/bootstrap-osd/ceph.keyring"); os.IsNotExist(err) {
-		cmd := exec.CommandContext(ctx, "ceph-authtool", "--create-keyring", "/var/lib/ceph/bootstrap-osd/ceph.keyring", "--gen-key", "-n", "client.bootstrap-osd", "--cap", "mon", "profile bootstrap-osd", "--cap", "mgr", "allow r")
-		if out, err := cmd.CombinedOutput(); err != nil {
-			return fmt.Errorf("could not create keyring: %w: %s", err, string(out))
-		}
-	}
+	dataDir := filepath.Join(storeDir, "ceph-"+mon.id)
 
-	keyRingPath := fmt.Sprintf("/tmp/ceph.mon.%s.keyring", mon.id)
+	// Reuse the monitor store from a previous run.
+	if _, err := os.Stat(filepath.Join(dataDir, "done")); err == nil {
+		if err := util.ChownRecursive(dataDir, cephUserUid, cephGroupGid); err != nil {
+			return fmt.Errorf("could not change owner: %w", err)
+		}
 
-	cmd := exec.CommandContext(ctx, "ceph-authtool", "--create-keyring", keyRingPath, "--gen-key", "-n", "mon.", "--cap", "mon", "allow *")
-	if out, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("could not create keyring: %w: %s", err, string(out))
+		return nil
 	}
 
-	cmd = exec.CommandContext(ctx, "ceph-authtool", keyRingPath, "--import-keyring", "/etc/ceph/ceph.client.admin.keyring")
-	if out, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("could not import keyring: %w: %s", err, string(out))
+	tmpDir, err := tempfile.MkdirPrivate("picoceph-mon-")
+	if err != nil {
+		return err
 	}
+	defer tmpDir.Remove()
 
-	cmd = exec.CommandContext(ctx, "ceph-authtool", keyRingPath, "--import-keyring", "/var/lib/ceph/bootstrap-osd/ceph.keyring")
-	if out, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("could not import keyring: %w: %s", err, string(out))
-	}
+	keyRingPath := tmpDir.Path("ceph.mon.keyring")
 
-	cmd = exec.CommandContext(ctx, "monmaptool", "--create", "--addv", mon.id, "[v2:127.0.0.1:3300,v1:127.0.0.1:6789]", "--fsid", mon.fsid, "/tmp/monmap-"+mon.id)
-	if out, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("could not create monmap: %w: %s", err, string(out))
-	}
+	// The mon keyring and data directory are independent of each other.
+	var g errgroup.Group
+
+	g.Go(func() error {
+		monEntry, err := keyring.NewEntry("mon.", map[string]string{"mon": "allow *"})
+		if err != nil {
+			return fmt.Errorf("could not create keyring: %w", err)
+		}
+
+		monKeyring := keyring.Keyring{*monEntry}
+
+		// Import the shared keyrings created during bootstrap.
+		for _, sharedKeyRingPath := range auth.SharedKeyrings() {
+			sharedKeyring, err := keyring.ReadFile(sharedKeyRingPath)
+			if err != nil {
+				return fmt.Errorf("could not import keyring: %w", err)
+			}
+
+			monKeyring = append(monKeyring, sharedKeyring...)
+		}
 
-	if err := os.MkdirAll("/var/lib/ceph/mon/ceph-"+mon.id, 0o755); err != nil {
-		return fmt.Errorf("could not create directory: %w", err)
+		if err := monKeyring.WriteFile(keyRingPath); err != nil {
+			return fmt.Errorf("could not create keyring: %w", err)
+		}
+
+		return nil
+	})
+
+	g.Go(func() error {
+		if err := ceph.MkdirAll(dataDir); err != nil {
+			return fmt.Errorf("could not create directory: %w", err)
+		}
+
+		return nil
+	})
+
+	if err := g.Wait(); err != nil {
+		return err
 	}
 
-	cmd = exec.CommandContext(ctx, "ceph-mon", "--mkfs", "-i", mon.id, "--monmap", "/tmp/monmap-"+mon.id, "--keyring", keyRingPath)
+	// Without an explicit --monmap, ceph-mon builds the initial monmap from
+	// the monitors listed in ceph.conf.
+	cmd := command.Context(ctx, "ceph-mon", "--mkfs", "-i", mon.id, "--fsid", mon.fsid, "--keyring", keyRingPath)
 	if out, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("could not create monitor: %w: %s", err, string(out))
 	}
 
-	// Delete the temporary keyring and monmap.
-	if err := os.Remove(keyRingPath); err != nil {
+	// Delete the temporary keyring.
+	if err := tmpDir.Remove(); err != nil {
 		return fmt.Errorf("could not delete keyring: %w", err)
 	}
 
-	if err := os.RemoveAll("/tmp/monmap-" + mon.id); err != nil {
-		return fmt.Errorf("could not delete temporary monmap: %w", err)
-	}
-
-	cephUserUid, cephGroupGid, err := ceph.User()
-	if err != nil {
-		return fmt.Errorf("could not get ceph user: %w", err)
+	// Mark the store as complete, so that it can be reused.
+	if err := os.WriteFile(filepath.Join(dataDir, "done"), nil, 0o644); err != nil {
+		return fmt.Errorf("could not mark monitor as created: %w", err)
 	}
 
-	if err := util.ChownRecursive("/etc/ceph", cephUserUid, cephGroupGid); err != nil {
+	if err := util.ChownRecursive(dataDir, cephUserUid, cephGroupGid); err != nil {
 		return fmt.Errorf("could not change owner: %w", err)
 	}
 
-	if err := os.Chown("/var/lib/ceph/mon/ceph-"+mon.id, cephUserUid, cephGroupGid); err != nil {
-		return fmt.Errorf("could not change owner: %w", err)
+	return nil
+}
+
+func (mon *Monitor) Start(ctx context.Context) error {
+	if err := mon.daemon.Run(ctx); err != nil {
+		return fmt.Errorf("could not start monitor: %w", err)
 	}
 
 	return nil
 }
 
-func (mon *Monitor) Start(ctx context.Context) error {
-	cmd := exec.CommandContext(ctx, "ceph-mon", "-f", "-i", mon.id)
-	if out, err := cmd.CombinedOutput(); err != nil {
-		if strings.Contains(err.Error(), "signal: killed") {
-			return nil
-		}
+func (mon *Monitor) Stop(ctx context.Context) error {
+	return mon.daemon.Stop(ctx)
+}
+
+func (mon *Monitor) Pid() int {
+	return mon.daemon.Pid()
+}
+
+func (mon *Monitor) Signal(sig os.Signal) error {
+	return mon.daemon.Signal(sig)
+}
+
+func (mon *Monitor) Ready(ctx context.Context) error {
+	var quorumStatus struct {
+		QuorumNames []string `json:"quorum_names"`
+	}
+
+	if err := ceph.RunJSON(ctx, &quorumStatus, "quorum_status"); err != nil {
+		return err
+	}
 
-		return fmt.Errorf("could not start monitor: %w: %s", err, string(out))
+	if !slices.Contains(quorumStatus.QuorumNames, mon.id) {
+		return fmt.Errorf("monitor is not in quorum")
 	}
 
 	return nil
@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package monmap describes the initial monitor map of a cluster. Rather than
+// encoding the binary monmap ourselves (or shelling out to monmaptool), the
+// monitors are written to ceph.conf and ceph-mon --mkfs builds the monmap.
+package monmap
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultV2Port is the default port for the msgr2 protocol.
+	DefaultV2Port = 3300
+	// DefaultV1Port is the default port for the legacy msgr1 protocol.
+	DefaultV1Port = 6789
+)
+
+// Monitor is a single monitor in the monmap.
+type Monitor struct {
+	// ID is the id of the monitor eg. "a".
+	ID string
+	// Addr is the IP address the monitor listens on.
+	Addr string
+	// V2Port is the port the monitor listens on for the msgr2 protocol.
+	V2Port int
+	// V1Port is the port the monitor listens on for the legacy msgr1 protocol.
+	V1Port int
+}
+
+// AddrVec returns the address vector of the monitor,
+// eg. "[v2:127.0.0.1:3300,v1:127.0.0.1:6789]".
+func (m Monitor) AddrVec() string {
+	return fmt.Sprintf("[v2:%s,v1:%s]",
+		net.JoinHostPort(m.Addr, strconv.Itoa(m.V2Port)),
+		net.JoinHostPort(m.Addr, strconv.Itoa(m.V1Port)))
+}
+
+// MonMap is the initial monitor map of a cluster.
+type MonMap struct {
+	// FSID is the unique identifier of the cluster.
+	FSID string
+	// Monitors are the initial members of the cluster.
+	Monitors []Monitor
+}
+
+// New creates an empty monmap for the cluster.
+func New(fsid string) *MonMap {
+	return &MonMap{FSID: fsid}
+}
+
+// Add adds a monitor, listening on the default ports, to the monmap.
+func (m *MonMap) Add(id, addr string) error {
+	return m.AddWithPorts(id, addr, DefaultV2Port, DefaultV1Port)
+}
+
+// AddWithPorts adds a monitor, listening on the given ports, to the monmap.
+func (m *MonMap) AddWithPorts(id, addr string, v2Port, v1Port int) error {
+	if net.ParseIP(addr) == nil {
+		return fmt.Errorf("invalid monitor address: %s", addr)
+	}
+
+	for _, mon := range m.Monitors {
+		if mon.ID == id {
+			return fmt.Errorf("duplicate monitor: %s", id)
+		}
+
+		if mon.Addr == addr && (mon.V2Port == v2Port || mon.V1Port == v1Port) {
+			return fmt.Errorf("monitor %s has a conflicting address with monitor %s", id, mon.ID)
+		}
+	}
+
+	m.Monitors = append(m.Monitors, Monitor{
+		ID:     id,
+		Addr:   addr,
+		V2Port: v2Port,
+		V1Port: v1Port,
+	})
+
+	return nil
+}
+
+// InitialMembers returns the comma separated ids of the monitors,
+// suitable for mon_initial_members.
+func (m *MonMap) InitialMembers() string {
+	var ids []string
+	for _, mon := range m.Monitors {
+		ids = append(ids, mon.ID)
+	}
+
+	return strings.Join(ids, ",")
+}
+
+// Hosts returns the comma separated address vectors of the monitors,
+// suitable for mon_host.
+func (m *MonMap) Hosts() string {
+	var addrs []string
+	for _, mon := range m.Monitors {
+		addrs = append(addrs, mon.AddrVec())
+	}
+
+	return strings.Join(addrs, ",")
+}
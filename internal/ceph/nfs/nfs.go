@@ -0,0 +1,208 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package nfs serves buckets over NFS with NFS-Ganesha. The nfs manager module
+// can only deploy Ganesha through an orchestrator (eg. cephadm), so picoceph
+// runs and configures Ganesha itself.
+package nfs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/ceph/auth"
+	"github.com/dpeckett/picoceph/internal/ceph/radosgw"
+	"github.com/dpeckett/picoceph/internal/daemon"
+	"github.com/dpeckett/picoceph/internal/util"
+	"github.com/nxadm/tail"
+
+	_ "embed"
+)
+
+const (
+	// DefaultPort is the default port NFS is served on.
+	DefaultPort = 2049
+	// DefaultPseudo is the default NFSv4 path of the export.
+	DefaultPseudo = "/s3"
+	// configPath is where the Ganesha configuration is written.
+	configPath = "/etc/ceph/ganesha.conf"
+	// dataDir is where Ganesha keeps its keyring and recovery state.
+	dataDir = "/var/lib/ceph/nfs"
+)
+
+//go:embed assets/ganesha.conf.tmpl
+var ganeshaConfTmpl string
+
+// DefaultCaps are the capabilities of the RGW instance embedded in Ganesha,
+// which are the same as those of the gateway.
+var DefaultCaps = radosgw.DefaultCaps
+
+// Options are the options for the NFS gateway.
+type Options struct {
+	// Addr is the address NFS is served on (defaults to all addresses).
+	Addr string
+	// Port is the port NFS is served on (defaults to DefaultPort).
+	Port int
+	// Pseudo is the NFSv4 path of the export (defaults to DefaultPseudo).
+	Pseudo string
+	// Bucket is the bucket to export (defaults to every bucket of the default
+	// S3 user).
+	Bucket string
+}
+
+// Endpoint returns the address NFS clients can mount the export from.
+func (o Options) Endpoint() string {
+	port := o.Port
+	if port == 0 {
+		port = DefaultPort
+	}
+
+	pseudo := o.Pseudo
+	if pseudo == "" {
+		pseudo = DefaultPseudo
+	}
+
+	return util.LocalAddr(net.JoinHostPort(o.Addr, strconv.Itoa(port))) + ":" + pseudo
+}
+
+// NFS serves the buckets of the default S3 user over NFSv4.
+type NFS struct {
+	opts   Options
+	daemon *daemon.Daemon
+}
+
+func New(logger *slog.Logger, opts Options) ceph.Component {
+	if opts.Port == 0 {
+		opts.Port = DefaultPort
+	}
+
+	if opts.Pseudo == "" {
+		opts.Pseudo = DefaultPseudo
+	}
+
+	return &NFS{
+		opts: opts,
+		daemon: daemon.New(logger.With("component", "nfs"), "ganesha.nfsd",
+			"-F", "-f", configPath, "-L", "/var/log/ceph/ganesha.log", "-p", "/var/run/ganesha.pid"),
+	}
+}
+
+func (n *NFS) Name() string {
+	return "nfs"
+}
+
+func (n *NFS) Requires() []string {
+	// Buckets are exported with the credentials of the default S3 user.
+	return []string{"rgw.user"}
+}
+
+func (n *NFS) Configure(ctx context.Context) error {
+	if err := ceph.MkdirAll(dataDir); err != nil {
+		return fmt.Errorf("could not create directory: %w", err)
+	}
+
+	keyringPath := dataDir + "/keyring"
+	if err := auth.GetOrCreate(ctx, "client.nfs.ganesha", DefaultCaps, keyringPath); err != nil {
+		return err
+	}
+
+	creds, err := radosgw.ReadCredentials(radosgw.CredentialsPath)
+	if err != nil {
+		return fmt.Errorf("could not read S3 credentials: %w", err)
+	}
+
+	path := "/"
+	if n.opts.Bucket != "" {
+		path = n.opts.Bucket
+	}
+
+	tmpl, err := template.New("ganesha.conf").Funcs(template.FuncMap{"quote": quote}).Parse(ganeshaConfTmpl)
+	if err != nil {
+		return fmt.Errorf("could not parse ganesha.conf template: %w", err)
+	}
+
+	f, err := os.OpenFile(configPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("could not create ganesha.conf: %w", err)
+	}
+	defer f.Close()
+
+	if err := tmpl.Execute(f, map[string]any{
+		"Addr":        n.opts.Addr,
+		"Port":        n.opts.Port,
+		"Path":        path,
+		"Pseudo":      n.opts.Pseudo,
+		"KeyringPath": keyringPath,
+		"UserID":      creds.UserID,
+		"AccessKey":   creds.AccessKey,
+		"SecretKey":   creds.SecretKey,
+	}); err != nil {
+		return fmt.Errorf("could not execute ganesha.conf template: %w", err)
+	}
+
+	return nil
+}
+
+func (n *NFS) Start(ctx context.Context) error {
+	if err := n.daemon.Run(ctx); err != nil {
+		return fmt.Errorf("could not start NFS gateway: %w", err)
+	}
+
+	return nil
+}
+
+func (n *NFS) Stop(ctx context.Context) error {
+	return n.daemon.Stop(ctx)
+}
+
+func (n *NFS) Pid() int {
+	return n.daemon.Pid()
+}
+
+func (n *NFS) Signal(sig os.Signal) error {
+	return n.daemon.Signal(sig)
+}
+
+func (n *NFS) Ready(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", util.LocalAddr(net.JoinHostPort(n.opts.Addr, strconv.Itoa(n.opts.Port))))
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+func (n *NFS) Logs() (*tail.Tail, error) {
+	return tail.TailFile(
+		"/var/log/ceph/ganesha.log",
+		tail.Config{Follow: true, ReOpen: true},
+	)
+}
+
+// quote quotes a value of the Ganesha configuration. Rather than relying on
+// Ganesha's escaping, values with quotes, backslashes or control characters
+// are refused.
+func quote(value string) (string, error) {
+	if strings.ContainsFunc(value, func(r rune) bool {
+		return r == '"' || r == '\\' || r < ' ' || r == 0x7f
+	}) {
+		// Don't include the value, which may be a secret.
+		return "", fmt.Errorf("value contains a quote, backslash or control character")
+	}
+
+	return `"` + value + `"`, nil
+}
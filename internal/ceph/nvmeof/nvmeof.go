@@ -0,0 +1,233 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package nvmeof exports an RBD image over NVMe/TCP, using the Ceph NVMe-oF
+// gateway (ceph-nvmeof). Like the nfs module, the nvmeof manager module can
+// only deploy the gateway through an orchestrator, so picoceph runs it itself.
+package nvmeof
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"text/template"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/ceph/rbd"
+	"github.com/dpeckett/picoceph/internal/command"
+	"github.com/dpeckett/picoceph/internal/daemon"
+	"github.com/dpeckett/picoceph/internal/util"
+	"github.com/nxadm/tail"
+
+	_ "embed"
+)
+
+const (
+	// DefaultNQN is the default name of the NVMe subsystem.
+	DefaultNQN = "nqn.2016-06.io.spdk:picoceph"
+	// DefaultPool is the default pool of the exported image.
+	DefaultPool = "rbd"
+	// DefaultImage is the default name of the exported image.
+	DefaultImage = "nvmeof"
+	// DefaultImageSize is the default size of the exported image.
+	DefaultImageSize = 1 << 30
+	// DefaultPort is the default port NVMe/TCP is served on.
+	DefaultPort = 4420
+	// DefaultInstallDir is where ceph-nvmeof is installed in the upstream image.
+	DefaultInstallDir = "/src"
+	// gatewayName is the name the gateway is registered under.
+	gatewayName = "picoceph"
+	// apiPort is the port the gRPC API of the gateway is served on.
+	apiPort = 5500
+	// memSize is the memory (in MiB) SPDK is given, in place of hugepages.
+	memSize = 1024
+	// configPath is where the gateway configuration is written.
+	configPath = "/etc/ceph/ceph-nvmeof.conf"
+	// firstRelease is the first major release supported by the gateway (Reef).
+	firstRelease = 18
+)
+
+//go:embed assets/ceph-nvmeof.conf.tmpl
+var configTmpl string
+
+// Options are the options for the NVMe-oF gateway.
+type Options struct {
+	// Addr is the address NVMe/TCP is served on (defaults to the address of
+	// the host).
+	Addr string
+	// Port is the port NVMe/TCP is served on (defaults to DefaultPort).
+	Port int
+	// NQN is the name of the subsystem (defaults to DefaultNQN).
+	NQN string
+	// Pool is the pool of the exported image (defaults to DefaultPool).
+	Pool string
+	// Image is the name of the exported image (defaults to DefaultImage).
+	Image string
+	// ImageSize is the size of the exported image in bytes (defaults to
+	// DefaultImageSize).
+	ImageSize int64
+	// InstallDir is where ceph-nvmeof is installed (defaults to
+	// DefaultInstallDir).
+	InstallDir string
+}
+
+func (o *Options) setDefaults() {
+	if o.Addr == "" {
+		o.Addr = util.HostIP()
+	}
+
+	if o.Port == 0 {
+		o.Port = DefaultPort
+	}
+
+	if o.NQN == "" {
+		o.NQN = DefaultNQN
+	}
+
+	if o.Pool == "" {
+		o.Pool = DefaultPool
+	}
+
+	if o.Image == "" {
+		o.Image = DefaultImage
+	}
+
+	if o.ImageSize == 0 {
+		o.ImageSize = DefaultImageSize
+	}
+
+	if o.InstallDir == "" {
+		o.InstallDir = DefaultInstallDir
+	}
+}
+
+// Endpoint returns the listener NVMe/TCP hosts can connect to.
+func (o Options) Endpoint() string {
+	o.setDefaults()
+
+	return net.JoinHostPort(o.Addr, strconv.Itoa(o.Port))
+}
+
+// New creates the components of the NVMe-oF gateway.
+func New(logger *slog.Logger, opts Options) []ceph.Component {
+	opts.setDefaults()
+
+	return []ceph.Component{
+		&Gateway{
+			opts: opts,
+			// The gateway is a python package, that has to be run from where
+			// it is installed.
+			daemon: daemon.New(logger.With("component", "nvmeof.gateway"), "env",
+				"-C", opts.InstallDir, "python3", "-m", "control", "-c", configPath),
+		},
+		&Subsystem{logger: logger.With("component", "nvmeof.subsystem"), opts: opts},
+	}
+}
+
+// Gateway runs the NVMe-oF gateway, which manages an SPDK target.
+type Gateway struct {
+	opts   Options
+	daemon *daemon.Daemon
+}
+
+func (g *Gateway) Name() string {
+	return "nvmeof.gateway"
+}
+
+func (g *Gateway) Requires() []string {
+	// The gateway stores its state in a pool, which requires OSDs.
+	return []string{"osd"}
+}
+
+func (g *Gateway) Configure(ctx context.Context) error {
+	version, err := ceph.InstalledVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get ceph version: %w", err)
+	}
+
+	if version.Major < firstRelease {
+		return fmt.Errorf("the NVMe-oF gateway requires Ceph %d or later, but %s is installed", firstRelease, version)
+	}
+
+	if err := rbd.CreatePool(ctx, g.opts.Pool); err != nil {
+		return err
+	}
+
+	// Since Squid, gateways must be registered with the monitors before they
+	// will be given any namespaces.
+	if version.Major > firstRelease {
+		cmd := command.Context(ctx, "ceph", "nvme-gw", "create", gatewayName, g.opts.Pool, "")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("could not register NVMe-oF gateway: %w: %s", err, string(out))
+		}
+	}
+
+	tmpl, err := template.New("ceph-nvmeof.conf").Parse(configTmpl)
+	if err != nil {
+		return fmt.Errorf("could not parse ceph-nvmeof.conf template: %w", err)
+	}
+
+	f, err := os.OpenFile(configPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("could not create ceph-nvmeof.conf: %w", err)
+	}
+	defer f.Close()
+
+	if err := tmpl.Execute(f, map[string]any{
+		"Name":    gatewayName,
+		"APIPort": apiPort,
+		"Pool":    g.opts.Pool,
+		"MemSize": memSize,
+	}); err != nil {
+		return fmt.Errorf("could not execute ceph-nvmeof.conf template: %w", err)
+	}
+
+	return nil
+}
+
+func (g *Gateway) Start(ctx context.Context) error {
+	if err := g.daemon.Run(ctx); err != nil {
+		return fmt.Errorf("could not start NVMe-oF gateway: %w", err)
+	}
+
+	return nil
+}
+
+func (g *Gateway) Stop(ctx context.Context) error {
+	return g.daemon.Stop(ctx)
+}
+
+func (g *Gateway) Pid() int {
+	return g.daemon.Pid()
+}
+
+func (g *Gateway) Signal(sig os.Signal) error {
+	return g.daemon.Signal(sig)
+}
+
+func (g *Gateway) Ready(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(apiPort)))
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+func (g *Gateway) Logs() (*tail.Tail, error) {
+	// The gateway logs to stdout/stderr, which are streamed by the daemon.
+	return tail.TailFile(
+		"/dev/null",
+		tail.Config{Follow: true, ReOpen: true},
+	)
+}
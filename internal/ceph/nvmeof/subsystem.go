@@ -0,0 +1,107 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package nvmeof
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/dpeckett/picoceph/internal/ceph/rbd"
+	"github.com/dpeckett/picoceph/internal/command"
+	"github.com/nxadm/tail"
+)
+
+// Subsystem configures the NVMe subsystem, and exports the image as its
+// namespace.
+type Subsystem struct {
+	logger     *slog.Logger
+	opts       Options
+	configured atomic.Bool
+}
+
+func (s *Subsystem) Name() string {
+	return "nvmeof.subsystem"
+}
+
+func (s *Subsystem) Requires() []string {
+	return []string{"nvmeof.gateway"}
+}
+
+func (s *Subsystem) Configure(ctx context.Context) error {
+	return nil
+}
+
+func (s *Subsystem) Start(ctx context.Context) error {
+	if err := rbd.CreateImage(ctx, s.opts.Pool, s.opts.Image, s.opts.ImageSize); err != nil {
+		return err
+	}
+
+	// Every step is skipped if it has already been done (eg. by a previous run).
+	steps := [][]string{
+		{"subsystem", "add", "--subsystem", s.opts.NQN},
+		{"namespace", "add", "--subsystem", s.opts.NQN, "--rbd-pool", s.opts.Pool, "--rbd-image", s.opts.Image},
+		{"listener", "add", "--subsystem", s.opts.NQN, "--host-name", gatewayName,
+			"--traddr", s.opts.Addr, "--trsvcid", strconv.Itoa(s.opts.Port)},
+		// Any host can connect.
+		{"host", "add", "--subsystem", s.opts.NQN, "--host", "*"},
+	}
+
+	for _, args := range steps {
+		if err := s.cli(ctx, args...); err != nil {
+			return err
+		}
+	}
+
+	s.logger.Info("NVMe-oF subsystem is available", "nqn", s.opts.NQN,
+		"listener", s.opts.Endpoint(), "transport", "tcp", "image", s.opts.Pool+"/"+s.opts.Image)
+
+	s.configured.Store(true)
+
+	return nil
+}
+
+func (s *Subsystem) Stop(ctx context.Context) error {
+	// Nothing is running.
+	return nil
+}
+
+func (s *Subsystem) Ready(ctx context.Context) error {
+	if !s.configured.Load() {
+		return fmt.Errorf("NVMe-oF subsystem has not been configured")
+	}
+
+	return nil
+}
+
+func (s *Subsystem) Logs() (*tail.Tail, error) {
+	return tail.TailFile(
+		"/dev/null",
+		tail.Config{Follow: true, ReOpen: true},
+	)
+}
+
+// cli runs a command of the gateway CLI, ignoring errors about things that
+// already exist.
+func (s *Subsystem) cli(ctx context.Context, args ...string) error {
+	cmd := command.Context(ctx, "python3", append([]string{"-m", "control.cli",
+		"--server-address", "127.0.0.1", "--server-port", strconv.Itoa(apiPort)}, args...)...)
+	cmd.Dir = s.opts.InstallDir
+
+	out, err := cmd.CombinedOutput()
+	if err != nil && !strings.Contains(string(out), "already") {
+		return fmt.Errorf("could not run nvmeof cli %s: %w: %s", strings.Join(args, " "), err, string(out))
+	}
+
+	return nil
+}
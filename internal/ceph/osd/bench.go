@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package osd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+)
+
+// BenchOptions are the options for an OSD write benchmark.
+type BenchOptions struct {
+	// TotalBytes is the number of bytes to write (zero keeps the Ceph default
+	// of 1GiB).
+	TotalBytes int64
+	// BlockSize is the size of each write (zero keeps the Ceph default of 4MiB).
+	BlockSize int64
+}
+
+// BenchResult is the result of `ceph tell osd.N bench`.
+type BenchResult struct {
+	BytesWritten int64   `json:"bytes_written"`
+	BlockSize    int64   `json:"blocksize"`
+	ElapsedSec   float64 `json:"elapsed_sec"`
+	BytesPerSec  float64 `json:"bytes_per_sec"`
+	IOPS         float64 `json:"iops"`
+	// Device is the block device backing the OSD (eg. /dev/nbd0).
+	Device string `json:"device,omitempty"`
+}
+
+// Bench measures the write throughput of an OSD.
+func Bench(ctx context.Context, id string, opts BenchOptions) (*BenchResult, error) {
+	args := []string{"tell", "osd." + id, "bench"}
+	if opts.TotalBytes > 0 || opts.BlockSize > 0 {
+		totalBytes := opts.TotalBytes
+		if totalBytes == 0 {
+			totalBytes = 1 << 30
+		}
+
+		args = append(args, strconv.FormatInt(totalBytes, 10))
+		if opts.BlockSize > 0 {
+			args = append(args, strconv.FormatInt(opts.BlockSize, 10))
+		}
+	}
+
+	var result BenchResult
+	if err := ceph.RunJSON(ctx, &result, args...); err != nil {
+		return nil, fmt.Errorf("could not benchmark osd.%s: %w", id, err)
+	}
+
+	var metadata struct {
+		Devices string `json:"devices"`
+	}
+	if err := ceph.RunJSON(ctx, &metadata, "osd", "metadata", id); err == nil && metadata.Devices != "" {
+		result.Device = "/dev/" + metadata.Devices
+	}
+
+	return &result, nil
+}
+
+// PerfStats are the latencies of an OSD, as reported by `ceph osd perf`.
+type PerfStats struct {
+	ID    int `json:"id"`
+	Stats struct {
+		CommitLatencyMs int `json:"commit_latency_ms"`
+		ApplyLatencyMs  int `json:"apply_latency_ms"`
+	} `json:"perf_stats"`
+}
+
+// Perf returns the commit and apply latencies of every OSD.
+func Perf(ctx context.Context) ([]PerfStats, error) {
+	var perf struct {
+		// Ceph releases since Octopus nest the stats under "osdstats".
+		OSDStats struct {
+			PerfInfos []PerfStats `json:"osd_perf_infos"`
+		} `json:"osdstats"`
+		PerfInfos []PerfStats `json:"osd_perf_infos"`
+	}
+	if err := ceph.RunJSON(ctx, &perf, "osd", "perf"); err != nil {
+		return nil, fmt.Errorf("could not get OSD perf stats: %w", err)
+	}
+
+	if perf.PerfInfos != nil {
+		return perf.PerfInfos, nil
+	}
+
+	return perf.OSDStats.PerfInfos, nil
+}
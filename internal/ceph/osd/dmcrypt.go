@@ -0,0 +1,91 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package osd
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/dpeckett/picoceph/internal/audit"
+	"github.com/dpeckett/picoceph/internal/command"
+)
+
+// CryptMappings returns the dm-crypt mappings, keyed by name, along with the
+// device mapper name of the device each is opened on. ceph-volume names the
+// mappings of encrypted OSDs after the uuids of their logical volumes, so
+// they are found by what they are opened on, which works even once the
+// volume group is gone.
+func CryptMappings(ctx context.Context) (map[string]string, error) {
+	cmd := command.Context(ctx, "/usr/sbin/dmsetup", "ls", "--target", "crypt")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("could not list dm-crypt devices: %w: %s", err, string(out))
+	}
+
+	mappings := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		// Without any mappings, dmsetup prints "No devices found".
+		if len(fields) != 2 || !strings.HasPrefix(fields[1], "(") {
+			continue
+		}
+
+		cmd := command.Context(ctx, "/usr/sbin/dmsetup", "deps", "-o", "devname", fields[0])
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return nil, fmt.Errorf("could not get dependencies of %s: %w: %s", fields[0], err, string(out))
+		}
+
+		// eg. " 1 dependencies  : (ceph--vg--0-osd)"
+		_, deps, _ := strings.Cut(string(out), ":")
+		mappings[fields[0]] = strings.Trim(strings.TrimSpace(deps), "()")
+	}
+
+	return mappings, nil
+}
+
+// RemoveCryptMapping removes a dm-crypt mapping, so that the device it is
+// opened on can be released.
+func RemoveCryptMapping(ctx context.Context, name string) error {
+	audit.Record("remove dm-crypt device", "name", name)
+
+	cmd := command.Context(ctx, "/usr/sbin/dmsetup", "remove", "--force", name)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not remove dm-crypt device %s: %w: %s", name, err, string(out))
+	}
+
+	return nil
+}
+
+// closeCryptDevices removes the dm-crypt mappings opened on the logical
+// volumes of the OSD.
+func (osd *OSD) closeCryptDevices(ctx context.Context) error {
+	mappings, err := CryptMappings(ctx)
+	if err != nil {
+		return err
+	}
+
+	var lvNames []string
+	for _, lv := range LogicalVolumes {
+		lvNames = append(lvNames, deviceMapperName(osd.opts.Instance, osd.id, lv))
+	}
+
+	for name, device := range mappings {
+		if slices.Contains(lvNames, device) {
+			if err := RemoveCryptMapping(ctx, name); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package osd
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// volumeGroupPrefix is the prefix of the LVM volume groups of OSDs.
+	volumeGroupPrefix = "ceph-vg-"
+	// imagePrefix is the prefix of the backing images of OSDs.
+	imagePrefix = "osd-"
+)
+
+// VolumeGroup returns the name of the LVM volume group of an OSD. Volume
+// groups are visible to the whole host, so they are namespaced by the
+// instance (if any), eg. ceph-vg-ci1-0.
+func VolumeGroup(instance, id string) string {
+	return deviceName(volumeGroupPrefix, instance, id)
+}
+
+// ImageName returns the name of the backing image of an OSD, without its
+// extension. Loop devices are reused by the path of their image, so images
+// are namespaced by the instance too.
+func ImageName(instance, id string) string {
+	return deviceName(imagePrefix, instance, id)
+}
+
+// VolumeGroupInstance returns the instance of an OSD volume group, and false
+// if name isn't the name of an OSD volume group.
+func VolumeGroupInstance(name string) (string, bool) {
+	return parseDeviceName(volumeGroupPrefix, name)
+}
+
+// ImageInstance returns the instance of an OSD backing image, and false if
+// path isn't the path of an OSD backing image.
+func ImageInstance(path string) (string, bool) {
+	name := filepath.Base(path)
+	return parseDeviceName(imagePrefix, strings.TrimSuffix(name, filepath.Ext(name)))
+}
+
+// LogicalVolumes are the names of the logical volumes an OSD volume group
+// may hold: the data, and optionally a separate BlueStore DB and WAL.
+var LogicalVolumes = []string{"osd", "db", "wal"}
+
+// deviceMapperName returns the device mapper name of a logical volume of an
+// OSD, which escapes hyphens in the volume group name.
+func deviceMapperName(instance, id, lv string) string {
+	return strings.ReplaceAll(VolumeGroup(instance, id), "-", "--") + "-" + lv
+}
+
+func deviceName(prefix, instance, id string) string {
+	if instance != "" {
+		return prefix + instance + "-" + id
+	}
+
+	return prefix + id
+}
+
+func parseDeviceName(prefix, name string) (string, bool) {
+	rest, ok := strings.CutPrefix(name, prefix)
+	if !ok {
+		return "", false
+	}
+
+	var instance, id string
+	if i := strings.LastIndex(rest, "-"); i >= 0 {
+		instance, id = rest[:i], rest[i+1:]
+		if instance == "" {
+			return "", false
+		}
+	} else {
+		id = rest
+	}
+
+	if _, err := strconv.ParseUint(id, 10, 32); err != nil {
+		return "", false
+	}
+
+	return instance, true
+}
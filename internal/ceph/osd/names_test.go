@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package osd
+
+import "testing"
+
+func TestParseDeviceName(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		instance string
+		ok       bool
+	}{
+		{name: "ceph-vg-0", ok: true},
+		{name: "ceph-vg-12", ok: true},
+		{name: "ceph-vg-ci-0", instance: "ci", ok: true},
+		{name: "ceph-vg-ci-1-0", instance: "ci-1", ok: true},
+		{name: "ceph-vg-my-test-instance-3", instance: "my-test-instance", ok: true},
+		{name: "ceph-vg-"},
+		{name: "ceph-vg--0"},
+		{name: "ceph-vg-ci-"},
+		{name: "ceph-vg-ci"},
+		{name: "ceph-vg-ci-x"},
+		{name: "ceph-vg-99999999999"},
+		{name: "ceph-0"},
+		{name: "ubuntu-vg"},
+		{name: "vg-ceph-vg-0"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			instance, ok := parseDeviceName(volumeGroupPrefix, tc.name)
+			if ok != tc.ok || instance != tc.instance {
+				t.Fatalf("got (%q, %v), want (%q, %v)", instance, ok, tc.instance, tc.ok)
+			}
+		})
+	}
+}
+
+func TestDeviceNameRoundTrip(t *testing.T) {
+	for _, instance := range []string{"", "ci", "ci-1", "my-test-instance"} {
+		for _, id := range []string{"0", "7", "42"} {
+			if got, ok := VolumeGroupInstance(VolumeGroup(instance, id)); !ok || got != instance {
+				t.Fatalf("volume group of %q/%s: got (%q, %v)", instance, id, got, ok)
+			}
+
+			path := "/var/lib/picoceph/disks/" + ImageName(instance, id) + ".img"
+			if got, ok := ImageInstance(path); !ok || got != instance {
+				t.Fatalf("image of %q/%s: got (%q, %v)", instance, id, got, ok)
+			}
+		}
+	}
+}
+
+func TestDeviceMapperName(t *testing.T) {
+	for _, tc := range []struct {
+		instance, id, lv string
+		want             string
+	}{
+		{id: "0", lv: "osd", want: "ceph--vg--0-osd"},
+		{instance: "ci", id: "1", lv: "db", want: "ceph--vg--ci--1-db"},
+		{instance: "ci-1", id: "2", lv: "wal", want: "ceph--vg--ci--1--2-wal"},
+	} {
+		if got := deviceMapperName(tc.instance, tc.id, tc.lv); got != tc.want {
+			t.Fatalf("got %s, want %s", got, tc.want)
+		}
+	}
+}
@@ -11,37 +11,267 @@ package osd
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 
+	"github.com/dpeckett/picoceph/internal/audit"
 	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/command"
+	"github.com/dpeckett/picoceph/internal/daemon"
+	"github.com/dpeckett/picoceph/internal/loop"
 	"github.com/dpeckett/picoceph/internal/nbd"
+	"github.com/dpeckett/picoceph/internal/util"
 	"github.com/nxadm/tail"
 )
 
+// Backend is the kind of block device used to back an OSD.
+type Backend string
+
+const (
+	// BackendAuto uses nbd if it is available, and falls back to a loop device otherwise.
+	BackendAuto Backend = "auto"
+	// BackendNBD uses a qcow2 image attached with qemu-nbd.
+	BackendNBD Backend = "nbd"
+	// BackendLoop uses a sparse raw file attached to a loop device, it does not require qemu.
+	BackendLoop Backend = "loop"
+)
+
+// ParseBackend parses the name of an OSD backend.
+func ParseBackend(name string) (Backend, error) {
+	switch backend := Backend(name); backend {
+	case BackendAuto, BackendNBD, BackendLoop:
+		return backend, nil
+	default:
+		return "", fmt.Errorf("unknown OSD backend: %s", name)
+	}
+}
+
+// Storage is where the backing image of an OSD is kept.
+type Storage string
+
+const (
+	// StoragePersistent keeps the backing image on disk, under /var/lib/ceph/disk.
+	StoragePersistent Storage = "persistent"
+	// StorageEphemeral keeps the backing image on a tmpfs, it is lost when picoceph exits.
+	StorageEphemeral Storage = "ephemeral"
+)
+
+// ParseStorage parses the name of an OSD storage type.
+func ParseStorage(name string) (Storage, error) {
+	switch storage := Storage(name); storage {
+	case StoragePersistent, StorageEphemeral:
+		return storage, nil
+	default:
+		return "", fmt.Errorf("unknown OSD storage type: %s", name)
+	}
+}
+
+// deviceClassRegexp matches CRUSH device class names, eg. ssd, hdd or nvme.
+var deviceClassRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+
+// ValidateDeviceClass returns an error if class isn't a valid CRUSH device
+// class name.
+func ValidateDeviceClass(class string) error {
+	if !deviceClassRegexp.MatchString(class) {
+		return fmt.Errorf("invalid device class (eg. ssd, hdd, nvme): %q", class)
+	}
+
+	return nil
+}
+
+const (
+	// DefaultImageSize is the default size of an OSD backing image.
+	DefaultImageSize = 10 << 30
+	// MinImageSize is the smallest backing image we are willing to create,
+	// anything smaller and BlueStore will quickly run out of space.
+	MinImageSize = 2 << 30
+)
+
+// Options are the options for an OSD.
+type Options struct {
+	// Backend is the kind of block device used to back the OSD.
+	Backend Backend
+	// Storage is where the backing image of the OSD is kept.
+	Storage Storage
+	// Discard passes discards through to the backing image, so that freed
+	// space is returned to the host.
+	Discard bool
+	// Joined is true if the OSD is joining the cluster of another instance,
+	// so there are no local monitors and no admin keyring.
+	Joined bool
+	// Instance namespaces the volume group and backing image of the OSD, so
+	// that several instances can run on one host.
+	Instance string
+	// ImageSize is the size of a new backing image in bytes (defaults to
+	// DefaultImageSize). Existing images keep their size.
+	ImageSize int64
+	// DBSize and WALSize are the sizes in bytes of separate BlueStore DB and
+	// WAL logical volumes, which the backing image is grown to hold (zero
+	// keeps them on the data volume).
+	DBSize  int64
+	WALSize int64
+	// Encrypted encrypts new OSDs with dm-crypt, with their keys kept by the
+	// monitors.
+	Encrypted bool
+	// DeviceClass is the CRUSH device class of the OSD, eg. ssd (empty keeps
+	// the class Ceph detects).
+	DeviceClass string
+	// Device is an existing block device to use instead of a backing image
+	// (the backend, storage and image size don't apply).
+	Device string
+	// Raw prepares the OSD directly on its block device with ceph-volume raw,
+	// rather than on an LVM logical volume (DB, WAL and encryption aren't
+	// supported).
+	Raw bool
+}
+
+// configureMu serializes the configuration of OSDs, as they would otherwise
+// race for free nbd devices, and ceph-volume doesn't expect to be run
+// concurrently.
+var configureMu sync.Mutex
+
 type OSD struct {
-	id string
+	logger    *slog.Logger
+	id        string
+	opts      Options
+	imageSize int64
+	// devicePath is the block device the OSD is on.
+	devicePath string
+	loops      *loop.Pool
+	daemon     *daemon.Daemon
 }
 
-func New(id string) ceph.Component {
+func New(logger *slog.Logger, id string, opts Options) ceph.Component {
+	if opts.Backend == "" {
+		opts.Backend = BackendAuto
+	}
+
+	if opts.Storage == "" {
+		opts.Storage = StoragePersistent
+	}
+
+	if opts.ImageSize == 0 {
+		opts.ImageSize = DefaultImageSize
+	}
+
+	logger = logger.With("component", "osd."+id)
+
 	return &OSD{
-		id: id,
+		logger: logger,
+		id:     id,
+		opts:   opts,
+		loops:  loop.NewPool("/var/lib/ceph/disk"),
+		daemon: daemon.New(logger, "ceph-osd", "-f", "--id", id),
 	}
 }
 
 func (osd *OSD) Name() string {
-	return fmt.Sprintf("osd (osd.%s)", osd.id)
+	return "osd." + osd.id
+}
+
+func (osd *OSD) Requires() []string {
+	if osd.opts.Joined {
+		return nil
+	}
+
+	return []string{"mon"}
 }
 
 func (osd *OSD) Configure(ctx context.Context) error {
+	configureMu.Lock()
+	defer configureMu.Unlock()
+
+	if err := osd.removeStaleDevices(ctx); err != nil {
+		return fmt.Errorf("could not remove stale OSD devices: %w", err)
+	}
+
+	// Reuse the OSD from a previous run.
+	reattached, err := osd.reattachDevice(ctx)
+	if err != nil {
+		return fmt.Errorf("could not reattach OSD device: %w", err)
+	}
+
+	if reattached {
+		// A joined OSD has no admin keyring to change its class with.
+		if osd.opts.DeviceClass != "" && !osd.opts.Joined {
+			if err := osd.setDeviceClass(ctx); err != nil {
+				return err
+			}
+		}
+
+		audit.Record("activate OSD", "id", osd.id)
+
+		if osd.opts.Raw {
+			return osd.activateRaw(ctx)
+		}
+
+		// Only this OSD is activated, as the volume groups of other instances
+		// (and OSDs) are visible too.
+		osdFSID, err := osd.lvmFSID(ctx)
+		if err != nil {
+			return err
+		}
+
+		cmd := command.Context(ctx, "ceph-volume", "lvm", "activate", "--no-systemd", osd.id, osdFSID)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("could not activate existing OSD (run picoceph purge to start over): %w: %s", err, string(out))
+		}
+
+		return nil
+	}
+
+	if osd.opts.Encrypted && !command.Replaying() {
+		if _, err := exec.LookPath("cryptsetup"); err != nil {
+			return fmt.Errorf("encrypted OSDs need cryptsetup: %w", err)
+		}
+	}
+
 	if err := osd.createDevice(ctx); err != nil {
 		return fmt.Errorf("could not create OSD device: %w", err)
 	}
 
 	// Prepare the OSD device.
-	cmd := exec.CommandContext(ctx, "ceph-volume", "lvm", "create", "--no-systemd", "--data", fmt.Sprintf("ceph-vg-%s/osd", osd.id), "--osd-id", osd.id)
+	audit.Record("prepare OSD", "id", osd.id)
+
+	if osd.opts.Raw {
+		args := []string{"raw", "prepare", "--bluestore", "--data", osd.devicePath, "--osd-id", osd.id}
+		if osd.opts.DeviceClass != "" {
+			args = append(args, "--crush-device-class", osd.opts.DeviceClass)
+		}
+
+		cmd := command.Context(ctx, "ceph-volume", args...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("could not prepare OSD device: %w: %s", err, string(out))
+		}
+
+		return osd.activateRaw(ctx)
+	}
+
+	args := []string{"lvm", "create", "--no-systemd", "--data", osd.volumeGroup() + "/osd", "--osd-id", osd.id}
+	if osd.opts.DBSize > 0 {
+		args = append(args, "--block.db", osd.volumeGroup()+"/db")
+	}
+	if osd.opts.WALSize > 0 {
+		args = append(args, "--block.wal", osd.volumeGroup()+"/wal")
+	}
+	if osd.opts.Encrypted {
+		args = append(args, "--dmcrypt")
+	}
+	if osd.opts.DeviceClass != "" {
+		args = append(args, "--crush-device-class", osd.opts.DeviceClass)
+	}
+
+	cmd := command.Context(ctx, "ceph-volume", args...)
 	if out, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("could not prepare OSD device: %w: %s", err, string(out))
 	}
@@ -50,70 +280,322 @@ func (osd *OSD) Configure(ctx context.Context) error {
 }
 
 func (osd *OSD) Start(ctx context.Context) error {
-	cmd := exec.CommandContext(ctx, "ceph-osd", "-f", "--id", osd.id)
-	if out, err := cmd.CombinedOutput(); err != nil {
-		if strings.Contains(err.Error(), "signal: killed") {
-			return nil
+	if err := osd.daemon.Run(ctx); err != nil {
+		return fmt.Errorf("could not start OSD: %w", err)
+	}
+
+	return nil
+}
+
+func (osd *OSD) Stop(ctx context.Context) error {
+	if err := osd.daemon.Stop(ctx); err != nil {
+		return err
+	}
+
+	// The backing image of an ephemeral OSD is on a tmpfs, which is unmounted
+	// once the image has been released.
+	ephemeral := osd.opts.Storage == StorageEphemeral && osd.opts.Device == ""
+
+	if osd.loops.Len() == 0 && osd.opts.Device == "" && !ephemeral {
+		return nil
+	}
+
+	// Release the loop or block device, so that it can't be leaked if the
+	// container is killed before the next run.
+	if err := osd.closeCryptDevices(ctx); err != nil {
+		osd.logger.Warn("Could not close dm-crypt devices", "error", err)
+	}
+
+	if !osd.opts.Raw {
+		audit.Record("deactivate volume group", "volumeGroup", osd.volumeGroup())
+
+		cmd := command.Context(ctx, "vgchange", "--activate", "n", osd.volumeGroup())
+		cmd.Env = append(os.Environ(), "DM_DISABLE_UDEV=1")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			osd.logger.Warn("Could not deactivate volume group", "error", err, "output", string(out))
 		}
+	}
 
-		return fmt.Errorf("could not start OSD: %w: %s", err, string(out))
+	if ephemeral && strings.HasPrefix(osd.devicePath, "/dev/nbd") {
+		if err := nbd.Disconnect(ctx, osd.devicePath); err != nil {
+			osd.logger.Warn("Could not disconnect nbd device", "error", err)
+		}
+	}
+
+	if err := osd.loops.DetachAll(ctx); err != nil {
+		return fmt.Errorf("could not detach loop devices: %w", err)
+	}
+
+	if ephemeral {
+		audit.Record("unmount", "path", "/var/lib/ceph/disk")
+
+		if err := syscall.Unmount("/var/lib/ceph/disk", 0); err != nil && !errors.Is(err, syscall.EINVAL) {
+			return fmt.Errorf("could not unmount ephemeral storage: %w", err)
+		}
 	}
 
 	return nil
 }
 
-// createDevice creates a new NBD block device for the OSD.
-func (osd *OSD) createDevice(ctx context.Context) error {
-	// Clean up any orphaned device nodes from previous runs.
-	cmd := exec.CommandContext(ctx, "/usr/sbin/dmsetup", "remove", "-v", fmt.Sprintf("ceph--vg--%s-osd", osd.id))
-	_ = cmd.Run()
+// removeStaleDevices cleans up any orphaned device nodes from previous runs.
+func (osd *OSD) removeStaleDevices(ctx context.Context) error {
+	// The dm-crypt mappings of encrypted OSDs hold their logical volumes open.
+	if err := osd.closeCryptDevices(ctx); err != nil {
+		osd.logger.Debug("Could not close stale dm-crypt devices", "error", err)
+	}
+
+	for _, lv := range LogicalVolumes {
+		audit.Record("remove device mapper device", "name", deviceMapperName(osd.opts.Instance, osd.id, lv))
+
+		cmd := command.Context(ctx, "/usr/sbin/dmsetup", "remove", "-v", deviceMapperName(osd.opts.Instance, osd.id, lv))
+		_ = cmd.Run()
+	}
+
+	// The device nodes of replayed OSDs were never created.
+	if command.Replaying() {
+		return nil
+	}
 
-	if err := os.RemoveAll("/dev/ceph-vg-" + osd.id); err != nil {
+	if err := os.RemoveAll("/dev/" + osd.volumeGroup()); err != nil {
 		return fmt.Errorf("could not remove directory: %w", err)
 	}
 
-	if err := os.MkdirAll("/var/lib/ceph/disk", 0o755); err != nil {
-		return fmt.Errorf("could not create directory: %w", err)
+	// losetup isn't always available, and isn't needed if nbd is used.
+	if _, err := exec.LookPath("losetup"); err == nil && osd.opts.Backend != BackendNBD {
+		if err := osd.loops.RemoveStale(ctx); err != nil {
+			return fmt.Errorf("could not detach stale loop devices: %w", err)
+		}
 	}
 
-	// Create a qemu image.
-	cmd = exec.CommandContext(ctx, "qemu-img", "create", "-f", "qcow2", fmt.Sprintf("/var/lib/ceph/disk/osd-%s.qcow2", osd.id), "10G")
+	return nil
+}
+
+// reattachDevice reattaches the backing image left behind by a previous run,
+// and activates its volume group. It returns false if there is no such image.
+func (osd *OSD) reattachDevice(ctx context.Context) (bool, error) {
+	// Ephemeral images never outlive picoceph.
+	if osd.opts.Storage == StorageEphemeral && osd.opts.Device == "" {
+		return false, nil
+	}
+
+	nbdImagePath := osd.imagePath(".qcow2")
+	loopImagePath := osd.imagePath(".img")
+
+	var devicePath string
+	if osd.opts.Device != "" && osd.opts.Raw {
+		id, cephFSID, err := rawOSD(ctx, osd.opts.Device)
+		if err != nil {
+			return false, err
+		}
+
+		// purge leaves raw devices alone, so the OSD may belong to a cluster
+		// that no longer exists.
+		fsid, err := ceph.ReadFSID()
+		if err != nil {
+			return false, err
+		}
+
+		switch {
+		case id == "":
+			return false, nil
+		case cephFSID != fsid:
+			return false, fmt.Errorf("block device %s holds OSD %s of another cluster (%s), wipe it first (eg. with wipefs --all %s)",
+				osd.opts.Device, id, cephFSID, osd.opts.Device)
+		case id == osd.id:
+			devicePath = osd.opts.Device
+		default:
+			return false, fmt.Errorf("block device %s holds OSD %s, wipe it first (eg. with wipefs --all %s)", osd.opts.Device, id, osd.opts.Device)
+		}
+	} else if osd.opts.Device != "" {
+		vg, err := osd.deviceVolumeGroup(ctx)
+		if err != nil {
+			return false, err
+		}
+
+		switch vg {
+		case "":
+			return false, nil
+		case osd.volumeGroup():
+			devicePath = osd.opts.Device
+		default:
+			return false, fmt.Errorf("block device %s belongs to volume group %s", osd.opts.Device, vg)
+		}
+	} else if _, err := os.Stat(nbdImagePath); err == nil {
+		if err := nbd.Setup(ctx); err != nil {
+			return false, fmt.Errorf("could not setup nbd: %w", err)
+		}
+
+		devicePath, err = osd.connectNBDImage(ctx, nbdImagePath)
+		if err != nil {
+			return false, err
+		}
+	} else if _, err := os.Stat(loopImagePath); err == nil {
+		if err := loop.Setup(ctx); err != nil {
+			return false, fmt.Errorf("could not setup loop: %w", err)
+		}
+
+		devicePath, err = osd.loops.Attach(ctx, loopImagePath)
+		if err != nil {
+			return false, fmt.Errorf("could not attach raw image: %w", err)
+		}
+	} else {
+		return false, nil
+	}
+
+	osd.devicePath = devicePath
+
+	// Raw OSDs are activated straight from the device.
+	if osd.opts.Raw {
+		return true, nil
+	}
+
+	audit.Record("activate volume group", "device", devicePath, "volumeGroup", osd.volumeGroup())
+
+	cmd := command.Context(ctx, "vgchange", "--activate", "y", osd.volumeGroup())
+	cmd.Env = append(os.Environ(), "DM_DISABLE_UDEV=1")
 	if out, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("could not create qemu image: %w: %s", err, string(out))
+		return false, fmt.Errorf("could not activate volume group: %w: %s", err, string(out))
 	}
 
-	// Load the nbd kernel module (if not already loaded or built-in).
-	if err := nbd.Setup(ctx); err != nil {
-		// TODO: maybe we can fall back to using a loop device?
-		return fmt.Errorf("could not setup nbd: %w", err)
+	// Without udev, the device nodes have to be created by hand.
+	cmd = command.Context(ctx, "vgscan", "--mknodes")
+	cmd.Env = append(os.Environ(), "DM_DISABLE_UDEV=1")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return false, fmt.Errorf("could not create volume group device nodes: %w: %s", err, string(out))
 	}
 
-	// Find the next free nbd device.
-	nbdDevicePath, err := nbd.NextFreeDevice()
-	if err != nil {
-		return fmt.Errorf("could not find free nbd device: %w", err)
+	return true, nil
+}
+
+// setDeviceClass sets the device class of an existing OSD, which replaces
+// the class it was created with.
+func (osd *OSD) setDeviceClass(ctx context.Context) error {
+	audit.Record("set device class", "id", osd.id, "class", osd.opts.DeviceClass)
+
+	cmd := command.Context(ctx, "ceph", "osd", "crush", "rm-device-class", "osd."+osd.id)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not remove device class: %w: %s", err, string(out))
 	}
 
-	// Mount the image using nbd.
-	cmd = exec.CommandContext(ctx, "qemu-nbd", "--connect="+nbdDevicePath, fmt.Sprintf("/var/lib/ceph/disk/osd-%s.qcow2", osd.id))
+	cmd = command.Context(ctx, "ceph", "osd", "crush", "set-device-class", osd.opts.DeviceClass, "osd."+osd.id)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not set device class: %w: %s", err, string(out))
+	}
+
+	return nil
+}
+
+// activateRaw activates the OSD prepared directly on its block device.
+func (osd *OSD) activateRaw(ctx context.Context) error {
+	cmd := command.Context(ctx, "ceph-volume", "raw", "activate", "--no-systemd", "--device", osd.devicePath)
 	if out, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("could not mount qemu image: %w: %s", err, string(out))
+		return fmt.Errorf("could not activate OSD (run picoceph purge and wipe the device, eg. with wipefs --all %s, to start over): %w: %s",
+			osd.devicePath, err, string(out))
+	}
+
+	return nil
+}
+
+// rawOSD returns the id of the OSD prepared directly on a block device, and
+// the fsid of its cluster, or empty strings if there is none.
+func rawOSD(ctx context.Context, device string) (string, string, error) {
+	cmd := command.Context(ctx, "ceph-volume", "raw", "list", "--format", "json", device)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", "", fmt.Errorf("could not list raw OSDs: %w", err)
+	}
+
+	var osds map[string]struct {
+		OSDID    int    `json:"osd_id"`
+		CephFSID string `json:"ceph_fsid"`
+	}
+	if err := json.Unmarshal(out, &osds); err != nil {
+		return "", "", fmt.Errorf("could not parse raw OSDs: %w", err)
+	}
+
+	for _, o := range osds {
+		return strconv.Itoa(o.OSDID), o.CephFSID, nil
+	}
+
+	return "", "", nil
+}
+
+// lvmFSID returns the fsid of the OSD prepared on its logical volume, from
+// the tags ceph-volume left on the volume.
+func (osd *OSD) lvmFSID(ctx context.Context) (string, error) {
+	cmd := command.Context(ctx, "lvs", "--noheadings", "-o", "lv_tags", osd.volumeGroup()+"/osd")
+	cmd.Env = append(os.Environ(), "DM_DISABLE_UDEV=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("could not get logical volume tags: %w: %s", err, string(out))
+	}
+
+	for _, tag := range strings.Split(strings.TrimSpace(string(out)), ",") {
+		if osdFSID, ok := strings.CutPrefix(tag, "ceph.osd_fsid="); ok {
+			return osdFSID, nil
+		}
+	}
+
+	return "", fmt.Errorf("logical volume of OSD %s has no fsid tag (run picoceph purge to start over)", osd.id)
+}
+
+// createDevice creates a new block device for the OSD.
+func (osd *OSD) createDevice(ctx context.Context) error {
+	devicePath := osd.opts.Device
+	if devicePath != "" {
+		if err := checkBlockDevice(devicePath); err != nil {
+			return err
+		}
+
+		if err := checkUnusedDevice(ctx, devicePath); err != nil {
+			return err
+		}
+	} else {
+		var err error
+		devicePath, err = osd.createImage(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	osd.devicePath = devicePath
+
+	if osd.opts.Raw {
+		return nil
 	}
 
 	// Set up the image for use with LVM.
-	cmd = exec.CommandContext(ctx, "pvcreate", nbdDevicePath)
+	audit.Record("create logical volume", "device", devicePath, "volumeGroup", osd.volumeGroup())
+
+	cmd := command.Context(ctx, "pvcreate", devicePath)
 	cmd.Env = append(os.Environ(), "DM_DISABLE_UDEV=1")
 	if out, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("could not create physical volume: %w: %s", err, string(out))
 	}
 
-	cmd = exec.CommandContext(ctx, "vgcreate", "ceph-vg-"+osd.id, nbdDevicePath)
+	cmd = command.Context(ctx, "vgcreate", osd.volumeGroup(), devicePath)
 	cmd.Env = append(os.Environ(), "DM_DISABLE_UDEV=1")
 	if out, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("could not create volume group: %w: %s", err, string(out))
 	}
 
-	cmd = exec.CommandContext(ctx, "lvcreate", "-l", "100%FREE", "-n", "osd", "ceph-vg-"+osd.id)
+	// The DB and WAL are created first, so that the data gets the rest.
+	for _, lv := range []struct {
+		name string
+		size int64
+	}{{"db", osd.opts.DBSize}, {"wal", osd.opts.WALSize}} {
+		if lv.size == 0 {
+			continue
+		}
+
+		cmd = command.Context(ctx, "lvcreate", "-L", strconv.FormatInt(lv.size, 10)+"b", "-n", lv.name, osd.volumeGroup())
+		cmd.Env = append(os.Environ(), "DM_DISABLE_UDEV=1")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("could not create %s logical volume: %w: %s", lv.name, err, string(out))
+		}
+	}
+
+	cmd = command.Context(ctx, "lvcreate", "-l", "100%FREE", "-n", "osd", osd.volumeGroup())
 	cmd.Env = append(os.Environ(), "DM_DISABLE_UDEV=1")
 	if out, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("could not create logical volume: %w: %s", err, string(out))
@@ -122,6 +604,266 @@ func (osd *OSD) createDevice(ctx context.Context) error {
 	return nil
 }
 
+// createImage creates a new backing image for the OSD, and returns the path
+// of the block device it is attached to.
+func (osd *OSD) createImage(ctx context.Context) (string, error) {
+	if err := ceph.MkdirAll("/var/lib/ceph/disk"); err != nil {
+		return "", fmt.Errorf("could not create directory: %w", err)
+	}
+
+	osd.imageSize = osd.opts.ImageSize + osd.opts.DBSize + osd.opts.WALSize
+
+	if osd.opts.Storage == StorageEphemeral {
+		if err := osd.mountEphemeralStorage(); err != nil {
+			return "", fmt.Errorf("could not setup ephemeral storage: %w", err)
+		}
+	}
+
+	devicePath, err := osd.attachDevice(ctx)
+	if err != nil {
+		return "", fmt.Errorf("could not attach OSD device: %w", err)
+	}
+
+	return devicePath, nil
+}
+
+// deviceVolumeGroup returns the volume group on the block device of the OSD,
+// or an empty string if it isn't an LVM physical volume.
+func (osd *OSD) deviceVolumeGroup(ctx context.Context) (string, error) {
+	devicePath, err := filepath.EvalSymlinks(osd.opts.Device)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve block device: %w", err)
+	}
+
+	cmd := command.Context(ctx, "pvs", "--noheadings", "-o", "pv_name,vg_name")
+	cmd.Env = append(os.Environ(), "DM_DISABLE_UDEV=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("could not list physical volumes: %w: %s", err, string(out))
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == devicePath {
+			return fields[1], nil
+		}
+	}
+
+	return "", nil
+}
+
+// checkBlockDevice returns an error if path isn't a block device.
+func checkBlockDevice(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("could not stat block device: %w", err)
+	}
+
+	if fi.Mode()&os.ModeDevice == 0 || fi.Mode()&os.ModeCharDevice != 0 {
+		return fmt.Errorf("not a block device: %s", path)
+	}
+
+	return nil
+}
+
+// checkUnusedDevice returns an error if the block device at path holds a
+// partition table, a filesystem or any other signature (eg. an LVM physical
+// volume), so that existing data is never overwritten.
+func checkUnusedDevice(ctx context.Context, path string) error {
+	cmd := command.Context(ctx, "blkid", "--probe", "--output", "export", path)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		// No signatures were found.
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 2 {
+			return nil
+		}
+
+		return fmt.Errorf("could not probe block device: %w: %s", err, string(out))
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, ok := strings.Cut(line, "=")
+		if ok && (key == "TYPE" || key == "PTTYPE") {
+			return fmt.Errorf("block device %s is in use (it holds a %s signature), wipe it first (eg. with wipefs --all)", path, value)
+		}
+	}
+
+	return nil
+}
+
+// mountEphemeralStorage mounts a tmpfs over the disk directory, sized so that
+// the backing image can't consume more than half of the available memory.
+func (osd *OSD) mountEphemeralStorage() error {
+	availableMemory, err := util.AvailableMemory()
+	if err != nil {
+		return err
+	}
+
+	osd.imageSize = min(osd.imageSize, availableMemory/2)
+	if osd.imageSize < MinImageSize {
+		return fmt.Errorf("not enough memory for ephemeral storage: need %d bytes, only %d bytes available",
+			2*MinImageSize, availableMemory)
+	}
+
+	// Leave some headroom for image metadata.
+	tmpfsSize := osd.imageSize + 64<<20
+
+	// A replayed OSD has no backing image to keep.
+	if command.Replaying() {
+		return nil
+	}
+
+	audit.Record("mount tmpfs", "path", "/var/lib/ceph/disk", "size", tmpfsSize)
+
+	if err := syscall.Mount("tmpfs", "/var/lib/ceph/disk", "tmpfs", 0, fmt.Sprintf("size=%d,mode=%o", tmpfsSize, ceph.DirMode.Perm())); err != nil {
+		return fmt.Errorf("could not mount tmpfs: %w", err)
+	}
+
+	return nil
+}
+
+// attachDevice attaches a new backing image using the configured backend.
+func (osd *OSD) attachDevice(ctx context.Context) (string, error) {
+	switch osd.opts.Backend {
+	case BackendNBD:
+		return osd.attachNBDDevice(ctx)
+	case BackendLoop:
+		return osd.attachLoopDevice(ctx)
+	default:
+		// Prefer a qemu image attached with nbd, but fall back to a loop device if
+		// nbd is unavailable (eg. locked down kernels and CI runners).
+		devicePath, err := osd.attachNBDDevice(ctx)
+		if err != nil {
+			var loopErr error
+			devicePath, loopErr = osd.attachLoopDevice(ctx)
+			if loopErr != nil {
+				return "", errors.Join(err, loopErr)
+			}
+		}
+
+		return devicePath, nil
+	}
+}
+
+// attachNBDDevice creates a qemu image and attaches it to a free nbd device.
+func (osd *OSD) attachNBDDevice(ctx context.Context) (string, error) {
+	// Load the nbd kernel module (if not already loaded or built-in).
+	if err := nbd.Setup(ctx); err != nil {
+		return "", fmt.Errorf("could not setup nbd: %w", err)
+	}
+
+	imagePath := osd.imagePath(".qcow2")
+
+	// Create a qemu image.
+	cmd := command.Context(ctx, "qemu-img", "create", "-f", "qcow2", imagePath, strconv.FormatInt(osd.imageSize, 10))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("could not create qemu image: %w: %s", err, string(out))
+	}
+
+	return osd.connectNBDImage(ctx, imagePath)
+}
+
+// connectNBDImage attaches a qemu image to the next free nbd device.
+func (osd *OSD) connectNBDImage(ctx context.Context, imagePath string) (string, error) {
+	// Find the next free nbd device.
+	nbdDevicePath, err := nbd.NextFreeDevice()
+	if err != nil {
+		return "", fmt.Errorf("could not find free nbd device: %w", err)
+	}
+
+	// Mount the image using nbd.
+	audit.Record("attach nbd device", "device", nbdDevicePath, "file", imagePath)
+
+	args := []string{"--connect=" + nbdDevicePath}
+	if osd.opts.Discard {
+		// Punch holes in the image for discarded (and zeroed) blocks. Loop
+		// devices do this by default.
+		args = append(args, "--discard=unmap", "--detect-zeroes=unmap")
+	}
+
+	cmd := command.Context(ctx, "qemu-nbd", append(args, imagePath)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("could not mount qemu image: %w: %s", err, string(out))
+	}
+
+	return nbdDevicePath, nil
+}
+
+// attachLoopDevice creates a sparse raw image and attaches it to a free loop device.
+func (osd *OSD) attachLoopDevice(ctx context.Context) (string, error) {
+	// Load the loop kernel module (if not already loaded or built-in).
+	if err := loop.Setup(ctx); err != nil {
+		return "", fmt.Errorf("could not setup loop: %w", err)
+	}
+
+	imagePath := osd.imagePath(".img")
+
+	// Create a sparse raw image.
+	image, err := os.Create(imagePath)
+	if err != nil {
+		return "", fmt.Errorf("could not create raw image: %w", err)
+	}
+	defer image.Close()
+
+	if err := image.Truncate(osd.imageSize); err != nil {
+		return "", fmt.Errorf("could not resize raw image: %w", err)
+	}
+
+	loopDevicePath, err := osd.loops.Attach(ctx, imagePath)
+	if err != nil {
+		return "", fmt.Errorf("could not attach raw image: %w", err)
+	}
+
+	return loopDevicePath, nil
+}
+
+// volumeGroup returns the name of the volume group of the OSD.
+func (osd *OSD) volumeGroup() string {
+	return VolumeGroup(osd.opts.Instance, osd.id)
+}
+
+// imagePath returns the path of the backing image of the OSD, with the
+// extension of its format.
+func (osd *OSD) imagePath(ext string) string {
+	return filepath.Join("/var/lib/ceph/disk", ImageName(osd.opts.Instance, osd.id)+ext)
+}
+
+func (osd *OSD) Pid() int {
+	return osd.daemon.Pid()
+}
+
+func (osd *OSD) Signal(sig os.Signal) error {
+	return osd.daemon.Signal(sig)
+}
+
+func (osd *OSD) Ready(ctx context.Context) error {
+	var osdDump struct {
+		OSDs []struct {
+			OSD int `json:"osd"`
+			Up  int `json:"up"`
+		} `json:"osds"`
+	}
+
+	var args []string
+	if osd.opts.Joined {
+		// Without an admin keyring, the OSD has to check on itself.
+		args = []string{"--name", "osd." + osd.id, "--keyring", fmt.Sprintf("/var/lib/ceph/osd/ceph-%s/keyring", osd.id)}
+	}
+
+	if err := ceph.RunJSON(ctx, &osdDump, append(args, "osd", "dump")...); err != nil {
+		return err
+	}
+
+	for _, o := range osdDump.OSDs {
+		if strconv.Itoa(o.OSD) == osd.id && o.Up == 1 {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("OSD is not up")
+}
+
 func (osd *OSD) Logs() (*tail.Tail, error) {
 	return tail.TailFile(
 		fmt.Sprintf("/var/log/ceph/ceph-osd.%s.log", osd.id),
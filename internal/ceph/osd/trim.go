@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package osd
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/dpeckett/picoceph/internal/command"
+)
+
+// Trimmer periodically compacts the OSD databases. BlueStore discards extents
+// as they are freed, but space used by deleted RocksDB data is only freed (and
+// so discarded) once it has been compacted, which can take a long time on an
+// otherwise idle OSD.
+type Trimmer struct {
+	logger   *slog.Logger
+	interval time.Duration
+}
+
+// NewTrimmer creates a new trimmer that compacts at the given interval.
+func NewTrimmer(logger *slog.Logger, interval time.Duration) *Trimmer {
+	return &Trimmer{
+		logger:   logger.With("component", "trim"),
+		interval: interval,
+	}
+}
+
+// Run compacts the OSD databases until the context is cancelled.
+func (t *Trimmer) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		trimCtx, cancel := context.WithTimeout(ctx, t.interval)
+		out, err := command.Context(trimCtx, "ceph", "tell", "osd.*", "compact").CombinedOutput()
+		cancel()
+		if err != nil {
+			t.logger.Warn("Could not compact OSDs", "error", err, "output", string(out))
+			continue
+		}
+
+		t.logger.Debug("Compacted OSDs")
+	}
+}
@@ -0,0 +1,151 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package pools automatically tags pools with the application that uses
+// them, so that the cluster doesn't report POOL_APP_NOT_ENABLED.
+package pools
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/command"
+)
+
+// Applications are the pool applications known to Ceph.
+var Applications = []string{"rbd", "cephfs", "rgw"}
+
+// Pool is a single pool from `ceph osd pool ls detail`.
+type Pool struct {
+	Name         string                       `json:"pool_name"`
+	Applications map[string]map[string]string `json:"application_metadata"`
+}
+
+// List returns every pool in the cluster.
+func List(ctx context.Context) ([]Pool, error) {
+	var pools []Pool
+	if err := ceph.RunJSON(ctx, &pools, "osd", "pool", "ls", "detail"); err != nil {
+		return nil, err
+	}
+
+	return pools, nil
+}
+
+// EnableApplication tags the pool with the given application.
+func EnableApplication(ctx context.Context, pool, app string) error {
+	cmd := command.Context(ctx, "ceph", "osd", "pool", "application", "enable", pool, app)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not enable application %s on pool %s: %w: %s", app, pool, err, string(out))
+	}
+
+	return nil
+}
+
+// ParseOverrides parses a comma separated list of pool=application pairs.
+func ParseOverrides(s string) (map[string]string, error) {
+	overrides := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if pair == "" {
+			continue
+		}
+
+		pool, app, ok := strings.Cut(pair, "=")
+		if !ok || pool == "" {
+			return nil, fmt.Errorf("invalid pool application: %s", pair)
+		}
+
+		if !slices.Contains(Applications, app) {
+			return nil, fmt.Errorf("unknown pool application: %s", app)
+		}
+
+		overrides[pool] = app
+	}
+
+	return overrides, nil
+}
+
+// Tagger periodically tags any untagged pools with their application.
+type Tagger struct {
+	logger    *slog.Logger
+	interval  time.Duration
+	overrides map[string]string
+}
+
+// NewTagger creates a new pool tagger. Overrides map pool names to the
+// application they should be tagged with, otherwise it is guessed from the
+// name of the pool.
+func NewTagger(logger *slog.Logger, interval time.Duration, overrides map[string]string) *Tagger {
+	return &Tagger{
+		logger:    logger.With("component", "pools"),
+		interval:  interval,
+		overrides: overrides,
+	}
+}
+
+// Run tags pools until the context is cancelled.
+func (t *Tagger) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pollCtx, cancel := context.WithTimeout(ctx, t.interval)
+		t.tag(pollCtx)
+		cancel()
+	}
+}
+
+func (t *Tagger) tag(ctx context.Context) {
+	pools, err := List(ctx)
+	if err != nil {
+		// Expected until the monitor is up.
+		t.logger.Debug("Could not list pools", "error", err)
+		return
+	}
+
+	for _, pool := range pools {
+		if len(pool.Applications) > 0 {
+			continue
+		}
+
+		app := t.application(pool.Name)
+		if err := EnableApplication(ctx, pool.Name, app); err != nil {
+			t.logger.Warn("Could not tag pool", "pool", pool.Name, "error", err)
+			continue
+		}
+
+		t.logger.Info("Tagged pool", "pool", pool.Name, "application", app)
+	}
+}
+
+// application returns the application that uses the named pool.
+func (t *Tagger) application(pool string) string {
+	if app, ok := t.overrides[pool]; ok {
+		return app
+	}
+
+	switch {
+	case strings.Contains(pool, "rgw"):
+		return "rgw"
+	case strings.Contains(pool, "cephfs"), strings.HasSuffix(pool, ".meta"), strings.HasSuffix(pool, ".data"):
+		return "cephfs"
+	default:
+		return "rbd"
+	}
+}
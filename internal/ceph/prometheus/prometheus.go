@@ -0,0 +1,157 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/command"
+	"github.com/dpeckett/picoceph/internal/util"
+	"github.com/nxadm/tail"
+)
+
+// DefaultPort is the default port metrics are served on.
+const DefaultPort = 9283
+
+// Options are the options for the prometheus manager module.
+type Options struct {
+	// Addr is the address metrics are served on (defaults to all addresses).
+	Addr string
+	// Port is the port metrics are served on (defaults to DefaultPort).
+	Port int
+	// Profile tunes the generated alert rules to the cluster.
+	Profile Profile
+	// ExporterEndpoint is the URL of ceph-exporter (if it is running), to add to
+	// the generated scrape config.
+	ExporterEndpoint string
+}
+
+// Endpoint returns the URL metrics can be scraped from.
+func (o Options) Endpoint() string {
+	port := o.Port
+	if port == 0 {
+		port = DefaultPort
+	}
+
+	return "http://" + util.LocalAddr(net.JoinHostPort(o.Addr, strconv.Itoa(port))) + "/metrics"
+}
+
+// Prometheus exports Ceph metrics for scraping, using the prometheus manager
+// module.
+type Prometheus struct {
+	logger *slog.Logger
+	opts   Options
+}
+
+func New(logger *slog.Logger, opts Options) ceph.Component {
+	if opts.Port == 0 {
+		opts.Port = DefaultPort
+	}
+
+	return &Prometheus{
+		logger: logger.With("component", "prometheus"),
+		opts:   opts,
+	}
+}
+
+func (p *Prometheus) Name() string {
+	return "prometheus"
+}
+
+func (p *Prometheus) Requires() []string {
+	// Metrics are served by a manager module.
+	return []string{"mgr"}
+}
+
+func (p *Prometheus) Configure(ctx context.Context) error {
+	cmd := command.Context(ctx, "ceph", "config", "set", "mgr", "mgr/prometheus/server_port", strconv.Itoa(p.opts.Port))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not set prometheus port: %w: %s", err, string(out))
+	}
+
+	if p.opts.Addr != "" {
+		cmd = command.Context(ctx, "ceph", "config", "set", "mgr", "mgr/prometheus/server_addr", p.opts.Addr)
+	} else {
+		cmd = command.Context(ctx, "ceph", "config", "rm", "mgr", "mgr/prometheus/server_addr")
+	}
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not set prometheus address: %w: %s", err, string(out))
+	}
+
+	return nil
+}
+
+func (p *Prometheus) Start(ctx context.Context) error {
+	cmd := command.Context(ctx, "ceph", "mgr", "module", "enable", "prometheus")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not enable prometheus module: %w: %s", err, string(out))
+	}
+
+	// Don't block forever if the module does not come up.
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		err := p.Ready(ctx)
+		if err == nil {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %w", ctx.Err(), err)
+		case <-ticker.C:
+		}
+	}
+
+	if err := writeConfig(p.opts.Endpoint(), p.opts.ExporterEndpoint, p.opts.Profile); err != nil {
+		return fmt.Errorf("could not write prometheus configuration: %w", err)
+	}
+
+	p.logger.Info("Metrics are available", "url", p.opts.Endpoint(), "config", ConfigPath, "rules", RulesPath)
+
+	return nil
+}
+
+func (p *Prometheus) Stop(ctx context.Context) error {
+	// Metrics are served by the manager, so there is nothing to stop.
+	return nil
+}
+
+func (p *Prometheus) Ready(ctx context.Context) error {
+	services := map[string]string{}
+	if err := ceph.RunJSON(ctx, &services, "mgr", "services"); err != nil {
+		return err
+	}
+
+	if _, ok := services["prometheus"]; !ok {
+		return fmt.Errorf("metrics are not being served")
+	}
+
+	return nil
+}
+
+func (p *Prometheus) Logs() (*tail.Tail, error) {
+	// Module logs are logged by the manager.
+	return tail.TailFile(
+		"/dev/null",
+		tail.Config{Follow: true, ReOpen: true},
+	)
+}
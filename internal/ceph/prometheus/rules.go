@@ -0,0 +1,125 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package prometheus
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	_ "embed"
+)
+
+const (
+	// ConfigPath is where a Prometheus configuration, that scrapes the cluster,
+	// is written.
+	ConfigPath = "/etc/ceph/prometheus/prometheus.yml"
+	// RulesPath is where the alert rules are written.
+	RulesPath = "/etc/ceph/prometheus/alerts.yml"
+)
+
+//go:embed assets/prometheus.yml.tmpl
+var configTmpl string
+
+//go:embed assets/alerts.yml.tmpl
+var rulesTmpl string
+
+// Profile describes the cluster, so that alerts fire when it deviates from
+// what is expected of it.
+type Profile struct {
+	// Monitors is the number of monitors (defaults to 1).
+	Monitors int
+	// OSDs is the number of OSDs (defaults to 1).
+	OSDs int
+	// Degraded relaxes the latency thresholds, for slow hosts (eg. nested VMs).
+	Degraded bool
+}
+
+// writeConfig writes the Prometheus configuration and alert rules.
+func writeConfig(endpoint, exporterEndpoint string, profile Profile) error {
+	target, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid metrics endpoint: %w", err)
+	}
+
+	var exporterTarget string
+	if exporterEndpoint != "" {
+		u, err := url.Parse(exporterEndpoint)
+		if err != nil {
+			return fmt.Errorf("invalid exporter endpoint: %w", err)
+		}
+
+		exporterTarget = u.Host
+	}
+
+	if profile.Monitors == 0 {
+		profile.Monitors = 1
+	}
+
+	if profile.OSDs == 0 {
+		profile.OSDs = 1
+	}
+
+	rules := struct {
+		Profile
+		Quorum           int
+		LatencyMs        int
+		SlowOpsFor       string
+		HealthWarningFor string
+	}{
+		Profile:          profile,
+		Quorum:           profile.Monitors/2 + 1,
+		LatencyMs:        500,
+		SlowOpsFor:       "30s",
+		HealthWarningFor: "5m",
+	}
+
+	if profile.Degraded {
+		rules.LatencyMs = 2000
+		rules.SlowOpsFor = "2m"
+		rules.HealthWarningFor = "10m"
+	}
+
+	if err := os.MkdirAll(filepath.Dir(ConfigPath), 0o755); err != nil {
+		return fmt.Errorf("could not create directory: %w", err)
+	}
+
+	if err := writeTemplate(RulesPath, rulesTmpl, rules); err != nil {
+		return err
+	}
+
+	return writeTemplate(ConfigPath, configTmpl, map[string]string{
+		"RulesPath":      filepath.Base(RulesPath),
+		"Target":         target.Host,
+		"ExporterTarget": exporterTarget,
+	})
+}
+
+func writeTemplate(path, text string, data any) error {
+	// Alert annotations use Go template syntax themselves.
+	tmpl, err := template.New(filepath.Base(path)).Delims("[[", "]]").Parse(text)
+	if err != nil {
+		return fmt.Errorf("could not parse %s template: %w", filepath.Base(path), err)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not create %s: %w", path, err)
+	}
+	defer f.Close()
+
+	if err := tmpl.Execute(f, data); err != nil {
+		return fmt.Errorf("could not execute %s template: %w", filepath.Base(path), err)
+	}
+
+	return nil
+}
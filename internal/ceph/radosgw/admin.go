@@ -0,0 +1,183 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package radosgw
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dpeckett/picoceph/internal/command"
+	"github.com/dpeckett/picoceph/internal/seed"
+)
+
+// Key is an S3 access key.
+type Key struct {
+	User      string `json:"user"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+}
+
+// User is a RADOS Gateway user, as returned by `radosgw-admin user info`.
+type User struct {
+	UserID      string     `json:"user_id"`
+	DisplayName string     `json:"display_name"`
+	Keys        []Key      `json:"keys"`
+	SwiftKeys   []SwiftKey `json:"swift_keys"`
+}
+
+// UserOptions are the options for creating a user.
+type UserOptions struct {
+	// UID is the id of the user.
+	UID string
+	// DisplayName is the display name of the user (defaults to the UID).
+	DisplayName string
+	// System marks the user as a system user (eg. for the dashboard).
+	System bool
+	// Caps are the admin capabilities of the user (eg. "users=*;buckets=*").
+	Caps string
+	// AccessKey and SecretKey are a static S3 key for the user (defaults to a
+	// generated key).
+	AccessKey string
+	SecretKey string
+}
+
+// CreateUser creates a user, or returns the existing user with the same id.
+func CreateUser(ctx context.Context, opts UserOptions) (*User, error) {
+	if opts.DisplayName == "" {
+		opts.DisplayName = opts.UID
+	}
+
+	if opts.AccessKey == "" && seed.Enabled() {
+		opts.AccessKey = seed.String("rgw/access-key/"+opts.UID, 20, "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
+		opts.SecretKey = seed.String("rgw/secret-key/"+opts.UID, 40, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789")
+	}
+
+	args := []string{"user", "create", "--uid=" + opts.UID, "--display-name=" + opts.DisplayName}
+	if opts.System {
+		args = append(args, "--system")
+	}
+	if opts.Caps != "" {
+		args = append(args, "--caps="+opts.Caps)
+	}
+	var keys []string
+	if opts.AccessKey != "" {
+		keys = []string{"--access-key=" + opts.AccessKey, "--secret-key=" + opts.SecretKey}
+	}
+
+	var user User
+	err := radosgwAdminWithSecrets(ctx, &user, keys, args...)
+	if err == nil {
+		return &user, nil
+	}
+
+	if !strings.Contains(err.Error(), "exists") {
+		return nil, fmt.Errorf("could not create user %s: %w", opts.UID, err)
+	}
+
+	if opts.Caps != "" {
+		// Adding caps the user already has is a no-op.
+		if err := radosgwAdmin(ctx, nil, "caps", "add", "--uid="+opts.UID, "--caps="+opts.Caps); err != nil {
+			return nil, fmt.Errorf("could not add caps to user %s: %w", opts.UID, err)
+		}
+	}
+
+	existing, err := GetUser(ctx, opts.UID)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.AccessKey == "" || existing.hasKey(opts.AccessKey, opts.SecretKey) {
+		return existing, nil
+	}
+
+	// The static key has changed since the user was created.
+	if err := radosgwAdminWithSecrets(ctx, existing, keys, "key", "create", "--uid="+opts.UID, "--key-type=s3"); err != nil {
+		return nil, fmt.Errorf("could not create key for user %s: %w", opts.UID, err)
+	}
+
+	return existing, nil
+}
+
+// Key returns the user's S3 key with the given access key, or the first key
+// if accessKey is empty.
+func (u *User) Key(accessKey string) (*Key, bool) {
+	for i, key := range u.Keys {
+		if accessKey == "" || key.AccessKey == accessKey {
+			return &u.Keys[i], true
+		}
+	}
+
+	return nil, false
+}
+
+func (u *User) hasKey(accessKey, secretKey string) bool {
+	key, ok := u.Key(accessKey)
+	return ok && key.SecretKey == secretKey
+}
+
+// GetUser returns an existing user.
+func GetUser(ctx context.Context, uid string) (*User, error) {
+	var user User
+	if err := radosgwAdmin(ctx, &user, "user", "info", "--uid="+uid); err != nil {
+		return nil, fmt.Errorf("could not get user %s: %w", uid, err)
+	}
+
+	return &user, nil
+}
+
+// radosgwAdmin runs a radosgw-admin command and decodes its JSON output into v.
+func radosgwAdmin(ctx context.Context, v any, args ...string) error {
+	return radosgwAdminWithSecrets(ctx, v, nil, args...)
+}
+
+// radosgwAdminWithSecrets is radosgwAdmin, but passes secretArgs (eg.
+// --secret-key=...) through CEPH_ARGS rather than on the command line, where
+// any user could read them. Ceph appends CEPH_ARGS to the arguments, and the
+// environment of a process is only readable by its owner.
+func radosgwAdminWithSecrets(ctx context.Context, v any, secretArgs []string, args ...string) error {
+	cmd := command.Context(ctx, "radosgw-admin", args...)
+
+	if len(secretArgs) > 0 {
+		// CEPH_ARGS is split on whitespace.
+		for _, arg := range secretArgs {
+			if strings.ContainsAny(arg, " \t\r\n") {
+				return fmt.Errorf("invalid radosgw-admin argument: keys must not contain whitespace")
+			}
+		}
+
+		cephArgs := strings.Join(secretArgs, " ")
+		if existing := os.Getenv("CEPH_ARGS"); existing != "" {
+			cephArgs = existing + " " + cephArgs
+		}
+
+		cmd.Env = append(os.Environ(), "CEPH_ARGS="+cephArgs)
+	}
+
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, stderr.String())
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(out, v); err != nil {
+		return fmt.Errorf("could not parse output of radosgw-admin %s: %w", strings.Join(args, " "), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package radosgw
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/nxadm/tail"
+)
+
+// CreateBucket creates a bucket using the given credentials. Creating a bucket
+// the user already owns is not an error.
+func CreateBucket(ctx context.Context, creds *Credentials, name string) error {
+	bucketURL, err := url.JoinPath(creds.Endpoint, url.PathEscape(name))
+	if err != nil {
+		return fmt.Errorf("invalid bucket name %q: %w", name, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, bucketURL, nil)
+	if err != nil {
+		return err
+	}
+
+	signRequest(req, creds.AccessKey, creds.SecretKey, emptyPayloadHash, time.Now())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not create bucket %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode == http.StatusConflict && strings.Contains(string(body), "BucketAlreadyOwnedByYou") {
+		return nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not create bucket %s: %s: %s", name, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+// Buckets creates buckets, owned by the default S3 user, so that test suites
+// don't have to.
+type Buckets struct {
+	logger  *slog.Logger
+	names   []string
+	created atomic.Bool
+}
+
+// NewBuckets creates a component that creates the named buckets once the
+// default S3 user has been provisioned.
+func NewBuckets(logger *slog.Logger, names []string) ceph.Component {
+	return &Buckets{
+		logger: logger.With("component", "rgw.buckets"),
+		names:  names,
+	}
+}
+
+func (b *Buckets) Name() string {
+	return "rgw.buckets"
+}
+
+func (b *Buckets) Requires() []string {
+	return []string{"rgw.user"}
+}
+
+func (b *Buckets) Configure(ctx context.Context) error {
+	return nil
+}
+
+func (b *Buckets) Start(ctx context.Context) error {
+	creds, err := ReadCredentials(CredentialsPath)
+	if err != nil {
+		return fmt.Errorf("could not read S3 credentials: %w", err)
+	}
+
+	for _, name := range b.names {
+		if err := CreateBucket(ctx, creds, name); err != nil {
+			return err
+		}
+
+		b.logger.Info("Created bucket", "bucket", name)
+	}
+
+	b.created.Store(true)
+
+	return nil
+}
+
+func (b *Buckets) Stop(ctx context.Context) error {
+	// Nothing is running.
+	return nil
+}
+
+func (b *Buckets) Ready(ctx context.Context) error {
+	if !b.created.Load() {
+		return fmt.Errorf("buckets have not been created")
+	}
+
+	return nil
+}
+
+func (b *Buckets) Logs() (*tail.Tail, error) {
+	return tail.TailFile(
+		"/dev/null",
+		tail.Config{Follow: true, ReOpen: true},
+	)
+}
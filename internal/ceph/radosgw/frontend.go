@@ -0,0 +1,139 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package radosgw
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+)
+
+// DefaultPort is the default port the RADOS Gateway is served on.
+const DefaultPort = 7480
+
+// Frontend is the HTTP server used by the RADOS Gateway.
+type Frontend string
+
+const (
+	// FrontendBeast is the boost::beast based frontend (the default).
+	FrontendBeast Frontend = "beast"
+	// FrontendCivetweb is the legacy civetweb frontend, it was removed in Quincy.
+	FrontendCivetweb Frontend = "civetweb"
+)
+
+// lastCivetwebRelease is the major version of the last Ceph release with civetweb.
+const lastCivetwebRelease = 16
+
+// ParseFrontend parses the name of an RGW frontend.
+func ParseFrontend(name string) (Frontend, error) {
+	switch frontend := Frontend(name); frontend {
+	case FrontendBeast, FrontendCivetweb:
+		return frontend, nil
+	default:
+		return "", fmt.Errorf("unknown RGW frontend: %s", name)
+	}
+}
+
+// FrontendOptions are the options for the RGW frontend.
+type FrontendOptions struct {
+	// Frontend is the HTTP server to use (defaults to beast).
+	Frontend Frontend
+	// Threads is the size of the request thread pool (zero keeps the Ceph default).
+	Threads int
+	// RequestTimeout is how long to wait for a request (zero keeps the Ceph default).
+	RequestTimeout time.Duration
+	// MaxConnections limits the number of concurrent requests (zero keeps the
+	// Ceph default).
+	MaxConnections int
+}
+
+// checkSupported returns an error if the installed Ceph release doesn't
+// support the frontend.
+func (o FrontendOptions) checkSupported(ctx context.Context) error {
+	if o.Frontend != FrontendCivetweb {
+		return nil
+	}
+
+	version, err := ceph.InstalledVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	if version.Major > lastCivetwebRelease {
+		return fmt.Errorf("the civetweb frontend is not supported by ceph %s", version)
+	}
+
+	return nil
+}
+
+// args returns the radosgw command line arguments for the frontend.
+func (o FrontendOptions) args(addr string, port int, tlsOpts TLSOptions) []string {
+	frontend := []string{string(o.Frontend)}
+
+	endpoint := strconv.Itoa(port)
+	if addr != "" {
+		endpoint = net.JoinHostPort(addr, endpoint)
+	}
+
+	tlsEndpoint := strconv.Itoa(tlsOpts.Port)
+	if addr != "" {
+		tlsEndpoint = net.JoinHostPort(addr, tlsEndpoint)
+	}
+
+	certPath, keyPath := tlsOpts.certPaths()
+
+	switch {
+	case o.Frontend == FrontendCivetweb && tlsOpts.Enabled:
+		// The "s" suffix marks an HTTPS port.
+		frontend = append(frontend, "port="+endpoint+"+"+tlsEndpoint+"s", "ssl_certificate="+tlsBundlePath)
+	case o.Frontend == FrontendCivetweb:
+		frontend = append(frontend, "port="+endpoint)
+	case addr == "":
+		frontend = append(frontend, "port="+endpoint)
+	default:
+		frontend = append(frontend, "endpoint="+endpoint)
+	}
+
+	if o.Frontend == FrontendBeast && tlsOpts.Enabled {
+		if addr == "" {
+			frontend = append(frontend, "ssl_port="+tlsEndpoint)
+		} else {
+			frontend = append(frontend, "ssl_endpoint="+tlsEndpoint)
+		}
+
+		frontend = append(frontend, "ssl_certificate="+certPath, "ssl_private_key="+keyPath)
+	}
+
+	if o.RequestTimeout > 0 {
+		frontend = append(frontend, "request_timeout_ms="+strconv.FormatInt(o.RequestTimeout.Milliseconds(), 10))
+	}
+
+	// civetweb uses a thread per connection.
+	if o.Frontend == FrontendCivetweb && o.Threads > 0 {
+		frontend = append(frontend, "num_threads="+strconv.Itoa(o.Threads))
+	}
+
+	args := []string{"--rgw-frontends", strings.Join(frontend, " ")}
+
+	if o.Threads > 0 {
+		args = append(args, "--rgw-thread-pool-size", strconv.Itoa(o.Threads))
+	}
+
+	if o.MaxConnections > 0 {
+		args = append(args, "--rgw-max-concurrent-requests", strconv.Itoa(o.MaxConnections))
+	}
+
+	return args
+}
@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package radosgw
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// SecondaryOptions are the options for running the gateway as a secondary
+// zone, that syncs from the primary zone of an existing realm.
+type SecondaryOptions struct {
+	// PrimaryURL is the URL of a gateway in the primary zone (empty disables).
+	PrimaryURL string
+	// AccessKey and SecretKey are the keys of a system user on the primary.
+	AccessKey string
+	SecretKey string
+	// Realm is the realm to pull (defaults to the primary's default realm).
+	Realm string
+	// ZoneGroup is the zonegroup the zone is created in (defaults to the
+	// realm's default zonegroup).
+	ZoneGroup string
+	// Zone is the name of the secondary zone.
+	Zone string
+	// Endpoint is the URL the primary reaches this gateway on.
+	Endpoint string
+}
+
+// Enabled returns true if the gateway should run as a secondary zone.
+func (o SecondaryOptions) Enabled() bool {
+	return o.PrimaryURL != ""
+}
+
+// Validate checks that the options are complete.
+func (o SecondaryOptions) Validate() error {
+	if !o.Enabled() {
+		return nil
+	}
+
+	if o.AccessKey == "" || o.SecretKey == "" {
+		return fmt.Errorf("the keys of a system user on the primary are required")
+	}
+
+	if o.Zone == "" {
+		return fmt.Errorf("a zone name is required")
+	}
+
+	return nil
+}
+
+// joinSecondaryZone pulls the realm from the primary and creates the
+// secondary zone, if it hasn't been already (eg. because the cluster is being
+// reused).
+func joinSecondaryZone(ctx context.Context, logger *slog.Logger, opts SecondaryOptions) error {
+	if err := radosgwAdmin(ctx, nil, "zone", "get", "--rgw-zone="+opts.Zone); err == nil {
+		logger.Info("Secondary zone already exists", "zone", opts.Zone)
+		return nil
+	}
+
+	logger.Info("Pulling realm from primary", "url", opts.PrimaryURL)
+
+	// The system keys are secret, so they aren't passed on the command line.
+	keys := []string{"--access-key=" + opts.AccessKey, "--secret=" + opts.SecretKey}
+
+	args := []string{"realm", "pull", "--url=" + opts.PrimaryURL, "--default"}
+	if opts.Realm != "" {
+		args = append(args, "--rgw-realm="+opts.Realm)
+	}
+
+	if err := radosgwAdminWithSecrets(ctx, nil, keys, args...); err != nil {
+		return fmt.Errorf("could not pull realm: %w", err)
+	}
+
+	logger.Info("Creating secondary zone", "zone", opts.Zone, "endpoint", opts.Endpoint)
+
+	args = []string{"zone", "create", "--rgw-zone=" + opts.Zone, "--endpoints=" + opts.Endpoint, "--default"}
+	if opts.ZoneGroup != "" {
+		args = append(args, "--rgw-zonegroup="+opts.ZoneGroup)
+	}
+
+	if err := radosgwAdminWithSecrets(ctx, nil, keys, args...); err != nil {
+		return fmt.Errorf("could not create zone: %w", err)
+	}
+
+	// The commit is forwarded to the primary, using the zone's system keys.
+	if err := radosgwAdmin(ctx, nil, "period", "update", "--commit"); err != nil {
+		return fmt.Errorf("could not commit period: %w", err)
+	}
+
+	return nil
+}
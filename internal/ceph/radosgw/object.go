@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package radosgw
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// bucketObjectURL returns the path-style URL of an object.
+func bucketObjectURL(creds *Credentials, bucket, key string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSuffix(creds.Endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint: %w", err)
+	}
+
+	u.Path += "/" + bucket + "/" + key
+	u.RawPath = escapePath(u.Path)
+
+	return u, nil
+}
+
+// PutObject writes data to an object, replacing it if it exists.
+func PutObject(ctx context.Context, creds *Credentials, bucket, key string, data []byte) error {
+	u, err := bucketObjectURL(creds, bucket, key)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	h := sha256.Sum256(data)
+	signRequest(req, creds.AccessKey, creds.SecretKey, hex.EncodeToString(h[:]), time.Now())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("could not put object %s: %s: %s", key, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+// GetObject reads an object, discarding its content, and returns its size.
+func GetObject(ctx context.Context, creds *Credentials, bucket, key string) (int64, error) {
+	u, err := bucketObjectURL(creds, bucket, key)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	signRequest(req, creds.AccessKey, creds.SecretKey, emptyPayloadHash, time.Now())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("could not get object %s: %s: %s", key, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return io.Copy(io.Discard, resp.Body)
+}
@@ -12,49 +12,147 @@ package radosgw
 import (
 	"context"
 	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
 	"os"
 	"os/exec"
-	"strings"
-	"time"
+	"strconv"
 
 	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/ceph/auth"
+	"github.com/dpeckett/picoceph/internal/command"
+	"github.com/dpeckett/picoceph/internal/daemon"
 	"github.com/dpeckett/picoceph/internal/util"
 	"github.com/nxadm/tail"
 )
 
-type RADOSGW struct{}
+// DefaultCaps are the default capabilities of the RADOS Gateway.
+var DefaultCaps = ceph.Caps{
+	"osd": "allow rwx",
+	"mon": "allow rw",
+}
+
+// Options are the options for the RADOS Gateway.
+type Options struct {
+	// Caps are the capabilities of the gateway (defaults to DefaultCaps).
+	Caps ceph.Caps
+	// Addr is the address the gateway binds to (defaults to all addresses).
+	Addr string
+	// Port is the port the gateway is served on (defaults to DefaultPort).
+	Port int
+	// Frontend configures the HTTP server of the gateway.
+	Frontend FrontendOptions
+	// TLS configures HTTPS.
+	TLS TLSOptions
+	// Secondary runs the gateway as a secondary zone of an existing realm.
+	Secondary SecondaryOptions
+	// STS enables the Security Token Service (eg. AssumeRole).
+	STS bool
+	// DNSName is the domain that buckets are served under as subdomains, for
+	// virtual-hosted-style addressing (eg. "s3.localhost").
+	DNSName string
+}
+
+// Endpoint returns the URL clients can reach the gateway on.
+func (o Options) Endpoint() string {
+	port := o.Port
+	if port == 0 {
+		port = DefaultPort
+	}
+
+	return "http://" + util.LocalAddr(net.JoinHostPort(o.Addr, strconv.Itoa(port)))
+}
+
+// TLSEndpoint returns the HTTPS URL clients can reach the gateway on, or an
+// empty string if TLS is disabled.
+func (o Options) TLSEndpoint() string {
+	if !o.TLS.Enabled {
+		return ""
+	}
+
+	port := o.TLS.Port
+	if port == 0 {
+		port = DefaultTLSPort
+	}
+
+	return "https://" + util.LocalAddr(net.JoinHostPort(o.Addr, strconv.Itoa(port)))
+}
+
+type RADOSGW struct {
+	logger *slog.Logger
+	opts   Options
+	daemon *daemon.Daemon
+}
+
+func New(logger *slog.Logger, opts Options) ceph.Component {
+	if opts.Caps == nil {
+		opts.Caps = DefaultCaps
+	}
+
+	if opts.Port == 0 {
+		opts.Port = DefaultPort
+	}
+
+	if opts.TLS.Port == 0 {
+		opts.TLS.Port = DefaultTLSPort
+	}
+
+	if opts.Frontend.Frontend == "" {
+		opts.Frontend.Frontend = FrontendBeast
+	}
+
+	if opts.Secondary.Enabled() && opts.Secondary.Endpoint == "" {
+		opts.Secondary.Endpoint = opts.Endpoint()
+	}
+
+	args := append([]string{"-f", "-n", "client.radosgw.gateway"}, opts.Frontend.args(opts.Addr, opts.Port, opts.TLS)...)
+	if opts.Secondary.Enabled() {
+		args = append(args, "--rgw-zone", opts.Secondary.Zone)
+	}
 
-func New() ceph.Component {
-	return &RADOSGW{}
+	logger = logger.With("component", "rgw.gateway")
+
+	return &RADOSGW{
+		logger: logger,
+		opts:   opts,
+		daemon: daemon.New(logger, "radosgw", args...),
+	}
 }
 
 func (rgw *RADOSGW) Name() string {
 	return "rgw.gateway"
 }
 
+func (rgw *RADOSGW) Requires() []string {
+	// RGW creates its pools on startup, which requires OSDs to be up.
+	return []string{"mon", "osd"}
+}
+
 func (rgw *RADOSGW) Configure(ctx context.Context) error {
-	if err := os.MkdirAll("/var/lib/ceph/radosgw/ceph-radosgw.gateway", 0o755); err != nil {
-		return fmt.Errorf("could not create directory: %w", err)
+	if err := rgw.opts.Frontend.checkSupported(ctx); err != nil {
+		return err
 	}
 
-	radosgwKeyring, err := os.Create("/var/lib/ceph/radosgw/ceph-radosgw.gateway/keyring")
-	if err != nil {
-		return fmt.Errorf("could not create keyring: %w", err)
+	if err := rgw.opts.Secondary.Validate(); err != nil {
+		return fmt.Errorf("invalid secondary zone options: %w", err)
 	}
-	defer radosgwKeyring.Close()
 
-	// Don't block forever if ceph does not come up.
-	cephCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
-	defer cancel()
+	if err := rgw.opts.configureTLS(); err != nil {
+		return err
+	}
 
-	cmd := exec.CommandContext(cephCtx, "ceph", "auth", "get-or-create", "client.radosgw.gateway", "osd", "allow rwx", "mon", "allow rw")
-	cmd.Stdout = radosgwKeyring
+	if err := ceph.MkdirAll("/var/lib/ceph/radosgw/ceph-radosgw.gateway"); err != nil {
+		return fmt.Errorf("could not create directory: %w", err)
+	}
 
-	var out strings.Builder
-	cmd.Stderr = &out
+	caps, err := rgw.opts.Caps.Render("client.radosgw.gateway", "radosgw.gateway")
+	if err != nil {
+		return err
+	}
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("could not create keyring: %w: %s", err, out.String())
+	if err := auth.GetOrCreate(ctx, "client.radosgw.gateway", caps, "/var/lib/ceph/radosgw/ceph-radosgw.gateway/keyring"); err != nil {
+		return err
 	}
 
 	cephUserUid, cephGroupGid, err := ceph.User()
@@ -66,18 +164,60 @@ func (rgw *RADOSGW) Configure(ctx context.Context) error {
 		return fmt.Errorf("could not change owner: %w", err)
 	}
 
+	if err := configureDNSName(ctx, rgw.opts.DNSName); err != nil {
+		return err
+	}
+
+	if err := configureUsageLog(ctx); err != nil {
+		return err
+	}
+
+	if rgw.opts.STS {
+		if err := configureSTS(ctx); err != nil {
+			return err
+		}
+	}
+
+	if rgw.opts.Secondary.Enabled() {
+		if err := joinSecondaryZone(ctx, rgw.logger, rgw.opts.Secondary); err != nil {
+			return fmt.Errorf("could not join primary zone: %w", err)
+		}
+	}
+
 	return nil
 }
 
 func (rgw *RADOSGW) Start(ctx context.Context) error {
-	cmd := exec.CommandContext(ctx, "radosgw", "-f", "-n", "client.radosgw.gateway")
-	if out, err := cmd.CombinedOutput(); err != nil {
-		if strings.Contains(err.Error(), "signal: killed") {
-			return nil
-		}
+	if err := rgw.daemon.Run(ctx); err != nil {
+		return fmt.Errorf("could not start RADOS Gateway: %w", err)
+	}
+
+	return nil
+}
 
-		return fmt.Errorf("could not start RADOS Gateway: %w: %s", err, out)
+func (rgw *RADOSGW) Stop(ctx context.Context) error {
+	return rgw.daemon.Stop(ctx)
+}
+
+func (rgw *RADOSGW) Pid() int {
+	return rgw.daemon.Pid()
+}
+
+func (rgw *RADOSGW) Signal(sig os.Signal) error {
+	return rgw.daemon.Signal(sig)
+}
+
+func (rgw *RADOSGW) Ready(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rgw.opts.Endpoint()+"/", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
 	}
+	defer resp.Body.Close()
 
 	return nil
 }
@@ -88,3 +228,19 @@ func (rgw *RADOSGW) Logs() (*tail.Tail, error) {
 		tail.Config{Follow: true, ReOpen: true},
 	)
 }
+
+// configureDNSName sets (or clears) the domain of virtual-hosted buckets.
+func configureDNSName(ctx context.Context, name string) error {
+	var cmd *exec.Cmd
+	if name != "" {
+		cmd = command.Context(ctx, "ceph", "config", "set", "client.radosgw.gateway", "rgw_dns_name", name)
+	} else {
+		cmd = command.Context(ctx, "ceph", "config", "rm", "client.radosgw.gateway", "rgw_dns_name")
+	}
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not configure DNS name: %w: %s", err, string(out))
+	}
+
+	return nil
+}
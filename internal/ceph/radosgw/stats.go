@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package radosgw
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dpeckett/picoceph/internal/command"
+)
+
+// BucketStats are the statistics of a bucket, as returned by
+// `radosgw-admin bucket stats`.
+type BucketStats struct {
+	Bucket string `json:"bucket"`
+	Owner  string `json:"owner"`
+	// Usage is keyed by category, eg. "rgw.main" for objects and
+	// "rgw.multimeta" for incomplete multipart uploads.
+	Usage map[string]BucketUsage `json:"usage"`
+}
+
+// BucketUsage is the space used by a category of a bucket.
+type BucketUsage struct {
+	Size       int64 `json:"size"`
+	SizeActual int64 `json:"size_actual"`
+	NumObjects int64 `json:"num_objects"`
+}
+
+// Objects returns the usage of the objects of the bucket.
+func (s *BucketStats) Objects() BucketUsage {
+	return s.Usage["rgw.main"]
+}
+
+// GetBucketStats returns the statistics of a bucket.
+func GetBucketStats(ctx context.Context, bucket string) (*BucketStats, error) {
+	var stats BucketStats
+	if err := radosgwAdmin(ctx, &stats, "bucket", "stats", "--bucket="+bucket); err != nil {
+		return nil, fmt.Errorf("could not get stats of bucket %s: %w", bucket, err)
+	}
+
+	return &stats, nil
+}
+
+// ListBucketStats returns the statistics of every bucket.
+func ListBucketStats(ctx context.Context) ([]BucketStats, error) {
+	var stats []BucketStats
+	if err := radosgwAdmin(ctx, &stats, "bucket", "stats"); err != nil {
+		return nil, fmt.Errorf("could not get bucket stats: %w", err)
+	}
+
+	return stats, nil
+}
+
+// UsageCategory counts the operations of a category (eg. "put_obj").
+type UsageCategory struct {
+	Category      string `json:"category"`
+	BytesSent     int64  `json:"bytes_sent"`
+	BytesReceived int64  `json:"bytes_received"`
+	Ops           int64  `json:"ops"`
+	SuccessfulOps int64  `json:"successful_ops"`
+}
+
+// Usage is the usage log, as returned by `radosgw-admin usage show`. Entries
+// are kept per user, bucket and hour.
+type Usage struct {
+	Entries []struct {
+		User    string `json:"user"`
+		Buckets []struct {
+			Bucket     string          `json:"bucket"`
+			Owner      string          `json:"owner"`
+			Time       string          `json:"time"`
+			Categories []UsageCategory `json:"categories"`
+		} `json:"buckets"`
+	} `json:"entries"`
+}
+
+// UsageOptions select the entries of the usage log.
+type UsageOptions struct {
+	// UID selects the entries of a single user (defaults to all users).
+	UID string
+	// Start and End bound the time of the entries (zero is unbounded).
+	Start time.Time
+	End   time.Time
+}
+
+// GetUsage returns entries of the usage log. The usage log is only written
+// if it is enabled (which picoceph does), and is flushed every second.
+func GetUsage(ctx context.Context, opts UsageOptions) (*Usage, error) {
+	args := []string{"usage", "show", "--show-log-sum=false"}
+	if opts.UID != "" {
+		args = append(args, "--uid="+opts.UID)
+	}
+	if !opts.Start.IsZero() {
+		args = append(args, "--start-date="+opts.Start.UTC().Format(time.DateTime))
+	}
+	if !opts.End.IsZero() {
+		args = append(args, "--end-date="+opts.End.UTC().Format(time.DateTime))
+	}
+
+	var usage Usage
+	if err := radosgwAdmin(ctx, &usage, args...); err != nil {
+		return nil, fmt.Errorf("could not get usage: %w", err)
+	}
+
+	return &usage, nil
+}
+
+// configureUsageLog enables the usage log, and flushes it often enough that
+// tests don't have to wait for it.
+func configureUsageLog(ctx context.Context) error {
+	for key, value := range map[string]string{
+		"rgw_enable_usage_log":        "true",
+		"rgw_usage_log_tick_interval": "1",
+	} {
+		cmd := command.Context(ctx, "ceph", "config", "set", "client.radosgw.gateway", key, value)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("could not enable usage log: %w: %s", err, string(out))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package radosgw
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/command"
+	"github.com/dpeckett/picoceph/internal/seed"
+	"github.com/nxadm/tail"
+)
+
+// configureSTS enables STS, keeping the key used to encrypt session tokens
+// across restarts so that issued credentials remain valid.
+func configureSTS(ctx context.Context) error {
+	out, err := command.Context(ctx, "ceph", "config", "get", "client.radosgw.gateway", "rgw_sts_key").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("could not get STS key: %w: %s", err, string(out))
+	}
+
+	// The key must be exactly 16 characters.
+	if key := strings.TrimSpace(string(out)); len(key) != 16 {
+		if seed.Enabled() {
+			key = hex.EncodeToString(seed.Bytes("rgw/sts-key", 8))
+		} else {
+			b := make([]byte, 8)
+			if _, err := rand.Read(b); err != nil {
+				return fmt.Errorf("could not generate STS key: %w", err)
+			}
+
+			key = hex.EncodeToString(b)
+		}
+
+		cmd := command.Context(ctx, "ceph", "config", "set", "client.radosgw.gateway", "rgw_sts_key", key)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("could not set STS key: %w: %s", err, string(out))
+		}
+	}
+
+	cmd := command.Context(ctx, "ceph", "config", "set", "client.radosgw.gateway", "rgw_s3_auth_use_sts", "true")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not enable STS: %w: %s", err, string(out))
+	}
+
+	return nil
+}
+
+// RoleOptions are the options for an STS role.
+type RoleOptions struct {
+	// Name is the name of the role.
+	Name string
+	// TrustedUser is the id of the user allowed to assume the role (defaults to
+	// any user).
+	TrustedUser string
+}
+
+// ARN returns the ARN of the role, for AssumeRole requests.
+func (o RoleOptions) ARN() string {
+	return "arn:aws:iam:::role/" + o.Name
+}
+
+// Role provisions an STS role, with full access to S3.
+type Role struct {
+	logger      *slog.Logger
+	opts        RoleOptions
+	provisioned atomic.Bool
+}
+
+// NewRole creates a component that provisions an STS role.
+func NewRole(logger *slog.Logger, opts RoleOptions) ceph.Component {
+	return &Role{
+		logger: logger.With("component", "rgw.role"),
+		opts:   opts,
+	}
+}
+
+func (r *Role) Name() string {
+	return "rgw.role"
+}
+
+func (r *Role) Requires() []string {
+	return []string{"rgw"}
+}
+
+func (r *Role) Configure(ctx context.Context) error {
+	return nil
+}
+
+func (r *Role) Start(ctx context.Context) error {
+	principal := "*"
+	if r.opts.TrustedUser != "" {
+		principal = "arn:aws:iam:::user/" + r.opts.TrustedUser
+	}
+
+	trustPolicy, err := json.Marshal(map[string]any{
+		"Version": "2012-10-17",
+		"Statement": []map[string]any{{
+			"Effect":    "Allow",
+			"Principal": map[string]any{"AWS": []string{principal}},
+			"Action":    []string{"sts:AssumeRole"},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := radosgwAdmin(ctx, nil, "role", "get", "--role-name="+r.opts.Name); err != nil {
+		if err := radosgwAdmin(ctx, nil, "role", "create", "--role-name="+r.opts.Name, "--path=/",
+			"--assume-role-policy-doc="+string(trustPolicy)); err != nil {
+			return fmt.Errorf("could not create role %s: %w", r.opts.Name, err)
+		}
+	} else if err := radosgwAdmin(ctx, nil, "role", "update", "--role-name="+r.opts.Name,
+		"--assume-role-policy-doc="+string(trustPolicy)); err != nil {
+		return fmt.Errorf("could not update role %s: %w", r.opts.Name, err)
+	}
+
+	permissionPolicy, err := json.Marshal(map[string]any{
+		"Version": "2012-10-17",
+		"Statement": []map[string]any{{
+			"Effect":   "Allow",
+			"Action":   []string{"s3:*"},
+			"Resource": []string{"arn:aws:s3:::*"},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := radosgwAdmin(ctx, nil, "role-policy", "put", "--role-name="+r.opts.Name,
+		"--policy-name=s3-full-access", "--policy-doc="+string(permissionPolicy)); err != nil {
+		return fmt.Errorf("could not set policy of role %s: %w", r.opts.Name, err)
+	}
+
+	r.logger.Info("STS role is available", "arn", r.opts.ARN(), "trustedUser", principal)
+
+	r.provisioned.Store(true)
+
+	return nil
+}
+
+func (r *Role) Stop(ctx context.Context) error {
+	// Nothing is running.
+	return nil
+}
+
+func (r *Role) Ready(ctx context.Context) error {
+	if !r.provisioned.Load() {
+		return fmt.Errorf("role %s has not been provisioned", r.opts.Name)
+	}
+
+	return nil
+}
+
+func (r *Role) Logs() (*tail.Tail, error) {
+	return tail.TailFile(
+		"/dev/null",
+		tail.Config{Follow: true, ReOpen: true},
+	)
+}
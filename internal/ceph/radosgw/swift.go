@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package radosgw
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/dpeckett/picoceph/internal/seed"
+)
+
+// SwiftAuthPath is the path of the Swift (v1) auth endpoint of the gateway.
+const SwiftAuthPath = "/auth/1.0"
+
+// SwiftKey is a Swift secret key.
+type SwiftKey struct {
+	User      string `json:"user"`
+	SecretKey string `json:"secret_key"`
+}
+
+// CreateSwiftSubuser creates a Swift subuser of a user (named "<uid>:swift"),
+// with full access and a Swift key, or returns the existing subuser's key.
+func CreateSwiftSubuser(ctx context.Context, uid string) (*SwiftKey, error) {
+	subuser := uid + ":swift"
+
+	// A seeded key is secret, so it isn't passed on the command line.
+	secretArgs := []string{"--gen-secret"}
+	if seed.Enabled() {
+		secretArgs = []string{"--secret-key=" + seed.String("rgw/swift-key/"+subuser, 40, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789")}
+	}
+
+	var user User
+	err := radosgwAdminWithSecrets(ctx, &user, secretArgs, "subuser", "create", "--uid="+uid, "--subuser="+subuser,
+		"--access=full", "--key-type=swift")
+	if err != nil && !strings.Contains(err.Error(), "exists") {
+		return nil, fmt.Errorf("could not create subuser %s: %w", subuser, err)
+	}
+
+	if err != nil {
+		existing, err := GetUser(ctx, uid)
+		if err != nil {
+			return nil, err
+		}
+
+		user = *existing
+	}
+
+	if key, ok := user.SwiftKey(subuser); ok {
+		return key, nil
+	}
+
+	// The subuser exists, but without a Swift key.
+	if err := radosgwAdminWithSecrets(ctx, &user, secretArgs, "key", "create", "--subuser="+subuser, "--key-type=swift"); err != nil {
+		return nil, fmt.Errorf("could not create swift key for %s: %w", subuser, err)
+	}
+
+	key, ok := user.SwiftKey(subuser)
+	if !ok {
+		return nil, fmt.Errorf("subuser %s has no swift key", subuser)
+	}
+
+	return key, nil
+}
+
+// SwiftKey returns the Swift key of a subuser.
+func (u *User) SwiftKey(subuser string) (*SwiftKey, bool) {
+	for i, key := range u.SwiftKeys {
+		if key.User == subuser {
+			return &u.SwiftKeys[i], true
+		}
+	}
+
+	return nil, false
+}
@@ -0,0 +1,132 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package radosgw
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/certs"
+	"github.com/dpeckett/picoceph/internal/util"
+)
+
+// DefaultTLSPort is the default port the RADOS Gateway serves HTTPS on.
+const DefaultTLSPort = 7443
+
+const (
+	// TLSCertPath is where the generated self-signed certificate is written.
+	TLSCertPath = "/etc/ceph/rgw-tls.crt"
+	// tlsKeyPath is where the private key of the generated certificate is written.
+	tlsKeyPath = "/etc/ceph/rgw-tls.key"
+	// tlsBundlePath is where the certificate and key are combined for civetweb.
+	tlsBundlePath = "/etc/ceph/rgw-tls.pem"
+)
+
+// TLSOptions are the options for serving HTTPS from the RADOS Gateway.
+type TLSOptions struct {
+	// Enabled serves HTTPS alongside HTTP.
+	Enabled bool
+	// Port is the port HTTPS is served on (defaults to DefaultTLSPort).
+	Port int
+	// CertFile and KeyFile are the certificate and private key to use
+	// (defaults to a generated self-signed certificate).
+	CertFile string
+	KeyFile  string
+	// Hosts are extra hostnames or IP addresses the generated certificate is
+	// valid for.
+	Hosts []string
+}
+
+// configureTLS generates the certificate used by the frontend if needed, and
+// makes it readable by the gateway.
+func (o *Options) configureTLS() error {
+	if !o.TLS.Enabled {
+		return nil
+	}
+
+	if (o.TLS.CertFile == "") != (o.TLS.KeyFile == "") {
+		return fmt.Errorf("both a certificate and a key are required for TLS")
+	}
+
+	certPath, keyPath := o.TLS.certPaths()
+
+	if o.TLS.CertFile == "" {
+		// Keep the certificate across restarts, so clients only need to trust it once.
+		if _, err := os.Stat(TLSCertPath); errors.Is(err, os.ErrNotExist) {
+			hosts := o.TLS.Hosts
+			if o.Addr != "" {
+				hosts = append(hosts, o.Addr)
+			}
+
+			certPEM, keyPEM, err := certs.SelfSigned(hosts...)
+			if err != nil {
+				return fmt.Errorf("could not generate certificate: %w", err)
+			}
+
+			if err := os.WriteFile(tlsKeyPath, keyPEM, 0o600); err != nil {
+				return fmt.Errorf("could not write private key: %w", err)
+			}
+
+			if err := os.WriteFile(TLSCertPath, certPEM, 0o644); err != nil {
+				return fmt.Errorf("could not write certificate: %w", err)
+			}
+		} else if err != nil {
+			return fmt.Errorf("could not stat certificate: %w", err)
+		}
+	}
+
+	var generated []string
+	if o.TLS.CertFile == "" {
+		generated = []string{certPath, keyPath}
+	}
+
+	// civetweb expects the certificate and key in a single file.
+	if o.Frontend.Frontend == FrontendCivetweb {
+		certPEM, err := os.ReadFile(certPath)
+		if err != nil {
+			return fmt.Errorf("could not read certificate: %w", err)
+		}
+
+		keyPEM, err := os.ReadFile(keyPath)
+		if err != nil {
+			return fmt.Errorf("could not read private key: %w", err)
+		}
+
+		if err := os.WriteFile(tlsBundlePath, append(keyPEM, certPEM...), 0o600); err != nil {
+			return fmt.Errorf("could not write certificate bundle: %w", err)
+		}
+
+		generated = append(generated, tlsBundlePath)
+	}
+
+	cephUserUid, cephGroupGid, err := ceph.User()
+	if err != nil {
+		return fmt.Errorf("could not get ceph user: %w", err)
+	}
+
+	for _, path := range generated {
+		if err := util.Chown(path, cephUserUid, cephGroupGid); err != nil {
+			return fmt.Errorf("could not change owner: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// certPaths returns the paths of the certificate and private key.
+func (o TLSOptions) certPaths() (string, string) {
+	if o.CertFile != "" {
+		return o.CertFile, o.KeyFile
+	}
+
+	return TLSCertPath, tlsKeyPath
+}
@@ -0,0 +1,240 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package radosgw
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/nxadm/tail"
+)
+
+// Upload is a local directory to upload into a bucket.
+type Upload struct {
+	Dir    string
+	Bucket string
+}
+
+// ParseUploads parses comma separated dir=bucket pairs.
+func ParseUploads(s string) ([]Upload, error) {
+	var uploads []Upload
+	for _, pair := range strings.Split(s, ",") {
+		if pair == "" {
+			continue
+		}
+
+		dir, bucket, ok := strings.Cut(pair, "=")
+		if !ok || dir == "" || bucket == "" {
+			return nil, fmt.Errorf("invalid upload %q, expected dir=bucket", pair)
+		}
+
+		uploads = append(uploads, Upload{Dir: dir, Bucket: bucket})
+	}
+
+	return uploads, nil
+}
+
+// UploadDir uploads every file under dir into a bucket, keyed by its path
+// relative to dir. Objects that already exist are left alone, so that changes
+// made by tests survive a restart. It returns the number of uploaded objects.
+func UploadDir(ctx context.Context, creds *Credentials, dir, bucket string) (int, error) {
+	var uploaded int
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		key, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		objectURL, err := bucketObjectURL(creds, bucket, filepath.ToSlash(key))
+		if err != nil {
+			return err
+		}
+
+		exists, err := objectExists(ctx, creds, objectURL)
+		if err != nil || exists {
+			return err
+		}
+
+		if err := putObject(ctx, creds, objectURL, path); err != nil {
+			return fmt.Errorf("could not upload %s: %w", path, err)
+		}
+
+		uploaded++
+
+		return nil
+	})
+
+	return uploaded, err
+}
+
+func objectExists(ctx context.Context, creds *Credentials, objectURL *url.URL) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, objectURL.String(), nil)
+	if err != nil {
+		return false, err
+	}
+
+	signRequest(req, creds.AccessKey, creds.SecretKey, emptyPayloadHash, time.Now())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("could not check if %s exists: %s", objectURL.Path, resp.Status)
+	}
+}
+
+func putObject(ctx context.Context, creds *Credentials, objectURL *url.URL, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, objectURL.String(), f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+
+	signRequest(req, creds.AccessKey, creds.SecretKey, hex.EncodeToString(h.Sum(nil)), time.Now())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+// Uploads uploads local directories into buckets, owned by the default S3
+// user, so that tests start against pre-populated data.
+type Uploads struct {
+	logger   *slog.Logger
+	uploads  []Upload
+	uploaded atomic.Bool
+}
+
+// NewUploads creates a component that uploads directories once the default S3
+// user has been provisioned.
+func NewUploads(logger *slog.Logger, uploads []Upload) ceph.Component {
+	return &Uploads{
+		logger:  logger.With("component", "rgw.uploads"),
+		uploads: uploads,
+	}
+}
+
+func (u *Uploads) Name() string {
+	return "rgw.uploads"
+}
+
+func (u *Uploads) Requires() []string {
+	return []string{"rgw.user"}
+}
+
+func (u *Uploads) Configure(ctx context.Context) error {
+	for _, upload := range u.uploads {
+		if info, err := os.Stat(upload.Dir); err != nil {
+			return fmt.Errorf("could not read directory to upload: %w", err)
+		} else if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", upload.Dir)
+		}
+	}
+
+	return nil
+}
+
+func (u *Uploads) Start(ctx context.Context) error {
+	creds, err := ReadCredentials(CredentialsPath)
+	if err != nil {
+		return fmt.Errorf("could not read S3 credentials: %w", err)
+	}
+
+	for _, upload := range u.uploads {
+		if err := CreateBucket(ctx, creds, upload.Bucket); err != nil {
+			return err
+		}
+
+		n, err := UploadDir(ctx, creds, upload.Dir, upload.Bucket)
+		if err != nil {
+			return fmt.Errorf("could not upload %s into bucket %s: %w", upload.Dir, upload.Bucket, err)
+		}
+
+		u.logger.Info("Uploaded directory", "dir", upload.Dir, "bucket", upload.Bucket, "objects", n)
+	}
+
+	u.uploaded.Store(true)
+
+	return nil
+}
+
+func (u *Uploads) Stop(ctx context.Context) error {
+	// Nothing is running.
+	return nil
+}
+
+func (u *Uploads) Ready(ctx context.Context) error {
+	if !u.uploaded.Load() {
+		return fmt.Errorf("directories have not been uploaded")
+	}
+
+	return nil
+}
+
+func (u *Uploads) Logs() (*tail.Tail, error) {
+	return tail.TailFile(
+		"/dev/null",
+		tail.Config{Follow: true, ReOpen: true},
+	)
+}
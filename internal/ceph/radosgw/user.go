@@ -0,0 +1,183 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package radosgw
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/nxadm/tail"
+)
+
+const (
+	// CredentialsPath is where the credentials of the default S3 user are written.
+	CredentialsPath = "/etc/ceph/s3-credentials.json"
+	// AdminCredentialsPath is where the credentials of the admin user are written.
+	AdminCredentialsPath = "/etc/ceph/s3-admin-credentials.json"
+)
+
+// AdminCaps are the capabilities of the admin user, they allow it to use the
+// admin REST API.
+const AdminCaps = "users=*;buckets=*;metadata=*;usage=*"
+
+// Credentials are what S3 clients need to talk to the gateway.
+type Credentials struct {
+	Endpoint  string `json:"endpoint"`
+	UserID    string `json:"userID"`
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"secretKey"`
+	// Swift credentials, if the user has a Swift subuser.
+	SwiftAuthURL string `json:"swiftAuthURL,omitempty"`
+	SwiftUser    string `json:"swiftUser,omitempty"`
+	SwiftKey     string `json:"swiftKey,omitempty"`
+}
+
+// ReadCredentials reads the credentials of a provisioned user.
+func ReadCredentials(path string) (*Credentials, error) {
+	credsJSON, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var creds Credentials
+	if err := json.Unmarshal(credsJSON, &creds); err != nil {
+		return nil, fmt.Errorf("could not parse S3 credentials: %w", err)
+	}
+
+	return &creds, nil
+}
+
+// ProvisionedUser provisions an S3 user and writes its credentials to a
+// well-known file, so that clients can use the gateway without running
+// radosgw-admin themselves.
+type ProvisionedUser struct {
+	logger      *slog.Logger
+	name        string
+	endpoint    string
+	path        string
+	opts        UserOptions
+	swift       bool
+	provisioned atomic.Bool
+}
+
+// NewDefaultUser creates a component that provisions the default S3 user, of
+// the gateway at the given endpoint. If swift is true, the user is also given
+// a Swift subuser.
+func NewDefaultUser(logger *slog.Logger, endpoint string, opts UserOptions, swift bool) ceph.Component {
+	u := newProvisionedUser(logger, "rgw.user", endpoint, CredentialsPath, opts)
+	u.swift = swift
+	return u
+}
+
+// NewAdminUser creates a component that provisions a user with AdminCaps, for
+// tools that use the admin REST API.
+func NewAdminUser(logger *slog.Logger, endpoint string, opts UserOptions) ceph.Component {
+	opts.Caps = AdminCaps
+	return newProvisionedUser(logger, "rgw.admin", endpoint, AdminCredentialsPath, opts)
+}
+
+func newProvisionedUser(logger *slog.Logger, name, endpoint, path string, opts UserOptions) *ProvisionedUser {
+	return &ProvisionedUser{
+		logger:   logger.With("component", name),
+		name:     name,
+		endpoint: endpoint,
+		path:     path,
+		opts:     opts,
+	}
+}
+
+func (u *ProvisionedUser) Name() string {
+	return u.name
+}
+
+func (u *ProvisionedUser) Requires() []string {
+	return []string{"rgw"}
+}
+
+func (u *ProvisionedUser) Configure(ctx context.Context) error {
+	if (u.opts.AccessKey == "") != (u.opts.SecretKey == "") {
+		return fmt.Errorf("both an access key and a secret key are required for a static S3 key")
+	}
+
+	return nil
+}
+
+func (u *ProvisionedUser) Start(ctx context.Context) error {
+	user, err := CreateUser(ctx, u.opts)
+	if err != nil {
+		return err
+	}
+
+	key, ok := user.Key(u.opts.AccessKey)
+	if !ok {
+		return fmt.Errorf("user %s has no keys", u.opts.UID)
+	}
+
+	creds := Credentials{
+		Endpoint:  u.endpoint,
+		UserID:    user.UserID,
+		AccessKey: key.AccessKey,
+		SecretKey: key.SecretKey,
+	}
+
+	if u.swift {
+		swiftKey, err := CreateSwiftSubuser(ctx, u.opts.UID)
+		if err != nil {
+			return err
+		}
+
+		creds.SwiftAuthURL = u.endpoint + SwiftAuthPath
+		creds.SwiftUser = swiftKey.User
+		creds.SwiftKey = swiftKey.SecretKey
+
+		u.logger.Info("Swift user is available", "authURL", creds.SwiftAuthURL, "user", creds.SwiftUser, "key", creds.SwiftKey)
+	}
+
+	credsJSON, err := json.MarshalIndent(creds, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(u.path, credsJSON, 0o600); err != nil {
+		return fmt.Errorf("could not write S3 credentials: %w", err)
+	}
+
+	u.logger.Info("S3 user is available", "endpoint", creds.Endpoint, "user", creds.UserID,
+		"accessKey", creds.AccessKey, "secretKey", creds.SecretKey)
+
+	u.provisioned.Store(true)
+
+	return nil
+}
+
+func (u *ProvisionedUser) Stop(ctx context.Context) error {
+	// Nothing is running.
+	return nil
+}
+
+func (u *ProvisionedUser) Ready(ctx context.Context) error {
+	if !u.provisioned.Load() {
+		return fmt.Errorf("S3 user %s has not been provisioned", u.opts.UID)
+	}
+
+	return nil
+}
+
+func (u *ProvisionedUser) Logs() (*tail.Tail, error) {
+	return tail.TailFile(
+		"/dev/null",
+		tail.Config{Follow: true, ReOpen: true},
+	)
+}
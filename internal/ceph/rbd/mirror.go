@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package rbd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/dpeckett/picoceph/internal/command"
+)
+
+// MirrorMode is how the images of a pool are mirrored.
+type MirrorMode string
+
+const (
+	// MirrorModeImage only mirrors images that have mirroring enabled.
+	MirrorModeImage MirrorMode = "image"
+	// MirrorModePool mirrors every journaled image in the pool.
+	MirrorModePool MirrorMode = "pool"
+)
+
+// EnableMirroring enables mirroring on a pool.
+func EnableMirroring(ctx context.Context, pool string, mode MirrorMode) error {
+	cmd := command.Context(ctx, "rbd", "mirror", "pool", "enable", pool, string(mode))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not enable mirroring on pool %s: %w: %s", pool, err, string(out))
+	}
+
+	return nil
+}
+
+// CreatePeerToken names the local site, and returns a token that a peer
+// cluster can import to mirror the pool.
+func CreatePeerToken(ctx context.Context, pool, siteName string) (string, error) {
+	cmd := command.Context(ctx, "rbd", "mirror", "pool", "peer", "bootstrap", "create", "--site-name", siteName, pool)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("could not create peer bootstrap token for pool %s: %w", pool, err)
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// ImportPeerToken names the local site, and adds the peer cluster that
+// created the token. The direction is either "rx-only" or "rx-tx".
+func ImportPeerToken(ctx context.Context, pool, siteName, token, direction string) error {
+	cmd := command.Context(ctx, "rbd", "mirror", "pool", "peer", "bootstrap", "import",
+		"--site-name", siteName, "--direction", direction, pool, "-")
+	cmd.Stdin = strings.NewReader(token)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not import peer bootstrap token for pool %s: %w: %s", pool, err, string(out))
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package rbd creates RBD pools and images.
+package rbd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dpeckett/picoceph/internal/command"
+)
+
+// CreatePool creates (and initializes) an RBD pool, if it doesn't already exist.
+func CreatePool(ctx context.Context, pool string) error {
+	cmd := command.Context(ctx, "ceph", "osd", "pool", "create", pool, "8")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not create pool %s: %w: %s", pool, err, string(out))
+	}
+
+	cmd = command.Context(ctx, "rbd", "pool", "init", pool)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not initialize pool %s: %w: %s", pool, err, string(out))
+	}
+
+	return nil
+}
+
+// CreateImage creates an image of the given size (in bytes, rounded down to
+// MiB), if it doesn't already exist.
+func CreateImage(ctx context.Context, pool, image string, size int64) error {
+	cmd := command.Context(ctx, "rbd", "create", pool+"/"+image, "--size", strconv.FormatInt(size>>20, 10)+"M")
+	out, err := cmd.CombinedOutput()
+	if err != nil && !strings.Contains(string(out), "exists") {
+		return fmt.Errorf("could not create image %s/%s: %w: %s", pool, image, err, string(out))
+	}
+
+	return nil
+}
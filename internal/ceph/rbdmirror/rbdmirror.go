@@ -0,0 +1,152 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package rbdmirror runs rbd-mirror, which replicates RBD images between
+// clusters.
+package rbdmirror
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/ceph/auth"
+	"github.com/dpeckett/picoceph/internal/ceph/rbd"
+	"github.com/dpeckett/picoceph/internal/daemon"
+	"github.com/dpeckett/picoceph/internal/util"
+	"github.com/nxadm/tail"
+)
+
+const (
+	// name is the name of the rbd-mirror entity.
+	name = "client.rbd-mirror.a"
+	// dataDir is where the keyring of rbd-mirror is kept.
+	dataDir = "/var/lib/ceph/rbd-mirror"
+	// sockPath is the admin socket of rbd-mirror.
+	sockPath = "/var/run/ceph/ceph-" + name + ".asok"
+)
+
+// DefaultCaps are the default capabilities of rbd-mirror.
+var DefaultCaps = ceph.Caps{
+	"mon": "profile rbd-mirror",
+	"osd": "profile rbd",
+}
+
+// Options are the options for rbd-mirror.
+type Options struct {
+	// Caps are the capabilities of rbd-mirror (defaults to DefaultCaps).
+	Caps ceph.Caps
+	// Pools are created (if needed), and have mirroring enabled in image mode.
+	Pools []string
+}
+
+// RBDMirror runs rbd-mirror.
+type RBDMirror struct {
+	opts   Options
+	daemon *daemon.Daemon
+}
+
+func New(logger *slog.Logger, opts Options) ceph.Component {
+	if opts.Caps == nil {
+		opts.Caps = DefaultCaps
+	}
+
+	return &RBDMirror{
+		opts: opts,
+		daemon: daemon.New(logger.With("component", "rbd-mirror"), "rbd-mirror",
+			"-f", "-n", name, "--keyring", dataDir+"/keyring"),
+	}
+}
+
+func (m *RBDMirror) Name() string {
+	return "rbd-mirror"
+}
+
+func (m *RBDMirror) Requires() []string {
+	// Mirrored pools require OSDs.
+	return []string{"osd"}
+}
+
+func (m *RBDMirror) Configure(ctx context.Context) error {
+	if err := ceph.MkdirAll(dataDir); err != nil {
+		return fmt.Errorf("could not create directory: %w", err)
+	}
+
+	caps, err := m.opts.Caps.Render(name, "rbd-mirror.a")
+	if err != nil {
+		return err
+	}
+
+	if err := auth.GetOrCreate(ctx, name, caps, dataDir+"/keyring"); err != nil {
+		return err
+	}
+
+	cephUserUid, cephGroupGid, err := ceph.User()
+	if err != nil {
+		return fmt.Errorf("could not get ceph user: %w", err)
+	}
+
+	if err := util.ChownRecursive(dataDir, cephUserUid, cephGroupGid); err != nil {
+		return fmt.Errorf("could not change owner: %w", err)
+	}
+
+	for _, pool := range m.opts.Pools {
+		if err := rbd.CreatePool(ctx, pool); err != nil {
+			return err
+		}
+
+		if err := rbd.EnableMirroring(ctx, pool, rbd.MirrorModeImage); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *RBDMirror) Start(ctx context.Context) error {
+	if err := m.daemon.Run(ctx); err != nil {
+		return fmt.Errorf("could not start rbd-mirror: %w", err)
+	}
+
+	return nil
+}
+
+func (m *RBDMirror) Stop(ctx context.Context) error {
+	return m.daemon.Stop(ctx)
+}
+
+func (m *RBDMirror) Pid() int {
+	return m.daemon.Pid()
+}
+
+func (m *RBDMirror) Signal(sig os.Signal) error {
+	return m.daemon.Signal(sig)
+}
+
+func (m *RBDMirror) Ready(ctx context.Context) error {
+	if m.daemon.Pid() == 0 {
+		return fmt.Errorf("rbd-mirror is not running")
+	}
+
+	// The admin socket is created once rbd-mirror has connected to the cluster.
+	if _, err := os.Stat(sockPath); err != nil {
+		return fmt.Errorf("rbd-mirror is not running: %w", err)
+	}
+
+	return nil
+}
+
+func (m *RBDMirror) Logs() (*tail.Tail, error) {
+	return tail.TailFile(
+		"/var/log/ceph/ceph-"+name+".log",
+		tail.Config{Follow: true, ReOpen: true},
+	)
+}
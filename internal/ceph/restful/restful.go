@@ -0,0 +1,215 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package restful
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/command"
+	"github.com/nxadm/tail"
+)
+
+// CredentialsPath is where the URL and API key of the REST API are written.
+const CredentialsPath = "/etc/ceph/restful-credentials.json"
+
+// DefaultPort is the default port the REST API is served on.
+const DefaultPort = 8003
+
+// Options are the options for the restful manager module.
+type Options struct {
+	// Addr is the address the REST API binds to (defaults to all addresses).
+	Addr string
+	// Port is the port the REST API is served on (defaults to DefaultPort).
+	Port int
+	// Username is the name of the API key (defaults to "admin").
+	Username string
+}
+
+// Credentials are the URL and API key of the REST API.
+type Credentials struct {
+	URL      string `json:"url"`
+	Username string `json:"username"`
+	Key      string `json:"key"`
+}
+
+// Restful serves the management REST API of the restful manager module, over
+// HTTPS with a self-signed certificate.
+type Restful struct {
+	logger *slog.Logger
+	opts   Options
+}
+
+func New(logger *slog.Logger, opts Options) ceph.Component {
+	if opts.Username == "" {
+		opts.Username = "admin"
+	}
+
+	if opts.Port == 0 {
+		opts.Port = DefaultPort
+	}
+
+	return &Restful{
+		logger: logger.With("component", "restful"),
+		opts:   opts,
+	}
+}
+
+func (r *Restful) Name() string {
+	return "restful"
+}
+
+func (r *Restful) Requires() []string {
+	// The REST API is a manager module.
+	return []string{"mgr"}
+}
+
+func (r *Restful) Configure(ctx context.Context) error {
+	cmd := command.Context(ctx, "ceph", "config", "set", "mgr", "mgr/restful/server_port", strconv.Itoa(r.opts.Port))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not set REST API port: %w: %s", err, string(out))
+	}
+
+	if r.opts.Addr != "" {
+		cmd = command.Context(ctx, "ceph", "config", "set", "mgr", "mgr/restful/server_addr", r.opts.Addr)
+	} else {
+		cmd = command.Context(ctx, "ceph", "config", "rm", "mgr", "mgr/restful/server_addr")
+	}
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not set REST API address: %w: %s", err, string(out))
+	}
+
+	return nil
+}
+
+func (r *Restful) Start(ctx context.Context) error {
+	cmd := command.Context(ctx, "ceph", "mgr", "module", "enable", "restful")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not enable restful module: %w: %s", err, string(out))
+	}
+
+	// Don't block forever if the module does not come up.
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+
+	// The restful commands aren't available until the module is loaded.
+	if _, err := retry(ctx, "restful", "create-self-signed-cert"); err != nil {
+		return fmt.Errorf("could not create REST API certificate: %w", err)
+	}
+
+	// Creating a key that already exists returns the existing key.
+	key, err := retry(ctx, "restful", "create-key", r.opts.Username)
+	if err != nil {
+		return fmt.Errorf("could not create REST API key: %w", err)
+	}
+
+	url, err := r.waitForURL(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get REST API url: %w", err)
+	}
+
+	creds := Credentials{
+		URL:      url,
+		Username: r.opts.Username,
+		Key:      key,
+	}
+
+	credsJSON, err := json.MarshalIndent(creds, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(CredentialsPath, credsJSON, 0o600); err != nil {
+		return fmt.Errorf("could not write REST API credentials: %w", err)
+	}
+
+	r.logger.Info("REST API is available", "url", creds.URL, "username", creds.Username, "credentials", CredentialsPath)
+
+	return nil
+}
+
+func (r *Restful) Stop(ctx context.Context) error {
+	// The REST API is served by the manager, so there is nothing to stop.
+	return nil
+}
+
+func (r *Restful) Ready(ctx context.Context) error {
+	_, err := serviceURL(ctx)
+	return err
+}
+
+func (r *Restful) Logs() (*tail.Tail, error) {
+	// Module logs are logged by the manager.
+	return tail.TailFile(
+		"/dev/null",
+		tail.Config{Follow: true, ReOpen: true},
+	)
+}
+
+// serviceURL returns the url the REST API is being served on.
+func serviceURL(ctx context.Context) (string, error) {
+	services := map[string]string{}
+	if err := ceph.RunJSON(ctx, &services, "mgr", "services"); err != nil {
+		return "", err
+	}
+
+	url, ok := services["restful"]
+	if !ok {
+		return "", fmt.Errorf("REST API is not being served")
+	}
+
+	return url, nil
+}
+
+// waitForURL polls until the REST API is being served, and returns its url.
+func (r *Restful) waitForURL(ctx context.Context) (string, error) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		url, err := serviceURL(ctx)
+		if err == nil {
+			return url, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("%w: %w", ctx.Err(), err)
+		case <-ticker.C:
+		}
+	}
+}
+
+// retry runs a ceph command until it succeeds, and returns its output.
+func retry(ctx context.Context, args ...string) (string, error) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		out, err := command.Context(ctx, "ceph", args...).CombinedOutput()
+		if err == nil {
+			return strings.TrimSpace(string(out)), nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("%w: %w: %s", ctx.Err(), err, string(out))
+		case <-ticker.C:
+		}
+	}
+}
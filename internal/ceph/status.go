@@ -0,0 +1,44 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package ceph
+
+import "context"
+
+// Status is a summary of the output of `ceph status`.
+type Status struct {
+	Health struct {
+		Status string `json:"status"`
+	} `json:"health"`
+	MonMap struct {
+		NumMons int `json:"num_mons"`
+	} `json:"monmap"`
+	OSDMap struct {
+		NumOSDs   int `json:"num_osds"`
+		NumUpOSDs int `json:"num_up_osds"`
+		NumInOSDs int `json:"num_in_osds"`
+	} `json:"osdmap"`
+	PGMap struct {
+		NumPGs     int   `json:"num_pgs"`
+		NumPools   int   `json:"num_pools"`
+		BytesUsed  int64 `json:"bytes_used"`
+		BytesAvail int64 `json:"bytes_avail"`
+		BytesTotal int64 `json:"bytes_total"`
+	} `json:"pgmap"`
+}
+
+// GetStatus returns the current status of the cluster.
+func GetStatus(ctx context.Context) (*Status, error) {
+	var status Status
+	if err := RunJSON(ctx, &status, "status"); err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package ceph
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/dpeckett/picoceph/internal/command"
+)
+
+// TellTypes are the daemon types that accept `ceph tell` commands.
+var TellTypes = []string{"mon", "mgr", "osd", "mds"}
+
+// TellResult is the result of a `ceph tell` command sent to a single daemon.
+type TellResult struct {
+	// Output is the JSON output of the command (or a JSON string if the
+	// command didn't output JSON).
+	Output json.RawMessage `json:"output,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// Tell concurrently runs `ceph tell <daemon> <args>` against every daemon and
+// returns the results keyed by daemon name (eg. "osd.0").
+func Tell(ctx context.Context, daemons []string, args ...string) map[string]TellResult {
+	var mu sync.Mutex
+	results := make(map[string]TellResult, len(daemons))
+
+	var wg sync.WaitGroup
+	for _, daemon := range daemons {
+		wg.Add(1)
+
+		go func(daemon string) {
+			defer wg.Done()
+
+			result := tell(ctx, daemon, args...)
+
+			mu.Lock()
+			results[daemon] = result
+			mu.Unlock()
+		}(daemon)
+	}
+
+	wg.Wait()
+
+	return results
+}
+
+func tell(ctx context.Context, daemon string, args ...string) TellResult {
+	cmd := command.Context(ctx, "ceph", append([]string{"tell", daemon}, append(args, "--format=json")...)...)
+
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return TellResult{Error: fmt.Sprintf("%v: %s", err, strings.TrimSpace(stderr.String()))}
+	}
+
+	out = []byte(strings.TrimSpace(string(out)))
+	if len(out) == 0 {
+		return TellResult{}
+	}
+
+	if !json.Valid(out) {
+		out, _ = json.Marshal(string(out))
+	}
+
+	return TellResult{Output: out}
+}
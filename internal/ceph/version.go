@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package ceph
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/dpeckett/picoceph/internal/command"
+)
+
+var versionRegexp = regexp.MustCompile(`ceph version (\d+)\.(\d+)\.(\d+)\S* \(\w+\) (\w+)`)
+
+// Version is the version of the installed Ceph release.
+type Version struct {
+	Major    int
+	Minor    int
+	Patch    int
+	Codename string
+}
+
+// InstalledVersion returns the version of the installed Ceph release.
+func InstalledVersion(ctx context.Context) (*Version, error) {
+	out, err := command.Context(ctx, "ceph", "--version").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("could not get ceph version: %w: %s", err, string(out))
+	}
+
+	return ParseVersion(string(out))
+}
+
+// ParseVersion parses the output of `ceph --version`, eg.
+// "ceph version 18.2.2 (531c0d11a1c5d39fbfe6aa8a521f023abf3bf3e2) reef (stable)".
+func ParseVersion(s string) (*Version, error) {
+	m := versionRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return nil, fmt.Errorf("could not parse ceph version: %q", s)
+	}
+
+	v := &Version{Codename: m[4]}
+	v.Major, _ = strconv.Atoi(m[1])
+	v.Minor, _ = strconv.Atoi(m[2])
+	v.Patch, _ = strconv.Atoi(m[3])
+
+	return v, nil
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d (%s)", v.Major, v.Minor, v.Patch, v.Codename)
+}
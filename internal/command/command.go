@@ -0,0 +1,194 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package command runs external commands, optionally recording their output
+// to a fixture file, or replaying it from one instead of running them. Replay
+// allows the orchestration logic to be tested without Ceph, or root.
+//
+// Both modes work by running picoceph itself as a shim in place of the
+// command, so that callers can keep using *exec.Cmd as usual. Daemons are run
+// through the shim too: a replayed daemon that was stopped by a signal keeps
+// running until it is signalled again.
+package command
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	// recordShim is argv[0] of a shim that runs a command and records its output.
+	recordShim = "picoceph-record"
+	// replayShim is argv[0] of a shim that replays the output of a command.
+	replayShim = "picoceph-replay"
+)
+
+// Record is the recorded output of a command.
+type Record struct {
+	Args     []string `json:"args"`
+	Stdout   []byte   `json:"stdout,omitempty"`
+	Stderr   []byte   `json:"stderr,omitempty"`
+	ExitCode int      `json:"exitCode"`
+	// Signaled is true if the command was terminated by a signal (eg. a
+	// daemon that was stopped).
+	Signaled bool `json:"signaled,omitempty"`
+}
+
+var (
+	mu          sync.Mutex
+	recordPath  string
+	replaying   bool
+	replayQueue map[string][]Record
+)
+
+// RecordTo records the output of every command run from now on to a fixture
+// file (one JSON record per line), replacing any existing fixtures. The file
+// is only readable by its owner, as the output includes keys.
+func RecordTo(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("could not create fixture file: %w", err)
+	}
+	defer f.Close()
+
+	// Fixtures recorded by earlier versions may be world-readable.
+	if err := f.Chmod(0o600); err != nil {
+		return fmt.Errorf("could not create fixture file: %w", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	recordPath = path
+
+	return nil
+}
+
+// ReplayFrom replays the output of commands from a fixture file, rather than
+// running them. Each recorded output is replayed once, in the order it was
+// recorded, to a command with the same arguments.
+func ReplayFrom(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not open fixture file: %w", err)
+	}
+	defer f.Close()
+
+	queue := map[string][]Record{}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return fmt.Errorf("could not parse fixture: %w", err)
+		}
+
+		queue[key(r.Args)] = append(queue[key(r.Args)], r)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("could not read fixture file: %w", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	replaying = true
+	replayQueue = queue
+
+	return nil
+}
+
+// Replaying returns true if commands are being replayed, rather than run.
+func Replaying() bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return replaying
+}
+
+// Context is exec.CommandContext, but records or replays the command if
+// enabled.
+func Context(ctx context.Context, name string, args ...string) *exec.Cmd {
+	mu.Lock()
+	defer mu.Unlock()
+
+	argv := append([]string{name}, args...)
+
+	switch {
+	case replaying:
+		r, ok := nextRecord(argv)
+		if !ok {
+			r = Record{
+				Args:     argv,
+				Stderr:   []byte(fmt.Sprintf("no recorded output for: %s\n", strings.Join(argv, " "))),
+				ExitCode: 127,
+			}
+		}
+
+		recordFile, err := writeReplayRecord(r)
+		if err != nil {
+			// Fail the same way a missing command would.
+			return exec.CommandContext(ctx, "/nonexistent/"+replayShim)
+		}
+
+		cmd := exec.CommandContext(ctx, "/proc/self/exe")
+		cmd.Args = []string{replayShim, recordFile}
+		return cmd
+	case recordPath != "":
+		cmd := exec.CommandContext(ctx, "/proc/self/exe")
+		cmd.Args = append([]string{recordShim, recordPath}, argv...)
+		return cmd
+	default:
+		return exec.CommandContext(ctx, name, args...)
+	}
+}
+
+// nextRecord pops the next recorded output of a command.
+func nextRecord(argv []string) (Record, bool) {
+	queue := replayQueue[key(argv)]
+	if len(queue) == 0 {
+		return Record{}, false
+	}
+
+	replayQueue[key(argv)] = queue[1:]
+
+	return queue[0], true
+}
+
+// tempPathRegexp matches the pid and random suffix of picoceph's temporary
+// directories.
+var tempPathRegexp = regexp.MustCompile(`(/picoceph-[a-z-]+-)[\d.]+`)
+
+// key identifies a command, ignoring the random names of temporary files.
+func key(argv []string) string {
+	return tempPathRegexp.ReplaceAllString(strings.Join(argv, "\x00"), "$1")
+}
+
+func writeReplayRecord(r Record) (string, error) {
+	f, err := os.CreateTemp("", "picoceph-replay-*.json")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if err := json.NewEncoder(f).Encode(r); err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), nil
+}
@@ -0,0 +1,185 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package command
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Recorded and replayed commands re-execute the test binary as a shim.
+	if IsShim() {
+		os.Exit(RunShim())
+	}
+
+	os.Exit(m.Run())
+}
+
+// reset stops recording or replaying commands.
+func reset(t *testing.T) {
+	t.Cleanup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		recordPath = ""
+		replaying = false
+		replayQueue = nil
+	})
+}
+
+func TestKey(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		a, b  []string
+		equal bool
+	}{
+		{
+			name:  "random suffix",
+			a:     []string{"ceph", "auth", "import", "-i", "/tmp/picoceph-auth-1234/keyring"},
+			b:     []string{"ceph", "auth", "import", "-i", "/tmp/picoceph-auth-98765/keyring"},
+			equal: true,
+		},
+		{
+			name:  "pid and random suffix",
+			a:     []string{"ceph-mon", "--keyring", "/tmp/picoceph-mon-4121.2870561839/ceph.mon.keyring"},
+			b:     []string{"ceph-mon", "--keyring", "/tmp/picoceph-mon-77.12/ceph.mon.keyring"},
+			equal: true,
+		},
+		{
+			name:  "hyphenated pattern",
+			a:     []string{"ceph", "-i", "/tmp/picoceph-dashboard-rgw-12.34/key"},
+			b:     []string{"ceph", "-i", "/tmp/picoceph-dashboard-rgw-56.78/key"},
+			equal: true,
+		},
+		{
+			name: "different patterns",
+			a:    []string{"ceph", "-i", "/tmp/picoceph-mon-12.34/keyring"},
+			b:    []string{"ceph", "-i", "/tmp/picoceph-auth-12.34/keyring"},
+		},
+		{
+			name: "different files",
+			a:    []string{"ceph", "-i", "/tmp/picoceph-auth-12.34/keyring"},
+			b:    []string{"ceph", "-i", "/tmp/picoceph-auth-12.34/password"},
+		},
+		{
+			name: "argument boundaries",
+			a:    []string{"ceph", "osd ls"},
+			b:    []string{"ceph", "osd", "ls"},
+		},
+		{
+			name: "other paths",
+			a:    []string{"cat", "/var/lib/ceph/osd-1"},
+			b:    []string{"cat", "/var/lib/ceph/osd-2"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if equal := key(tc.a) == key(tc.b); equal != tc.equal {
+				t.Fatalf("key(%q) == key(%q) is %v", tc.a, tc.b, equal)
+			}
+		})
+	}
+}
+
+func TestNextRecord(t *testing.T) {
+	reset(t)
+
+	first := Record{Args: []string{"ceph", "-i", "/tmp/picoceph-auth-1.2/keyring"}, Stdout: []byte("first")}
+	second := Record{Args: []string{"ceph", "-i", "/tmp/picoceph-auth-3.4/keyring"}, Stdout: []byte("second")}
+
+	replayQueue = map[string][]Record{key(first.Args): {first, second}}
+
+	argv := []string{"ceph", "-i", "/tmp/picoceph-auth-5.6/keyring"}
+	for _, want := range []string{"first", "second"} {
+		r, ok := nextRecord(argv)
+		if !ok || string(r.Stdout) != want {
+			t.Fatalf("got %q (%v), want %q", r.Stdout, ok, want)
+		}
+	}
+
+	if _, ok := nextRecord(argv); ok {
+		t.Fatal("expected the recorded outputs to be used up")
+	}
+
+	if _, ok := nextRecord([]string{"ceph", "status"}); ok {
+		t.Fatal("expected no recorded output for an unknown command")
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	reset(t)
+
+	fixturePath := filepath.Join(t.TempDir(), "fixtures.jsonl")
+	if err := RecordTo(fixturePath); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(fixturePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fi.Mode().Perm() != 0o600 {
+		t.Fatalf("fixture file has mode %o", fi.Mode().Perm())
+	}
+
+	ctx := context.Background()
+
+	out, err := Context(ctx, "echo", "recorded").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(out) != "recorded\n" {
+		t.Fatalf("got %q", out)
+	}
+
+	err = Context(ctx, "sh", "-c", "exit 3").Run()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Fatalf("got %v, want exit status 3", err)
+	}
+
+	mu.Lock()
+	recordPath = ""
+	mu.Unlock()
+
+	if err := ReplayFrom(fixturePath); err != nil {
+		t.Fatal(err)
+	}
+
+	if !Replaying() {
+		t.Fatal("expected commands to be replayed")
+	}
+
+	out, err = Context(ctx, "echo", "recorded").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(out) != "recorded\n" {
+		t.Fatalf("got %q", out)
+	}
+
+	err = Context(ctx, "sh", "-c", "exit 3").Run()
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Fatalf("got %v, want exit status 3", err)
+	}
+
+	// Each output is only replayed once.
+	err = Context(ctx, "echo", "recorded").Run()
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 127 {
+		t.Fatalf("got %v, want exit status 127", err)
+	}
+}
@@ -0,0 +1,156 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+// IsShim returns true if this process was started as a record or replay shim.
+func IsShim() bool {
+	return len(os.Args) > 1 && (os.Args[0] == recordShim || os.Args[0] == replayShim)
+}
+
+// RunShim runs this process as a shim, and returns its exit code.
+func RunShim() int {
+	var err error
+	var exitCode int
+	if os.Args[0] == recordShim {
+		exitCode, err = record(os.Args[1], os.Args[2:])
+	} else {
+		exitCode, err = replay(os.Args[1])
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		return 127
+	}
+
+	return exitCode
+}
+
+// record runs a command, passing through its output, and appends the output
+// to the fixture file.
+func record(fixturePath string, argv []string) (int, error) {
+	if len(argv) == 0 {
+		return 0, errors.New("no command")
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = io.MultiWriter(os.Stdout, &stdout)
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+	// Don't outlive the shim if it is killed (eg. the context was cancelled).
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGKILL}
+
+	r := Record{Args: argv}
+
+	// Signals meant for the command (eg. stopping a daemon) are sent to the
+	// shim, so pass them on.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, forwardedSignals...)
+	defer signal.Stop(sigs)
+
+	err := cmd.Start()
+	if err == nil {
+		go func() {
+			for sig := range sigs {
+				_ = cmd.Process.Signal(sig)
+			}
+		}()
+
+		err = cmd.Wait()
+	}
+
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			fmt.Fprintln(&stderr, err)
+			fmt.Fprintln(os.Stderr, err)
+			r.ExitCode = 127
+		} else if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			r.ExitCode = 128 + int(status.Signal())
+			r.Signaled = true
+		} else {
+			r.ExitCode = exitErr.ExitCode()
+		}
+	}
+
+	r.Stdout = stdout.Bytes()
+	r.Stderr = stderr.Bytes()
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		return 0, err
+	}
+
+	f, err := os.OpenFile(fixturePath, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return 0, fmt.Errorf("could not open fixture file: %w", err)
+	}
+	defer f.Close()
+
+	// Commands run concurrently, so don't interleave records.
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return 0, fmt.Errorf("could not lock fixture file: %w", err)
+	}
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return 0, fmt.Errorf("could not write fixture: %w", err)
+	}
+
+	return r.ExitCode, nil
+}
+
+// forwardedSignals are passed on to recorded commands, and replayed commands
+// that were terminated by a signal run until they receive one of them.
+var forwardedSignals = []os.Signal{syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2}
+
+// replay writes the recorded output of a command. If the command was
+// terminated by a signal, the shim runs until it receives SIGTERM or SIGINT.
+func replay(recordPath string) (int, error) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, forwardedSignals...)
+	defer signal.Stop(sigs)
+
+	data, err := os.ReadFile(recordPath)
+	_ = os.Remove(recordPath)
+	if err != nil {
+		return 0, fmt.Errorf("could not read record: %w", err)
+	}
+
+	var r Record
+	if err := json.Unmarshal(data, &r); err != nil {
+		return 0, fmt.Errorf("could not parse record: %w", err)
+	}
+
+	_, _ = os.Stdout.Write(r.Stdout)
+	_, _ = os.Stderr.Write(r.Stderr)
+
+	if r.Signaled {
+		for sig := range sigs {
+			if sig == syscall.SIGTERM || sig == syscall.SIGINT {
+				break
+			}
+		}
+	}
+
+	return r.ExitCode, nil
+}
@@ -0,0 +1,105 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package config loads the picoceph configuration file.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/ceph/custom"
+	"github.com/dpeckett/picoceph/internal/ceph/osd"
+)
+
+// Config is the picoceph configuration file.
+type Config struct {
+	// Components are user defined components, that are run alongside the cluster.
+	Components []custom.Spec `json:"components,omitempty"`
+	// Caps override the capabilities of generated keyrings, keyed by
+	// component type (mgr, rgw, exporter). Each capability is a template (see ceph.Caps).
+	Caps map[string]ceph.Caps `json:"caps,omitempty"`
+	// OSDs override the options of individual OSDs, keyed by OSD id.
+	OSDs map[string]OSD `json:"osds,omitempty"`
+}
+
+// OSD are the options of an individual OSD.
+type OSD struct {
+	// SizeGiB is the size of the backing image of the OSD, in GiB (overrides
+	// --osd-size-gib).
+	SizeGiB int64 `json:"sizeGiB,omitempty"`
+	// DeviceClass is the CRUSH device class of the OSD (overrides
+	// --osd-device-class).
+	DeviceClass string `json:"deviceClass,omitempty"`
+	// Device is an existing block device to use for the OSD instead of a
+	// backing image (overrides --osd-device).
+	Device string `json:"device,omitempty"`
+}
+
+// capsComponentTypes are the component types whose caps can be overridden.
+var capsComponentTypes = []string{"mgr", "rgw", "exporter", "rbd-mirror", "cephfs-mirror", "crash"}
+
+// Load reads a JSON configuration file.
+func Load(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open config: %w", err)
+	}
+	defer f.Close()
+
+	var conf Config
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&conf); err != nil {
+		return nil, fmt.Errorf("could not parse config: %w", err)
+	}
+
+	for componentType, caps := range conf.Caps {
+		if !slices.Contains(capsComponentTypes, componentType) {
+			return nil, fmt.Errorf("caps can't be set for component type: %s", componentType)
+		}
+
+		if _, err := caps.Render(componentType+".test", "test"); err != nil {
+			return nil, err
+		}
+	}
+
+	for id, osdConf := range conf.OSDs {
+		if _, err := strconv.ParseUint(id, 10, 32); err != nil {
+			return nil, fmt.Errorf("invalid OSD id: %q", id)
+		}
+
+		if osdConf.SizeGiB < 0 || (osdConf.SizeGiB > 0 && osdConf.SizeGiB<<30 < osd.MinImageSize) {
+			return nil, fmt.Errorf("invalid size of OSD %s, must be at least %d GiB", id, osd.MinImageSize>>30)
+		}
+
+		if osdConf.DeviceClass != "" {
+			if err := osd.ValidateDeviceClass(osdConf.DeviceClass); err != nil {
+				return nil, fmt.Errorf("OSD %s: %w", id, err)
+			}
+		}
+
+		if osdConf.Device != "" && !filepath.IsAbs(osdConf.Device) {
+			return nil, fmt.Errorf("device of OSD %s must be an absolute path: %q", id, osdConf.Device)
+		}
+	}
+
+	for i := range conf.Components {
+		if err := conf.Components[i].Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &conf, nil
+}
@@ -0,0 +1,183 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/dpeckett/picoceph/internal/audit"
+	"github.com/dpeckett/picoceph/internal/command"
+)
+
+var (
+	envMu sync.Mutex
+	env   []string
+)
+
+// SetEnv sets extra environment variables for every daemon started from now on.
+func SetEnv(extra []string) {
+	envMu.Lock()
+	defer envMu.Unlock()
+
+	env = extra
+}
+
+// Daemon is a long running, foreground process (eg. ceph-mon -f). Its
+// stdout and stderr are streamed, line by line, to the logger.
+type Daemon struct {
+	logger  *slog.Logger
+	name    string
+	args    []string
+	mu      sync.Mutex
+	cmd     *exec.Cmd
+	done    chan struct{}
+	stopped bool
+}
+
+// New creates a new daemon that will run the named program with the given arguments.
+func New(logger *slog.Logger, name string, args ...string) *Daemon {
+	return &Daemon{
+		logger: logger,
+		name:   name,
+		args:   args,
+	}
+}
+
+// Run starts the daemon and waits for it to exit. A daemon that exits because
+// it was stopped (or because the context was cancelled) is not an error.
+func (d *Daemon) Run(ctx context.Context) error {
+	stdout := &lineWriter{logger: d.logger, stream: "stdout"}
+	defer stdout.Flush()
+
+	stderr := &lineWriter{logger: d.logger, stream: "stderr"}
+	defer stderr.Flush()
+
+	cmd := command.Context(ctx, d.name, d.args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	envMu.Lock()
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	envMu.Unlock()
+	// Don't hang forever if a child process inherits stdout/stderr.
+	cmd.WaitDelay = 5 * time.Second
+
+	d.mu.Lock()
+	if d.stopped {
+		d.mu.Unlock()
+		return nil
+	}
+
+	if err := cmd.Start(); err != nil {
+		d.mu.Unlock()
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	d.cmd = cmd
+	d.done = done
+	d.mu.Unlock()
+
+	audit.Record("start daemon", "command", cmd.String(), "daemonPid", cmd.Process.Pid)
+
+	if err := cmd.Wait(); err != nil {
+		d.mu.Lock()
+		stopped := d.stopped
+		d.mu.Unlock()
+
+		if stopped || ctx.Err() != nil {
+			return nil
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// Stop asks the daemon to exit by sending it SIGTERM, and waits for it to do
+// so. If the context expires before the daemon exits, it is killed.
+func (d *Daemon) Stop(ctx context.Context) error {
+	d.mu.Lock()
+	d.stopped = true
+	cmd, done := d.cmd, d.done
+	d.mu.Unlock()
+
+	// Never started.
+	if cmd == nil {
+		return nil
+	}
+
+	audit.Record("stop daemon", "command", cmd.String(), "daemonPid", cmd.Process.Pid)
+
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return fmt.Errorf("could not signal process: %w", err)
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		audit.Record("kill daemon", "command", cmd.String(), "daemonPid", cmd.Process.Pid)
+
+		_ = cmd.Process.Kill()
+		<-done
+
+		return fmt.Errorf("process did not exit in time: %w", ctx.Err())
+	}
+}
+
+// Pid returns the pid of the daemon, or zero if it isn't running.
+func (d *Daemon) Pid() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.cmd == nil {
+		return 0
+	}
+
+	select {
+	case <-d.done:
+		return 0
+	default:
+		return d.cmd.Process.Pid
+	}
+}
+
+// Signal sends a signal to the daemon (eg. SIGHUP to reopen its logs).
+func (d *Daemon) Signal(sig os.Signal) error {
+	pid := d.Pid()
+	if pid == 0 {
+		return fmt.Errorf("%s is not running", d.name)
+	}
+
+	audit.Record("signal daemon", "command", d.name, "daemonPid", pid, "signal", sig.String())
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.cmd.Process.Signal(sig); err != nil {
+		return fmt.Errorf("could not signal process: %w", err)
+	}
+
+	return nil
+}
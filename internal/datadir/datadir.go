@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package datadir relocates all of ceph's state under a single directory.
+//
+// Ceph (and in particular ceph-volume) has many hardcoded paths, so rather
+// than rewriting them, picoceph re-executes itself in a private mount
+// namespace and bind mounts the data directory over the standard locations.
+package datadir
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/dpeckett/picoceph/internal/audit"
+	"github.com/dpeckett/picoceph/internal/ceph"
+)
+
+// namespaceEnv is set once picoceph is running in its own mount namespace.
+const namespaceEnv = "PICOCEPH_MOUNT_NAMESPACE"
+
+// Enter relocates ceph's state under dataDir, eg. /etc/ceph is backed by
+// <dataDir>/etc/ceph. The first time it is called, picoceph is re-executed in
+// a private mount namespace and Enter never returns (the process exits with
+// the status of the child).
+func Enter(dataDir string) error {
+	dataDir, err := filepath.Abs(dataDir)
+	if err != nil {
+		return fmt.Errorf("could not resolve data directory: %w", err)
+	}
+
+	if os.Getenv(namespaceEnv) == "" {
+		os.Exit(reexec())
+	}
+
+	for _, dir := range ceph.Directories {
+		source := filepath.Join(dataDir, dir)
+
+		if err := ceph.MkdirAll(source); err != nil {
+			return fmt.Errorf("could not create directory: %w", err)
+		}
+
+		if err := ceph.MkdirAll(dir); err != nil {
+			return fmt.Errorf("could not create directory: %w", err)
+		}
+
+		audit.Record("bind mount", "source", source, "target", dir)
+
+		if err := syscall.Mount(source, dir, "", syscall.MS_BIND, ""); err != nil {
+			return fmt.Errorf("could not bind mount %s: %w", source, err)
+		}
+	}
+
+	return nil
+}
+
+// reexec runs picoceph again in a private mount namespace, forwarding any
+// signals to it, and returns its exit status.
+func reexec() int {
+	cmd := exec.Command("/proc/self/exe", os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), namespaceEnv+"=1")
+	// Go makes the mounts of the new namespace private, so that the bind
+	// mounts don't propagate back to the host.
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Unshareflags: syscall.CLONE_NEWNS,
+		Pdeathsig:    syscall.SIGTERM,
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(sigs)
+
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "could not create mount namespace: %v\n", err)
+		return 1
+	}
+
+	go func() {
+		for sig := range sigs {
+			_ = cmd.Process.Signal(sig)
+		}
+	}()
+
+	if err := cmd.Wait(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitCode()
+		}
+
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,218 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package diskusage monitors the disk space used by the OSD backing images, so
+// that runaway writes don't fill the host.
+package diskusage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/dpeckett/picoceph/internal/audit"
+	"github.com/dpeckett/picoceph/internal/command"
+)
+
+// DefaultDir is where the OSD backing images are kept.
+const DefaultDir = "/var/lib/ceph/disk"
+
+// Usage is the disk space used by the backing images, and by the filesystem
+// they are stored on.
+type Usage struct {
+	// Images is the space actually allocated to the backing images (which are
+	// sparse), in bytes.
+	Images int64
+	// Used is the space used on the host filesystem, in bytes.
+	Used uint64
+	// Total is the size of the host filesystem, in bytes.
+	Total uint64
+}
+
+// Percent returns how full the host filesystem is.
+func (u Usage) Percent() float64 {
+	if u.Total == 0 {
+		return 0
+	}
+
+	return float64(u.Used) / float64(u.Total) * 100
+}
+
+// Get returns the disk usage of the backing images in dir.
+func Get(dir string) (*Usage, error) {
+	var usage Usage
+
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("could not read directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		var st syscall.Stat_t
+		if err := syscall.Stat(filepath.Join(dir, entry.Name()), &st); err != nil {
+			// The image may have been removed in the meantime.
+			continue
+		}
+
+		if st.Mode&syscall.S_IFMT == syscall.S_IFREG {
+			// Blocks are always 512 bytes, regardless of the block size.
+			usage.Images += st.Blocks * 512
+		}
+	}
+
+	path := dir
+	if _, err := os.Stat(path); err != nil {
+		path = filepath.Dir(dir)
+	}
+
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return nil, fmt.Errorf("could not get filesystem usage: %w", err)
+	}
+
+	usage.Total = fs.Blocks * uint64(fs.Bsize)
+	// Space reserved for root is counted as used, as it's not available to
+	// the rest of the host.
+	usage.Used = usage.Total - fs.Bavail*uint64(fs.Bsize)
+
+	return &usage, nil
+}
+
+// Options are the options for the disk usage monitor.
+type Options struct {
+	// Dir is where the backing images are kept (defaults to DefaultDir).
+	Dir string
+	// Interval is how often disk usage is checked.
+	Interval time.Duration
+	// WarnPercent is how full the host filesystem can get before a warning is
+	// logged (zero disables).
+	WarnPercent float64
+	// MaxBytes is how large the backing images can get before client writes
+	// are paused (zero disables).
+	MaxBytes int64
+}
+
+// Monitor periodically checks the disk usage of the backing images, warns
+// before the host fills, and pauses client writes if the images exceed their
+// cap.
+type Monitor struct {
+	logger *slog.Logger
+	opts   Options
+	warned bool
+	paused bool
+}
+
+// NewMonitor creates a new disk usage monitor.
+func NewMonitor(logger *slog.Logger, opts Options) *Monitor {
+	if opts.Dir == "" {
+		opts.Dir = DefaultDir
+	}
+
+	return &Monitor{
+		logger: logger.With("component", "diskusage"),
+		opts:   opts,
+		// Lift any pause left behind by a previous run with a smaller cap.
+		paused: opts.MaxBytes > 0,
+	}
+}
+
+// Run monitors disk usage until the context is cancelled.
+func (m *Monitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		usage, err := Get(m.opts.Dir)
+		if err != nil {
+			m.logger.Warn("Could not get disk usage", "error", err)
+			continue
+		}
+
+		m.logger.Debug("Disk usage",
+			"images", usage.Images, "used", usage.Used, "total", usage.Total)
+
+		m.checkHost(usage)
+
+		checkCtx, cancel := context.WithTimeout(ctx, m.opts.Interval)
+		m.checkCap(checkCtx, usage)
+		cancel()
+	}
+}
+
+func (m *Monitor) checkHost(usage *Usage) {
+	if m.opts.WarnPercent <= 0 {
+		return
+	}
+
+	full := usage.Percent() >= m.opts.WarnPercent
+	if full && !m.warned {
+		m.logger.Warn("Host filesystem is nearly full",
+			"percent", fmt.Sprintf("%.1f", usage.Percent()), "images", usage.Images)
+	} else if !full && m.warned {
+		m.logger.Info("Host filesystem is no longer nearly full",
+			"percent", fmt.Sprintf("%.1f", usage.Percent()))
+	}
+
+	m.warned = full
+}
+
+func (m *Monitor) checkCap(ctx context.Context, usage *Usage) {
+	if m.opts.MaxBytes <= 0 {
+		return
+	}
+
+	exceeded := usage.Images >= m.opts.MaxBytes
+	if exceeded == m.paused {
+		return
+	}
+
+	if exceeded {
+		m.logger.Error("Backing images have exceeded their cap, pausing client writes",
+			"images", usage.Images, "max", m.opts.MaxBytes)
+	} else {
+		m.logger.Info("Backing images are below their cap, unpausing client writes",
+			"images", usage.Images, "max", m.opts.MaxBytes)
+	}
+
+	if err := setPaused(ctx, exceeded); err != nil {
+		// Retried on the next check.
+		m.logger.Warn("Could not change whether client writes are paused", "error", err)
+		return
+	}
+
+	m.paused = exceeded
+}
+
+// setPaused pauses (or unpauses) client writes. Reads are left alone, so that
+// clients can still get their data out of a capped cluster.
+func setPaused(ctx context.Context, paused bool) error {
+	verb := "unset"
+	if paused {
+		verb = "set"
+	}
+
+	audit.Record(verb+" osd flag", "flag", "pausewr")
+
+	if out, err := command.Context(ctx, "ceph", "osd", verb, "pausewr").CombinedOutput(); err != nil {
+		return fmt.Errorf("could not %s pausewr flag: %w: %s", verb, err, string(out))
+	}
+
+	return nil
+}
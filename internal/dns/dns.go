@@ -0,0 +1,264 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package dns serves a tiny authoritative DNS server, that resolves a domain
+// and all of its subdomains to a single address (eg. for virtual-hosted S3
+// buckets).
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/nxadm/tail"
+)
+
+const (
+	typeA    = 1
+	typeAAAA = 28
+	classIN  = 1
+
+	rcodeFormErr = 1
+	rcodeNotImp  = 4
+	rcodeRefused = 5
+
+	// ttl is short, as the address can change between runs.
+	ttl = 60
+)
+
+// Options are the options for the DNS server.
+type Options struct {
+	// Addr is the UDP address the server listens on (eg. ":5353").
+	Addr string
+	// Domain is resolved, along with all of its subdomains.
+	Domain string
+	// IP is the address the domain resolves to.
+	IP string
+}
+
+// Server is a DNS server component.
+type Server struct {
+	logger    *slog.Logger
+	opts      Options
+	conn      atomic.Pointer[net.UDPConn]
+	listening atomic.Bool
+}
+
+func New(logger *slog.Logger, opts Options) ceph.Component {
+	opts.Domain = strings.ToLower(strings.TrimSuffix(opts.Domain, "."))
+
+	return &Server{
+		logger: logger.With("component", "dns"),
+		opts:   opts,
+	}
+}
+
+func (s *Server) Name() string {
+	return "dns"
+}
+
+func (s *Server) Requires() []string {
+	return nil
+}
+
+func (s *Server) Configure(ctx context.Context) error {
+	if s.opts.Domain == "" {
+		return fmt.Errorf("no domain to serve")
+	}
+
+	if net.ParseIP(s.opts.IP) == nil {
+		return fmt.Errorf("invalid address: %s", s.opts.IP)
+	}
+
+	return nil
+}
+
+func (s *Server) Start(ctx context.Context) error {
+	addr, err := net.ResolveUDPAddr("udp", s.opts.Addr)
+	if err != nil {
+		return fmt.Errorf("invalid address: %w", err)
+	}
+
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return fmt.Errorf("could not listen: %w", err)
+	}
+	defer conn.Close()
+
+	s.conn.Store(conn)
+
+	s.logger.Info("Serving DNS", "addr", s.opts.Addr, "domain", s.opts.Domain, "ip", s.opts.IP)
+
+	s.listening.Store(true)
+	defer s.listening.Store(false)
+
+	buf := make([]byte, 512)
+	for {
+		n, remote, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+
+			return fmt.Errorf("could not read query: %w", err)
+		}
+
+		resp := s.answer(buf[:n])
+		if resp == nil {
+			continue
+		}
+
+		if _, err := conn.WriteToUDP(resp, remote); err != nil {
+			s.logger.Debug("Could not send response", "remote", remote, "error", err)
+		}
+	}
+}
+
+func (s *Server) Stop(ctx context.Context) error {
+	if conn := s.conn.Load(); conn != nil {
+		return conn.Close()
+	}
+
+	return nil
+}
+
+func (s *Server) Ready(ctx context.Context) error {
+	if !s.listening.Load() {
+		return fmt.Errorf("DNS server is not listening")
+	}
+
+	return nil
+}
+
+func (s *Server) Logs() (*tail.Tail, error) {
+	// Errors are logged directly.
+	return tail.TailFile(
+		"/dev/null",
+		tail.Config{Follow: true, ReOpen: true},
+	)
+}
+
+// answer returns the response to a query, or nil if it should be dropped.
+func (s *Server) answer(query []byte) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+
+	flags := binary.BigEndian.Uint16(query[2:4])
+	// Ignore responses.
+	if flags&0x8000 != 0 {
+		return nil
+	}
+
+	// Echo the id, opcode and recursion desired flag, and set authoritative.
+	header := make([]byte, 12)
+	copy(header[0:2], query[0:2])
+	respFlags := 0x8000 | flags&0x7800 | 0x0400 | flags&0x0100
+
+	reply := func(rcode uint16, question []byte, answers ...[]byte) []byte {
+		binary.BigEndian.PutUint16(header[2:4], respFlags|rcode)
+		if question != nil {
+			binary.BigEndian.PutUint16(header[4:6], 1)
+		}
+		binary.BigEndian.PutUint16(header[6:8], uint16(len(answers)))
+
+		resp := append(header, question...)
+		for _, a := range answers {
+			resp = append(resp, a...)
+		}
+
+		return resp
+	}
+
+	if flags&0x7800 != 0 {
+		return reply(rcodeNotImp, nil)
+	}
+
+	if binary.BigEndian.Uint16(query[4:6]) != 1 {
+		return reply(rcodeFormErr, nil)
+	}
+
+	name, end, ok := parseName(query, 12)
+	if !ok || end+4 > len(query) {
+		return reply(rcodeFormErr, nil)
+	}
+
+	question := query[12 : end+4]
+	qtype := binary.BigEndian.Uint16(query[end : end+2])
+	qclass := binary.BigEndian.Uint16(query[end+2 : end+4])
+
+	if name != s.opts.Domain && !strings.HasSuffix(name, "."+s.opts.Domain) {
+		return reply(rcodeRefused, question)
+	}
+
+	ip := net.ParseIP(s.opts.IP)
+	var rdata []byte
+	switch {
+	case qclass != classIN:
+	case qtype == typeA && ip.To4() != nil:
+		rdata = ip.To4()
+	case qtype == typeAAAA && ip.To4() == nil:
+		rdata = ip.To16()
+	}
+
+	if rdata == nil {
+		// The name exists, but has no records of this type.
+		return reply(0, question)
+	}
+
+	// The name is a pointer to the question.
+	answer := []byte{0xc0, 12}
+	answer = binary.BigEndian.AppendUint16(answer, qtype)
+	answer = binary.BigEndian.AppendUint16(answer, classIN)
+	answer = binary.BigEndian.AppendUint32(answer, ttl)
+	answer = binary.BigEndian.AppendUint16(answer, uint16(len(rdata)))
+	answer = append(answer, rdata...)
+
+	return reply(0, question, answer)
+}
+
+// parseName parses an uncompressed domain name at offset, and returns it in
+// lower case along with the offset of the end of the name.
+func parseName(msg []byte, offset int) (string, int, bool) {
+	start := offset
+
+	var labels []string
+	for {
+		if offset >= len(msg) {
+			return "", 0, false
+		}
+
+		length := int(msg[offset])
+		offset++
+
+		if length == 0 {
+			// Names are at most 255 octets long, including the length octets.
+			if offset-start > 255 {
+				return "", 0, false
+			}
+
+			return strings.ToLower(strings.Join(labels, ".")), offset, true
+		}
+
+		// Questions are never compressed.
+		if length > 63 || offset+length > len(msg) {
+			return "", 0, false
+		}
+
+		labels = append(labels, string(msg[offset:offset+length]))
+		offset += length
+	}
+}
@@ -0,0 +1,191 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package dns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// query builds a standard query with a single question.
+func query(name string, qtype, qclass uint16) []byte {
+	msg := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	for _, label := range strings.Split(name, ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	msg = binary.BigEndian.AppendUint16(msg, qclass)
+
+	return msg
+}
+
+func newTestServer() *Server {
+	return New(slog.New(slog.NewTextHandler(io.Discard, nil)), Options{
+		Domain: "S3.Test.",
+		IP:     "10.0.0.1",
+	}).(*Server)
+}
+
+func TestAnswer(t *testing.T) {
+	s := newTestServer()
+
+	// header returns a query with its header modified.
+	header := func(offset int, value uint16) []byte {
+		msg := query("s3.test", typeA, classIN)
+		binary.BigEndian.PutUint16(msg[offset:], value)
+		return msg
+	}
+
+	valid := query("s3.test", typeA, classIN)
+
+	for _, tc := range []struct {
+		name    string
+		query   []byte
+		dropped bool
+		rcode   uint16
+		answers uint16
+	}{
+		{name: "domain", query: valid, answers: 1},
+		{name: "subdomain", query: query("bucket.S3.test", typeA, classIN), answers: 1},
+		{name: "aaaa", query: query("s3.test", typeAAAA, classIN)},
+		{name: "unknown qtype", query: query("s3.test", 16, classIN)},
+		{name: "any qtype", query: query("s3.test", 255, classIN)},
+		{name: "chaos class", query: query("s3.test", typeA, 3)},
+		{name: "other domain", query: query("example.com", typeA, classIN), rcode: rcodeRefused},
+		{name: "domain suffix", query: query("evils3.test", typeA, classIN), rcode: rcodeRefused},
+		{name: "empty", query: nil, dropped: true},
+		{name: "short header", query: valid[:11], dropped: true},
+		{name: "response", query: header(2, 0x8100), dropped: true},
+		{name: "opcode", query: header(2, 0x1100), rcode: rcodeNotImp},
+		{name: "no questions", query: header(4, 0), rcode: rcodeFormErr},
+		{name: "two questions", query: header(4, 2), rcode: rcodeFormErr},
+		{name: "header only", query: valid[:12], rcode: rcodeFormErr},
+		{name: "truncated label", query: valid[:15], rcode: rcodeFormErr},
+		{name: "unterminated name", query: valid[:20], rcode: rcodeFormErr},
+		{name: "truncated qtype", query: valid[:22], rcode: rcodeFormErr},
+		{name: "truncated qclass", query: valid[:len(valid)-1], rcode: rcodeFormErr},
+		{
+			name:  "compression pointer loop",
+			query: append(append([]byte{}, valid[:12]...), 0xc0, 12, 0, 1, 0, 1),
+			rcode: rcodeFormErr,
+		},
+		{
+			name:  "compression pointer after label",
+			query: append(append([]byte{}, valid[:12]...), 2, 's', '3', 0xc0, 12, 0, 1, 0, 1),
+			rcode: rcodeFormErr,
+		},
+		{
+			name:  "oversized label",
+			query: query(strings.Repeat("a", 64)+".s3.test", typeA, classIN),
+			rcode: rcodeFormErr,
+		},
+		{
+			name:    "longest label",
+			query:   query(strings.Repeat("a", 63)+".s3.test", typeA, classIN),
+			answers: 1,
+		},
+		{
+			name:  "oversized name",
+			query: query(strings.Repeat(strings.Repeat("a", 63)+".", 4)+"s3.test", typeA, classIN),
+			rcode: rcodeFormErr,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := s.answer(tc.query)
+			if tc.dropped {
+				if resp != nil {
+					t.Fatalf("expected the query to be dropped, got %x", resp)
+				}
+				return
+			}
+
+			if len(resp) < 12 {
+				t.Fatalf("got short response %x", resp)
+			}
+
+			if !bytes.Equal(resp[0:2], tc.query[0:2]) {
+				t.Fatalf("got id %x", resp[0:2])
+			}
+
+			flags := binary.BigEndian.Uint16(resp[2:4])
+			if flags&0x8000 == 0 {
+				t.Fatal("response flag is not set")
+			}
+
+			if rcode := flags & 0x000f; rcode != tc.rcode {
+				t.Fatalf("got rcode %d, want %d", rcode, tc.rcode)
+			}
+
+			if answers := binary.BigEndian.Uint16(resp[6:8]); answers != tc.answers {
+				t.Fatalf("got %d answers, want %d", answers, tc.answers)
+			}
+
+			if tc.answers > 0 && !bytes.Equal(resp[len(resp)-4:], []byte{10, 0, 0, 1}) {
+				t.Fatalf("got address %v", resp[len(resp)-4:])
+			}
+		})
+	}
+}
+
+func TestAnswerGarbage(t *testing.T) {
+	s := newTestServer()
+	valid := query("bucket.s3.test", typeA, classIN)
+
+	// Every truncation of a valid query.
+	for n := range valid {
+		_ = s.answer(valid[:n])
+	}
+
+	// Random corruptions of a valid query, and random messages.
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		msg := append([]byte{}, valid...)
+		for j := r.Intn(4); j >= 0; j-- {
+			msg[r.Intn(len(msg))] = byte(r.Intn(256))
+		}
+		_ = s.answer(msg)
+
+		msg = make([]byte, r.Intn(64))
+		r.Read(msg)
+		_ = s.answer(msg)
+	}
+}
+
+func TestParseName(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		msg  []byte
+		want string
+		end  int
+		ok   bool
+	}{
+		{name: "root", msg: []byte{0}, end: 1, ok: true},
+		{name: "lower case", msg: []byte{2, 'S', '3', 4, 'T', 'e', 's', 't', 0}, want: "s3.test", end: 9, ok: true},
+		{name: "empty", msg: nil},
+		{name: "truncated label", msg: []byte{4, 't', 'e'}},
+		{name: "unterminated", msg: []byte{2, 's', '3'}},
+		{name: "compression pointer", msg: []byte{0xc0, 0}},
+		{name: "reserved label type", msg: []byte{0x40, 0}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			name, end, ok := parseName(tc.msg, 0)
+			if ok != tc.ok || name != tc.want || end != tc.end {
+				t.Fatalf("got (%q, %d, %v), want (%q, %d, %v)", name, end, ok, tc.want, tc.end, tc.ok)
+			}
+		})
+	}
+}
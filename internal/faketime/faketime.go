@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package faketime runs daemons under libfaketime, so that clients can be
+// tested against a cluster whose clock is skewed.
+package faketime
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+)
+
+// TimestampPath is where the fake time is written, it is re-read by the
+// daemons so the time can be changed while they are running.
+const TimestampPath = "/run/picoceph-faketime"
+
+// libraryPaths are where distributions install libfaketime.
+var libraryPaths = []string{
+	"/usr/lib64/faketime/libfaketime.so.1",
+	"/usr/lib/x86_64-linux-gnu/faketime/libfaketime.so.1",
+	"/usr/lib/aarch64-linux-gnu/faketime/libfaketime.so.1",
+	"/usr/lib/faketime/libfaketime.so.1",
+	"/usr/local/lib/faketime/libfaketime.so.1",
+}
+
+// specRegexp matches the libfaketime time specifications that picoceph
+// supports: a relative offset (eg. "+2d" or "-90m"), or an absolute start
+// time (eg. "@2030-01-01 00:00:00").
+var specRegexp = regexp.MustCompile(`^([+-]\d+(\.\d+)?[smhdy]?|@\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2})$`)
+
+// Library returns the path of libfaketime.
+func Library() (string, error) {
+	for _, path := range libraryPaths {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+
+	return "", errors.New("libfaketime is not installed")
+}
+
+// Clock is the fake clock of the daemons.
+type Clock struct {
+	mu      sync.Mutex
+	library string
+	spec    string
+}
+
+// New creates a fake clock with the given time specification.
+func New(spec string) (*Clock, error) {
+	library, err := Library()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Clock{library: library}
+	if err := c.Set(spec); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Spec returns the current time specification.
+func (c *Clock) Spec() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.spec
+}
+
+// Set changes the time specification, running daemons pick it up within a
+// second.
+func (c *Clock) Set(spec string) error {
+	if !specRegexp.MatchString(spec) {
+		return fmt.Errorf("invalid time specification: %q", spec)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := os.WriteFile(TimestampPath, []byte(spec+"\n"), 0o644); err != nil {
+		return fmt.Errorf("could not write fake time: %w", err)
+	}
+
+	c.spec = spec
+
+	return nil
+}
+
+// Env returns the environment variables that run a program under the clock.
+func (c *Clock) Env() []string {
+	return []string{
+		"LD_PRELOAD=" + c.library,
+		"FAKETIME_TIMESTAMP_FILE=" + TimestampPath,
+		"FAKETIME_CACHE_DURATION=1",
+		// Ceph's internal timers rely on the monotonic clock.
+		"FAKETIME_DONT_FAKE_MONOTONIC=1",
+	}
+}
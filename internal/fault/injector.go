@@ -0,0 +1,183 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package fault
+
+import (
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Injector injects faults into requests according to a policy, which can be
+// changed at runtime.
+type Injector struct {
+	mu        sync.Mutex
+	policy    Policy
+	rand      *rand.Rand
+	remaining int
+	injected  int
+}
+
+// NewInjector creates an injector with an initial policy.
+func NewInjector(policy Policy) (*Injector, error) {
+	i := &Injector{}
+	if err := i.SetPolicy(policy); err != nil {
+		return nil, err
+	}
+
+	return i, nil
+}
+
+// Policy returns the current policy.
+func (i *Injector) Policy() Policy {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.policy
+}
+
+// SetPolicy replaces the current policy.
+func (i *Injector) SetPolicy(policy Policy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	seed := policy.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.policy = policy
+	i.rand = rand.New(rand.NewSource(seed))
+	i.remaining = policy.Count
+	i.injected = 0
+
+	return nil
+}
+
+// Injected returns how many requests have been faulted under the current policy.
+func (i *Injector) Injected() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.injected
+}
+
+// next decides the fault for the next request.
+func (i *Injector) next() (Policy, Action) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	p := i.policy
+	if p.Action == ActionNone {
+		return p, ActionNone
+	}
+
+	if p.Count > 0 && i.remaining == 0 {
+		return p, ActionNone
+	}
+
+	if p.Percent > 0 && i.rand.Float64()*100 >= p.Percent {
+		return p, ActionNone
+	}
+
+	if p.Count > 0 {
+		i.remaining--
+	}
+	i.injected++
+
+	return p, p.Action
+}
+
+// Handler wraps a handler with fault injection.
+func (i *Injector) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, action := i.next()
+
+		if p.Latency > 0 {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(time.Duration(p.Latency)):
+			}
+		}
+
+		switch action {
+		case ActionError:
+			status := p.Status
+			if status == 0 {
+				status = http.StatusServiceUnavailable
+			}
+
+			http.Error(w, http.StatusText(status), status)
+		case ActionDrop:
+			abort(w)
+		case ActionTruncate:
+			tw := &truncatingWriter{ResponseWriter: w, remaining: p.TruncateBytes}
+			next.ServeHTTP(tw, r)
+
+			if tw.truncated {
+				// Make sure the partial body reaches the client first.
+				if f, ok := w.(http.Flusher); ok {
+					f.Flush()
+				}
+
+				abort(w)
+			}
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// abort closes the connection of a request without completing the response,
+// as if the network failed.
+func abort(w http.ResponseWriter) {
+	if hj, ok := w.(http.Hijacker); ok {
+		if conn, _, err := hj.Hijack(); err == nil {
+			_ = conn.Close()
+			return
+		}
+	}
+
+	// Eg. HTTP/2 connections, and responses that have already been flushed.
+	panic(http.ErrAbortHandler)
+}
+
+// truncatingWriter lets through the first bytes of a response body, and
+// discards the rest.
+type truncatingWriter struct {
+	http.ResponseWriter
+	remaining int64
+	truncated bool
+}
+
+func (w *truncatingWriter) Write(b []byte) (int, error) {
+	if int64(len(b)) <= w.remaining {
+		w.remaining -= int64(len(b))
+		return w.ResponseWriter.Write(b)
+	}
+
+	w.truncated = true
+
+	if w.remaining > 0 {
+		if _, err := w.ResponseWriter.Write(b[:w.remaining]); err != nil {
+			return 0, err
+		}
+		w.remaining = 0
+	}
+
+	// Pretend the write succeeded, so the handler carries on.
+	return len(b), nil
+}
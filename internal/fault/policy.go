@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package fault injects faults into HTTP requests, for testing how clients
+// handle a slow or unreliable server.
+package fault
+
+import (
+	"fmt"
+	"time"
+)
+
+// Action is what is done to a faulted request.
+type Action string
+
+const (
+	// ActionNone only adds latency.
+	ActionNone Action = ""
+	// ActionError responds with an error status.
+	ActionError Action = "error"
+	// ActionDrop closes the connection without a response.
+	ActionDrop Action = "drop"
+	// ActionTruncate closes the connection part way through the response body.
+	ActionTruncate Action = "truncate"
+)
+
+// Policy describes the faults to inject.
+type Policy struct {
+	// Latency is added to every request.
+	Latency Duration `json:"latency,omitempty"`
+	// Action is what is done to faulted requests.
+	Action Action `json:"action,omitempty"`
+	// Percent is the percentage of requests that are faulted (zero means
+	// every request).
+	Percent float64 `json:"percent,omitempty"`
+	// Status is the status of ActionError responses (defaults to 503).
+	Status int `json:"status,omitempty"`
+	// TruncateBytes is how much of the response body ActionTruncate lets
+	// through.
+	TruncateBytes int64 `json:"truncateBytes,omitempty"`
+	// Count limits the policy to the next Count faulted requests, after which
+	// no more faults are injected (zero means no limit).
+	Count int `json:"count,omitempty"`
+	// Seed seeds the choice of faulted requests, so that a run can be
+	// reproduced (zero picks a random seed).
+	Seed int64 `json:"seed,omitempty"`
+}
+
+// Validate checks that the policy is valid.
+func (p Policy) Validate() error {
+	switch p.Action {
+	case ActionNone, ActionError, ActionDrop, ActionTruncate:
+	default:
+		return fmt.Errorf("unknown action: %s", p.Action)
+	}
+
+	if p.Percent < 0 || p.Percent > 100 {
+		return fmt.Errorf("percent must be between 0 and 100: %v", p.Percent)
+	}
+
+	if p.Status != 0 && (p.Status < 100 || p.Status > 599) {
+		return fmt.Errorf("invalid status: %d", p.Status)
+	}
+
+	if p.Latency < 0 || p.TruncateBytes < 0 || p.Count < 0 {
+		return fmt.Errorf("latency, truncateBytes and count must not be negative")
+	}
+
+	return nil
+}
+
+// Duration is a time.Duration that is encoded as a string (eg. "250ms").
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	duration, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(duration)
+
+	return nil
+}
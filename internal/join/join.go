@@ -0,0 +1,229 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package join adds the OSD of one picoceph instance to the cluster of
+// another, using only the monitor addresses and the client.bootstrap-osd key of
+// that cluster.
+package join
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/ceph/auth"
+	"github.com/dpeckett/picoceph/internal/ceph/monmap"
+	"github.com/dpeckett/picoceph/internal/command"
+	"github.com/dpeckett/picoceph/internal/keyring"
+	"github.com/dpeckett/picoceph/internal/tempfile"
+	"github.com/dpeckett/picoceph/internal/util"
+)
+
+// DefaultOSDID is the id of a joined OSD, the cluster being joined already has
+// osd.0.
+const DefaultOSDID = "1"
+
+// Options are the options for joining a cluster.
+type Options struct {
+	// MonHosts are the addresses of the monitors of the cluster, either "host"
+	// or "host:port" where port is the msgr2 port.
+	MonHosts []string
+	// OSDID is the id of the OSD to add to the cluster.
+	OSDID string
+}
+
+// Parse parses a join spec, eg. "mon-host=10.0.0.2,10.0.0.3:3301 osd-id=2".
+func Parse(s string) (*Options, error) {
+	opts := Options{OSDID: DefaultOSDID}
+	for _, field := range strings.Fields(s) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid join option: %s", field)
+		}
+
+		switch key {
+		case "mon-host":
+			opts.MonHosts = strings.Split(value, ",")
+		case "osd-id":
+			if id, err := strconv.Atoi(value); err != nil || id < 0 {
+				return nil, fmt.Errorf("invalid osd id: %s", value)
+			}
+
+			opts.OSDID = value
+		default:
+			return nil, fmt.Errorf("unknown join option: %s", key)
+		}
+	}
+
+	if len(opts.MonHosts) == 0 {
+		return nil, fmt.Errorf("join spec is missing mon-host")
+	}
+
+	return &opts, nil
+}
+
+// MonMap returns a monmap of the monitors being joined. The ids of the
+// monitors are only used locally, so they needn't match the cluster.
+func (o *Options) MonMap(ctx context.Context, fsid string) (*monmap.MonMap, error) {
+	monMap := monmap.New(fsid)
+	for i, monHost := range o.MonHosts {
+		host, port := monHost, monmap.DefaultV2Port
+		if h, p, err := net.SplitHostPort(monHost); err == nil {
+			host = h
+			if port, err = strconv.Atoi(p); err != nil {
+				return nil, fmt.Errorf("invalid monitor port: %s", p)
+			}
+		}
+
+		addr := host
+		if net.ParseIP(host) == nil {
+			ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+			if err != nil || len(ips) == 0 {
+				return nil, fmt.Errorf("could not resolve monitor %s: %w", host, err)
+			}
+
+			addr = ips[0].String()
+		}
+
+		// picoceph counts the ports of additional monitors up from the defaults.
+		v1Port := monmap.DefaultV1Port + port - monmap.DefaultV2Port
+		if err := monMap.AddWithPorts(string(rune('a'+i)), addr, port, v1Port); err != nil {
+			return nil, err
+		}
+	}
+
+	return monMap, nil
+}
+
+// CheckKey checks that key looks like a Ceph key.
+func CheckKey(key string) error {
+	if _, err := base64.StdEncoding.DecodeString(key); err != nil || key == "" {
+		return fmt.Errorf("invalid bootstrap-osd key")
+	}
+
+	return nil
+}
+
+// WriteKeyring writes the client.bootstrap-osd keyring of the cluster being
+// joined, which ceph-volume uses to create the OSD.
+func WriteKeyring(key string) error {
+	if err := CheckKey(key); err != nil {
+		return err
+	}
+
+	if err := ceph.MkdirAll(filepath.Dir(auth.BootstrapOSDKeyringPath)); err != nil {
+		return fmt.Errorf("could not create directory: %w", err)
+	}
+
+	entry := keyring.Entry{
+		Name: "client.bootstrap-osd",
+		Key:  key,
+		Caps: map[string]string{
+			"mon": "profile bootstrap-osd",
+			"mgr": "allow r",
+		},
+	}
+
+	if err := (keyring.Keyring{entry}).WriteFile(auth.BootstrapOSDKeyringPath); err != nil {
+		return fmt.Errorf("could not write keyring: %w", err)
+	}
+
+	uid, gid, err := ceph.User()
+	if err != nil {
+		return fmt.Errorf("could not get ceph user: %w", err)
+	}
+
+	if err := util.Chown(auth.BootstrapOSDKeyringPath, uid, gid); err != nil {
+		return fmt.Errorf("could not change owner: %w", err)
+	}
+
+	return nil
+}
+
+// FSID asks the monitors being joined for the fsid of their cluster, waiting
+// for them to come up.
+func (o *Options) FSID(ctx context.Context, key string) (string, error) {
+	monMap, err := o.MonMap(ctx, "")
+	if err != nil {
+		return "", err
+	}
+
+	// There is no ceph.conf or keyring yet, so use a temporary keyring (rather
+	// than passing the key on the command line, where anyone could read it).
+	tmpDir, err := tempfile.MkdirPrivate("picoceph-join-")
+	if err != nil {
+		return "", err
+	}
+	defer tmpDir.Remove()
+
+	keyringPath := tmpDir.Path("keyring")
+	if err := (keyring.Keyring{{Name: "client.bootstrap-osd", Key: key}}).WriteFile(keyringPath); err != nil {
+		return "", fmt.Errorf("could not create keyring: %w", err)
+	}
+
+	// Don't block forever if the monitors do not come up.
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		cmd := command.Context(ctx, "ceph", "--conf", "/dev/null", "--mon-host", monMap.Hosts(),
+			"--name", "client.bootstrap-osd", "--keyring", keyringPath,
+			"--connect-timeout", "10", "fsid", "--format=json")
+
+		var stderr strings.Builder
+		cmd.Stderr = &stderr
+
+		out, err := cmd.Output()
+		if err == nil {
+			var fsid struct {
+				FSID string `json:"fsid"`
+			}
+
+			if err := json.Unmarshal(out, &fsid); err != nil {
+				return "", fmt.Errorf("could not parse fsid: %w", err)
+			}
+
+			return fsid.FSID, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("could not get fsid from monitors: %w: %s", err, stderr.String())
+		case <-ticker.C:
+		}
+	}
+}
+
+// PublicNetwork returns the network the OSD should listen on, the /32 of the
+// local address that the monitors are reached from.
+func PublicNetwork(monMap *monmap.MonMap) (string, error) {
+	if len(monMap.Monitors) == 0 {
+		return "", fmt.Errorf("no monitors to join")
+	}
+
+	// Nothing is sent, dialing UDP just picks the route.
+	mon := monMap.Monitors[0]
+	conn, err := net.Dial("udp", net.JoinHostPort(mon.Addr, strconv.Itoa(mon.V2Port)))
+	if err != nil {
+		return "", fmt.Errorf("could not find route to monitor %s: %w", mon.ID, err)
+	}
+	defer conn.Close()
+
+	return util.HostNetwork(conn.LocalAddr().(*net.UDPAddr).IP), nil
+}
@@ -0,0 +1,127 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package k8s derives the identity of picoceph from the pod it is running in,
+// when it is run as a Kubernetes StatefulSet.
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// podNameRegexp matches the hostname of a StatefulSet pod, eg. "picoceph-2".
+var podNameRegexp = regexp.MustCompile(`^(.+)-(\d+)$`)
+
+// Pod is the identity of a StatefulSet pod.
+type Pod struct {
+	// StatefulSet is the name of the StatefulSet.
+	StatefulSet string
+	// Ordinal is the index of the pod in the StatefulSet.
+	Ordinal int
+	// Service is the name of the headless service that governs the
+	// StatefulSet (defaults to the name of the StatefulSet).
+	Service string
+}
+
+// CurrentPod returns the identity of the pod picoceph is running in, from its
+// hostname.
+func CurrentPod(service string) (*Pod, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("could not get hostname: %w", err)
+	}
+
+	return ParsePod(hostname, service)
+}
+
+// ParsePod parses the hostname of a StatefulSet pod (eg. "picoceph-2").
+func ParsePod(hostname, service string) (*Pod, error) {
+	m := podNameRegexp.FindStringSubmatch(hostname)
+	if m == nil {
+		return nil, fmt.Errorf("hostname is not that of a StatefulSet pod: %s", hostname)
+	}
+
+	ordinal, err := strconv.Atoi(m[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid pod ordinal: %s", m[2])
+	}
+
+	if service == "" {
+		service = m[1]
+	}
+
+	return &Pod{StatefulSet: m[1], Ordinal: ordinal, Service: service}, nil
+}
+
+// Name returns the name (and hostname) of the pod.
+func (p Pod) Name() string {
+	return p.Peer(p.Ordinal)
+}
+
+// Peer returns the name of the pod with the given ordinal.
+func (p Pod) Peer(ordinal int) string {
+	return p.StatefulSet + "-" + strconv.Itoa(ordinal)
+}
+
+// PeerDNSName returns the name the pod with the given ordinal is resolvable as
+// through the headless service (relying on the namespace being in the DNS
+// search path).
+func (p Pod) PeerDNSName(ordinal int) string {
+	return p.Peer(ordinal) + "." + p.Service
+}
+
+// ResolvePeers resolves the IPv4 addresses of the pods with ordinals up to
+// count. The DNS records of a pod only exist once it has started (or is
+// published while not ready), so it retries until every pod has resolved or
+// the context is cancelled.
+func (p Pod) ResolvePeers(ctx context.Context, count int) ([]string, error) {
+	addrs := make([]string, count)
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		var missing string
+		for i := range addrs {
+			if addrs[i] != "" {
+				continue
+			}
+
+			// Our own address is available even without DNS.
+			if i == p.Ordinal && os.Getenv("POD_IP") != "" {
+				addrs[i] = os.Getenv("POD_IP")
+				continue
+			}
+
+			ips, err := net.DefaultResolver.LookupIP(ctx, "ip4", p.PeerDNSName(i))
+			if err != nil || len(ips) == 0 {
+				missing = p.PeerDNSName(i)
+				continue
+			}
+
+			addrs[i] = ips[0].String()
+		}
+
+		if missing == "" {
+			return addrs, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("could not resolve %s: %w", missing, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
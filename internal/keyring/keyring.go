@@ -0,0 +1,202 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package keyring reads, writes and generates Ceph keyrings without
+// depending upon ceph-authtool.
+package keyring
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dpeckett/picoceph/internal/seed"
+)
+
+// cryptoAES is the CEPH_CRYPTO_AES key type.
+const cryptoAES = 1
+
+// Entry is a single entity in a keyring.
+type Entry struct {
+	// Name is the name of the entity eg. "client.admin".
+	Name string
+	// Key is the base64 encoded secret key of the entity.
+	Key string
+	// Caps maps a daemon type (eg. "mon") to the capabilities granted for it.
+	Caps map[string]string
+}
+
+// Keyring is a collection of keyring entries.
+type Keyring []Entry
+
+// GenerateKey generates a new random secret key, encoded in the same format
+// as ceph-authtool --gen-key.
+func GenerateKey() (string, error) {
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("could not generate secret: %w", err)
+	}
+
+	return EncodeKey(secret, time.Now())
+}
+
+// EncodeKey encodes a secret and its creation time as a Ceph key.
+func EncodeKey(secret []byte, created time.Time) (string, error) {
+	var buf bytes.Buffer
+	for _, v := range []any{
+		uint16(cryptoAES),
+		uint32(created.Unix()),
+		uint32(created.Nanosecond()),
+		uint16(len(secret)),
+		secret,
+	} {
+		if err := binary.Write(&buf, binary.LittleEndian, v); err != nil {
+			return "", fmt.Errorf("could not encode key: %w", err)
+		}
+	}
+
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// seededKeyCreated is the creation time of keys derived from a seed.
+var seededKeyCreated = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// DeriveKey derives the key of the named entity from the seed.
+func DeriveKey(name string) (string, error) {
+	return EncodeKey(seed.Bytes("keyring/"+name, 16), seededKeyCreated)
+}
+
+// NewEntry creates a new keyring entry with a freshly generated key (or a key
+// derived from the seed, if one has been set).
+func NewEntry(name string, caps map[string]string) (*Entry, error) {
+	var key string
+	var err error
+	if seed.Enabled() {
+		key, err = DeriveKey(name)
+	} else {
+		key, err = GenerateKey()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Entry{
+		Name: name,
+		Key:  key,
+		Caps: caps,
+	}, nil
+}
+
+// Get returns the entry for the named entity.
+func (k Keyring) Get(name string) (*Entry, bool) {
+	for i := range k {
+		if k[i].Name == name {
+			return &k[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// Marshal encodes the keyring in the ini style format used by Ceph.
+func (k Keyring) Marshal() []byte {
+	var buf bytes.Buffer
+	for _, entry := range k {
+		fmt.Fprintf(&buf, "[%s]\n", entry.Name)
+		fmt.Fprintf(&buf, "\tkey = %s\n", entry.Key)
+
+		daemonTypes := make([]string, 0, len(entry.Caps))
+		for daemonType := range entry.Caps {
+			daemonTypes = append(daemonTypes, daemonType)
+		}
+		sort.Strings(daemonTypes)
+
+		for _, daemonType := range daemonTypes {
+			fmt.Fprintf(&buf, "\tcaps %s = %q\n", daemonType, entry.Caps[daemonType])
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// Unmarshal decodes a keyring in the ini style format used by Ceph.
+func Unmarshal(data []byte) (Keyring, error) {
+	var k Keyring
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			k = append(k, Entry{
+				Name: strings.TrimSuffix(strings.TrimPrefix(line, "["), "]"),
+				Caps: map[string]string{},
+			})
+			continue
+		}
+
+		if len(k) == 0 {
+			return nil, fmt.Errorf("line %d: entry outside of section", lineNumber)
+		}
+
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNumber)
+		}
+		name = strings.TrimSpace(name)
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+
+		entry := &k[len(k)-1]
+		if name == "key" {
+			entry.Key = value
+		} else if daemonType, ok := strings.CutPrefix(name, "caps "); ok {
+			entry.Caps[strings.TrimSpace(daemonType)] = value
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// ReadFile reads a keyring from a file.
+func ReadFile(path string) (Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read keyring: %w", err)
+	}
+
+	k, err := Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse keyring %s: %w", path, err)
+	}
+
+	return k, nil
+}
+
+// WriteFile writes the keyring to a file that is only readable by its owner.
+func (k Keyring) WriteFile(path string) error {
+	if err := os.WriteFile(path, k.Marshal(), 0o600); err != nil {
+		return fmt.Errorf("could not write keyring: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package keyring
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// authtoolKeyring is a keyring as written by ceph-authtool.
+const authtoolKeyring = `[client.admin]
+	key = AQBvaBFZAAAAABAA9VHgwCg3rWn8fMaX8KL01A==
+	caps mds = "allow *"
+	caps mgr = "allow *"
+	caps mon = "allow *"
+	caps osd = "allow *"
+[client.bootstrap-osd]
+	key = AQBvaBFZAAAAABAA9VHgwCg3rWn8fMaX8KL01A==
+	caps mon = "profile bootstrap-osd"
+`
+
+func TestUnmarshalAuthtool(t *testing.T) {
+	k, err := Unmarshal([]byte(authtoolKeyring))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Keyring{
+		{
+			Name: "client.admin",
+			Key:  "AQBvaBFZAAAAABAA9VHgwCg3rWn8fMaX8KL01A==",
+			Caps: map[string]string{"mds": "allow *", "mgr": "allow *", "mon": "allow *", "osd": "allow *"},
+		},
+		{
+			Name: "client.bootstrap-osd",
+			Key:  "AQBvaBFZAAAAABAA9VHgwCg3rWn8fMaX8KL01A==",
+			Caps: map[string]string{"mon": "profile bootstrap-osd"},
+		},
+	}
+	if !reflect.DeepEqual(k, want) {
+		t.Fatalf("got %+v, want %+v", k, want)
+	}
+
+	// Caps are written in sorted order, as ceph-authtool does.
+	if got := string(k.Marshal()); got != authtoolKeyring {
+		t.Fatalf("marshalled keyring differs from ceph-authtool:\n%s", got)
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := Keyring{
+		{Name: "mon.", Key: key, Caps: map[string]string{"mon": "allow *"}},
+		{Name: "client.rgw", Key: key, Caps: map[string]string{"mon": "allow rw", "osd": "allow rwx pool=default.rgw.meta, allow rwx"}},
+		{Name: "client.nocaps", Key: key, Caps: map[string]string{}},
+	}
+
+	got, err := Unmarshal(k.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, k) {
+		t.Fatalf("got %+v, want %+v", got, k)
+	}
+
+	if entry, ok := got.Get("client.rgw"); !ok || entry.Key != key {
+		t.Fatalf("could not get client.rgw: %+v", entry)
+	}
+}
+
+func TestUnmarshalInvalid(t *testing.T) {
+	for _, data := range []string{
+		"key = AQBvaBFZAAAAABAA9VHgwCg3rWn8fMaX8KL01A==\n",
+		"[client.admin]\n\tkey\n",
+	} {
+		if _, err := Unmarshal([]byte(data)); err == nil {
+			t.Errorf("expected an error for %q", data)
+		}
+	}
+}
+
+func TestEncodeKey(t *testing.T) {
+	// Decode a key generated by ceph-authtool, and encode it again.
+	raw, err := base64.StdEncoding.DecodeString("AQBvaBFZAAAAABAA9VHgwCg3rWn8fMaX8KL01A==")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := time.Unix(int64(binary.LittleEndian.Uint32(raw[2:6])), int64(binary.LittleEndian.Uint32(raw[6:10])))
+
+	key, err := EncodeKey(raw[12:], created)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key != "AQBvaBFZAAAAABAA9VHgwCg3rWn8fMaX8KL01A==" {
+		t.Fatalf("got %s", key)
+	}
+}
+
+func TestGenerateKey(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// type (2) + created (8) + length (2) + secret (16)
+	if len(raw) != 28 {
+		t.Fatalf("got a %d byte key", len(raw))
+	}
+
+	if keyType := binary.LittleEndian.Uint16(raw[0:2]); keyType != cryptoAES {
+		t.Fatalf("got key type %d", keyType)
+	}
+
+	if secretLen := binary.LittleEndian.Uint16(raw[10:12]); secretLen != 16 {
+		t.Fatalf("got secret length %d", secretLen)
+	}
+}
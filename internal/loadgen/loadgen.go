@@ -0,0 +1,262 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package loadgen continuously writes and reads objects, to give monitoring
+// dashboards something to show and keep the cluster warm during demos.
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"math"
+	mathrand "math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/ceph/radosgw"
+	"github.com/dpeckett/picoceph/internal/command"
+	"github.com/nxadm/tail"
+)
+
+const (
+	// Bucket is the bucket objects are written to over S3.
+	Bucket = "loadgen"
+	// Pool is the pool objects are written to over RADOS.
+	Pool = "loadgen"
+	// reportInterval is how often the number of operations is logged.
+	reportInterval = time.Minute
+)
+
+// Target is the interface load is generated through.
+type Target string
+
+const (
+	// TargetS3 writes objects through RGW, as the default S3 user.
+	TargetS3 Target = "s3"
+	// TargetRADOS writes objects directly to a pool.
+	TargetRADOS Target = "rados"
+)
+
+// ParseTarget parses the name of a load generator target.
+func ParseTarget(s string) (Target, error) {
+	switch t := Target(s); t {
+	case TargetS3, TargetRADOS:
+		return t, nil
+	default:
+		return "", fmt.Errorf("unknown load generator target: %s", s)
+	}
+}
+
+// Options are the options for the load generator.
+type Options struct {
+	Target Target
+	// ObjectSize is the size of each object in bytes.
+	ObjectSize int64
+	// Rate is the number of operations per second.
+	Rate float64
+	// ReadPercent is the percentage of operations that are reads.
+	ReadPercent int
+	// Objects is the number of distinct objects, which are overwritten in turn
+	// so that the cluster doesn't fill up.
+	Objects int
+}
+
+// LoadGen is the load generator component.
+type LoadGen struct {
+	logger  *slog.Logger
+	opts    Options
+	running atomic.Bool
+	mu      sync.Mutex
+	cancel  context.CancelFunc
+	ops     atomic.Int64
+	errors  atomic.Int64
+}
+
+func New(logger *slog.Logger, opts Options) ceph.Component {
+	return &LoadGen{
+		logger: logger.With("component", "loadgen"),
+		opts:   opts,
+	}
+}
+
+func (g *LoadGen) Name() string {
+	return "loadgen"
+}
+
+func (g *LoadGen) Requires() []string {
+	if g.opts.Target == TargetS3 {
+		// Objects are written as the default S3 user.
+		return []string{"rgw.user"}
+	}
+
+	return []string{"osd"}
+}
+
+func (g *LoadGen) Configure(ctx context.Context) error {
+	if g.opts.ObjectSize <= 0 || g.opts.Rate <= 0 || g.opts.Objects <= 0 {
+		return fmt.Errorf("object size, rate and number of objects must be positive")
+	}
+
+	if g.opts.ReadPercent < 0 || g.opts.ReadPercent > 100 {
+		return fmt.Errorf("invalid read percentage: %d", g.opts.ReadPercent)
+	}
+
+	if g.opts.Target != TargetRADOS {
+		return nil
+	}
+
+	cmd := command.Context(ctx, "ceph", "osd", "pool", "create", Pool, "8")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not create pool: %w: %s", err, string(out))
+	}
+
+	cmd = command.Context(ctx, "ceph", "osd", "pool", "application", "enable", Pool, "rados")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not enable pool application: %w: %s", err, string(out))
+	}
+
+	return nil
+}
+
+func (g *LoadGen) Start(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	g.mu.Lock()
+	g.cancel = cancel
+	g.mu.Unlock()
+
+	var write func(ctx context.Context, key string, data []byte) error
+	var read func(ctx context.Context, key string) error
+	switch g.opts.Target {
+	case TargetS3:
+		creds, err := radosgw.ReadCredentials(radosgw.CredentialsPath)
+		if err != nil {
+			return fmt.Errorf("could not read S3 credentials: %w", err)
+		}
+
+		if err := radosgw.CreateBucket(ctx, creds, Bucket); err != nil {
+			return err
+		}
+
+		write = func(ctx context.Context, key string, data []byte) error {
+			return radosgw.PutObject(ctx, creds, Bucket, key, data)
+		}
+		read = func(ctx context.Context, key string) error {
+			_, err := radosgw.GetObject(ctx, creds, Bucket, key)
+			return err
+		}
+	default:
+		write, read = radosPut, radosGet
+	}
+
+	data := make([]byte, g.opts.ObjectSize)
+	if _, err := rand.Read(data); err != nil {
+		return fmt.Errorf("could not generate object data: %w", err)
+	}
+
+	g.logger.Info("Generating load", "target", g.opts.Target, "objectSize", g.opts.ObjectSize,
+		"rate", g.opts.Rate, "readPercent", g.opts.ReadPercent)
+
+	g.running.Store(true)
+	defer g.running.Store(false)
+
+	ticker := time.NewTicker(time.Duration(math.Max(float64(time.Second)/g.opts.Rate, 1)))
+	defer ticker.Stop()
+
+	report := time.NewTicker(reportInterval)
+	defer report.Stop()
+
+	// Only objects that have been written are read.
+	var next, written int
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-report.C:
+			g.logger.Info("Generated load", "ops", g.ops.Swap(0), "errors", g.errors.Swap(0))
+			continue
+		case <-ticker.C:
+		}
+
+		var err error
+		if written > 0 && mathrand.Intn(100) < g.opts.ReadPercent {
+			err = read(ctx, objectKey(mathrand.Intn(written)))
+		} else if err = write(ctx, objectKey(next), data); err == nil {
+			written = max(written, next+1)
+			next = (next + 1) % g.opts.Objects
+		}
+
+		if err != nil && ctx.Err() == nil {
+			g.errors.Add(1)
+			g.logger.Debug("Operation failed", "error", err)
+			continue
+		}
+
+		g.ops.Add(1)
+	}
+}
+
+func (g *LoadGen) Stop(ctx context.Context) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.cancel != nil {
+		g.cancel()
+	}
+
+	return nil
+}
+
+func (g *LoadGen) Ready(ctx context.Context) error {
+	if !g.running.Load() {
+		return fmt.Errorf("load generator is not running")
+	}
+
+	return nil
+}
+
+func (g *LoadGen) Logs() (*tail.Tail, error) {
+	// Failures are logged directly.
+	return tail.TailFile(
+		"/dev/null",
+		tail.Config{Follow: true, ReOpen: true},
+	)
+}
+
+func objectKey(i int) string {
+	return "object-" + strconv.Itoa(i)
+}
+
+func radosPut(ctx context.Context, key string, data []byte) error {
+	cmd := command.Context(ctx, "rados", "-p", Pool, "put", key, "-")
+	cmd.Stdin = bytes.NewReader(data)
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not put object %s: %w: %s", key, err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+func radosGet(ctx context.Context, key string) error {
+	cmd := command.Context(ctx, "rados", "-p", Pool, "get", key, "/dev/null")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not get object %s: %w: %s", key, err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
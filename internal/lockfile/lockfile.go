@@ -0,0 +1,86 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package lockfile makes sure that only one picoceph process uses the ceph
+// directories (or a data directory) at a time.
+package lockfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+)
+
+// Path is the lock file of the ceph directories. It is under /var/lib/ceph
+// so that it follows the data directory.
+const Path = "/var/lib/ceph/picoceph.lock"
+
+// ErrLocked is returned if another process holds the lock.
+var ErrLocked = errors.New("another picoceph process is using the same ceph directories")
+
+// Lock is a held lock file.
+type Lock struct {
+	f *os.File
+}
+
+// Acquire takes an exclusive lock on the lock file at path, failing
+// immediately if another process holds it. The lock is released when the
+// process exits, even if it crashes.
+func Acquire(path string) (*Lock, error) {
+	if err := ceph.MkdirAll(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("could not create directory: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open lock file: %w", err)
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		defer f.Close()
+
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			if pid := holder(f); pid != "" {
+				return nil, fmt.Errorf("%w (pid %s holds %s)", ErrLocked, pid, path)
+			}
+
+			return nil, fmt.Errorf("%w (%s is locked)", ErrLocked, path)
+		}
+
+		return nil, fmt.Errorf("could not lock %s: %w", path, err)
+	}
+
+	// Record the holder, for the error of the next process.
+	if err := f.Truncate(0); err == nil {
+		_, _ = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+
+	return &Lock{f: f}, nil
+}
+
+// Release releases the lock.
+func (l *Lock) Release() error {
+	defer l.f.Close()
+
+	return syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
+}
+
+// holder returns the pid recorded by the holder of a lock file.
+func holder(f *os.File) string {
+	buf := make([]byte, 32)
+	n, _ := f.ReadAt(buf, 0)
+
+	return strings.TrimSpace(string(buf[:n]))
+}
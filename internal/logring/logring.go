@@ -0,0 +1,136 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package logring retains the most recent log lines of each component, so that
+// they can be included when reporting failures.
+package logring
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Buffer keeps the last N log lines of each component.
+type Buffer struct {
+	lines int
+	mu    sync.Mutex
+	rings map[string]*ring
+}
+
+// NewBuffer creates a new buffer that keeps the given number of lines per
+// component.
+func NewBuffer(lines int) *Buffer {
+	return &Buffer{
+		lines: lines,
+		rings: make(map[string]*ring),
+	}
+}
+
+// Recent returns the retained log lines of the named component, oldest first.
+func (b *Buffer) Recent(component string) []string {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	r, ok := b.rings[component]
+	if !ok {
+		return nil
+	}
+
+	return r.lines()
+}
+
+func (b *Buffer) add(component, line string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	r, ok := b.rings[component]
+	if !ok {
+		r = &ring{buf: make([]string, b.lines)}
+		b.rings[component] = r
+	}
+
+	r.add(line)
+}
+
+// Handler returns a log handler that retains the records (of loggers with a
+// "component" attribute) that it passes on to next.
+func (b *Buffer) Handler(next slog.Handler) slog.Handler {
+	return &handler{next: next, buf: b}
+}
+
+type ring struct {
+	buf  []string
+	next int
+	full bool
+}
+
+func (r *ring) add(line string) {
+	r.buf[r.next] = line
+	r.next = (r.next + 1) % len(r.buf)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+func (r *ring) lines() []string {
+	if !r.full {
+		return append([]string(nil), r.buf[:r.next]...)
+	}
+
+	return append(append([]string(nil), r.buf[r.next:]...), r.buf[:r.next]...)
+}
+
+type handler struct {
+	next      slog.Handler
+	buf       *Buffer
+	component string
+}
+
+func (h *handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	component := h.component
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == "component" {
+			component = a.Value.String()
+			return false
+		}
+
+		return true
+	})
+
+	if component != "" {
+		h.buf.add(component, r.Time.UTC().Format(time.RFC3339)+" "+r.Level.String()+" "+r.Message)
+	}
+
+	return h.next.Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	component := h.component
+	for _, a := range attrs {
+		if a.Key == "component" {
+			component = a.Value.String()
+		}
+	}
+
+	return &handler{next: h.next.WithAttrs(attrs), buf: h.buf, component: component}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return &handler{next: h.next.WithGroup(name), buf: h.buf, component: h.component}
+}
@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package loop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dpeckett/picoceph/internal/audit"
+	"github.com/dpeckett/picoceph/internal/command"
+)
+
+// Setup ensures that the loop kernel module is loaded and that the kernel supports loop devices.
+func Setup(ctx context.Context) error {
+	audit.Record("load kernel module", "module", "loop")
+
+	// Load the loop kernel module (if not already loaded or built-in).
+	cmd := command.Context(ctx, "/sbin/modprobe", "loop")
+	_ = cmd.Run()
+
+	// Do we have support for loop devices?
+	if _, err := os.Stat("/dev/loop-control"); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("your kernel does not support loop devices")
+	}
+
+	return nil
+}
+
+// Attach attaches the backing file to the next free loop device and returns the path to the device.
+func Attach(ctx context.Context, path string) (string, error) {
+	cmd := command.Context(ctx, "losetup", "--find", "--show", path)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("could not attach loop device: %w: %s", err, string(out))
+	}
+
+	device := strings.TrimSpace(string(out))
+
+	audit.Record("attach loop device", "device", device, "file", path)
+
+	return device, nil
+}
+
+// List returns the backing file of every attached loop device, keyed by device path.
+func List(ctx context.Context) (map[string]string, error) {
+	cmd := command.Context(ctx, "losetup", "--list", "--noheadings", "--raw", "--output", "NAME,BACK-FILE")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("could not list loop devices: %w: %s", err, string(out))
+	}
+
+	devices := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		device, backingFile, ok := strings.Cut(line, " ")
+		if ok {
+			devices[device] = backingFile
+		}
+	}
+
+	return devices, nil
+}
+
+// Detach detaches a loop device from its backing file.
+func Detach(ctx context.Context, device string) error {
+	audit.Record("detach loop device", "device", device)
+
+	cmd := command.Context(ctx, "losetup", "--detach", device)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not detach loop device: %w: %s", err, string(out))
+	}
+
+	return nil
+}
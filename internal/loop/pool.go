@@ -0,0 +1,136 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package loop
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Pool manages the loop devices backed by image files in a directory. Devices
+// left attached by a previous (eg. crashed) run are reused where possible.
+type Pool struct {
+	dir      string
+	mu       sync.Mutex
+	attached map[string]string
+}
+
+// NewPool creates a pool of loop devices backed by image files in dir.
+func NewPool(dir string) *Pool {
+	return &Pool{
+		dir:      filepath.Clean(dir),
+		attached: make(map[string]string),
+	}
+}
+
+// Attach attaches an image file to a loop device, reusing a device the file
+// is already attached to, and returns the path to the device.
+func (p *Pool) Attach(ctx context.Context, path string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	devices, err := p.list(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	for device, backingFile := range devices {
+		if backingFile == path {
+			p.attached[device] = path
+			return device, nil
+		}
+	}
+
+	device, err := Attach(ctx, path)
+	if err != nil {
+		return "", err
+	}
+
+	p.attached[device] = path
+
+	return device, nil
+}
+
+// RemoveStale detaches the loop devices whose image file no longer exists
+// (eg. it was on a tmpfs that has since been unmounted).
+func (p *Pool) RemoveStale(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	devices, err := p.list(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for device, backingFile := range devices {
+		if _, ok := p.attached[device]; ok {
+			continue
+		}
+
+		if _, err := os.Stat(backingFile); errors.Is(err, os.ErrNotExist) || strings.HasSuffix(backingFile, deletedSuffix) {
+			errs = append(errs, Detach(ctx, device))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Len returns the number of loop devices attached through the pool.
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.attached)
+}
+
+// DetachAll detaches every loop device attached through the pool. Devices
+// that are still in use are detached once they are released.
+func (p *Pool) DetachAll(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var errs []error
+	for device := range p.attached {
+		errs = append(errs, Detach(ctx, device))
+		delete(p.attached, device)
+	}
+
+	return errors.Join(errs...)
+}
+
+// deletedSuffix is appended by the kernel to the backing file of a loop
+// device when the file has been deleted.
+const deletedSuffix = " (deleted)"
+
+// list returns the backing file of every loop device backed by an image file
+// in the pool directory, keyed by device path.
+func (p *Pool) list(ctx context.Context) (map[string]string, error) {
+	all, err := List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make(map[string]string)
+	for device, backingFile := range all {
+		// Raw output escapes whitespace.
+		backingFile = strings.ReplaceAll(backingFile, `\x20`, " ")
+
+		if filepath.Dir(strings.TrimSuffix(backingFile, deletedSuffix)) == p.dir {
+			devices[device] = backingFile
+		}
+	}
+
+	return devices, nil
+}
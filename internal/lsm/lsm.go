@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package lsm inspects the Linux Security Modules (SELinux and AppArmor) that
+// may be confining picoceph.
+package lsm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dpeckett/picoceph/internal/audit"
+	"github.com/dpeckett/picoceph/internal/command"
+)
+
+// SELinuxEnforcing returns true if SELinux is enabled and in enforcing mode.
+func SELinuxEnforcing() bool {
+	enforce, err := os.ReadFile("/sys/fs/selinux/enforce")
+	if err != nil {
+		return false
+	}
+
+	return strings.TrimSpace(string(enforce)) == "1"
+}
+
+// SELinuxContext returns the SELinux context of the current process
+// (eg. "system_u:system_r:container_t:s0:c1,c2").
+func SELinuxContext() string {
+	if _, err := os.Stat("/sys/fs/selinux"); err != nil {
+		return ""
+	}
+
+	return readAttr("/proc/self/attr/current")
+}
+
+// SELinuxType returns the type (domain) of the current process's SELinux context.
+func SELinuxType() string {
+	parts := strings.Split(SELinuxContext(), ":")
+	if len(parts) < 3 {
+		return ""
+	}
+
+	return parts[2]
+}
+
+// AppArmorProfile returns the name of the AppArmor profile confining the
+// current process, and whether it is in enforce mode.
+func AppArmorProfile() (string, bool) {
+	current := readAttr("/proc/self/attr/apparmor/current")
+	if current == "" {
+		if _, err := os.Stat("/sys/kernel/security/apparmor"); err != nil {
+			return "", false
+		}
+
+		// Older kernels expose the AppArmor label via the generic attribute.
+		current = readAttr("/proc/self/attr/current")
+	}
+
+	if current == "" || current == "unconfined" {
+		return current, false
+	}
+
+	// eg. "docker-default (enforce)"
+	profile, mode, _ := strings.Cut(current, " ")
+	return profile, mode == "(enforce)"
+}
+
+// Relabel recursively labels the given paths so that they can be accessed
+// from within a container (the equivalent of the :Z volume option).
+func Relabel(ctx context.Context, paths ...string) error {
+	for _, path := range paths {
+		audit.Record("relabel", "path", path, "type", "container_file_t")
+
+		cmd := command.Context(ctx, "chcon", "-R", "-t", "container_file_t", path)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("could not relabel %s: %w: %s", path, err, string(out))
+		}
+	}
+
+	return nil
+}
+
+func readAttr(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(strings.TrimRight(string(data), "\x00"))
+}
@@ -15,15 +15,19 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+
+	"github.com/dpeckett/picoceph/internal/audit"
+	"github.com/dpeckett/picoceph/internal/command"
 )
 
 // Setup ensures that the nbd kernel module is loaded and that the kernel supports nbd.
 func Setup(ctx context.Context) error {
+	audit.Record("load kernel module", "module", "nbd")
+
 	// Load the nbd kernel module (if not already loaded or built-in).
-	cmd := exec.CommandContext(ctx, "/sbin/modprobe", "nbd")
+	cmd := command.Context(ctx, "/sbin/modprobe", "nbd")
 	_ = cmd.Run()
 
 	// Do we have support for nbd?
@@ -62,3 +66,51 @@ func NextFreeDevice() (string, error) {
 
 	return filepath.Join("/dev/", availableNBDDevices[rand.Intn(len(availableNBDDevices))]), nil
 }
+
+// ConnectedDevices returns the paths of every connected nbd device, keyed by
+// the command line of the qemu-nbd process serving it.
+func ConnectedDevices() (map[string]string, error) {
+	dir, err := os.Open("/sys/block")
+	if err != nil {
+		return nil, fmt.Errorf("could not open /sys/block: %w", err)
+	}
+	defer dir.Close()
+
+	devices, err := dir.Readdirnames(-1)
+	if err != nil {
+		return nil, fmt.Errorf("could not read /sys/block: %w", err)
+	}
+
+	connected := make(map[string]string)
+	for _, dev := range devices {
+		if !strings.HasPrefix(dev, "nbd") {
+			continue
+		}
+
+		pid, err := os.ReadFile(filepath.Join("/sys/block", dev, "pid"))
+		if err != nil {
+			continue
+		}
+
+		cmdline, err := os.ReadFile(filepath.Join("/proc", strings.TrimSpace(string(pid)), "cmdline"))
+		if err != nil {
+			continue
+		}
+
+		connected[filepath.Join("/dev", dev)] = strings.ReplaceAll(string(cmdline), "\x00", " ")
+	}
+
+	return connected, nil
+}
+
+// Disconnect disconnects an nbd device.
+func Disconnect(ctx context.Context, device string) error {
+	audit.Record("detach nbd device", "device", device)
+
+	cmd := command.Context(ctx, "qemu-nbd", "--disconnect", device)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not disconnect nbd device: %w: %s", err, string(out))
+	}
+
+	return nil
+}
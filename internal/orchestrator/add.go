@@ -0,0 +1,110 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+)
+
+// Add configures and starts a component while the orchestrator is running
+// (eg. an OSD to expand the cluster), and blocks until it is ready. Its
+// dependencies must already be running. A component that doesn't become
+// ready is stopped and removed again, leaving the others running.
+//
+// The component is provisioned on the orchestrator's context, rather than
+// that of the caller, so that it isn't torn down partway through (eg. if an
+// API client disconnects).
+func (o *Orchestrator) Add(cmp ceph.Component) error {
+	name := cmp.Name()
+
+	o.mu.Lock()
+	if o.group == nil || o.stopping {
+		o.mu.Unlock()
+		return errors.New("components can only be added while running")
+	}
+
+	if _, ok := o.states[name]; ok {
+		o.mu.Unlock()
+		return fmt.Errorf("duplicate component: %s", name)
+	}
+
+	var dependencies []string
+	for _, req := range cmp.Requires() {
+		var matched bool
+		for _, dep := range o.components {
+			if dep.Name() != req && !strings.HasPrefix(dep.Name(), req+".") {
+				continue
+			}
+
+			if state := o.states[dep.Name()].state; state != StateRunning {
+				o.mu.Unlock()
+				return fmt.Errorf("component %s requires %s, which is %s", name, dep.Name(), state)
+			}
+
+			dependencies = append(dependencies, dep.Name())
+			matched = true
+		}
+
+		if !matched {
+			o.mu.Unlock()
+			return fmt.Errorf("component %s requires unknown component: %s", name, req)
+		}
+	}
+
+	o.components = append(o.components, cmp)
+	o.dependencies[name] = dependencies
+	o.states[name] = &componentState{state: StatePending}
+	g, runCtx, daemonCtx := o.group, o.runCtx, o.daemonCtx
+	o.mu.Unlock()
+
+	logger := o.logger.With("component", name)
+
+	exited, err := o.start(runCtx, daemonCtx, logger, cmp)
+	if err != nil {
+		stopCtx, cancel := context.WithTimeout(context.Background(), o.stopTimeout(name))
+		if err := cmp.Stop(stopCtx); err != nil {
+			logger.Warn("Could not stop component", "error", err)
+		}
+		cancel()
+
+		o.remove(name)
+
+		return err
+	}
+
+	g.Go(func() error {
+		if err := o.supervise(runCtx, daemonCtx, logger, cmp, exited); err != nil {
+			o.fail(name, err)
+			return fmt.Errorf("could not run component %s: %w", name, err)
+		}
+
+		return nil
+	})
+
+	return nil
+}
+
+// remove forgets a component that was added but never became ready.
+func (o *Orchestrator) remove(name string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.components = slices.DeleteFunc(o.components, func(cmp ceph.Component) bool {
+		return cmp.Name() == name
+	})
+	delete(o.dependencies, name)
+	delete(o.states, name)
+}
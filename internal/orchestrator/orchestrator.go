@@ -0,0 +1,405 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package orchestrator configures and starts components once their
+// dependencies are ready, and stops them again in reverse order.
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/logring"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// DefaultReadyTimeout is the default time to wait for a component to become ready.
+	DefaultReadyTimeout = 2 * time.Minute
+	// DefaultStopTimeout is the default time to wait for components to gracefully stop.
+	DefaultStopTimeout = time.Minute
+)
+
+// Options are the options for an orchestrator.
+type Options struct {
+	// ReadyTimeout is how long to wait for a started component to become ready.
+	ReadyTimeout time.Duration
+	// StopTimeout is how long to wait for each component to gracefully stop
+	// before it is forcibly killed.
+	StopTimeout time.Duration
+	// StopTimeouts override the StopTimeout of components, keyed by component
+	// name (eg. "osd.0") or type (eg. "osd").
+	StopTimeouts map[string]time.Duration
+	// MaxRestarts is how many times a failed component will be restarted
+	// before giving up (zero disables restarts).
+	MaxRestarts int
+	// RestartBackoff is the delay before the first restart of a failed
+	// component, it doubles with each subsequent restart.
+	RestartBackoff time.Duration
+	// Logs retains the recent log lines of each component, which are reported
+	// when a component fails (nil disables).
+	Logs *logring.Buffer
+}
+
+// Orchestrator manages the lifecycle of a set of components.
+type Orchestrator struct {
+	logger       *slog.Logger
+	opts         Options
+	components   []ceph.Component
+	dependencies map[string][]string
+	stopOnce     sync.Once
+	mu           sync.Mutex
+	states       map[string]*componentState
+	// group, runCtx and daemonCtx are set while running, so that components
+	// can be added (see Add).
+	group     *errgroup.Group
+	runCtx    context.Context
+	daemonCtx context.Context
+	stopping  bool
+}
+
+// New creates a new orchestrator for the given components. The components are
+// sorted so that every component comes after its dependencies.
+func New(logger *slog.Logger, opts Options, components ...ceph.Component) (*Orchestrator, error) {
+	if opts.ReadyTimeout == 0 {
+		opts.ReadyTimeout = DefaultReadyTimeout
+	}
+
+	if opts.StopTimeout == 0 {
+		opts.StopTimeout = DefaultStopTimeout
+	}
+
+	if opts.RestartBackoff == 0 {
+		opts.RestartBackoff = DefaultRestartBackoff
+	}
+
+	dependencies := make(map[string][]string)
+	states := make(map[string]*componentState)
+	for _, cmp := range components {
+		if _, ok := dependencies[cmp.Name()]; ok {
+			return nil, fmt.Errorf("duplicate component: %s", cmp.Name())
+		}
+
+		dependencies[cmp.Name()] = []string{}
+		states[cmp.Name()] = &componentState{state: StatePending}
+	}
+
+	for _, cmp := range components {
+		for _, req := range cmp.Requires() {
+			var matched bool
+			for _, dep := range components {
+				if dep.Name() == req || strings.HasPrefix(dep.Name(), req+".") {
+					dependencies[cmp.Name()] = append(dependencies[cmp.Name()], dep.Name())
+					matched = true
+				}
+			}
+
+			if !matched {
+				return nil, fmt.Errorf("component %s requires unknown component: %s", cmp.Name(), req)
+			}
+		}
+	}
+
+	sorted, err := sortComponents(components, dependencies)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Orchestrator{
+		logger:       logger,
+		opts:         opts,
+		components:   sorted,
+		dependencies: dependencies,
+		states:       states,
+	}, nil
+}
+
+// Run configures and starts every component, once its dependencies are ready,
+// and blocks until all of them have exited. If any component fails, or the
+// context is cancelled, the remaining components are stopped in order.
+func (o *Orchestrator) Run(ctx context.Context) error {
+	g, gctx := errgroup.WithContext(ctx)
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		<-gctx.Done()
+		o.Stop()
+	}()
+
+	// Daemons are stopped explicitly (and in order), so they shouldn't be
+	// killed as soon as the context is cancelled.
+	daemonCtx := context.WithoutCancel(ctx)
+
+	ready := make(map[string]chan struct{})
+	for _, cmp := range o.components {
+		ready[cmp.Name()] = make(chan struct{})
+	}
+
+	for _, cmp := range o.components {
+		cmp := cmp
+		dependencies := o.dependencies[cmp.Name()]
+
+		g.Go(func() error {
+			for _, dep := range dependencies {
+				select {
+				case <-ready[dep]:
+				case <-gctx.Done():
+					return gctx.Err()
+				}
+			}
+
+			logger := o.logger.With("component", cmp.Name())
+
+			exited, err := o.start(gctx, daemonCtx, logger, cmp)
+			if err != nil {
+				return err
+			}
+
+			close(ready[cmp.Name()])
+
+			if err := o.supervise(gctx, daemonCtx, logger, cmp, exited); err != nil {
+				o.fail(cmp.Name(), err)
+				return fmt.Errorf("could not run component %s: %w", cmp.Name(), err)
+			}
+
+			return nil
+		})
+	}
+
+	// Components can be added once the initial ones have been scheduled.
+	o.mu.Lock()
+	o.group, o.runCtx, o.daemonCtx = g, gctx, daemonCtx
+	o.mu.Unlock()
+
+	err := g.Wait()
+	<-stopped
+
+	return err
+}
+
+// start configures and starts a component, and waits until it is ready. It
+// returns a channel that receives the error the daemon of the component exits
+// with.
+func (o *Orchestrator) start(ctx, daemonCtx context.Context, logger *slog.Logger, cmp ceph.Component) (chan error, error) {
+	logger.Info("Configuring")
+	o.setState(cmp.Name(), StateConfiguring)
+
+	if err := cmp.Configure(ctx); err != nil {
+		o.fail(cmp.Name(), err)
+		return nil, fmt.Errorf("could not configure component %s: %w", cmp.Name(), err)
+	}
+
+	// Start echoing logs from the component.
+	go func() {
+		t, err := cmp.Logs()
+		if err != nil {
+			logger.Error("Could not tail logs", "error", err)
+			return
+		}
+		defer t.Cleanup()
+
+		for line := range t.Lines {
+			l := ceph.ParseLogLine(line.Text)
+			if l.Channel != "" {
+				logger.Log(context.Background(), l.Level, l.Message, "channel", l.Channel)
+			} else {
+				logger.Log(context.Background(), l.Level, l.Message)
+			}
+		}
+	}()
+
+	logger.Info("Starting")
+	o.setState(cmp.Name(), StateStarting)
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmp.Start(daemonCtx)
+	}()
+
+	if err := o.waitUntilReady(ctx, cmp, exited); err != nil {
+		o.fail(cmp.Name(), err)
+		return nil, fmt.Errorf("component %s did not become ready: %w", cmp.Name(), err)
+	}
+
+	logger.Info("Ready")
+	o.setState(cmp.Name(), StateRunning)
+
+	return exited, nil
+}
+
+// Stop stops every component in the reverse order to which they were started,
+// so that eg. the OSD is stopped before the monitor. It is safe to call more
+// than once.
+func (o *Orchestrator) Stop() {
+	o.stopOnce.Do(func() {
+		o.logger.Info("Shutting down")
+
+		o.mu.Lock()
+		o.stopping = true
+		o.mu.Unlock()
+
+		components := o.list()
+		for i := len(components) - 1; i >= 0; i-- {
+			cmp := components[i]
+
+			o.logger.Info("Stopping", "component", cmp.Name())
+
+			// Each component gets its own timeout, so that a hung daemon can't
+			// eat into the time its dependencies have to flush their state.
+			ctx, cancel := context.WithTimeout(context.Background(), o.stopTimeout(cmp.Name()))
+			if err := cmp.Stop(ctx); err != nil {
+				o.logger.Warn("Could not gracefully stop component",
+					"component", cmp.Name(), "error", err)
+			}
+			cancel()
+
+			if o.States()[cmp.Name()] != StateFailed {
+				o.setState(cmp.Name(), StateStopped)
+			}
+		}
+	})
+}
+
+// list returns a copy of the components, as components can be added while
+// running.
+func (o *Orchestrator) list() []ceph.Component {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return slices.Clone(o.components)
+}
+
+// fail marks the named component as failed, and reports its recent logs.
+func (o *Orchestrator) fail(name string, err error) {
+	o.setFailed(name, err)
+
+	if lines := o.opts.Logs.Recent(name); len(lines) > 0 {
+		o.logger.Error("Recent logs of failed component", "component", name, "lines", lines)
+	}
+}
+
+// stopTimeout returns how long to wait for the named component to stop.
+func (o *Orchestrator) stopTimeout(name string) time.Duration {
+	if timeout, ok := o.opts.StopTimeouts[name]; ok {
+		return timeout
+	}
+
+	componentType, _, _ := strings.Cut(name, ".")
+	if timeout, ok := o.opts.StopTimeouts[componentType]; ok {
+		return timeout
+	}
+
+	return o.opts.StopTimeout
+}
+
+// ParseStopTimeouts parses a comma separated list of component=timeout pairs
+// (eg. "osd=30s,mon.a=10s").
+func ParseStopTimeouts(s string) (map[string]time.Duration, error) {
+	timeouts := make(map[string]time.Duration)
+	for _, pair := range strings.Split(s, ",") {
+		if pair == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid stop timeout: %s", pair)
+		}
+
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid stop timeout for %s: %s", name, value)
+		}
+
+		timeouts[name] = timeout
+	}
+
+	return timeouts, nil
+}
+
+// waitUntilReady polls the component until it is ready. If the component
+// exits with an error in the meantime, the error is returned. Components that
+// exit successfully (eg. because they have nothing to run) are still polled.
+func (o *Orchestrator) waitUntilReady(ctx context.Context, cmp ceph.Component, exited chan error) error {
+	ctx, cancel := context.WithTimeout(ctx, o.opts.ReadyTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		err := cmp.Ready(ctx)
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case exitErr := <-exited:
+			if exitErr != nil {
+				return exitErr
+			}
+
+			// Put it back so that the caller can collect it.
+			exited <- nil
+			exited = nil
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %w", ctx.Err(), err)
+		case <-ticker.C:
+		}
+	}
+}
+
+// sortComponents topologically sorts the components so that each component
+// comes after its dependencies, preserving the original order where possible.
+func sortComponents(components []ceph.Component, dependencies map[string][]string) ([]ceph.Component, error) {
+	var sorted []ceph.Component
+	visited := make(map[string]bool)
+
+	for len(sorted) < len(components) {
+		progressed := false
+
+	next:
+		for _, cmp := range components {
+			if visited[cmp.Name()] {
+				continue
+			}
+
+			for _, dep := range dependencies[cmp.Name()] {
+				if !visited[dep] {
+					continue next
+				}
+			}
+
+			visited[cmp.Name()] = true
+			sorted = append(sorted, cmp)
+			progressed = true
+		}
+
+		if !progressed {
+			var cyclic []string
+			for _, cmp := range components {
+				if !visited[cmp.Name()] {
+					cyclic = append(cyclic, cmp.Name())
+				}
+			}
+
+			return nil, fmt.Errorf("dependency cycle between components: %s", strings.Join(cyclic, ", "))
+		}
+	}
+
+	return sorted, nil
+}
@@ -0,0 +1,49 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package orchestrator
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+)
+
+// Pids returns the pid of every running daemon, keyed by component name.
+func (o *Orchestrator) Pids() map[string]int {
+	pids := make(map[string]int)
+	for _, cmp := range o.list() {
+		if p, ok := cmp.(ceph.Process); ok {
+			if pid := p.Pid(); pid != 0 {
+				pids[cmp.Name()] = pid
+			}
+		}
+	}
+
+	return pids
+}
+
+// Signal sends a signal to the daemon of the named component.
+func (o *Orchestrator) Signal(name string, sig os.Signal) error {
+	for _, cmp := range o.list() {
+		if cmp.Name() != name {
+			continue
+		}
+
+		p, ok := cmp.(ceph.Process)
+		if !ok {
+			return fmt.Errorf("component %s does not run a daemon", name)
+		}
+
+		return p.Signal(sig)
+	}
+
+	return fmt.Errorf("unknown component: %s", name)
+}
@@ -0,0 +1,183 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// State is the lifecycle state of a component.
+type State string
+
+const (
+	StatePending     State = "pending"
+	StateConfiguring State = "configuring"
+	StateStarting    State = "starting"
+	StateRunning     State = "running"
+	StateRestarting  State = "restarting"
+	StateFailed      State = "failed"
+	StateStopped     State = "stopped"
+)
+
+// Names returns the names of every component, in the order they are started.
+func (o *Orchestrator) Names() []string {
+	components := o.list()
+
+	names := make([]string, len(components))
+	for i, cmp := range components {
+		names[i] = cmp.Name()
+	}
+
+	return names
+}
+
+// ComponentStatus is the detailed status of a component.
+type ComponentStatus struct {
+	Name       string `json:"name"`
+	State      State  `json:"state"`
+	Configured bool   `json:"configured"`
+	Started    bool   `json:"started"`
+	Ready      bool   `json:"ready"`
+	Failed     bool   `json:"failed"`
+	Restarts   int    `json:"restarts"`
+	// Error is the most recent error, either from a failure or from the
+	// component not being ready.
+	Error string `json:"error,omitempty"`
+	// RecentLogs are the most recent log lines of a failed component.
+	RecentLogs []string `json:"recentLogs,omitempty"`
+}
+
+// componentState is the lifecycle state of a component, as tracked by the orchestrator.
+type componentState struct {
+	state      State
+	configured bool
+	started    bool
+	restarts   int
+	err        error
+}
+
+// States returns the current state of every component.
+func (o *Orchestrator) States() map[string]State {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	states := make(map[string]State, len(o.states))
+	for name, cs := range o.states {
+		states[name] = cs.state
+	}
+
+	return states
+}
+
+// Live returns an error if any component has failed.
+func (o *Orchestrator) Live() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, cmp := range o.components {
+		if o.states[cmp.Name()].state == StateFailed {
+			return fmt.Errorf("component %s has failed", cmp.Name())
+		}
+	}
+
+	return nil
+}
+
+// Readiness returns the detailed status of every component, in the order
+// they are started. Running components are asked whether they are still
+// ready (eg. the monitor is in quorum).
+func (o *Orchestrator) Readiness(ctx context.Context) []ComponentStatus {
+	o.mu.Lock()
+	components := slices.Clone(o.components)
+	statuses := make([]ComponentStatus, len(components))
+	for i, cmp := range components {
+		cs := o.states[cmp.Name()]
+
+		statuses[i] = ComponentStatus{
+			Name:       cmp.Name(),
+			State:      cs.state,
+			Configured: cs.configured,
+			Started:    cs.started,
+			Failed:     cs.state == StateFailed,
+			Restarts:   cs.restarts,
+		}
+
+		if cs.err != nil {
+			statuses[i].Error = cs.err.Error()
+		}
+
+		if cs.state == StateFailed {
+			statuses[i].RecentLogs = o.opts.Logs.Recent(cmp.Name())
+		}
+	}
+	o.mu.Unlock()
+
+	var g errgroup.Group
+	for i, cmp := range components {
+		i, cmp := i, cmp
+
+		if statuses[i].State != StateRunning {
+			continue
+		}
+
+		g.Go(func() error {
+			if err := cmp.Ready(ctx); err != nil {
+				statuses[i].Error = err.Error()
+			} else {
+				statuses[i].Ready = true
+			}
+
+			return nil
+		})
+	}
+
+	_ = g.Wait()
+
+	return statuses
+}
+
+func (o *Orchestrator) setState(name string, state State) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	cs := o.states[name]
+	cs.state = state
+
+	switch state {
+	case StateStarting:
+		cs.configured = true
+		cs.started = true
+	case StateRunning:
+		cs.err = nil
+	}
+}
+
+func (o *Orchestrator) setFailed(name string, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	cs := o.states[name]
+	cs.state = StateFailed
+	cs.err = err
+}
+
+func (o *Orchestrator) recordRestart(name string, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	cs := o.states[name]
+	cs.state = StateRestarting
+	cs.restarts++
+	cs.err = err
+}
@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+)
+
+const (
+	// DefaultRestartBackoff is the default delay before the first restart of a failed component.
+	DefaultRestartBackoff = time.Second
+	// maxRestartBackoff caps the exponential backoff between restarts.
+	maxRestartBackoff = time.Minute
+)
+
+// supervise waits for a started component to exit. If it fails, it is
+// restarted with exponential backoff, until it has been restarted
+// MaxRestarts times, at which point its error is returned.
+func (o *Orchestrator) supervise(ctx, daemonCtx context.Context, logger *slog.Logger, cmp ceph.Component, exited chan error) error {
+	backoff := o.opts.RestartBackoff
+
+	for restarts := 0; ; restarts++ {
+		err := <-exited
+		if err == nil {
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			// We're shutting down anyway.
+			return nil
+		}
+
+		if restarts >= o.opts.MaxRestarts {
+			if restarts > 0 {
+				return fmt.Errorf("giving up after %d restarts: %w", restarts, err)
+			}
+
+			return err
+		}
+
+		o.recordRestart(cmp.Name(), err)
+
+		logger.Warn("Component exited unexpectedly, restarting",
+			"error", err, "restart", restarts+1, "maxRestarts", o.opts.MaxRestarts, "backoff", backoff)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil
+		}
+
+		backoff = min(2*backoff, maxRestartBackoff)
+
+		go func() {
+			exited <- cmp.Start(daemonCtx)
+		}()
+
+		o.setState(cmp.Name(), StateRunning)
+	}
+}
@@ -0,0 +1,145 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package platform detects the environment that picoceph is running in, and
+// picks defaults that are known to work there.
+package platform
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/dpeckett/picoceph/internal/ceph/osd"
+	"github.com/dpeckett/picoceph/internal/nbd"
+	"github.com/dpeckett/picoceph/internal/orchestrator"
+)
+
+// Environment is a kind of host that picoceph can run on.
+type Environment string
+
+const (
+	// EnvironmentNative is a regular Linux host (or a VM we can't tell apart from one).
+	EnvironmentNative Environment = "native"
+	// EnvironmentWSL2 is the Windows Subsystem for Linux (including Docker Desktop's WSL2 backend).
+	EnvironmentWSL2 Environment = "wsl2"
+	// EnvironmentDockerDesktop is Docker Desktop's LinuxKit VM (macOS or Windows).
+	EnvironmentDockerDesktop Environment = "docker-desktop"
+	// EnvironmentMacOSVM is a Linux VM on a Mac, eg. Lima or Colima.
+	EnvironmentMacOSVM Environment = "macos-vm"
+)
+
+// Platform describes the host and the defaults that suit it.
+type Platform struct {
+	// Environment is the kind of host.
+	Environment Environment
+	// Arch is the CPU architecture of the host.
+	Arch string
+	// OSDBackend is the OSD backend that is expected to work on the host.
+	OSDBackend osd.Backend
+	// ReadyTimeout is how long to wait for components to become ready.
+	ReadyTimeout time.Duration
+	// OSDMemoryTarget is the osd_memory_target in bytes (zero keeps the Ceph default).
+	OSDMemoryTarget int64
+	// Notes explain any defaults that were changed for the host.
+	Notes []string
+}
+
+// Degraded returns true if the host is a nested virtualization environment,
+// where picoceph runs with reduced defaults.
+func (p *Platform) Degraded() bool {
+	return p.Environment != EnvironmentNative
+}
+
+// Detect detects the platform that picoceph is running on.
+func Detect(ctx context.Context) *Platform {
+	p := &Platform{
+		Environment:  detectEnvironment(),
+		Arch:         runtime.GOARCH,
+		OSDBackend:   osd.BackendAuto,
+		ReadyTimeout: orchestrator.DefaultReadyTimeout,
+	}
+
+	if p.Degraded() {
+		// These kernels generally ship without the nbd module, and the VMs are
+		// often both slow and short on memory.
+		p.OSDBackend = osd.BackendLoop
+		p.ReadyTimeout = 2 * orchestrator.DefaultReadyTimeout
+		p.OSDMemoryTarget = 1 << 30
+	}
+
+	if p.Arch == "arm64" {
+		p.detectARM64(ctx)
+	}
+
+	return p
+}
+
+// detectARM64 validates that the tools needed by the nbd backend are available
+// on arm64 hosts (they are often missing from images and kernels built for
+// single board computers), and tunes the defaults for Raspberry Pis.
+func (p *Platform) detectARM64(ctx context.Context) {
+	if p.OSDBackend == osd.BackendAuto {
+		for _, name := range []string{"qemu-img", "qemu-nbd"} {
+			if _, err := exec.LookPath(name); err != nil {
+				p.OSDBackend = osd.BackendLoop
+				p.Notes = append(p.Notes, fmt.Sprintf("%s is not installed, using loop devices for OSDs", name))
+				break
+			}
+		}
+	}
+
+	if p.OSDBackend == osd.BackendAuto {
+		if err := nbd.Setup(ctx); err != nil {
+			p.OSDBackend = osd.BackendLoop
+			p.Notes = append(p.Notes, fmt.Sprintf("%s, using loop devices for OSDs", err))
+		}
+	}
+
+	if strings.Contains(readFile("/proc/device-tree/model"), "Raspberry Pi") {
+		p.ReadyTimeout = max(p.ReadyTimeout, 2*orchestrator.DefaultReadyTimeout)
+		if p.OSDMemoryTarget == 0 || p.OSDMemoryTarget > 1<<30 {
+			p.OSDMemoryTarget = 1 << 30
+		}
+		p.Notes = append(p.Notes, "running on a Raspberry Pi, using a longer ready timeout and a smaller OSD memory target")
+	}
+}
+
+func detectEnvironment() Environment {
+	osRelease := strings.ToLower(readFile("/proc/sys/kernel/osrelease"))
+
+	switch {
+	case strings.Contains(osRelease, "microsoft") || strings.Contains(osRelease, "wsl"):
+		return EnvironmentWSL2
+	case strings.Contains(osRelease, "linuxkit"):
+		return EnvironmentDockerDesktop
+	}
+
+	// Lima/Colima VMs using Apple's Virtualization.framework identify themselves via DMI.
+	for _, path := range []string{"/sys/class/dmi/id/sys_vendor", "/sys/class/dmi/id/product_name"} {
+		if strings.Contains(strings.ToLower(readFile(path)), "apple") {
+			return EnvironmentMacOSVM
+		}
+	}
+
+	return EnvironmentNative
+}
+
+func readFile(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(data))
+}
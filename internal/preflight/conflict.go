@@ -0,0 +1,129 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package preflight
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+)
+
+// CheckConflictingCeph is the name of the check for an existing Ceph installation.
+const CheckConflictingCeph = "conflicting-ceph"
+
+// InstanceEnv is the environment variable that holds the name of the
+// picoceph instance, which is inherited by its daemons.
+const InstanceEnv = "PICOCEPH_INSTANCE"
+
+// cephDaemons are the names of the processes run by a Ceph cluster.
+var cephDaemons = []string{"ceph-mon", "ceph-mgr", "ceph-osd", "ceph-mds", "radosgw"}
+
+// checkConflictingCeph makes sure that we aren't about to trample over a real
+// Ceph cluster (eg. on a developer's machine).
+func checkConflictingCeph(_ context.Context) Finding {
+	f := Finding{Check: CheckConflictingCeph, Status: StatusOK, Message: "no conflicting Ceph installation found"}
+
+	var conflicts []string
+
+	if daemons := runningCephDaemons(); len(daemons) > 0 {
+		conflicts = append(conflicts, "ceph daemons are already running ("+strings.Join(daemons, ", ")+")")
+	}
+
+	if units, _ := filepath.Glob("/etc/systemd/system/*.wants/ceph*"); len(units) > 0 {
+		conflicts = append(conflicts, "ceph systemd units are enabled")
+	}
+
+	if reason := foreignClusterState(); reason != "" {
+		conflicts = append(conflicts, reason)
+	}
+
+	if len(conflicts) > 0 {
+		f.Status = StatusFail
+		f.Message = "found an existing Ceph installation: " + strings.Join(conflicts, "; ")
+		f.Remediation = "if this is intended, pass --force (picoceph may destroy the existing cluster)"
+	}
+
+	return f
+}
+
+// runningCephDaemons returns the names of any running ceph daemons, other
+// than those of other picoceph instances. Daemons that don't belong to any
+// instance (eg. of a Ceph installation) always count.
+func runningCephDaemons() []string {
+	comms, _ := filepath.Glob("/proc/[0-9]*/comm")
+
+	var daemons []string
+	for _, comm := range comms {
+		name, err := os.ReadFile(comm)
+		if err != nil {
+			continue
+		}
+
+		daemon := strings.TrimSpace(string(name))
+		if !slices.Contains(cephDaemons, daemon) || slices.Contains(daemons, daemon) {
+			continue
+		}
+
+		if instance, ok := processInstance(filepath.Dir(comm)); ok && instance != os.Getenv(InstanceEnv) {
+			continue
+		}
+
+		daemons = append(daemons, daemon)
+	}
+
+	return daemons
+}
+
+// processInstance returns the picoceph instance a process belongs to (empty
+// for the unnamed instance), from its environment. It returns false if the
+// process wasn't started by picoceph.
+func processInstance(procDir string) (string, bool) {
+	environ, err := os.ReadFile(filepath.Join(procDir, "environ"))
+	if err != nil {
+		return "", false
+	}
+
+	for _, kv := range strings.Split(string(environ), "\x00") {
+		if value, ok := strings.CutPrefix(kv, InstanceEnv+"="); ok {
+			return value, true
+		}
+	}
+
+	return "", false
+}
+
+// foreignClusterState returns a reason if the ceph directories contain a
+// cluster that wasn't created by picoceph.
+func foreignClusterState() string {
+	fsid, err := ceph.ReadFSID()
+	if err != nil {
+		return err.Error()
+	}
+
+	if fsid == "" {
+		for _, dir := range []string{"/var/lib/ceph/mon", "/var/lib/ceph/osd"} {
+			if entries, _ := os.ReadDir(dir); len(entries) > 0 {
+				return dir + " contains data from a cluster not created by picoceph"
+			}
+		}
+
+		return ""
+	}
+
+	if confFSID := ceph.ConfiguredFSID(); confFSID != "" && confFSID != fsid {
+		return "/etc/ceph/ceph.conf belongs to a different cluster (" + confFSID + ")"
+	}
+
+	return ""
+}
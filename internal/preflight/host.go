@@ -0,0 +1,62 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package preflight
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/dpeckett/picoceph/internal/resolve"
+)
+
+// HostChecks check what the host is capable of. They aren't run on startup,
+// where the option resolver takes the capabilities of the host into account.
+var HostChecks = []Check{
+	checkPrivileges,
+	checkNBD,
+	checkLoop,
+}
+
+func checkPrivileges(_ context.Context) Finding {
+	f := Finding{Check: "privileges", Status: StatusOK, Message: "running as root with CAP_SYS_ADMIN"}
+
+	if !resolve.DetectHost().Privileged {
+		f.Status = StatusFail
+		f.Message = "not running as root with CAP_SYS_ADMIN, which is needed to create the OSD block devices"
+		f.Remediation = "run the container with --privileged"
+	}
+
+	return f
+}
+
+func checkNBD(_ context.Context) Finding {
+	f := Finding{Check: "nbd", Status: StatusOK, Message: "the nbd kernel module and qemu-nbd are available"}
+
+	if !resolve.DetectHost().NBD {
+		f.Status = StatusWarn
+		f.Message = "nbd is unavailable, OSDs will be backed by loop devices (and can't be snapshotted)"
+		f.Remediation = "install qemu-nbd and mount /lib/modules into the container, or pass --osd-backend=loop"
+	}
+
+	return f
+}
+
+func checkLoop(_ context.Context) Finding {
+	f := Finding{Check: "loop", Status: StatusOK, Message: "loop devices are available"}
+
+	if _, err := os.Stat("/dev/loop-control"); errors.Is(err, os.ErrNotExist) {
+		f.Status = StatusWarn
+		f.Message = "/dev/loop-control does not exist, OSDs can't be backed by loop devices"
+		f.Remediation = "mount /dev into the container (-v /dev:/dev)"
+	}
+
+	return f
+}
@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package preflight
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dpeckett/picoceph/internal/lsm"
+)
+
+func checkSELinux(_ context.Context) Finding {
+	f := Finding{Check: "selinux", Status: StatusOK}
+
+	if !lsm.SELinuxEnforcing() {
+		f.Message = "SELinux is not enforcing"
+		return f
+	}
+
+	switch domain := lsm.SELinuxType(); domain {
+	case "container_t", "container_init_t", "svirt_lxc_net_t":
+		// The confined container domains deny access to block devices, the
+		// device mapper, and loading kernel modules.
+		f.Status = StatusFail
+		f.Message = fmt.Sprintf("SELinux is enforcing and picoceph is confined to the %s domain (%s), "+
+			"which denies the nbd, loop and device-mapper operations needed to create OSDs", domain, lsm.SELinuxContext())
+		f.Remediation = "run the container with --privileged or --security-opt label=disable"
+	default:
+		f.Status = StatusWarn
+		f.Message = fmt.Sprintf("SELinux is enforcing (context %s), ceph directories will be relabeled as container_file_t", lsm.SELinuxContext())
+		f.Remediation = "if daemons fail with permission denied errors, check the audit log for AVC denials"
+	}
+
+	return f
+}
+
+func checkAppArmor(_ context.Context) Finding {
+	f := Finding{Check: "apparmor", Status: StatusOK}
+
+	profile, enforcing := lsm.AppArmorProfile()
+	if !enforcing {
+		f.Message = "AppArmor is not confining picoceph"
+		return f
+	}
+
+	// Profiles such as docker-default deny mount(2) and access to block
+	// devices, both of which are needed for the OSD backing devices.
+	f.Status = StatusFail
+	f.Message = fmt.Sprintf("AppArmor profile %q is enforcing, it will deny the mount, nbd, loop and device-mapper operations needed to create OSDs", profile)
+	f.Remediation = "run the container with --privileged or --security-opt apparmor=unconfined"
+
+	return f
+}
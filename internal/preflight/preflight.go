@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package preflight checks that the environment is able to run picoceph,
+// before we start making changes to it.
+package preflight
+
+import (
+	"context"
+	"slices"
+)
+
+// Status is the outcome of a preflight check.
+type Status string
+
+const (
+	// StatusOK means the check passed.
+	StatusOK Status = "ok"
+	// StatusWarn means picoceph can run, but something may not work as expected.
+	StatusWarn Status = "warn"
+	// StatusFail means picoceph will not be able to run.
+	StatusFail Status = "fail"
+)
+
+// Finding is the result of a single preflight check.
+type Finding struct {
+	// Check is the name of the check.
+	Check string `json:"check"`
+	// Status is the outcome of the check.
+	Status Status `json:"status"`
+	// Message describes what was found.
+	Message string `json:"message,omitempty"`
+	// Remediation is a hint on how to fix the problem (if any).
+	Remediation string `json:"remediation,omitempty"`
+}
+
+// Check is a single preflight check.
+type Check func(ctx context.Context) Finding
+
+// Checks are the preflight checks, run in order.
+var Checks = []Check{
+	checkSELinux,
+	checkAppArmor,
+	checkConflictingCeph,
+}
+
+// Run runs all the preflight checks.
+func Run(ctx context.Context) []Finding {
+	return RunChecks(ctx, Checks)
+}
+
+// RunChecks runs the given checks, in order.
+func RunChecks(ctx context.Context, checks []Check) []Finding {
+	var findings []Finding
+	for _, check := range checks {
+		findings = append(findings, check(ctx))
+	}
+
+	return findings
+}
+
+// Failed returns true if any of the findings failed, ignoring the named checks.
+func Failed(findings []Finding, ignore ...string) bool {
+	for _, f := range findings {
+		if f.Status == StatusFail && !slices.Contains(ignore, f.Check) {
+			return true
+		}
+	}
+
+	return false
+}
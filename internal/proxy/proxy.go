@@ -0,0 +1,183 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package proxy serves reverse proxies in front of cluster services (eg. RGW),
+// that can inject faults for testing clients.
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/certs"
+	"github.com/dpeckett/picoceph/internal/fault"
+	"github.com/nxadm/tail"
+)
+
+// Options are the options for a reverse proxy.
+type Options struct {
+	// Addr is the address the proxy listens on.
+	Addr string
+	// Upstream is the URL of the proxied service.
+	Upstream string
+	// TLS enables TLS termination, with a self-signed certificate unless
+	// TLSCertFile and TLSKeyFile are set.
+	TLS         bool
+	TLSCertFile string
+	TLSKeyFile  string
+	// Faults injects faults into proxied requests (nil disables).
+	Faults *fault.Injector
+}
+
+// TLSEnabled returns true if the proxy terminates TLS.
+func (o Options) TLSEnabled() bool {
+	return o.TLS || o.TLSCertFile != ""
+}
+
+// Proxy is a reverse proxy component.
+type Proxy struct {
+	logger    *slog.Logger
+	name      string
+	requires  []string
+	opts      Options
+	server    *http.Server
+	listening atomic.Bool
+}
+
+// New creates a component that serves a reverse proxy, that starts once the
+// required components are ready.
+func New(logger *slog.Logger, name string, requires []string, opts Options) ceph.Component {
+	p := &Proxy{
+		logger:   logger.With("component", name),
+		name:     name,
+		requires: requires,
+		opts:     opts,
+	}
+
+	target, err := url.Parse(opts.Upstream)
+	if err != nil {
+		// Reported by Configure.
+		target = &url.URL{}
+	}
+
+	rp := httputil.NewSingleHostReverseProxy(target)
+	rp.ErrorLog = slog.NewLogLogger(p.logger.Handler(), slog.LevelWarn)
+
+	var handler http.Handler = rp
+	if opts.Faults != nil {
+		handler = opts.Faults.Handler(rp)
+	}
+
+	p.server = &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+
+	return p
+}
+
+func (p *Proxy) Name() string {
+	return p.name
+}
+
+func (p *Proxy) Requires() []string {
+	return p.requires
+}
+
+func (p *Proxy) Configure(ctx context.Context) error {
+	if _, err := url.Parse(p.opts.Upstream); err != nil {
+		return fmt.Errorf("invalid upstream: %w", err)
+	}
+
+	if (p.opts.TLSCertFile == "") != (p.opts.TLSKeyFile == "") {
+		return fmt.Errorf("both a certificate and a key are required for TLS")
+	}
+
+	return nil
+}
+
+func (p *Proxy) Start(ctx context.Context) error {
+	l, err := net.Listen("tcp", p.opts.Addr)
+	if err != nil {
+		return fmt.Errorf("could not listen: %w", err)
+	}
+
+	if p.opts.TLSEnabled() {
+		cert, err := p.certificate()
+		if err != nil {
+			_ = l.Close()
+			return err
+		}
+
+		l = tls.NewListener(l, &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		})
+	}
+
+	p.logger.Info("Serving proxy", "addr", p.opts.Addr, "upstream", p.opts.Upstream, "tls", p.opts.TLSEnabled())
+
+	p.listening.Store(true)
+	defer p.listening.Store(false)
+
+	if err := p.server.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("could not serve proxy: %w", err)
+	}
+
+	return nil
+}
+
+func (p *Proxy) Stop(ctx context.Context) error {
+	return p.server.Shutdown(ctx)
+}
+
+func (p *Proxy) Ready(ctx context.Context) error {
+	if !p.listening.Load() {
+		return fmt.Errorf("proxy is not listening")
+	}
+
+	return nil
+}
+
+func (p *Proxy) Logs() (*tail.Tail, error) {
+	// Errors are logged directly.
+	return tail.TailFile(
+		"/dev/null",
+		tail.Config{Follow: true, ReOpen: true},
+	)
+}
+
+// certificate returns the TLS certificate of the proxy.
+func (p *Proxy) certificate() (tls.Certificate, error) {
+	if p.opts.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(p.opts.TLSCertFile, p.opts.TLSKeyFile)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("could not load certificate: %w", err)
+		}
+
+		return cert, nil
+	}
+
+	certPEM, keyPEM, err := certs.SelfSigned()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
@@ -0,0 +1,272 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package purge tears down everything left behind by previous runs of picoceph.
+package purge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/dpeckett/picoceph/internal/audit"
+	"github.com/dpeckett/picoceph/internal/ceph"
+	"github.com/dpeckett/picoceph/internal/ceph/osd"
+	"github.com/dpeckett/picoceph/internal/command"
+	"github.com/dpeckett/picoceph/internal/loop"
+	"github.com/dpeckett/picoceph/internal/nbd"
+)
+
+// diskDir is where OSD backing images are kept.
+const diskDir = "/var/lib/ceph/disk"
+
+// Run removes every volume group, device mapper node, block device and
+// directory created by the given picoceph instance (empty for the unnamed
+// instance), leaving those of other instances alone. It carries on after
+// failures, and returns all of the errors it encountered.
+func Run(ctx context.Context, logger *slog.Logger, instance string) error {
+	var errs []error
+
+	logger.Info("Removing dm-crypt devices")
+
+	// The dm-crypt mappings of encrypted OSDs hold their logical volumes open.
+	errs = append(errs, removeCryptMappings(ctx, instance))
+
+	logger.Info("Removing volume groups")
+
+	// The physical volumes are the OSD block devices, which need to be
+	// detached once their volume groups are gone.
+	physicalVolumes, err := removeVolumeGroups(ctx, instance)
+	errs = append(errs, err)
+
+	logger.Info("Removing device mapper nodes")
+	errs = append(errs, removeDeviceMapperNodes(ctx, instance))
+
+	logger.Info("Detaching block devices")
+	errs = append(errs, detachDevices(ctx, instance, physicalVolumes))
+
+	audit.Record("unmount", "path", diskDir)
+
+	if err := syscall.Unmount(diskDir, 0); err != nil && !errors.Is(err, syscall.EINVAL) && !errors.Is(err, syscall.ENOENT) {
+		errs = append(errs, fmt.Errorf("could not unmount %s: %w", diskDir, err))
+	}
+
+	logger.Info("Removing ceph directories")
+
+	// Only the contents are removed, as the directories may be mount points.
+	for _, dir := range ceph.Directories {
+		audit.Record("remove directory", "path", dir)
+
+		entries, err := os.ReadDir(dir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("could not read directory: %w", err))
+		}
+
+		for _, entry := range entries {
+			if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+				errs = append(errs, fmt.Errorf("could not remove directory: %w", err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// removeCryptMappings removes the dm-crypt mappings opened on the logical
+// volumes of OSDs.
+func removeCryptMappings(ctx context.Context, instance string) error {
+	mappings, err := osd.CryptMappings(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for name, device := range mappings {
+		if ownedDeviceMapperName(device, instance) {
+			errs = append(errs, osd.RemoveCryptMapping(ctx, name))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// removeVolumeGroups removes the OSD volume groups, and returns the paths of
+// their physical volumes.
+func removeVolumeGroups(ctx context.Context, instance string) ([]string, error) {
+	cmd := command.Context(ctx, "pvs", "--noheadings", "-o", "pv_name,vg_name")
+	cmd.Env = append(os.Environ(), "DM_DISABLE_UDEV=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("could not list physical volumes: %w: %s", err, string(out))
+	}
+
+	var physicalVolumes []string
+	volumeGroups := make(map[string]bool)
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !ownedVolumeGroup(fields[1], instance) {
+			continue
+		}
+
+		physicalVolumes = append(physicalVolumes, fields[0])
+		volumeGroups[fields[1]] = true
+	}
+
+	var errs []error
+	for vg := range volumeGroups {
+		audit.Record("remove volume group", "volumeGroup", vg)
+
+		cmd := command.Context(ctx, "vgremove", "--force", vg)
+		cmd.Env = append(os.Environ(), "DM_DISABLE_UDEV=1")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			errs = append(errs, fmt.Errorf("could not remove volume group %s: %w: %s", vg, err, string(out)))
+		}
+	}
+
+	return physicalVolumes, errors.Join(errs...)
+}
+
+// removeDeviceMapperNodes removes any device mapper nodes that outlived their
+// volume group (eg. because the backing device disappeared after an unclean exit).
+func removeDeviceMapperNodes(ctx context.Context, instance string) error {
+	cmd := command.Context(ctx, "/usr/sbin/dmsetup", "ls")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("could not list device mapper devices: %w: %s", err, string(out))
+	}
+
+	var errs []error
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if !ownedDeviceMapperName(fields[0], instance) {
+			continue
+		}
+
+		audit.Record("remove device mapper device", "name", fields[0])
+
+		cmd := command.Context(ctx, "/usr/sbin/dmsetup", "remove", "--force", fields[0])
+		if out, err := cmd.CombinedOutput(); err != nil {
+			errs = append(errs, fmt.Errorf("could not remove device mapper device %s: %w: %s", fields[0], err, string(out)))
+		}
+	}
+
+	devNodes, err := filepath.Glob("/dev/ceph-vg-*")
+	if err != nil {
+		return err
+	}
+
+	for _, devNode := range devNodes {
+		if !ownedVolumeGroup(filepath.Base(devNode), instance) {
+			continue
+		}
+
+		if err := os.RemoveAll(devNode); err != nil {
+			errs = append(errs, fmt.Errorf("could not remove %s: %w", devNode, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// detachDevices detaches the given physical volumes, along with any other
+// nbd and loop devices backed by OSD images. Other block devices (see
+// --osd-device), including loop devices not backed by OSD images, are left in
+// place with their LVM labels removed.
+func detachDevices(ctx context.Context, instance string, physicalVolumes []string) error {
+	var errs []error
+
+	nbdDevices := make(map[string]bool)
+	loopDevices := make(map[string]bool)
+	for _, pv := range physicalVolumes {
+		switch {
+		case strings.HasPrefix(pv, "/dev/nbd"):
+			nbdDevices[pv] = true
+		default:
+			audit.Record("remove physical volume", "device", pv)
+
+			cmd := command.Context(ctx, "pvremove", "--force", pv)
+			cmd.Env = append(os.Environ(), "DM_DISABLE_UDEV=1")
+			if out, err := cmd.CombinedOutput(); err != nil {
+				errs = append(errs, fmt.Errorf("could not remove physical volume %s: %w: %s", pv, err, string(out)))
+			}
+		}
+	}
+
+	connected, err := nbd.ConnectedDevices()
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	for device, cmdline := range connected {
+		for _, arg := range strings.Fields(cmdline) {
+			if strings.HasPrefix(arg, diskDir+"/") && ownedImage(arg, instance) {
+				nbdDevices[device] = true
+			}
+		}
+	}
+
+	// losetup isn't always available, and isn't needed if nbd was used.
+	if _, err := exec.LookPath("losetup"); err == nil {
+		attached, err := loop.List(ctx)
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		for device, backingFile := range attached {
+			if strings.HasPrefix(backingFile, diskDir+"/") && ownedImage(backingFile, instance) {
+				loopDevices[device] = true
+			}
+		}
+	}
+
+	for device := range nbdDevices {
+		errs = append(errs, nbd.Disconnect(ctx, device))
+	}
+
+	for device := range loopDevices {
+		errs = append(errs, loop.Detach(ctx, device))
+	}
+
+	return errors.Join(errs...)
+}
+
+// ownedVolumeGroup returns true if vg is the volume group of an OSD of the
+// instance.
+func ownedVolumeGroup(vg, instance string) bool {
+	owner, ok := osd.VolumeGroupInstance(vg)
+	return ok && owner == instance
+}
+
+// ownedDeviceMapperName returns true if name is the device mapper name of a
+// logical volume of an OSD of the instance.
+func ownedDeviceMapperName(name, instance string) bool {
+	// Device mapper escapes hyphens in volume group names.
+	return slices.ContainsFunc(osd.LogicalVolumes, func(lv string) bool {
+		vg, ok := strings.CutSuffix(name, "-"+lv)
+		return ok && ownedVolumeGroup(strings.ReplaceAll(vg, "--", "-"), instance)
+	})
+}
+
+// ownedImage returns true if path is the backing image of an OSD of the
+// instance.
+func ownedImage(path, instance string) bool {
+	owner, ok := osd.ImageInstance(strings.TrimSuffix(path, " (deleted)"))
+	return ok && owner == instance
+}
@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package purge
+
+import "testing"
+
+func TestOwnedVolumeGroup(t *testing.T) {
+	for _, tc := range []struct {
+		vg, instance string
+		owned        bool
+	}{
+		{vg: "ceph-vg-0", owned: true},
+		{vg: "ceph-vg-ci-0", instance: "ci", owned: true},
+		{vg: "ceph-vg-ci-1-0", instance: "ci-1", owned: true},
+		// Another instance's name may be prefixed by the instance's name.
+		{vg: "ceph-vg-ci-1-0", instance: "ci"},
+		{vg: "ceph-vg-ci-0", instance: "ci-1"},
+		{vg: "ceph-vg-ci-0"},
+		{vg: "ceph-vg-0", instance: "ci"},
+		// Foreign volume groups.
+		{vg: "ubuntu-vg"},
+		{vg: "ceph-vg-data"},
+		{vg: "ceph-0f3c1b9e-5d0a-4c4e-9d4c-2a7f4e0c9b1d"},
+	} {
+		if owned := ownedVolumeGroup(tc.vg, tc.instance); owned != tc.owned {
+			t.Errorf("ownedVolumeGroup(%q, %q) = %v", tc.vg, tc.instance, owned)
+		}
+	}
+}
+
+func TestOwnedDeviceMapperName(t *testing.T) {
+	for _, tc := range []struct {
+		name, instance string
+		owned          bool
+	}{
+		{name: "ceph--vg--0-osd", owned: true},
+		{name: "ceph--vg--0-db", owned: true},
+		{name: "ceph--vg--ci--0-wal", instance: "ci", owned: true},
+		{name: "ceph--vg--ci--1--0-osd", instance: "ci-1", owned: true},
+		{name: "ceph--vg--ci--1--0-osd", instance: "ci"},
+		{name: "ceph--vg--ci--0-osd", instance: "ci-1"},
+		{name: "ceph--vg--ci--0-osd"},
+		// Foreign device mapper names.
+		{name: "ceph--vg--0-block"},
+		{name: "ubuntu--vg-ubuntu--lv"},
+		{name: "ceph--0f3c1b9e-osd--block--1a2b"},
+		{name: "osd-0-dmcrypt"},
+	} {
+		if owned := ownedDeviceMapperName(tc.name, tc.instance); owned != tc.owned {
+			t.Errorf("ownedDeviceMapperName(%q, %q) = %v", tc.name, tc.instance, owned)
+		}
+	}
+}
+
+func TestOwnedImage(t *testing.T) {
+	for _, tc := range []struct {
+		path, instance string
+		owned          bool
+	}{
+		{path: "/var/lib/picoceph/disks/osd-0.img", owned: true},
+		{path: "/var/lib/picoceph/disks/osd-0.img (deleted)", owned: true},
+		{path: "/var/lib/picoceph/disks/osd-ci-0.img", instance: "ci", owned: true},
+		{path: "/var/lib/picoceph/disks/osd-ci-1-0.img", instance: "ci-1", owned: true},
+		{path: "/var/lib/picoceph/disks/osd-ci-1-0.img", instance: "ci"},
+		{path: "/var/lib/picoceph/disks/osd-ci-0.img", instance: "ci-1"},
+		{path: "/var/lib/picoceph/disks/osd-ci-0.img"},
+		// Foreign images.
+		{path: "/var/lib/libvirt/images/debian.qcow2"},
+		{path: "/var/lib/picoceph/disks/osd-data.img"},
+		{path: "/var/lib/picoceph/disks/swap.img"},
+	} {
+		if owned := ownedImage(tc.path, tc.instance); owned != tc.owned {
+			t.Errorf("ownedImage(%q, %q) = %v", tc.path, tc.instance, owned)
+		}
+	}
+}
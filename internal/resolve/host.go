@@ -0,0 +1,110 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolve
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// capSysAdmin is the bit of CAP_SYS_ADMIN in a capability set.
+const capSysAdmin = 21
+
+// Host is what the host is capable of.
+type Host struct {
+	// Privileged is true if picoceph is running as root with CAP_SYS_ADMIN.
+	Privileged bool
+	// NBD is true if the nbd kernel module and qemu-nbd are available.
+	NBD bool
+	// DMCrypt is true if the dm_crypt kernel module and cryptsetup are
+	// available.
+	DMCrypt bool
+}
+
+// DetectHost detects the capabilities of the host, without changing it (eg.
+// kernel modules are not loaded).
+func DetectHost() Host {
+	return Host{
+		Privileged: os.Geteuid() == 0 && hasCapability(capSysAdmin),
+		NBD:        nbdAvailable(),
+		DMCrypt:    dmCryptAvailable(),
+	}
+}
+
+// hasCapability returns true if the process has the capability in its
+// effective set.
+func hasCapability(capability uint) bool {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "CapEff:")
+		if !ok {
+			continue
+		}
+
+		caps, err := strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		if err != nil {
+			return false
+		}
+
+		return caps&(1<<capability) != 0
+	}
+
+	return false
+}
+
+// nbdAvailable returns true if nbd is loaded (or can be loaded) and qemu-nbd
+// is installed.
+func nbdAvailable() bool {
+	if _, err := exec.LookPath("qemu-nbd"); err != nil {
+		return false
+	}
+
+	if _, err := os.Stat("/sys/module/nbd"); err == nil {
+		return true
+	}
+
+	release, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return false
+	}
+
+	modules, _ := filepath.Glob(filepath.Join("/lib/modules", strings.TrimSpace(string(release)), "kernel/drivers/block/nbd.ko*"))
+	return len(modules) > 0
+}
+
+// dmCryptAvailable returns true if dm_crypt is loaded (or can be loaded) and
+// cryptsetup is installed.
+func dmCryptAvailable() bool {
+	if _, err := exec.LookPath("cryptsetup"); err != nil {
+		return false
+	}
+
+	if _, err := os.Stat("/sys/module/dm_crypt"); err == nil {
+		return true
+	}
+
+	release, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return false
+	}
+
+	modules, _ := filepath.Glob(filepath.Join("/lib/modules", strings.TrimSpace(string(release)), "kernel/drivers/md/dm-crypt.ko*"))
+	return len(modules) > 0
+}
@@ -0,0 +1,317 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package resolve checks combinations of options against each other and the
+// capabilities of the host, explaining any conflicts and, where possible,
+// resolving them to safe behavior rather than failing obscurely at runtime.
+package resolve
+
+import (
+	"github.com/dpeckett/picoceph/internal/ceph/osd"
+)
+
+// Options are the options that can conflict.
+type Options struct {
+	OSDBackend osd.Backend
+	// OSDBackendExplicit is true if the backend was chosen by the user.
+	OSDBackendExplicit bool
+	OSDEncrypted       bool
+	OSDDeviceClass     string
+	OSDDevice          string
+	OSDRaw             bool
+	OSDDBSizeMiB       int64
+	OSDWALSizeMiB      int64
+	Storage            osd.Storage
+	DataDir            string
+	Adopt              bool
+	FSID               string
+	Seed               string
+	RecordCommands     string
+	ReplayCommands     string
+	S3User             string
+	Swift              bool
+	PrimaryURL         string
+	K8sStatefulSet     bool
+	IPv6               bool
+	MsgrSecure         bool
+	PublicAddr         string
+	Join               string
+	BootstrapOSDKey    string
+	DNSAddr            string
+	RGWDNSName         string
+	LoadGen            string
+	VirtualHosts       string
+}
+
+// Conflict is a combination of options that can't be honoured as requested.
+type Conflict struct {
+	// Options are the conflicting options (eg. "--storage=ephemeral").
+	Options []string
+	// Message explains the conflict.
+	Message string
+	// Resolution is what picoceph does instead (empty if fatal).
+	Resolution string
+	// Fatal is true if picoceph can't safely continue.
+	Fatal bool
+	// Host is true if the conflict is with what the host is capable of,
+	// rather than between options.
+	Host bool
+}
+
+// Resolve checks the options for conflicts, and returns the options with any
+// resolutions applied.
+func Resolve(opts Options, host Host) (Options, []Conflict) {
+	var conflicts []Conflict
+
+	if opts.RecordCommands != "" && opts.ReplayCommands != "" {
+		conflicts = append(conflicts, Conflict{
+			Options: []string{"--record-commands", "--replay-commands"},
+			Message: "commands can't be recorded while they are being replayed",
+			Fatal:   true,
+		})
+	}
+
+	if !host.Privileged {
+		conflicts = append(conflicts, Conflict{
+			Message: "picoceph must run as root with CAP_SYS_ADMIN (eg. in a --privileged container) to create block devices and mount filesystems, rootless mode is not supported",
+			Fatal:   true,
+			Host:    true,
+		})
+	}
+
+	if opts.Storage == osd.StorageEphemeral && opts.DataDir != "" {
+		conflicts = append(conflicts, Conflict{
+			Options:    []string{"--storage=ephemeral", "--data-dir"},
+			Message:    "an ephemeral cluster's OSD data is lost when picoceph exits, so the state kept in the data directory could not be reused",
+			Resolution: "ignoring --data-dir",
+		})
+		opts.DataDir = ""
+	}
+
+	if opts.Adopt {
+		for flag, set := range map[string]bool{
+			"--storage=ephemeral": opts.Storage == osd.StorageEphemeral,
+			"--seed":              opts.Seed != "",
+			"--rgw-primary-url":   opts.PrimaryURL != "",
+			"--k8s-statefulset":   opts.K8sStatefulSet,
+			"--public-addr":       opts.PublicAddr != "",
+			"--virtual-hosts":     opts.VirtualHosts != "",
+			"--msgr-secure":       opts.MsgrSecure,
+			"--osd-encrypted":     opts.OSDEncrypted,
+			"--osd-device-class":  opts.OSDDeviceClass != "",
+			"--osd-device":        opts.OSDDevice != "",
+			"--osd-raw":           opts.OSDRaw,
+		} {
+			if set {
+				conflicts = append(conflicts, Conflict{
+					Options:    []string{"--adopt", flag},
+					Message:    "an adopted cluster is run as it is found",
+					Resolution: "ignoring " + flag,
+				})
+			}
+		}
+
+		opts.Storage = osd.StoragePersistent
+		opts.Seed = ""
+		opts.PrimaryURL = ""
+		opts.K8sStatefulSet = false
+		opts.PublicAddr = ""
+		opts.VirtualHosts = ""
+		opts.MsgrSecure = false
+		opts.OSDEncrypted = false
+		opts.OSDDeviceClass = ""
+		opts.OSDDevice = ""
+		opts.OSDRaw = false
+	}
+
+	if opts.K8sStatefulSet && opts.IPv6 {
+		conflicts = append(conflicts, Conflict{
+			Options: []string{"--k8s-statefulset", "--ipv6"},
+			Message: "the pods of a StatefulSet are found by their IPv4 addresses",
+			Fatal:   true,
+		})
+	}
+
+	if opts.K8sStatefulSet && opts.PublicAddr != "" {
+		conflicts = append(conflicts, Conflict{
+			Options:    []string{"--k8s-statefulset", "--public-addr"},
+			Message:    "the pods of a StatefulSet are reached on their pod IPs",
+			Resolution: "ignoring --public-addr",
+		})
+		opts.PublicAddr = ""
+	}
+
+	if opts.K8sStatefulSet && opts.VirtualHosts != "" {
+		conflicts = append(conflicts, Conflict{
+			Options:    []string{"--k8s-statefulset", "--virtual-hosts"},
+			Message:    "the pods of a StatefulSet are real hosts, each running its own OSD",
+			Resolution: "ignoring --virtual-hosts",
+		})
+		opts.VirtualHosts = ""
+	}
+
+	if opts.Join != "" {
+		for flag, set := range map[string]bool{
+			"--adopt":           opts.Adopt,
+			"--k8s-statefulset": opts.K8sStatefulSet,
+		} {
+			if set {
+				conflicts = append(conflicts, Conflict{
+					Options: []string{"--join", flag},
+					Message: "a joined instance only runs an OSD, its monitors belong to another instance",
+					Fatal:   true,
+				})
+			}
+		}
+
+		if opts.BootstrapOSDKey == "" {
+			conflicts = append(conflicts, Conflict{
+				Options: []string{"--join"},
+				Message: "joining a cluster needs the client.bootstrap-osd key of the cluster (--bootstrap-osd-key)",
+				Fatal:   true,
+			})
+		}
+
+		if opts.Seed != "" {
+			conflicts = append(conflicts, Conflict{
+				Options:    []string{"--join", "--seed"},
+				Message:    "a joined instance uses the fsid and keys of the cluster it joins",
+				Resolution: "ignoring --seed",
+			})
+			opts.Seed = ""
+		}
+
+		if opts.VirtualHosts != "" {
+			conflicts = append(conflicts, Conflict{
+				Options:    []string{"--join", "--virtual-hosts"},
+				Message:    "a joined instance runs a single OSD, on the host of its --crush-location",
+				Resolution: "ignoring --virtual-hosts",
+			})
+			opts.VirtualHosts = ""
+		}
+	}
+
+	if opts.Storage == osd.StorageEphemeral && opts.VirtualHosts != "" {
+		conflicts = append(conflicts, Conflict{
+			Options: []string{"--storage=ephemeral", "--virtual-hosts"},
+			Message: "ephemeral storage holds the image of a single OSD",
+			Fatal:   true,
+		})
+	}
+
+	if opts.OSDDevice != "" && opts.Storage == osd.StorageEphemeral {
+		conflicts = append(conflicts, Conflict{
+			Options: []string{"--osd-device", "--storage=ephemeral"},
+			Message: "the data on a block device outlives picoceph",
+			Fatal:   true,
+		})
+	}
+
+	if opts.OSDDevice != "" && opts.VirtualHosts != "" {
+		conflicts = append(conflicts, Conflict{
+			Options: []string{"--osd-device", "--virtual-hosts"},
+			Message: "a block device can only be used by one OSD (set the device of each OSD in the config file instead)",
+			Fatal:   true,
+		})
+	}
+
+	if opts.OSDRaw && (opts.OSDDBSizeMiB > 0 || opts.OSDWALSizeMiB > 0) {
+		conflicts = append(conflicts, Conflict{
+			Options:    []string{"--osd-raw", "--osd-db-size-mib", "--osd-wal-size-mib"},
+			Message:    "a raw OSD takes up its whole device, so there are no volumes to split off for the DB and WAL",
+			Resolution: "keeping the DB and WAL on the data device",
+		})
+		opts.OSDDBSizeMiB = 0
+		opts.OSDWALSizeMiB = 0
+	}
+
+	if opts.OSDRaw && opts.OSDEncrypted {
+		conflicts = append(conflicts, Conflict{
+			Options: []string{"--osd-raw", "--osd-encrypted"},
+			Message: "encrypted OSDs are only supported on LVM",
+			Fatal:   true,
+		})
+	}
+
+	if opts.K8sStatefulSet && opts.Seed == "" {
+		conflicts = append(conflicts, Conflict{
+			Options: []string{"--k8s-statefulset"},
+			Message: "the pods of a StatefulSet must share an fsid and keys, which are derived from --seed",
+			Fatal:   true,
+		})
+	}
+
+	if opts.FSID != "" && opts.Seed != "" {
+		conflicts = append(conflicts, Conflict{
+			Options:    []string{"--fsid", "--seed"},
+			Message:    "the fsid is normally derived from the seed",
+			Resolution: "using the fsid from --fsid, other credentials are still derived from the seed",
+		})
+	}
+
+	if opts.Swift && opts.S3User == "" {
+		conflicts = append(conflicts, Conflict{
+			Options:    []string{"--swift", `--s3-user=""`},
+			Message:    "the Swift subuser belongs to the default S3 user, which is disabled",
+			Resolution: "ignoring --swift",
+		})
+		opts.Swift = false
+	}
+
+	if opts.LoadGen == "s3" && opts.S3User == "" {
+		conflicts = append(conflicts, Conflict{
+			Options:    []string{"--loadgen=s3", `--s3-user=""`},
+			Message:    "the load generator writes objects as the default S3 user, which is disabled",
+			Resolution: "not generating load",
+		})
+		opts.LoadGen = ""
+	}
+
+	if opts.DNSAddr != "" && opts.RGWDNSName == "" {
+		conflicts = append(conflicts, Conflict{
+			Options:    []string{"--dns-addr"},
+			Message:    "the DNS server resolves the domain of virtual-hosted buckets, which is set with --rgw-dns-name",
+			Resolution: "not serving DNS",
+		})
+		opts.DNSAddr = ""
+	}
+
+	if opts.OSDEncrypted && !host.DMCrypt && opts.ReplayCommands == "" {
+		conflicts = append(conflicts, Conflict{
+			Options: []string{"--osd-encrypted"},
+			Message: "encrypted OSDs need the dm_crypt kernel module and cryptsetup, which are not available",
+			Fatal:   true,
+			Host:    true,
+		})
+	}
+
+	if opts.OSDBackend == osd.BackendNBD && opts.OSDBackendExplicit && !host.NBD && opts.ReplayCommands == "" {
+		conflicts = append(conflicts, Conflict{
+			Options:    []string{"--osd-backend=nbd"},
+			Message:    "the nbd backend needs the nbd kernel module and qemu-nbd, which are not available",
+			Resolution: "using loop devices for OSDs",
+		})
+		opts.OSDBackend = osd.BackendLoop
+	}
+
+	return opts, conflicts
+}
+
+// Fatal returns true if any of the conflicts are fatal. Fatal conflicts with
+// the host are ignored if skipHost is true (eg. when the capabilities of the
+// host are misdetected), whereas conflicts between options are always fatal.
+func Fatal(conflicts []Conflict, skipHost bool) bool {
+	for _, c := range conflicts {
+		if c.Fatal && !(c.Host && skipHost) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,174 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package resolve
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/dpeckett/picoceph/internal/ceph/osd"
+)
+
+func TestResolve(t *testing.T) {
+	capable := Host{Privileged: true, NBD: true, DMCrypt: true}
+
+	for _, tc := range []struct {
+		name string
+		opts Options
+		host Host
+		// want is the resolved options.
+		want Options
+		// conflicts are the options of each conflict.
+		conflicts [][]string
+		// fatal and fatalSkipHost are whether the conflicts are fatal, without
+		// and with --skip-preflight.
+		fatal, fatalSkipHost bool
+	}{
+		{
+			name: "no conflicts",
+			opts: Options{Storage: osd.StoragePersistent, S3User: "picoceph", Swift: true},
+			host: capable,
+			want: Options{Storage: osd.StoragePersistent, S3User: "picoceph", Swift: true},
+		},
+		{
+			name:          "unprivileged",
+			opts:          Options{Storage: osd.StoragePersistent},
+			host:          Host{},
+			want:          Options{Storage: osd.StoragePersistent},
+			conflicts:     [][]string{nil},
+			fatal:         true,
+			fatalSkipHost: false,
+		},
+		{
+			name:      "ephemeral data dir",
+			opts:      Options{Storage: osd.StorageEphemeral, DataDir: "/data"},
+			host:      capable,
+			want:      Options{Storage: osd.StorageEphemeral},
+			conflicts: [][]string{{"--storage=ephemeral", "--data-dir"}},
+		},
+		{
+			name:          "ephemeral virtual hosts",
+			opts:          Options{Storage: osd.StorageEphemeral, VirtualHosts: "a,b"},
+			host:          capable,
+			want:          Options{Storage: osd.StorageEphemeral, VirtualHosts: "a,b"},
+			conflicts:     [][]string{{"--storage=ephemeral", "--virtual-hosts"}},
+			fatal:         true,
+			fatalSkipHost: true,
+		},
+		{
+			name:          "ephemeral device",
+			opts:          Options{Storage: osd.StorageEphemeral, OSDDevice: "/dev/sdb"},
+			host:          capable,
+			want:          Options{Storage: osd.StorageEphemeral, OSDDevice: "/dev/sdb"},
+			conflicts:     [][]string{{"--osd-device", "--storage=ephemeral"}},
+			fatal:         true,
+			fatalSkipHost: true,
+		},
+		{
+			name:      "adopt ephemeral",
+			opts:      Options{Adopt: true, Storage: osd.StorageEphemeral},
+			host:      capable,
+			want:      Options{Adopt: true, Storage: osd.StoragePersistent},
+			conflicts: [][]string{{"--adopt", "--storage=ephemeral"}},
+		},
+		{
+			name:          "record and replay",
+			opts:          Options{RecordCommands: "a.jsonl", ReplayCommands: "b.jsonl"},
+			host:          capable,
+			want:          Options{RecordCommands: "a.jsonl", ReplayCommands: "b.jsonl"},
+			conflicts:     [][]string{{"--record-commands", "--replay-commands"}},
+			fatal:         true,
+			fatalSkipHost: true,
+		},
+		{
+			name:          "join without key",
+			opts:          Options{Join: "10.0.0.1", Seed: "test"},
+			host:          capable,
+			want:          Options{Join: "10.0.0.1"},
+			conflicts:     [][]string{{"--join"}, {"--join", "--seed"}},
+			fatal:         true,
+			fatalSkipHost: true,
+		},
+		{
+			name:      "raw with db and wal",
+			opts:      Options{OSDRaw: true, OSDDBSizeMiB: 1024, OSDWALSizeMiB: 512},
+			host:      capable,
+			want:      Options{OSDRaw: true},
+			conflicts: [][]string{{"--osd-raw", "--osd-db-size-mib", "--osd-wal-size-mib"}},
+		},
+		{
+			name:      "loadgen without s3 user",
+			opts:      Options{LoadGen: "s3", Swift: true},
+			host:      capable,
+			want:      Options{},
+			conflicts: [][]string{{"--swift", `--s3-user=""`}, {"--loadgen=s3", `--s3-user=""`}},
+		},
+		{
+			name:          "encrypted without dm-crypt",
+			opts:          Options{OSDEncrypted: true},
+			host:          Host{Privileged: true},
+			want:          Options{OSDEncrypted: true},
+			conflicts:     [][]string{{"--osd-encrypted"}},
+			fatal:         true,
+			fatalSkipHost: false,
+		},
+		{
+			name: "encrypted replay",
+			opts: Options{OSDEncrypted: true, ReplayCommands: "a.jsonl"},
+			host: Host{Privileged: true},
+			want: Options{OSDEncrypted: true, ReplayCommands: "a.jsonl"},
+		},
+		{
+			name:      "explicit nbd without nbd",
+			opts:      Options{OSDBackend: osd.BackendNBD, OSDBackendExplicit: true},
+			host:      Host{Privileged: true},
+			want:      Options{OSDBackend: osd.BackendLoop, OSDBackendExplicit: true},
+			conflicts: [][]string{{"--osd-backend=nbd"}},
+		},
+		{
+			name: "default nbd without nbd",
+			opts: Options{OSDBackend: osd.BackendNBD},
+			host: Host{Privileged: true},
+			want: Options{OSDBackend: osd.BackendNBD},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, conflicts := Resolve(tc.opts, tc.host)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got options %+v, want %+v", got, tc.want)
+			}
+
+			var options [][]string
+			for _, c := range conflicts {
+				if c.Message == "" {
+					t.Fatalf("conflict %v has no message", c.Options)
+				}
+
+				if !c.Fatal && c.Resolution == "" {
+					t.Fatalf("conflict %v is neither fatal nor resolved", c.Options)
+				}
+
+				options = append(options, c.Options)
+			}
+
+			if !reflect.DeepEqual(options, tc.conflicts) {
+				t.Fatalf("got conflicts %q, want %q", options, tc.conflicts)
+			}
+
+			if fatal := Fatal(conflicts, false); fatal != tc.fatal {
+				t.Fatalf("got fatal %v, want %v", fatal, tc.fatal)
+			}
+
+			if fatal := Fatal(conflicts, true); fatal != tc.fatalSkipHost {
+				t.Fatalf("got fatal %v with --skip-preflight, want %v", fatal, tc.fatalSkipHost)
+			}
+		})
+	}
+}
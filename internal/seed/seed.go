@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package seed deterministically derives identifiers and credentials from a
+// seed, so that they are the same every time a cluster is recreated.
+//
+// Anyone who knows the seed can derive the credentials, so this is insecure
+// and only meant for test fixtures.
+package seed
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+var (
+	mu   sync.Mutex
+	seed []byte
+)
+
+// Set sets the seed that values are derived from (empty disables).
+func Set(s string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if s == "" {
+		seed = nil
+		return
+	}
+
+	seed = []byte(s)
+}
+
+// Enabled returns true if a seed has been set.
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return seed != nil
+}
+
+// Bytes derives n bytes for the given purpose (eg. "keyring/client.admin").
+func Bytes(purpose string, n int) []byte {
+	mu.Lock()
+	key := seed
+	mu.Unlock()
+
+	out := make([]byte, 0, n+sha256.Size)
+	for counter := uint32(0); len(out) < n; counter++ {
+		mac := hmac.New(sha256.New, key)
+		_ = binary.Write(mac, binary.BigEndian, counter)
+		mac.Write([]byte(purpose))
+		out = mac.Sum(out)
+	}
+
+	return out[:n]
+}
+
+// String derives a string of n characters from the given alphabet.
+func String(purpose string, n int, alphabet string) string {
+	b := Bytes(purpose, n)
+	for i := range b {
+		b[i] = alphabet[int(b[i])%len(alphabet)]
+	}
+
+	return string(b)
+}
+
+// UUID derives a random (version 4) UUID.
+func UUID(purpose string) uuid.UUID {
+	var u uuid.UUID
+	copy(u[:], Bytes(purpose, len(u)))
+
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+
+	return u
+}
@@ -0,0 +1,237 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package snapshot takes and reverts snapshots of a stopped cluster, so that
+// its data can be rolled back far faster than by bootstrapping a new cluster.
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dpeckett/picoceph/internal/audit"
+	"github.com/dpeckett/picoceph/internal/command"
+	"github.com/dpeckett/picoceph/internal/nbd"
+)
+
+const (
+	// diskDir is where OSD backing images are kept.
+	diskDir = "/var/lib/ceph/disk"
+	// monDir is where the monitor stores are kept.
+	monDir = "/var/lib/ceph/mon"
+	// snapshotDir is where copies of the monitor stores are kept.
+	snapshotDir = "/var/lib/ceph/snapshots"
+)
+
+// Create snapshots the OSD images (as internal qcow2 snapshots) and copies the
+// monitor stores, so that the cluster maps stay consistent with the OSD data.
+// A snapshot that could not be created is removed again.
+func Create(ctx context.Context, name string) (err error) {
+	images, err := stoppedImages(name)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(filepath.Join(snapshotDir, name)); err == nil {
+		return fmt.Errorf("snapshot %s already exists", name)
+	}
+
+	if err := os.MkdirAll(filepath.Join(snapshotDir, name), 0o700); err != nil {
+		return fmt.Errorf("could not create snapshot directory: %w", err)
+	}
+
+	var snapshotted []string
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		for _, image := range snapshotted {
+			if deleteErr := qemuImgSnapshot(ctx, "-d", name, image); deleteErr != nil {
+				err = errors.Join(err, fmt.Errorf("could not delete partial snapshot of %s: %w", image, deleteErr))
+			}
+		}
+
+		audit.Record("remove directory", "path", filepath.Join(snapshotDir, name))
+
+		if removeErr := os.RemoveAll(filepath.Join(snapshotDir, name)); removeErr != nil {
+			err = errors.Join(err, fmt.Errorf("could not remove partial snapshot directory: %w", removeErr))
+		}
+	}()
+
+	audit.Record("copy directory", "from", monDir, "to", filepath.Join(snapshotDir, name))
+
+	cmd := command.Context(ctx, "cp", "-a", monDir, filepath.Join(snapshotDir, name, "mon"))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not copy monitor store: %w: %s", err, string(out))
+	}
+
+	for _, image := range images {
+		if err := qemuImgSnapshot(ctx, "-c", name, image); err != nil {
+			return fmt.Errorf("could not snapshot %s: %w", image, err)
+		}
+
+		snapshotted = append(snapshotted, image)
+	}
+
+	return nil
+}
+
+// Revert rolls the OSD images and monitor stores back to a snapshot.
+func Revert(ctx context.Context, name string) error {
+	images, err := stoppedImages(name)
+	if err != nil {
+		return err
+	}
+
+	snapshotMonDir := filepath.Join(snapshotDir, name, "mon")
+	if _, err := os.Stat(snapshotMonDir); err != nil {
+		return fmt.Errorf("snapshot %s does not exist", name)
+	}
+
+	// Copy the monitor stores next to the current ones first, so that they
+	// can be swapped in by renaming them, and a failed copy leaves the
+	// cluster untouched.
+	revertDir, err := os.MkdirTemp(filepath.Dir(monDir), "picoceph-mon-revert-")
+	if err != nil {
+		return fmt.Errorf("could not create temporary directory: %w", err)
+	}
+
+	keepRevertDir := false
+	defer func() {
+		if !keepRevertDir {
+			_ = os.RemoveAll(revertDir)
+		}
+	}()
+
+	audit.Record("copy directory", "from", snapshotMonDir, "to", filepath.Join(revertDir, "mon"))
+
+	cmd := command.Context(ctx, "cp", "-a", snapshotMonDir, filepath.Join(revertDir, "mon"))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not copy monitor store: %w: %s", err, string(out))
+	}
+
+	for _, image := range images {
+		if err := qemuImgSnapshot(ctx, "-a", name, image); err != nil {
+			return fmt.Errorf("could not revert %s: %w", image, err)
+		}
+	}
+
+	audit.Record("rename directory", "from", filepath.Join(revertDir, "mon"), "to", monDir)
+
+	if err := os.Rename(monDir, filepath.Join(revertDir, "old")); err != nil {
+		return fmt.Errorf("could not move monitor store aside: %w", err)
+	}
+
+	if err := os.Rename(filepath.Join(revertDir, "mon"), monDir); err != nil {
+		if restoreErr := os.Rename(filepath.Join(revertDir, "old"), monDir); restoreErr != nil {
+			keepRevertDir = true
+			return fmt.Errorf("could not restore monitor store (the previous store is in %s): %w",
+				filepath.Join(revertDir, "old"), errors.Join(err, restoreErr))
+		}
+
+		return fmt.Errorf("could not restore monitor store: %w", err)
+	}
+
+	return nil
+}
+
+// Delete removes a snapshot.
+func Delete(ctx context.Context, name string) error {
+	images, err := stoppedImages(name)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, image := range images {
+		if err := qemuImgSnapshot(ctx, "-d", name, image); err != nil {
+			errs = append(errs, fmt.Errorf("could not delete snapshot of %s: %w", image, err))
+		}
+	}
+
+	audit.Record("remove directory", "path", filepath.Join(snapshotDir, name))
+
+	if err := os.RemoveAll(filepath.Join(snapshotDir, name)); err != nil {
+		errs = append(errs, fmt.Errorf("could not remove snapshot directory: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// List returns the names of every snapshot.
+func List() ([]string, error) {
+	entries, err := os.ReadDir(snapshotDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("could not read snapshot directory: %w", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// stoppedImages returns the qcow2 OSD images, after checking that none of
+// them are in use and that the cluster can be snapshotted.
+func stoppedImages(name string) ([]string, error) {
+	if name == "" || strings.ContainsAny(name, "/ ") || name == "." || name == ".." {
+		return nil, fmt.Errorf("invalid snapshot name: %q", name)
+	}
+
+	if raw, _ := filepath.Glob(filepath.Join(diskDir, "*.img")); len(raw) > 0 {
+		return nil, fmt.Errorf("snapshots require qcow2 OSD images, but %s is a raw image (loop backend)", raw[0])
+	}
+
+	images, err := filepath.Glob(filepath.Join(diskDir, "*.qcow2"))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(images) == 0 {
+		return nil, fmt.Errorf("no OSD images found in %s", diskDir)
+	}
+
+	connected, err := nbd.ConnectedDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	for device, cmdline := range connected {
+		for _, image := range images {
+			if strings.Contains(cmdline, image) {
+				return nil, fmt.Errorf("%s is attached to %s, picoceph must be stopped first", image, device)
+			}
+		}
+	}
+
+	return images, nil
+}
+
+func qemuImgSnapshot(ctx context.Context, op, name, image string) error {
+	audit.Record("snapshot qemu image", "operation", op, "snapshot", name, "image", image)
+
+	cmd := command.Context(ctx, "qemu-img", "snapshot", op, name, image)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, string(out))
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package tempfile manages private temporary directories for sensitive files
+// (eg. keyrings) that must never be readable by other users.
+package tempfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+var (
+	mu   sync.Mutex
+	dirs = make(map[string]struct{})
+)
+
+// Dir is a private temporary directory, only accessible by its owner.
+type Dir struct {
+	path string
+}
+
+// MkdirPrivate creates a new private temporary directory. The directory must
+// be removed with Remove, or on exit with RemoveAll. Its name includes the pid
+// of the process, so that RemoveStale can tell when it has been left behind.
+func MkdirPrivate(pattern string) (*Dir, error) {
+	path, err := os.MkdirTemp("", pattern+strconv.Itoa(os.Getpid())+".")
+	if err != nil {
+		return nil, fmt.Errorf("could not create temporary directory: %w", err)
+	}
+
+	// MkdirTemp already uses 0700, but don't rely on it.
+	if err := os.Chmod(path, 0o700); err != nil {
+		_ = os.RemoveAll(path)
+		return nil, fmt.Errorf("could not change mode of temporary directory: %w", err)
+	}
+
+	mu.Lock()
+	dirs[path] = struct{}{}
+	mu.Unlock()
+
+	return &Dir{path: path}, nil
+}
+
+// Path returns the path of the named file within the directory.
+func (d *Dir) Path(name string) string {
+	return filepath.Join(d.path, name)
+}
+
+// Remove deletes the directory and everything in it. It is safe to call more
+// than once.
+func (d *Dir) Remove() error {
+	mu.Lock()
+	delete(dirs, d.path)
+	mu.Unlock()
+
+	if err := os.RemoveAll(d.path); err != nil {
+		return fmt.Errorf("could not remove temporary directory: %w", err)
+	}
+
+	return nil
+}
+
+// RemoveAll deletes every temporary directory that has not yet been removed.
+// It should be called before the process exits.
+func RemoveAll() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for path := range dirs {
+		_ = os.RemoveAll(path)
+		delete(dirs, path)
+	}
+}
+
+// Exit deletes every temporary directory, and exits with the given status.
+func Exit(code int) {
+	RemoveAll()
+	os.Exit(code)
+}
+
+// RemoveStale deletes the temporary directories with the given prefix that
+// were left behind by processes that are no longer running (eg. because they
+// were killed).
+func RemoveStale(prefix string) {
+	paths, _ := filepath.Glob(filepath.Join(os.TempDir(), prefix+"*"))
+	for _, path := range paths {
+		// The name ends with the pid of its creator, and a random suffix.
+		name := filepath.Base(path)
+		pid, _, ok := strings.Cut(name[strings.LastIndex(name, "-")+1:], ".")
+		if !ok {
+			continue
+		}
+
+		n, err := strconv.Atoi(pid)
+		if err != nil || n <= 0 || n == os.Getpid() {
+			continue
+		}
+
+		if err := syscall.Kill(n, 0); errors.Is(err, syscall.ESRCH) {
+			_ = os.RemoveAll(path)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// SPDX-License-Identifier: MPL-2.0
+/*
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package util
+
+import "net"
+
+// LocalAddr returns a listen address with an unspecified host replaced by the
+// loopback address (of the same family), so that it can be connected to.
+func LocalAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	switch host {
+	case "", "0.0.0.0":
+		host = "127.0.0.1"
+	case "::":
+		host = "::1"
+	}
+
+	return net.JoinHostPort(host, port)
+}
+
+// HostIP returns the first non-loopback IPv4 address of the host (eg. the
+// address of a container on its bridge network), or 127.0.0.1 if there is none.
+func HostIP() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "127.0.0.1"
+	}
+
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+			return ipNet.IP.String()
+		}
+	}
+
+	return "127.0.0.1"
+}
+
+// HostNetwork returns the network that contains only ip, eg. "10.0.0.1/32".
+func HostNetwork(ip net.IP) string {
+	if ip.To4() == nil {
+		return ip.String() + "/128"
+	}
+
+	return ip.String() + "/32"
+}
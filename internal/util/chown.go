@@ -12,10 +12,21 @@ package util
 import (
 	"os"
 	"path/filepath"
+
+	"github.com/dpeckett/picoceph/internal/audit"
 )
 
+// Chown chowns a file or directory.
+func Chown(path string, uid, gid int) error {
+	audit.Record("chown", "path", path, "uid", uid, "gid", gid)
+
+	return os.Chown(path, uid, gid)
+}
+
 // ChownRecursive chowns a file or directory and all of its children.
 func ChownRecursive(path string, uid, gid int) error {
+	audit.Record("chown", "path", path, "uid", uid, "gid", gid, "recursive", true)
+
 	if err := os.Chown(path, uid, gid); err != nil {
 		return err
 	}